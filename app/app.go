@@ -3,14 +3,19 @@ package app
 import (
 	"context"
 	"fmt"
+	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/xuning888/helloIMClient/conf"
 	"github.com/xuning888/helloIMClient/im"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 	"github.com/xuning888/helloIMClient/tui"
 )
 
+// appEventBufferSize TUI 订阅 SDK 事件的缓冲区大小, 积压超过这个数量后才会阻塞 SDK
+const appEventBufferSize = 4096
+
 // ImApp 应用程序，负责连接 SDK 和 TUI
 type ImApp struct {
 	sdk     *im.Client
@@ -31,16 +36,23 @@ func (i *ImApp) Start() error {
 		return fmt.Errorf("connect: %w", err)
 	}
 
-	// 拉取用户信息
-	i.sdk.Storage().Users.Refresh(ctx)
-
-	// 注册 SDK 事件回调，桥接到 TUI
-	i.registerEventCallbacks()
+	// 增量同步用户信息
+	if err := i.sdk.Storage().Users.Refresh(ctx); err != nil {
+		logger.Errorf("app: sync users: %v", err)
+	}
+	// 同步联系人、黑名单和好友申请
+	if err := i.sdk.Storage().Contacts.Sync(ctx); err != nil {
+		logger.Errorf("app: sync contacts: %v", err)
+	}
 
 	// 创建 Bubble Tea 程序
 	program := tea.NewProgram(tui.InitMainModel(i.sdk), tea.WithAltScreen())
 	i.program = program
 
+	// 注册 SDK 事件回调，桥接到 TUI
+	unsubscribe := i.registerEventCallbacks()
+	defer unsubscribe()
+
 	if _, err := program.Run(); err != nil {
 		return err
 	}
@@ -49,32 +61,59 @@ func (i *ImApp) Start() error {
 	return nil
 }
 
-// registerEventCallbacks 将 SDK 事件转换为 TUI 命令
-func (i *ImApp) registerEventCallbacks() {
-	i.sdk.OnEvent(func(evt im.Event) {
-		switch evt.Type {
-		case im.EventMessageReceived:
-			msg, ok := evt.Data.(*sqllite.ChatMessage)
-			if !ok {
-				return
-			}
+// registerEventCallbacks 将 SDK 事件转换为 TUI 命令, 返回退订函数.
+// Note: 使用阻塞的订阅, 收到的消息不会因为 TUI 处理不过来被丢弃. 代价是 TUI 积压超过
+// appEventBufferSize 个事件时会阻塞 SDK 处理推送, 直到 TUI 跟上或者退订
+func (i *ImApp) registerEventCallbacks() func() {
+	return i.sdk.OnEvent(func(evt im.Event) {
+		switch e := evt.(type) {
+		case im.MessageReceivedEvent:
 			// 更新 TUI：执行 tea.Cmd 得到 tea.Msg 后发送
 			if cmd := tui.FetchUpdatedChatListCmd(i.sdk); cmd != nil {
 				i.program.Send(cmd())
 			}
-			if cmd := tui.FetchUpdateMessage(msg.ChatID, []*sqllite.ChatMessage{msg}); cmd != nil {
+			if cmd := tui.FetchUpdateMessage(e.Message.ChatID, []*sqllite.ChatMessage{e.Message}); cmd != nil {
+				i.program.Send(cmd())
+			}
+		case im.NotificationEvent:
+			// 终端响铃提醒, 免打扰的会话不会发出这个事件
+			fmt.Fprint(os.Stderr, "\a")
+
+		case im.FriendRequestEvent:
+			if e.Request.Status == sqllite.FriendRequestPending && e.Request.ToUserId == conf.UserId {
+				fmt.Fprint(os.Stderr, "\a")
+			}
+			if cmd := tui.FetchFriendRequestsCmd(i.sdk); cmd != nil {
+				i.program.Send(cmd())
+			}
+			if cmd := tui.FetchUpdatedChatListCmd(i.sdk); cmd != nil {
+				i.program.Send(cmd())
+			}
+
+		case im.ChatUpdatedEvent:
+			if cmd := tui.FetchUpdatedChatListCmd(i.sdk); cmd != nil {
 				i.program.Send(cmd())
 			}
-		case im.EventConnected:
+
+		case im.UsersFetchedEvent:
+			i.program.Send(tui.UsersFetchedCmd()())
+
+		case im.KeyChangedEvent:
+			fmt.Fprint(os.Stderr, "\a")
+			i.program.Send(tui.KeyChangedCmd(e)())
+
+		case im.ConnectedEvent:
 			logger.Infof("app: SDK connected")
 
-		case im.EventDisconnected:
+		case im.DisconnectedEvent:
 			logger.Infof("app: SDK disconnected")
 
-		case im.EventError:
-			if err, ok := evt.Data.(error); ok {
-				logger.Errorf("app: SDK error: %v", err)
-			}
+		case im.KickedOutEvent:
+			logger.Warnf("app: kicked out: %v %s", e.Info.Reason, e.Info.Message)
+			i.program.Send(tui.KickedOutCmd(e.Info)())
+
+		case im.ErrorEvent:
+			logger.Errorf("app: SDK error: %v", e.Err)
 		}
-	})
+	}, im.WithBufferSize(appEventBufferSize), im.WithOverflowPolicy(im.OverflowBlock))
 }
@@ -5,12 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/xuning888/helloIMClient/im"
 	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/protocol"
 	"github.com/xuning888/helloIMClient/im/protocol/send"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
@@ -21,6 +23,8 @@ var (
 	startUserId  int64
 	numUsers     int
 	totalPerUser int
+	compression  string
+	msgSize      int
 )
 
 func init() {
@@ -29,6 +33,20 @@ func init() {
 	flag.Int64Var(&startUserId, "from", 100, "起始用户ID")
 	flag.IntVar(&numUsers, "users", 10, "模拟用户数")
 	flag.IntVar(&totalPerUser, "n", 1000, "每用户消息数")
+	flag.StringVar(&compression, "compression", "none", "压缩算法 none|snappy|zstd, 对比不同算法的压缩率和耗时")
+	flag.IntVar(&msgSize, "size", 0, "消息文本的字节数, 为 0 时发送短消息")
+}
+
+// filler 填充长消息的文本, 只包含 ASCII 字符, 按字节截断后仍是合法的 UTF-8
+const filler = " the quick brown fox jumps over the lazy dog"
+
+// messageText 构造 size 字节的文本, 重复的内容接近长文本和合并转发的压缩率
+func messageText(i int, uid int64) string {
+	text := fmt.Sprintf("msg %d from uid %d", i, uid)
+	if len(text) >= msgSize {
+		return text
+	}
+	return text + strings.Repeat(filler, msgSize/len(filler)+1)[:msgSize-len(text)]
 }
 
 func main() {
@@ -36,6 +54,14 @@ func main() {
 	if targetUser == 0 {
 		log.Fatal("请输入目标用户 target")
 	}
+	c, err := protocol.ParseCompression(compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var compressions []protocol.Compression
+	if c != protocol.CompressionNone {
+		compressions = append(compressions, c)
+	}
 
 	if err := logger.InitLogger(); err != nil {
 		log.Fatal(err)
@@ -45,6 +71,14 @@ func main() {
 	var success atomic.Int64
 	var fail atomic.Int64
 	var totalLatency atomic.Int64
+	var statsMu sync.Mutex
+	var compressed, decompressed protocol.CompressionCounter
+	addStats := func(stats im.Stats) {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		compressed = addCounter(compressed, stats.Compressed)
+		decompressed = addCounter(decompressed, stats.Decompressed)
+	}
 
 	start := time.Now()
 	var wg sync.WaitGroup
@@ -55,6 +89,7 @@ func main() {
 			im.WithUID(uid),
 			im.WithConnectTimeout(time.Second*10),
 			im.WithReconnect(false),
+			im.WithCompression(compressions...),
 		)
 		if err != nil {
 			log.Printf("user %d: create sdk failed: %v", uid, err)
@@ -73,11 +108,12 @@ func main() {
 		go func(sdk *im.Client, uid int64) {
 			defer wg.Done()
 			defer sdk.Disconnect(context.Background())
+			defer func() { addStats(sdk.Stats()) }()
 			for i := 0; i < totalPerUser; i++ {
-				p := payload.NewTextMessage(fmt.Sprintf("msg %d from uid %d", i, uid), false, nil)
+				p := payload.NewTextMessage(messageText(i, uid), false, nil)
 				msg := send.NewSendMsg(uid, targetUser, 1, p, 0, 0)
 				reqStart := time.Now()
-				_, err := sdk.SendMessage(context.Background(), msg)
+				_, _, err := sdk.SendMessage(context.Background(), msg)
 				latency := time.Since(reqStart).Microseconds()
 				if err != nil {
 					fail.Add(1)
@@ -102,6 +138,7 @@ func main() {
 	fmt.Printf("Users:             %d\n", numUsers)
 	fmt.Printf("Target:            %d\n", targetUser)
 	fmt.Printf("Per-user msgs:     %d\n", totalPerUser)
+	fmt.Printf("Compression:       %s\n", c)
 	fmt.Printf("Total messages:    %d\n", total)
 	fmt.Printf("Success:           %d\n", succ)
 	fmt.Printf("Failed:            %d\n", f)
@@ -110,5 +147,22 @@ func main() {
 		fmt.Printf("Throughput:        %.2f msg/s\n", float64(succ)/elapsed.Seconds())
 		fmt.Printf("Avg latency:       %.2f ms\n", float64(lat)/float64(succ)/1000)
 	}
+	if compressed.Frames > 0 {
+		fmt.Printf("Compressed:        %d frames, %d -> %d bytes, ratio %.2f, cpu %v\n",
+			compressed.Frames, compressed.RawBytes, compressed.WireBytes, compressed.Ratio(), compressed.CPUTime)
+	}
+	if decompressed.Frames > 0 {
+		fmt.Printf("Decompressed:      %d frames, %d -> %d bytes, ratio %.2f, cpu %v\n",
+			decompressed.Frames, decompressed.WireBytes, decompressed.RawBytes, decompressed.Ratio(), decompressed.CPUTime)
+	}
 	fmt.Println("============================================")
 }
+
+func addCounter(a, b protocol.CompressionCounter) protocol.CompressionCounter {
+	return protocol.CompressionCounter{
+		Frames:    a.Frames + b.Frames,
+		RawBytes:  a.RawBytes + b.RawBytes,
+		WireBytes: a.WireBytes + b.WireBytes,
+		CPUTime:   a.CPUTime + b.CPUTime,
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+var (
+	keyFile    string
+	newKeyFile string
+	plaintext  bool
+)
+
+func init() {
+	flag.Int64Var(&conf.UserId, "userId", 0, "数据库所属的用户ID")
+	flag.StringVar(&keyFile, "key-file", "", "当前的密钥文件, 使用口令时通过环境变量 HELLOIM_DB_PASSPHRASE 提供")
+	flag.StringVar(&newKeyFile, "new-key-file", "", "新的密钥文件, 不存在时生成, 使用口令时通过环境变量 HELLOIM_DB_NEW_PASSPHRASE 提供")
+	flag.BoolVar(&plaintext, "plaintext", false, "解密为明文, 不再加密本地数据库")
+}
+
+// helloIm-rekey 更换本地数据库的密钥, 数据库还没有加密时把已有的数据加密.
+// 运行前需要退出 helloIm
+func main() {
+	flag.Parse()
+	if conf.UserId == 0 {
+		log.Fatal("请输入userId")
+	}
+	oldKey := sqllite.KeySource{Passphrase: os.Getenv("HELLOIM_DB_PASSPHRASE"), KeyFile: keyFile}
+	newKey := sqllite.KeySource{Passphrase: os.Getenv("HELLOIM_DB_NEW_PASSPHRASE"), KeyFile: newKeyFile}
+	if !newKey.Enabled() && !plaintext {
+		log.Fatal("请提供新的密钥 -new-key-file 或者 HELLOIM_DB_NEW_PASSPHRASE, 解密为明文时使用 -plaintext")
+	}
+	if newKey.Enabled() && plaintext {
+		log.Fatal("-plaintext 不能和新的密钥同时使用")
+	}
+	if err := logger.InitLogger(); err != nil {
+		log.Fatal(err)
+	}
+	path, err := dal.Path()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = os.Stat(path); err != nil {
+		log.Fatalf("数据库 %s: %v", path, err)
+	}
+	if err = dal.Init(oldKey); err != nil {
+		log.Fatalf("打开数据库失败: %v", err)
+	}
+	if err = sqllite.Rekey(context.Background(), newKey); err != nil {
+		log.Fatalf("更换密钥失败: %v", err)
+	}
+	if plaintext {
+		fmt.Printf("%s 已解密为明文\n", path)
+		return
+	}
+	fmt.Printf("%s 已更换密钥\n", path)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/capture"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	_ "github.com/xuning888/helloIMClient/im/protocol/chatsync"
+	_ "github.com/xuning888/helloIMClient/im/protocol/contact"
+	"github.com/xuning888/helloIMClient/im/protocol/dump"
+	_ "github.com/xuning888/helloIMClient/im/protocol/push"
+	_ "github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	_ "github.com/xuning888/helloIMClient/im/transport"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	captureFile string
+	replay      bool
+	addr        string
+	speed       float64
+	wait        time.Duration
+	pretty      bool
+)
+
+func init() {
+	flag.StringVar(&captureFile, "file", "", "抓包文件, helloIm -capture 生成")
+	flag.BoolVar(&replay, "replay", false, "把抓包中客户端发出的帧重放到服务端, 输出双方的帧")
+	flag.StringVar(&addr, "addr", "", "重放的长连接地址, 为空时启动内置的测试服务端")
+	flag.Float64Var(&speed, "speed", 1, "重放速度倍数, 0 表示不等待原始的时间间隔")
+	flag.DurationVar(&wait, "wait", time.Second, "重放结束后等待服务端回复的时间")
+	flag.BoolVar(&pretty, "pretty", false, "格式化输出的 JSON")
+}
+
+// entry 一个帧解码后的输出
+type entry struct {
+	Time       string         `json:"time"`
+	Dir        string         `json:"dir"`
+	Req        string         `json:"req"`
+	Seq        int32          `json:"seq"`
+	CmdId      int32          `json:"cmdId"`
+	Cmd        string         `json:"cmd"`
+	BodyLength int32          `json:"bodyLength"`
+	Compress   string         `json:"compression,omitempty"`
+	Type       string         `json:"type,omitempty"`
+	Body       json.Marshaler `json:"body,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func main() {
+	flag.Parse()
+	if captureFile == "" {
+		log.Fatal("请输入抓包文件 -file")
+	}
+	records, err := capture.ReadFile(captureFile)
+	if err != nil {
+		if len(records) == 0 {
+			log.Fatal(err)
+		}
+		// 抓包文件末尾可能因为进程退出不完整, 保留已经读到的记录
+		log.Printf("读取抓包文件出错, 只处理前 %d 条记录: %v", len(records), err)
+	}
+	out := newPrinter()
+	if !replay {
+		for _, record := range records {
+			out.print(decode(record.Time, record.Direction, record.Data))
+		}
+		return
+	}
+	if err := runReplay(records, out); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runReplay 按原始的顺序和时间间隔重放客户端发出的帧, 每个 AUTH 帧使用新的连接, 与客户端重连的行为一致
+func runReplay(records []*capture.Record, out *printer) error {
+	target := addr
+	if target == "" {
+		server := testserver.New()
+		addGroups(server, records)
+		if err := server.Start(); err != nil {
+			return err
+		}
+		defer server.Close()
+		target = server.Addr()
+	}
+
+	var (
+		conn net.Conn
+		wg   sync.WaitGroup
+		last time.Time
+	)
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			wg.Wait()
+			conn = nil
+		}
+	}
+	defer closeConn()
+
+	for _, record := range records {
+		if record.Direction != capture.Outbound {
+			continue
+		}
+		frame, err := protocol.ParseFrame(record.Data)
+		if err != nil {
+			out.print(decode(record.Time, record.Direction, record.Data))
+			continue
+		}
+		if !last.IsZero() && speed > 0 {
+			time.Sleep(time.Duration(float64(record.Time.Sub(last)) / speed))
+		}
+		last = record.Time
+		if conn == nil || isAuth(frame) {
+			if conn != nil {
+				// 等待上一个连接上的回复
+				time.Sleep(wait)
+			}
+			closeConn()
+			if conn, err = net.Dial("tcp", target); err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func(conn net.Conn) {
+				defer wg.Done()
+				for {
+					frame, err := protocol.ReadFrame(conn, protocol.DefaultMaxFrameSize)
+					if err != nil {
+						return
+					}
+					out.print(decode(time.Now(), capture.Inbound, protocol.ToBytes(frame)))
+				}
+			}(conn)
+		}
+		out.print(decode(time.Now(), capture.Outbound, record.Data))
+		if _, err := conn.Write(record.Data); err != nil {
+			return err
+		}
+	}
+	time.Sleep(wait)
+	return nil
+}
+
+func isAuth(frame *protocol.Frame) bool {
+	return frame.Header.Req == protocol.REQ && frame.Header.CmdId == int32(helloim_proto.CmdId_CMD_ID_AUTH)
+}
+
+// addGroups 内置的测试服务端只给已知的群推送, 按抓包中的群聊消息建群
+func addGroups(server *testserver.Server, records []*capture.Record) {
+	for _, record := range records {
+		if record.Direction != capture.Outbound {
+			continue
+		}
+		frame, err := protocol.ParseFrame(record.Data)
+		if err != nil || frame.Header.Req != protocol.REQ || frame.Header.CmdId != int32(helloim_proto.CmdId_CMD_ID_SEND) {
+			continue
+		}
+		req := &helloim_proto.SendPktRequest{}
+		if proto.Unmarshal(frame.Body, req) != nil || req.GetChatType() != 2 {
+			continue
+		}
+		groupId, err1 := strconv.ParseInt(req.GetChatId(), 10, 64)
+		from, err2 := strconv.ParseInt(req.GetFrom(), 10, 64)
+		if err1 == nil && err2 == nil {
+			server.AddGroup(groupId, from)
+		}
+	}
+}
+
+// decode 解码一个帧. 服务端发来的帧使用 protocol.DecodeMessage,
+// 客户端发出的帧是请求或者推送的 ACK, 与注册的解码器方向相反, 按 dump 中登记的类型解码.
+// 类型未知或者解码失败时按字段号输出消息体
+func decode(t time.Time, dir capture.Direction, data []byte) *entry {
+	e := &entry{Time: t.Format("2006-01-02 15:04:05.000000"), Dir: dir.String()}
+	frame, err := protocol.ParseFrame(data)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	h := frame.Header
+	e.Req, e.Seq, e.CmdId, e.BodyLength = "REQ", h.Seq, h.CmdId, h.BodyLength
+	if h.Req == protocol.RES {
+		e.Req = "RES"
+	}
+	e.Cmd = helloim_proto.CmdId(h.CmdId).String()
+	if c := protocol.FrameCompression(h); c != protocol.CompressionNone {
+		e.Compress = c.String()
+		if err = protocol.Decompress(frame); err != nil {
+			e.Error = err.Error()
+			return e
+		}
+	}
+
+	var msg proto.Message
+	if dir == capture.Inbound {
+		msg, err = protocol.DecodeMessage(frame)
+	} else if mt := dump.MessageType(h); mt != nil {
+		msg = mt.New().Interface()
+		err = proto.Unmarshal(frame.Body, msg)
+	} else {
+		err = fmt.Errorf("unsupported cmdId: %d", h.CmdId)
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.Body = dump.Wire(frame.Body)
+		return e
+	}
+	e.Type = string(msg.ProtoReflect().Descriptor().FullName())
+	e.Body = dump.MessageValue(msg.ProtoReflect())
+	return e
+}
+
+// printer 每个帧输出一行 JSON, 重放时收发在不同的 goroutine 中
+type printer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newPrinter() *printer {
+	enc := json.NewEncoder(os.Stdout)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return &printer{enc: enc}
+}
+
+func (p *printer) print(e *entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enc.Encode(e)
+}
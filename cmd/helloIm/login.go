@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/auth"
+	http2 "github.com/xuning888/helloIMClient/im/http"
+)
+
+// login 优先使用保存的 token, 没有保存或者已经失效时用密码登录, 登录成功后设置 conf.UserId
+func login(ctx context.Context) (*auth.Manager, error) {
+	path, err := auth.DefaultPath(conf.UserName)
+	if err != nil {
+		return nil, err
+	}
+	manager := auth.NewManager(path)
+	if logout {
+		if err = manager.Logout(); err != nil {
+			return nil, err
+		}
+	}
+	http2.Init(conf.ServerUrl, time.Second*10)
+	err = manager.Load()
+	if err == nil {
+		err = manager.EnsureValid(ctx)
+	}
+	if errors.Is(err, auth.ErrLoginRequired) {
+		var password string
+		if password, err = readPassword(); err != nil {
+			return nil, err
+		}
+		err = manager.Login(ctx, conf.UserName, password)
+		if errors.Is(err, http2.ErrUnauthorized) {
+			return nil, errors.New("用户名或密码错误")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	conf.UserId = manager.Token().UserId
+	return manager, nil
+}
+
+// readPassword 密码从环境变量 HELLOIM_PASSWORD 读取, 没有设置时从终端读取, 不回显
+func readPassword() (string, error) {
+	if password := os.Getenv("HELLOIM_PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprintf(os.Stderr, "%s 的密码: ", conf.UserName)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		password, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
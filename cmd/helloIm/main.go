@@ -1,27 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
 	"time"
 
 	"github.com/xuning888/helloIMClient/app"
 	"github.com/xuning888/helloIMClient/conf"
 	"github.com/xuning888/helloIMClient/im"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
+var logout bool
+
 func init() {
-	flag.Int64Var(&conf.UserId, "userId", 0, "-userId userId")
 	flag.StringVar(&conf.UserName, "username", "", "-username username")
 	flag.StringVar(&conf.ServerUrl, "serverUrl", "http://127.0.0.1:8087", "-serverUrl http://127.0.0.1:8087")
+	flag.StringVar(&conf.VoicePlayer, "voicePlayer", "ffplay -nodisp -autoexit", "-voicePlayer \"ffplay -nodisp -autoexit\"")
+	flag.BoolVar(&conf.RichText, "richText", true, "-richText=false 按原文展示 markdown 消息")
+	flag.StringVar(&conf.CaptureFile, "capture", "", "-capture /tmp/helloIm.cap 记录收发的帧, 用 helloIm-replay 查看")
+	flag.BoolVar(&conf.TraceFrames, "trace-frames", false, "--trace-frames 把收发的帧解码成 JSON 输出到日志")
+	flag.BoolVar(&conf.E2E, "e2e", false, "-e2e 单聊开启端到端加密, 双方都开启后才能发送消息")
+	flag.BoolVar(&logout, "logout", false, "-logout 删除保存的 token, 重新使用密码登录")
+	flag.StringVar(&conf.DBKeyFile, "db-key-file", "", "-db-key-file ~/.helloIm/db.key 加密本地数据库, 也可以通过环境变量 HELLOIM_DB_PASSPHRASE 提供口令")
 }
 
 func main() {
 	flag.Parse()
-	if conf.UserId == 0 {
-		log.Fatal("请输userId")
-	}
+	conf.DBPassphrase = os.Getenv("HELLOIM_DB_PASSPHRASE")
 	if conf.UserName == "" {
 		log.Fatal("请输入用户名")
 	}
@@ -32,10 +41,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	manager, err := login(context.Background())
+	if err != nil {
+		log.Fatalf("登录失败: %v", err)
+	}
+
 	// 使用 SDK 创建客户端
 	sdk, err := im.New(conf.ServerUrl,
 		im.WithUID(conf.UserId),
+		im.WithTokenSource(manager),
 		im.WithConnectTimeout(time.Second*10),
+		im.WithCaptureFile(conf.CaptureFile),
+		im.WithTraceFrames(conf.TraceFrames),
+		im.WithE2E(conf.E2E),
+		im.WithDBKey(sqllite.KeySource{Passphrase: conf.DBPassphrase, KeyFile: conf.DBKeyFile}),
 	)
 	if err != nil {
 		log.Fatal(err)
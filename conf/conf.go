@@ -4,4 +4,18 @@ var (
 	UserId    int64
 	UserName  string
 	ServerUrl string
+	// VoicePlayer 播放语音消息的外部命令, 语音文件的路径会作为最后一个参数追加
+	VoicePlayer string
+	// RichText 是否渲染 markdown 消息, 关闭时按原文展示
+	RichText bool
+	// CaptureFile 记录收发帧的抓包文件, 为空时不抓包
+	CaptureFile string
+	// TraceFrames 把收发的帧解码成 JSON 输出到日志
+	TraceFrames bool
+	// E2E 单聊开启端到端加密
+	E2E bool
+	// DBKeyFile 本地数据库的密钥文件, 不存在时生成
+	DBKeyFile string
+	// DBPassphrase 本地数据库的口令, 从环境变量 HELLOIM_DB_PASSPHRASE 读取, 避免出现在命令行参数中
+	DBPassphrase string
 )
@@ -0,0 +1,236 @@
+// Package auth 登录以及 token 的保存和刷新
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	http2 "github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// ErrLoginRequired 没有保存的 token 或者 refresh token 已经失效, 需要使用密码重新登录
+var ErrLoginRequired = errors.New("login required")
+
+// refreshBefore access token 过期前多久主动刷新
+const refreshBefore = 30 * time.Second
+
+// DefaultPath token 的保存路径 ~/.helloIm/tokens/<userName>.json
+func DefaultPath(userName string) (string, error) {
+	if userName == "" || strings.ContainsAny(userName, `/\`) || userName == "." || userName == ".." {
+		return "", fmt.Errorf("invalid user name %q", userName)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".helloIm", "tokens", userName+".json"), nil
+}
+
+// Manager 管理登录得到的 token, 实现了 im.TokenSource.
+// token 保存在只有本人可读写的文件中, 刷新后覆盖
+type Manager struct {
+	path string
+
+	mu    sync.Mutex
+	token *http2.Token
+	// onRefresh 刷新成功后的回调, 用于长连接重新认证
+	onRefresh []func()
+	// refreshing 正在进行的刷新, 为 nil 时没有刷新
+	refreshing *refreshCall
+}
+
+// refreshCall 一次刷新请求, 并发的 Refresh 等待同一次请求的结果
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+func NewManager(path string) *Manager {
+	return &Manager{path: path}
+}
+
+// Load 读取保存的 token, 没有保存时返回 ErrLoginRequired
+func (m *Manager) Load() error {
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrLoginRequired
+	}
+	if err != nil {
+		return err
+	}
+	token := &http2.Token{}
+	if err = json.Unmarshal(data, token); err != nil {
+		return fmt.Errorf("token file %s: %w", m.path, err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		return ErrLoginRequired
+	}
+	m.mu.Lock()
+	m.token = token
+	m.mu.Unlock()
+	return nil
+}
+
+// Login 使用用户名和密码登录并保存 token
+func (m *Manager) Login(ctx context.Context, userName, password string) error {
+	token, err := http2.Login(ctx, userName, password)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.setToken(token)
+}
+
+// Logout 删除保存的 token
+func (m *Manager) Logout() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = nil
+	if err := os.Remove(m.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Token 当前的 token, 没有登录时返回 nil
+func (m *Manager) Token() *http2.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == nil {
+		return nil
+	}
+	token := *m.token
+	return &token
+}
+
+// AccessToken 当前的 access token
+func (m *Manager) AccessToken() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == nil {
+		return ""
+	}
+	return m.token.AccessToken
+}
+
+// EnsureValid access token 即将过期时提前刷新, refresh token 失效时返回 ErrLoginRequired
+func (m *Manager) EnsureValid(ctx context.Context) error {
+	token := m.Token()
+	if token == nil {
+		return ErrLoginRequired
+	}
+	if token.ExpiresAt == 0 || time.Until(time.UnixMilli(token.ExpiresAt)) > refreshBefore {
+		return nil
+	}
+	return m.Refresh(ctx, token.AccessToken)
+}
+
+// Refresh 刷新 token. 并发的请求同时收到 401 时只刷新一次:
+// stale 与当前的 access token 不同说明已经被其他请求刷新过, 直接返回;
+// 正在刷新时等待这次刷新的结果. 请求服务端期间不持有锁, 不阻塞 AccessToken
+func (m *Manager) Refresh(ctx context.Context, stale string) error {
+	m.mu.Lock()
+	if m.token == nil {
+		m.mu.Unlock()
+		return ErrLoginRequired
+	}
+	if stale != "" && stale != m.token.AccessToken {
+		m.mu.Unlock()
+		return nil
+	}
+	if call := m.refreshing; call != nil {
+		m.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	m.refreshing = call
+	refreshToken := m.token.RefreshToken
+	m.mu.Unlock()
+
+	token, err := m.refresh(ctx, refreshToken)
+	m.mu.Lock()
+	m.refreshing = nil
+	callbacks := append([]func(){}, m.onRefresh...)
+	m.mu.Unlock()
+	call.err = err
+	close(call.done)
+	if err != nil || token == nil {
+		return err
+	}
+	logger.Infof("token refreshed, expires at %s", time.UnixMilli(token.ExpiresAt).Format(time.DateTime))
+	for _, f := range callbacks {
+		f()
+	}
+	return nil
+}
+
+// refresh 使用 refreshToken 获取并保存新的 token, 刷新期间重新登录时丢弃结果并返回 nil
+func (m *Manager) refresh(ctx context.Context, refreshToken string) (*http2.Token, error) {
+	token, err := http2.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		if errors.Is(err, http2.ErrUnauthorized) {
+			return nil, fmt.Errorf("%w: %v", ErrLoginRequired, err)
+		}
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 刷新期间退出登录
+	if m.token == nil {
+		return nil, ErrLoginRequired
+	}
+	if m.token.RefreshToken != refreshToken {
+		return nil, nil
+	}
+	if err = m.setToken(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// OnRefresh 注册刷新成功后的回调, 回调在刷新的 goroutine 中同步执行
+func (m *Manager) OnRefresh(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRefresh = append(m.onRefresh, f)
+}
+
+// setToken 更新并保存 token, 需要持有 mu
+func (m *Manager) setToken(token *http2.Token) error {
+	m.token = token
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+	// 先写临时文件再改名, 避免写到一半时退出留下损坏的文件
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
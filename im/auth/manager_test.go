@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	http2 "github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	"github.com/xuning888/helloIMClient/pkg"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func newServer(t *testing.T) *testserver.Server {
+	t.Helper()
+	logger.InitLogger()
+	server := testserver.New(
+		testserver.WithUsers(&sqllite.ImUser{UserID: 1, UserName: "user1"}),
+		testserver.WithRequireToken(true),
+		testserver.WithLogin(func(userName, password string) (int64, bool) {
+			return 1, userName == "user1" && password == "secret"
+		}),
+	)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	http2.Init(server.URL(), time.Second*3)
+	t.Cleanup(func() { http2.SetTokenSource(nil) })
+	return server
+}
+
+func TestManager_LoginAndLoad(t *testing.T) {
+	newServer(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens", "user1.json")
+	manager := NewManager(path)
+	assert.ErrorIs(t, manager.Load(), ErrLoginRequired)
+	assert.ErrorIs(t, manager.Login(ctx, "user1", "wrong"), http2.ErrUnauthorized)
+	assert.Nil(t, manager.Login(ctx, "user1", "secret"))
+	assert.Equal(t, int64(1), manager.Token().UserId)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded := NewManager(path)
+	assert.Nil(t, loaded.Load())
+	assert.Equal(t, manager.AccessToken(), loaded.AccessToken())
+
+	assert.Nil(t, loaded.Logout())
+	assert.ErrorIs(t, NewManager(path).Load(), ErrLoginRequired)
+}
+
+func TestManager_RefreshOnUnauthorized(t *testing.T) {
+	server := newServer(t)
+	ctx := context.Background()
+	manager := NewManager(filepath.Join(t.TempDir(), "user1.json"))
+	assert.Nil(t, manager.Login(ctx, "user1", "secret"))
+	http2.SetTokenSource(manager)
+	var refreshed sync.WaitGroup
+	refreshed.Add(1)
+	manager.OnRefresh(refreshed.Done)
+
+	// 并发的请求同时收到 401 时只刷新一次
+	server.ExpireAccessTokens()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := http2.SyncUsers(ctx, 0, 10)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	refreshed.Wait()
+	assert.Equal(t, int64(1), server.Refreshes())
+
+	// refresh token 被吊销后需要重新登录
+	server.RevokeTokens(1)
+	_, err := http2.SyncUsers(ctx, 0, 10)
+	assert.NotNil(t, err)
+	err = manager.Refresh(ctx, manager.AccessToken())
+	assert.True(t, errors.Is(err, ErrLoginRequired), err)
+}
+
+func TestManager_EnsureValid(t *testing.T) {
+	server := newServer(t)
+	ctx := context.Background()
+	manager := NewManager(filepath.Join(t.TempDir(), "user1.json"))
+	assert.ErrorIs(t, manager.EnsureValid(ctx), ErrLoginRequired)
+	assert.Nil(t, manager.Login(ctx, "user1", "secret"))
+	assert.Nil(t, manager.EnsureValid(ctx))
+	assert.Equal(t, int64(0), server.Refreshes())
+
+	expired := manager.Token()
+	expired.ExpiresAt = time.Now().UnixMilli()
+	manager.mu.Lock()
+	manager.token = expired
+	manager.mu.Unlock()
+	assert.Nil(t, manager.EnsureValid(ctx))
+	assert.Equal(t, int64(1), server.Refreshes())
+}
+
+func TestManager_RefreshInFlight(t *testing.T) {
+	logger.InitLogger()
+	release := make(chan struct{})
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pkg.RestResult[*http2.Token]{Data: &http2.Token{
+			UserId: 1, AccessToken: "at-new", RefreshToken: "rt-new",
+		}})
+	}))
+	defer server.Close()
+	http2.Init(server.URL, time.Second*3)
+
+	manager := NewManager(filepath.Join(t.TempDir(), "user1.json"))
+	manager.token = &http2.Token{UserId: 1, AccessToken: "at-old", RefreshToken: "rt-old"}
+	var refreshed atomic.Int64
+	manager.OnRefresh(func() { refreshed.Add(1) })
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, manager.Refresh(ctx, ""))
+		}()
+	}
+	assert.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+	// 请求服务端期间不持有锁
+	assert.Equal(t, "at-old", manager.AccessToken())
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), requests.Load())
+	assert.Equal(t, int64(1), refreshed.Load())
+	assert.Equal(t, "at-new", manager.AccessToken())
+}
@@ -0,0 +1,152 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Direction 帧的方向
+type Direction byte
+
+const (
+	Outbound Direction = 'O' // 客户端发往服务端
+	Inbound  Direction = 'I' // 服务端发往客户端
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Outbound:
+		return "out"
+	case Inbound:
+		return "in"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(d))
+}
+
+// 每条记录: magic(1) + 时间戳微秒(8) + 方向(1) + 帧长度(4) + 帧
+const (
+	recordMagic      byte = 0xC1
+	recordHeaderSize      = 14
+	// maxRecordSize 单条记录的帧长度上限, 超过时认为文件损坏
+	maxRecordSize = 16 << 20
+)
+
+// Record 一条抓包记录, Data 是包含固定消息头的完整帧
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+}
+
+// Recorder 把收发的帧写入按大小滚动的文件, 可以并发调用
+type Recorder struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+	buf []byte
+}
+
+// NewRecorder 写入 filename, 单个文件超过 maxSizeMB 后滚动, 最多保留 maxBackups 个旧文件
+func NewRecorder(filename string, maxSizeMB, maxBackups int) *Recorder {
+	return &Recorder{
+		out: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+		},
+	}
+}
+
+// Record 记录一个完整帧, 写入失败时丢弃
+func (r *Recorder) Record(dir Direction, frame []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 一次 Write 写出整条记录, 滚动不会把记录拆到两个文件里
+	r.buf = appendRecord(r.buf[:0], time.Now(), dir, frame)
+	r.out.Write(r.buf)
+}
+
+// Close 关闭文件
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.out.Close()
+}
+
+func appendRecord(buf []byte, t time.Time, dir Direction, frame []byte) []byte {
+	buf = append(buf, recordMagic)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(t.UnixMicro()))
+	buf = append(buf, byte(dir))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(frame)))
+	return append(buf, frame...)
+}
+
+// Reader 按顺序读取抓包记录
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next 读取下一条记录, 读完时返回 io.EOF
+func (r *Reader) Next() (*Record, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated record header: %w", err)
+		}
+		return nil, err
+	}
+	if header[0] != recordMagic {
+		return nil, fmt.Errorf("invalid record magic: %#x", header[0])
+	}
+	size := binary.BigEndian.Uint32(header[10:14])
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record too large: %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("truncated record: %w", err)
+	}
+	return &Record{
+		Time:      time.UnixMicro(int64(binary.BigEndian.Uint64(header[1:9]))),
+		Direction: Direction(header[9]),
+		Data:      data,
+	}, nil
+}
+
+// ReadFile 读取抓包文件中的全部记录
+func ReadFile(filename string) ([]*Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := NewReader(f)
+	var records []*Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
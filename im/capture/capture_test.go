@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_RoundTrip(t *testing.T) {
+	now := time.UnixMicro(time.Now().UnixMicro())
+	var buf []byte
+	buf = appendRecord(buf, now, Outbound, []byte("hello"))
+	buf = appendRecord(buf, now.Add(time.Millisecond), Inbound, nil)
+
+	reader := NewReader(bytes.NewReader(buf))
+	record, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, &Record{Time: now, Direction: Outbound, Data: []byte("hello")}, record)
+	record, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, Inbound, record.Direction)
+	assert.Equal(t, now.Add(time.Millisecond), record.Time)
+	assert.Empty(t, record.Data)
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// 截断的记录和错误的 magic 都返回错误而不是 io.EOF
+	reader = NewReader(bytes.NewReader(buf[:len(buf)-1]))
+	_, err = reader.Next()
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err)
+	_, err = NewReader(bytes.NewReader(buf[:3])).Next()
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err)
+	_, err = NewReader(bytes.NewReader(append([]byte{0}, buf[1:]...))).Next()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,62 @@
+package im
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	pb "github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol/chatsync"
+)
+
+// chatSettings 会话上需要在多个设备之间同步的设置
+func chatSettings(chat *sqllite.ImChat) *pb.ChatSettings {
+	return &pb.ChatSettings{
+		ChatId:        strconv.FormatInt(chat.ChatId, 10),
+		ChatType:      chat.ChatType,
+		Top:           chat.ChatTop,
+		Mute:          chat.ChatMute,
+		Del:           chat.ChatDel,
+		DelTimestamp:  chat.DelTimestamp,
+		LastReadMsgId: chat.LastReadMsgId,
+		Archive:       chat.ChatArchive,
+	}
+}
+
+// applyChatSettings 把其他设备上的修改合并到本地的会话, 已读游标只前进
+func applyChatSettings(chat *sqllite.ImChat, settings *pb.ChatSettings) {
+	chat.ChatTop = settings.GetTop()
+	chat.ChatMute = settings.GetMute()
+	chat.ChatDel = settings.GetDel()
+	chat.ChatArchive = settings.GetArchive()
+	chat.DelTimestamp = max(chat.DelTimestamp, settings.GetDelTimestamp())
+	chat.LastReadMsgId = max(chat.LastReadMsgId, settings.GetLastReadMsgId())
+}
+
+// SyncChat 把会话的设置同步给同一个账号的其他设备
+func (c *Client) SyncChat(ctx context.Context, chats ...*sqllite.ImChat) error {
+	if len(chats) == 0 {
+		return nil
+	}
+	settings := make([]*pb.ChatSettings, 0, len(chats))
+	for _, chat := range chats {
+		settings = append(settings, chatSettings(chat))
+	}
+	_, err := c.connManager.transport.Send(ctx, chatsync.NewSyncMsg(settings...))
+	return err
+}
+
+// MarkRead 把会话的已读游标移动到 msgId 并同步给其他设备, 游标只前进, 重复调用没有影响
+func (c *Client) MarkRead(ctx context.Context, chatId int64, chatType int32, msgId int64) error {
+	chat, err := c.store.Chats.GetOrCreate(ctx, chatId, chatType)
+	if err != nil {
+		return err
+	}
+	if msgId > chat.LastReadMsgId {
+		chat.LastReadMsgId = msgId
+		if err = c.store.Chats.Save(ctx, chat); err != nil {
+			return err
+		}
+	}
+	return c.SyncChat(ctx, chat)
+}
@@ -22,12 +22,12 @@ const (
 
 type connManager struct {
 	transport *transport.Client
-	events    *callbackRegistry
+	events    *eventBus
 	state     atomic.Int32
 	closeOnce sync.Once
 }
 
-func newConnManager(tr *transport.Client, events *callbackRegistry) *connManager {
+func newConnManager(tr *transport.Client, events *eventBus) *connManager {
 	return &connManager{
 		transport: tr,
 		events:    events,
@@ -36,16 +36,16 @@ func newConnManager(tr *transport.Client, events *callbackRegistry) *connManager
 
 func (c *connManager) Connect(ctx context.Context) error {
 	c.state.Store(int32(StateConnecting))
-	c.events.fire(Event{Type: EventConnecting})
+	c.events.publish(ConnectingEvent{})
 
 	if err := c.transport.Connect(ctx); err != nil {
 		c.state.Store(int32(StateDisconnected))
-		c.events.fire(Event{Type: EventDisconnected})
+		c.events.publish(DisconnectedEvent{})
 		return err
 	}
 
 	c.state.Store(int32(StateConnected))
-	c.events.fire(Event{Type: EventConnected})
+	c.events.publish(ConnectedEvent{})
 	return nil
 }
 
@@ -66,11 +66,17 @@ func (c *connManager) Disconnect(ctx context.Context) error {
 			logger.Errorf("connManager: disconnect timeout")
 		}
 		c.state.Store(int32(StateDisconnected))
-		c.events.fire(Event{Type: EventDisconnected})
+		c.events.publish(DisconnectedEvent{})
 	})
 	return err
 }
 
+// kickedOut 被服务端踢下线, transport 不再重连
+func (c *connManager) kickedOut(info *KickedOut) {
+	c.state.Store(int32(StateDisconnected))
+	c.events.publish(KickedOutEvent{Info: info})
+}
+
 func (c *connManager) State() ConnState {
 	return ConnState(c.state.Load())
 }
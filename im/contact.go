@@ -0,0 +1,30 @@
+package im
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	pb "github.com/xuning888/helloIMClient/im/proto"
+)
+
+// friendRequest 把推送的好友申请转换为本地保存的结构
+func friendRequest(request *pb.FriendRequest) (*sqllite.FriendRequest, error) {
+	from, err := strconv.ParseInt(request.GetFrom(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse from: %w", err)
+	}
+	to, err := strconv.ParseInt(request.GetTo(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse to: %w", err)
+	}
+	return &sqllite.FriendRequest{
+		RequestId:    request.GetRequestId(),
+		FromUserId:   from,
+		ToUserId:     to,
+		FromUserName: request.GetFromUserName(),
+		Message:      request.GetMessage(),
+		Status:       request.GetStatus(),
+		Timestamp:    request.GetTimestamp(),
+	}, nil
+}
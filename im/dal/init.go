@@ -9,17 +9,26 @@ import (
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 )
 
-func Init() error {
+// Path 当前用户的数据库文件 ~/.helloIm/<uid>/data.db
+func Path() (string, error) {
 	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".helloIm", fmt.Sprintf("%d", conf.UserId), "data.db"), nil
+}
+
+// Init 打开当前用户的数据库, key 为空时不加密
+func Init(key sqllite.KeySource) error {
+	absPath, err := Path()
 	if err != nil {
 		return err
 	}
-	absPath := filepath.Join(homeDir, ".helloIm", fmt.Sprintf("%d", conf.UserId), "data.db")
 	dir := filepath.Dir(absPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("create dir: %w", err)
 	}
-	err = sqllite.Init(absPath)
+	err = sqllite.Init(absPath, key)
 	if err != nil {
 		return err
 	}
@@ -20,11 +20,14 @@ type ImChat struct {
 	ChatTop            bool  `gorm:"column:chat_top;default:0" json:"chatTop"`
 	ChatMute           bool  `gorm:"column:chat_mute;default:0" json:"chatMute"`
 	ChatDel            bool  `gorm:"column:chat_del;default:0" json:"chatDel"`
+	ChatArchive        bool  `gorm:"column:chat_archive;default:0" json:"chatArchive"`
 	UpdateTimestamp    int64 `gorm:"column:update_timestamp;default:0" json:"updateTimestamp"`
 	DelTimestamp       int64 `gorm:"column:del_timestamp;default:0" json:"delTimestamp"`
 	LastReadMsgId      int64 `gorm:"column:last_read_msg_id;default:0" json:"lastReadMsgId"`
 	SubStatus          int   `gorm:"column:sub_status;default:0" json:"subStatus"`
 	JoinGroupTimestamp int64 `gorm:"column:join_group_timestamp;default:0" json:"joinGroupTimestamp"`
+	// DraftTimestamp 草稿的修改时间, 不保存在 im_chat 中, 查询会话列表时从 chat_draft 填充
+	DraftTimestamp int64 `gorm:"-" json:"-"`
 }
 
 func (ImChat) TableName() string {
@@ -60,16 +63,22 @@ func NewImChat(userId, chatId int64, chatType int32) *ImChat {
 	return chat
 }
 
-// SortChatList 会话列表排序
+// SortChatList 会话列表排序, 置顶的在前, 然后按最后一条消息和草稿中较新的时间倒序
 func SortChatList(chats []*ImChat) {
 	sort.Slice(chats, func(i, j int) bool {
 		if chats[i].ChatTop != chats[j].ChatTop {
 			return chats[i].ChatTop
 		}
-		return chats[i].UpdateTimestamp > chats[j].UpdateTimestamp
+		return chats[i].sortTimestamp() > chats[j].sortTimestamp()
 	})
 }
 
+func (c *ImChat) sortTimestamp() int64 {
+	return max(c.UpdateTimestamp, c.DraftTimestamp)
+}
+
+// BatchUpdate 合并服务端返回的会话, 不存在时插入.
+// 已经存在的会话只更新服务端维护的列, 归档状态只在本地修改; 更新时间、已读游标和删除时间只前进
 func BatchUpdate(ctx context.Context, chats []*ImChat) error {
 	if len(chats) == 0 {
 		return nil
@@ -92,15 +101,30 @@ func BatchUpdate(ctx context.Context, chats []*ImChat) error {
 		}
 	}
 	for _, chat := range updates {
+		// 使用 map 更新, 其他设备上取消置顶、免打扰这样的零值也要同步
 		if err := DB.WithContext(ctx).Model(&ImChat{}).
 			Where("user_id = ? AND chat_id = ?", chat.UserId, chat.ChatId).
-			Updates(chat).Error; err != nil {
+			Updates(map[string]any{
+				"chat_top":             chat.ChatTop,
+				"chat_mute":            chat.ChatMute,
+				"chat_del":             chat.ChatDel,
+				"sub_status":           chat.SubStatus,
+				"join_group_timestamp": chat.JoinGroupTimestamp,
+				"update_timestamp":     gorm.Expr("MAX(update_timestamp, ?)", chat.UpdateTimestamp),
+				"last_read_msg_id":     gorm.Expr("MAX(last_read_msg_id, ?)", chat.LastReadMsgId),
+				"del_timestamp":        gorm.Expr("MAX(del_timestamp, ?)", chat.DelTimestamp),
+			}).Error; err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// SaveChat 保存会话的所有字段, 取消置顶这样的零值也会写入, 不存在时插入
+func SaveChat(ctx context.Context, chat *ImChat) error {
+	return DB.WithContext(ctx).Save(chat).Error
+}
+
 func SelectChat(ctx context.Context, userId, chatId int64) (*ImChat, error) {
 	chat := &ImChat{}
 	err := DB.WithContext(ctx).Model(&ImChat{}).
@@ -113,11 +137,21 @@ func SelectChat(ctx context.Context, userId, chatId int64) (*ImChat, error) {
 }
 
 // MultiGetChat
-// Note: 查询100条会话
+// Note: 查询100条会话, 不包括已删除、已归档和与黑名单中的用户的会话
 func MultiGetChat(ctx context.Context) ([]*ImChat, error) {
+	return multiGetChat(ctx, false)
+}
+
+// MultiGetArchivedChat 查询100条已归档的会话
+func MultiGetArchivedChat(ctx context.Context) ([]*ImChat, error) {
+	return multiGetChat(ctx, true)
+}
+
+func multiGetChat(ctx context.Context, archived bool) ([]*ImChat, error) {
 	var chats = make([]*ImChat, 0)
 	res := DB.WithContext(ctx).Model(&ImChat{}).
-		Where("user_id = ?", conf.UserId).
+		Where("user_id = ? AND chat_del = ? AND chat_archive = ?", conf.UserId, false, archived).
+		Where("NOT (chat_type = ? AND chat_id IN (?))", 1, blockedContactIds(DB.WithContext(ctx))).
 		Order("chat_top desc").
 		Order("update_timestamp desc").
 		Limit(100).Find(&chats)
@@ -128,6 +162,15 @@ func MultiGetChat(ctx context.Context) ([]*ImChat, error) {
 		}
 		return nil, err
 	}
+	drafts, err := MultiGetDraft(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, chat := range chats {
+		if draft, ok := drafts[chat.Key()]; ok {
+			chat.DraftTimestamp = draft.UpdateTimestamp
+		}
+	}
 	SortChatList(chats)
 	logger.Infof("MultiGetChat chats: %v", chats)
 	return chats, nil
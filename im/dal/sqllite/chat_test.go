@@ -0,0 +1,64 @@
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestMultiGetChat_DeletedAndArchived(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	ctx := context.Background()
+
+	chats := []*ImChat{NewImChat(1, 2, 1), NewImChat(1, 3, 1), NewImChat(1, 4, 1)}
+	chats[1].ChatArchive = true
+	chats[2].ChatDel = true
+	for _, chat := range chats {
+		if err := SaveChat(ctx, chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := MultiGetChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ChatId != 2 {
+		t.Fatalf("chats = %v", list)
+	}
+	archived, err := MultiGetArchivedChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].ChatId != 3 {
+		t.Fatalf("archived = %v", archived)
+	}
+
+	// 服务端的会话不覆盖本地的归档状态和删除时间, 取消置顶这样的零值也要写入
+	chats[0].ChatTop = true
+	chats[0].DelTimestamp = 2000
+	if err = SaveChat(ctx, chats[0]); err != nil {
+		t.Fatal(err)
+	}
+	remote := []*ImChat{NewImChat(1, 2, 1), NewImChat(1, 3, 1)}
+	remote[0].DelTimestamp = 1000
+	if err = BatchUpdate(ctx, remote); err != nil {
+		t.Fatal(err)
+	}
+	chat, err := SelectChat(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.ChatTop || chat.DelTimestamp != 2000 {
+		t.Fatalf("chat after remote update = %v", chat)
+	}
+	if archived, _ = MultiGetArchivedChat(ctx); len(archived) != 1 || archived[0].ChatId != 3 {
+		t.Fatalf("archived after remote update = %v", archived)
+	}
+}
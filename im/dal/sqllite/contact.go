@@ -0,0 +1,188 @@
+package sqllite
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 好友申请的状态
+const (
+	FriendRequestPending int32 = iota
+	FriendRequestAccepted
+	FriendRequestRejected
+)
+
+// Contact 映射到 contact 表, 当前用户的好友和黑名单, 拉黑的用户不一定是好友
+type Contact struct {
+	UserId          int64 `gorm:"column:user_id;primaryKey;default:0" json:"userId"`
+	ContactId       int64 `gorm:"column:contact_id;primaryKey;default:0" json:"contactId"`
+	Friend          bool  `gorm:"column:friend;not null;default:false" json:"friend"`
+	Blocked         bool  `gorm:"column:blocked;not null;default:false" json:"blocked"`
+	UpdateTimestamp int64 `gorm:"column:update_timestamp;default:0" json:"updateTimestamp"`
+	// User 服务端返回联系人时附带的用户资料, 保存在 im_user 表
+	User *ImUser `gorm:"-" json:"user,omitempty"`
+}
+
+func (Contact) TableName() string {
+	return "contact"
+}
+
+// FriendRequest 映射到 friend_request 表, 发出和收到的好友申请
+type FriendRequest struct {
+	RequestId    int64  `gorm:"column:request_id;primaryKey;default:0" json:"requestId"`
+	UserId       int64  `gorm:"column:user_id;index;default:0" json:"userId"`
+	FromUserId   int64  `gorm:"column:from_user_id;default:0" json:"fromUserId"`
+	ToUserId     int64  `gorm:"column:to_user_id;default:0" json:"toUserId"`
+	FromUserName string `gorm:"column:from_user_name;not null;default:''" json:"fromUserName"`
+	Message      string `gorm:"column:message;not null;default:''" json:"message"`
+	Status       int32  `gorm:"column:status;not null;default:0" json:"status"`
+	Timestamp    int64  `gorm:"column:timestamp;default:0" json:"timestamp"`
+}
+
+func (FriendRequest) TableName() string {
+	return "friend_request"
+}
+
+// ReplaceContacts 用服务端的联系人列表覆盖本地的联系人, 同时保存联系人的用户资料
+func ReplaceContacts(ctx context.Context, contacts []*Contact) error {
+	users := make([]*ImUser, 0, len(contacts))
+	for _, contact := range contacts {
+		contact.UserId = conf.UserId
+		if contact.User != nil {
+			users = append(users, contact.User)
+		}
+	}
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", conf.UserId).Delete(&Contact{}).Error; err != nil {
+			return err
+		}
+		if len(contacts) > 0 {
+			if err := tx.Create(&contacts).Error; err != nil {
+				return err
+			}
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		return upsertUsers(tx, users)
+	})
+}
+
+// SaveContact 保存联系人的所有字段, 取消拉黑这样的零值也会写入
+func SaveContact(ctx context.Context, contact *Contact) error {
+	contact.UserId = conf.UserId
+	return DB.WithContext(ctx).Save(contact).Error
+}
+
+// GetContact 联系人, 不存在时返回 nil
+func GetContact(ctx context.Context, contactId int64) (*Contact, error) {
+	contact := &Contact{}
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND contact_id = ?", conf.UserId, contactId).
+		First(contact).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// IsBlocked uid 是否在黑名单中
+func IsBlocked(ctx context.Context, uid int64) (bool, error) {
+	var count int64
+	err := DB.WithContext(ctx).Model(&Contact{}).
+		Where("user_id = ? AND contact_id = ? AND blocked = ?", conf.UserId, uid, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// blockedContactIds 黑名单中用户id的子查询
+func blockedContactIds(db *gorm.DB) *gorm.DB {
+	return db.Model(&Contact{}).Select("contact_id").
+		Where("user_id = ? AND blocked = ?", conf.UserId, true)
+}
+
+// GetFriends 没有被拉黑的好友, 按用户名排序
+func GetFriends(ctx context.Context) ([]*ImUser, error) {
+	return SearchContacts(ctx, "")
+}
+
+// GetBlockedUsers 黑名单中的用户
+func GetBlockedUsers(ctx context.Context) ([]*ImUser, error) {
+	var users []*ImUser
+	err := DB.WithContext(ctx).
+		Where("user_id IN (?)", blockedContactIds(DB.WithContext(ctx))).
+		Order("user_name ASC").
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SearchContacts 在没有被拉黑的好友中按用户名搜索, key 为空时返回所有好友
+func SearchContacts(ctx context.Context, key string) ([]*ImUser, error) {
+	var users []*ImUser
+	db := DB.WithContext(ctx).
+		Joins("JOIN contact ON contact.contact_id = im_user.user_id").
+		Where("contact.user_id = ? AND contact.friend = ? AND contact.blocked = ?", conf.UserId, true, false)
+	if key = strings.TrimSpace(key); key != "" {
+		db = db.Where("im_user.user_name like ?", "%"+key+"%").Limit(20)
+	}
+	if err := db.Order("im_user.user_name ASC").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SaveFriendRequests 保存好友申请, 已存在时更新状态
+func SaveFriendRequests(ctx context.Context, requests ...*FriendRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	for _, request := range requests {
+		request.UserId = conf.UserId
+	}
+	return DB.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "request_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"from_user_name", "message", "status", "timestamp"}),
+		},
+	).Create(&requests).Error
+}
+
+// GetFriendRequest 好友申请, 不存在时返回 nil
+func GetFriendRequest(ctx context.Context, requestId int64) (*FriendRequest, error) {
+	request := &FriendRequest{}
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND request_id = ?", conf.UserId, requestId).
+		First(request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetPendingFriendRequests 收到的待处理的好友申请, 新的在前, 不包括黑名单中的用户发来的申请
+func GetPendingFriendRequests(ctx context.Context) ([]*FriendRequest, error) {
+	var requests []*FriendRequest
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND to_user_id = ? AND status = ?", conf.UserId, conf.UserId, FriendRequestPending).
+		Where("from_user_id NOT IN (?)", blockedContactIds(DB.WithContext(ctx))).
+		Order("timestamp DESC").
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
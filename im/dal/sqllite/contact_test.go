@@ -0,0 +1,86 @@
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestContacts_Block(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	ctx := context.Background()
+
+	contacts := []*Contact{
+		{ContactId: 2, Friend: true, User: &ImUser{UserID: 2, UserName: "alice"}},
+		{ContactId: 3, Friend: true, User: &ImUser{UserID: 3, UserName: "bob"}},
+	}
+	if err := ReplaceContacts(ctx, contacts); err != nil {
+		t.Fatal(err)
+	}
+	// 不是好友的用户搜索不到
+	if err := BatchUpsertUsers(ctx, []*ImUser{{UserID: 4, UserName: "alina"}}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := SearchContacts(ctx, "ali")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].UserID != 2 {
+		t.Fatalf("users = %v", users)
+	}
+
+	for _, chatId := range []int64{2, 3} {
+		if err = SaveChat(ctx, NewImChat(1, chatId, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests := []*FriendRequest{
+		{RequestId: 1, FromUserId: 3, ToUserId: 1},
+		{RequestId: 2, FromUserId: 4, ToUserId: 1},
+	}
+	if err = SaveFriendRequests(ctx, requests...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SaveContact(ctx, &Contact{ContactId: 3, Friend: true, Blocked: true}); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := IsBlocked(ctx, 3); !blocked {
+		t.Fatal("user 3 should be blocked")
+	}
+	chats, err := MultiGetChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chats) != 1 || chats[0].ChatId != 2 {
+		t.Fatalf("chats = %v", chats)
+	}
+	if users, _ = GetFriends(ctx); len(users) != 1 || users[0].UserID != 2 {
+		t.Fatalf("friends = %v", users)
+	}
+	if users, _ = GetBlockedUsers(ctx); len(users) != 1 || users[0].UserID != 3 {
+		t.Fatalf("blocked = %v", users)
+	}
+	pending, err := GetPendingFriendRequests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].RequestId != 2 {
+		t.Fatalf("pending = %v", pending)
+	}
+
+	// 同步后以服务端的联系人为准
+	if err = ReplaceContacts(ctx, contacts[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if chats, _ = MultiGetChat(ctx); len(chats) != 2 {
+		t.Fatalf("chats after unblock = %v", chats)
+	}
+}
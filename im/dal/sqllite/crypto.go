@@ -0,0 +1,355 @@
+package sqllite
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/xuning888/helloIMClient/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrKeyRequired = errors.New("database is encrypted, key required")
+	ErrWrongKey    = errors.New("wrong database key")
+)
+
+const (
+	// encryptedPrefix 加密后的列值的前缀, 没有前缀的值按明文读取, 兼容迁移前的数据
+	encryptedPrefix = "enc:v1:"
+	keySize         = 32
+	keyCheckText    = "helloIm db key check"
+
+	keySourcePassphrase = "passphrase"
+	keySourceFile       = "keyfile"
+)
+
+// passphraseIterations 口令派生密钥时 PBKDF2-SHA256 的迭代次数, 保存在 db_key 表中, 修改后不影响已有的数据库
+var passphraseIterations = 600_000
+
+// encryptedColumns 加密保存的列, 列名在所有表中唯一, 同时作为附加数据防止密文在列之间挪用
+var encryptedColumns = []struct{ table, column string }{
+	{"chat_message", "msg_content"},
+	{"im_user", "mobile"},
+	{"im_user", "extra"},
+	{"chat_draft", "draft_content"},
+}
+
+// dbCipher 当前数据库的列加密密钥, 为 nil 时以明文保存
+var dbCipher cipher.AEAD
+
+// KeySource 数据库密钥的来源, 都为空时不加密
+type KeySource struct {
+	// Passphrase 口令, 使用 PBKDF2 派生密钥
+	Passphrase string
+	// KeyFile 保存 32 字节随机密钥的文件, 不存在时生成, 只有本人可读写
+	KeyFile string
+}
+
+func (k KeySource) Enabled() bool {
+	return k.Passphrase != "" || k.KeyFile != ""
+}
+
+// dbKey 映射到 db_key 表, 最多一行, 记录密钥的来源和校验值, 不保存密钥本身
+type dbKey struct {
+	ID         int    `gorm:"primaryKey;column:id"`
+	Source     string `gorm:"column:source;not null;default:''"`
+	Salt       []byte `gorm:"column:salt"`
+	Iterations int    `gorm:"column:iterations;not null;default:0"`
+	// Check 用密钥加密的固定文本, 打开数据库时校验密钥是否正确
+	Check []byte `gorm:"column:check_value"`
+}
+
+func (dbKey) TableName() string {
+	return "db_key"
+}
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// encryptedSerializer 读写时透明地解密和加密字符串列, 空字符串不加密
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("column %s: unsupported type %T", field.DBName, dbValue)
+	}
+	plaintext, err := decryptColumn(dbCipher, field.DBName, value)
+	if err != nil {
+		return err
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	return encryptColumn(dbCipher, field.DBName, value)
+}
+
+func encryptColumn(aead cipher.AEAD, column, value string) (string, error) {
+	if aead == nil || value == "" {
+		return value, nil
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(column))
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptColumn(aead cipher.AEAD, column, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if aead == nil {
+		return "", fmt.Errorf("column %s: %w", column, ErrKeyRequired)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("column %s: malformed ciphertext", column)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("column %s: decrypt: %w", column, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newDBKey 为 source 生成新的 db_key 记录和对应的密钥
+func newDBKey(source KeySource) (*dbKey, cipher.AEAD, error) {
+	meta := &dbKey{ID: 1, Source: keySourceFile}
+	if source.Passphrase != "" {
+		meta.Source = keySourcePassphrase
+		meta.Salt = make([]byte, 16)
+		meta.Iterations = passphraseIterations
+		if _, err := rand.Read(meta.Salt); err != nil {
+			return nil, nil, err
+		}
+	}
+	aead, err := deriveKey(meta, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	meta.Check = aead.Seal(nonce, nonce, []byte(keyCheckText), []byte(meta.TableName()))
+	return meta, aead, nil
+}
+
+// openDBKey 按 db_key 记录派生密钥并校验
+func openDBKey(meta *dbKey, source KeySource) (cipher.AEAD, error) {
+	if meta.Source == keySourcePassphrase && source.Passphrase == "" ||
+		meta.Source == keySourceFile && source.KeyFile == "" {
+		return nil, fmt.Errorf("%w: %s", ErrKeyRequired, meta.Source)
+	}
+	aead, err := deriveKey(meta, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Check) < aead.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	nonce, sealed := meta.Check[:aead.NonceSize()], meta.Check[aead.NonceSize():]
+	if plaintext, err := aead.Open(nil, nonce, sealed, []byte(meta.TableName())); err != nil || string(plaintext) != keyCheckText {
+		return nil, ErrWrongKey
+	}
+	return aead, nil
+}
+
+func deriveKey(meta *dbKey, source KeySource) (cipher.AEAD, error) {
+	var key []byte
+	var err error
+	switch meta.Source {
+	case keySourcePassphrase:
+		key, err = pbkdf2.Key(sha256.New, source.Passphrase, meta.Salt, meta.Iterations, keySize)
+	case keySourceFile:
+		key, err = loadOrCreateKeyFile(source.KeyFile)
+	default:
+		err = fmt.Errorf("unknown key source %q", meta.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// loadOrCreateKeyFile 读取密钥文件, 不存在时生成随机密钥
+func loadOrCreateKeyFile(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key file %s: want %d bytes, got %d", path, keySize, len(key))
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, keySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return loadOrCreateKeyFile(path)
+		}
+		return nil, err
+	}
+	if _, err = f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// initEncryption 打开数据库时设置列加密密钥.
+// 没有 db_key 记录但提供了密钥时, 把已有的明文数据加密, 即旧数据库的迁移
+func initEncryption(db *gorm.DB, source KeySource) error {
+	var metas []*dbKey
+	if err := db.Find(&metas).Error; err != nil {
+		return err
+	}
+	if len(metas) == 0 {
+		if !source.Enabled() {
+			dbCipher = nil
+			return nil
+		}
+		logger.Infof("encrypting database")
+		return rekey(db, nil, source)
+	}
+	if !source.Enabled() {
+		return ErrKeyRequired
+	}
+	aead, err := openDBKey(metas[0], source)
+	if err != nil {
+		return err
+	}
+	dbCipher = aead
+	return nil
+}
+
+// Rekey 用新的密钥重新加密数据库, newKey 为空时解密为明文
+func Rekey(ctx context.Context, newKey KeySource) error {
+	if err := rekey(DB.WithContext(ctx), dbCipher, newKey); err != nil {
+		return err
+	}
+	if dbCipher == nil {
+		initMessageIndex(DB)
+	}
+	return nil
+}
+
+func rekey(db *gorm.DB, old cipher.AEAD, newKey KeySource) error {
+	var meta *dbKey
+	var aead cipher.AEAD
+	if newKey.Enabled() {
+		var err error
+		if meta, aead, err = newDBKey(newKey); err != nil {
+			return err
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, c := range encryptedColumns {
+			if err := rewriteColumn(tx, c.table, c.column, old, aead); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("1 = 1").Delete(&dbKey{}).Error; err != nil {
+			return err
+		}
+		if meta != nil {
+			// 全文索引中是明文, 加密后不再使用
+			if err := dropMessageIndex(tx); err != nil {
+				return err
+			}
+			return tx.Create(meta).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	dbCipher = aead
+	// 释放旧数据所在的页, 避免明文或者旧密钥的密文残留在数据库文件中
+	if err = db.Exec("VACUUM").Error; err != nil {
+		logger.Warnf("vacuum after rekey: %v", err)
+	}
+	return nil
+}
+
+// rewriteColumn 把一列的值从 old 密钥转换到 new 密钥, 密钥为 nil 表示明文
+func rewriteColumn(tx *gorm.DB, table, column string, old, new cipher.AEAD) error {
+	type row struct {
+		RowID int64  `gorm:"column:row_id"`
+		Value string `gorm:"column:value"`
+	}
+	var lastRowID int64
+	for {
+		var rows []row
+		err := tx.Raw(fmt.Sprintf("SELECT rowid AS row_id, COALESCE(%s, '') AS value FROM %s WHERE rowid > ? ORDER BY rowid LIMIT 500", column, table),
+			lastRowID).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		for _, r := range rows {
+			lastRowID = r.RowID
+			plaintext, err := decryptColumn(old, column, r.Value)
+			if err != nil {
+				return fmt.Errorf("%s rowid %d: %w", table, r.RowID, err)
+			}
+			value, err := encryptColumn(new, column, plaintext)
+			if err != nil {
+				return err
+			}
+			if value == r.Value {
+				continue
+			}
+			if err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", table, column), value, r.RowID).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
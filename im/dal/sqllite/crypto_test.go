@@ -0,0 +1,182 @@
+package sqllite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func openTestDB(t *testing.T, path string, key KeySource) {
+	t.Helper()
+	if err := Init(path, key); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+}
+
+func closeTestDB(t *testing.T) {
+	t.Helper()
+	db, err := DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+// rawColumn 绕过 serializer 读取列在数据库中的原始值
+func rawColumn(t *testing.T, query string, args ...interface{}) string {
+	t.Helper()
+	var value string
+	if err := DB.Raw(query, args...).Scan(&value).Error; err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func seed(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	msg := NewMessage(1, 2, 100, 2, conf.UserId, 0, 0, 1, "hello secret world", 0, 0, 1000, 0, 1)
+	if err := SaveOrUpdateMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	users := []*ImUser{{UserID: 2, UserName: "bob", Mobile: "13800000000", Extra: `{"k":"v"}`}}
+	if err := BatchUpsertUsers(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertSeed(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	msg, err := GetLastMessage(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgContent != "hello secret world" {
+		t.Fatalf("MsgContent = %q", msg.MsgContent)
+	}
+	user, err := GetUserById(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Mobile != "13800000000" || user.Extra != `{"k":"v"}` {
+		t.Fatalf("user = %v", user)
+	}
+}
+
+func assertEncrypted(t *testing.T, encrypted bool) {
+	t.Helper()
+	values := []string{
+		rawColumn(t, "SELECT msg_content FROM chat_message WHERE msg_id = ?", 100),
+		rawColumn(t, "SELECT mobile FROM im_user WHERE user_id = ?", 2),
+		rawColumn(t, "SELECT extra FROM im_user WHERE user_id = ?", 2),
+	}
+	for _, v := range values {
+		if strings.HasPrefix(v, encryptedPrefix) != encrypted {
+			t.Fatalf("raw value %q, want encrypted %v", v, encrypted)
+		}
+	}
+}
+
+func TestEncryption_MigrateAndRekey(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	old := passphraseIterations
+	passphraseIterations = 1000
+	defer func() { passphraseIterations = old }()
+	conf.UserId = 1
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+	keyFile := KeySource{KeyFile: filepath.Join(dir, "db.key")}
+
+	// 旧的明文数据库
+	openTestDB(t, path, KeySource{})
+	seed(t)
+	assertEncrypted(t, false)
+	closeTestDB(t)
+
+	// 提供密钥后迁移
+	openTestDB(t, path, keyFile)
+	assertEncrypted(t, true)
+	assertSeed(t)
+	closeTestDB(t)
+
+	if err := Init(path, KeySource{}); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("open without key: %v", err)
+	}
+	if err := Init(path, KeySource{Passphrase: "secret"}); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("open with passphrase: %v", err)
+	}
+
+	openTestDB(t, path, keyFile)
+	if err := Rekey(context.Background(), KeySource{Passphrase: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	assertSeed(t)
+	closeTestDB(t)
+
+	if err := Init(path, KeySource{Passphrase: "wrong"}); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("open with wrong passphrase: %v", err)
+	}
+
+	openTestDB(t, path, KeySource{Passphrase: "secret"})
+	assertEncrypted(t, true)
+	assertSeed(t)
+	if err := Rekey(context.Background(), KeySource{}); err != nil {
+		t.Fatal(err)
+	}
+	assertEncrypted(t, false)
+	closeTestDB(t)
+
+	openTestDB(t, path, KeySource{})
+	assertSeed(t)
+}
+
+func TestEncryption_Search(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	dir := t.TempDir()
+	openTestDB(t, filepath.Join(dir, "data.db"), KeySource{KeyFile: filepath.Join(dir, "db.key")})
+	seed(t)
+	ctx := context.Background()
+	other := NewMessage(1, 3, 101, 3, conf.UserId, 0, 0, 1, "nothing here", 0, 0, 2000, 0, 1)
+	if err := SaveOrUpdateMessage(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	var tables int64
+	DB.Raw("SELECT count(*) FROM sqlite_master WHERE name = ?", messageIndexTable).Scan(&tables)
+	if tables != 0 {
+		t.Fatal("message index should not exist in an encrypted database")
+	}
+	results, err := SearchMessages(ctx, MessageSearchOptions{Keyword: "SECRET hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Message.MsgID != 100 {
+		t.Fatalf("results = %v", results)
+	}
+	if !strings.Contains(results[0].Snippet, HighlightStart+"secret"+HighlightEnd) {
+		t.Fatalf("snippet = %q", results[0].Snippet)
+	}
+	results, err = SearchMessages(ctx, MessageSearchOptions{Keyword: "hello", ChatID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("results = %v", results)
+	}
+}
@@ -0,0 +1,39 @@
+package sqllite
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"gorm.io/gorm"
+)
+
+// SyncCursor 映射到 sync_cursor 表, 记录增量同步到的服务端版本, Name 区分同步的数据
+type SyncCursor struct {
+	UserId  int64  `gorm:"column:user_id;primaryKey;default:0"`
+	Name    string `gorm:"column:name;primaryKey"`
+	Version int64  `gorm:"column:version;not null;default:0"`
+}
+
+func (SyncCursor) TableName() string {
+	return "sync_cursor"
+}
+
+// GetSyncVersion 增量同步的游标, 没有同步过时返回 0
+func GetSyncVersion(ctx context.Context, name string) (int64, error) {
+	cursor := &SyncCursor{}
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND name = ?", conf.UserId, name).
+		First(cursor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cursor.Version, nil
+}
+
+func SaveSyncVersion(ctx context.Context, name string, version int64) error {
+	return DB.WithContext(ctx).Save(&SyncCursor{UserId: conf.UserId, Name: name, Version: version}).Error
+}
@@ -9,7 +9,8 @@ import (
 
 var DB *gorm.DB
 
-func Init(DSN string) error {
+// Init 打开数据库, key 不为空时加密保存消息内容和用户的手机号等敏感列
+func Init(DSN string, key KeySource) error {
 	var err error
 	DB, err = gorm.Open(sqlite.Open(DSN), &gorm.Config{
 		Logger: logger.NewGormLogger(),
@@ -17,13 +18,13 @@ func Init(DSN string) error {
 	if err != nil {
 		return err
 	}
-	if err = migrate(); err != nil {
+	if err = migrate(key); err != nil {
 		return err
 	}
 	return nil
 }
 
-func migrate() error {
+func migrate(key KeySource) error {
 	if err := DB.AutoMigrate(&ImUser{}); err != nil {
 		return err
 	}
@@ -33,8 +34,24 @@ func migrate() error {
 	if err := DB.AutoMigrate(&ImChat{}); err != nil {
 		return err
 	}
+	if err := DB.AutoMigrate(&ChatDraft{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Contact{}, &FriendRequest{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&SyncCursor{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&dbKey{}); err != nil {
+		return err
+	}
+	if err := initEncryption(DB, key); err != nil {
+		return err
+	}
 	if err := initSequenceManager(DB); err != nil {
 		return err
 	}
+	initMessageIndex(DB)
 	return nil
 }
@@ -0,0 +1,85 @@
+package sqllite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"gorm.io/gorm"
+)
+
+// ChatDraft 映射到 chat_draft 表, 会话中输入了但没有发送的内容
+type ChatDraft struct {
+	UserId          int64  `gorm:"column:user_id;primaryKey;default:0" json:"userId"`
+	ChatId          int64  `gorm:"column:chat_id;primaryKey;default:0" json:"chatId"`
+	ChatType        int32  `gorm:"column:chat_type;primaryKey;default:1" json:"chatType"`
+	DraftContent    string `gorm:"column:draft_content;type:text;serializer:encrypted" json:"draftContent"`
+	UpdateTimestamp int64  `gorm:"column:update_timestamp;default:0" json:"updateTimestamp"`
+}
+
+func (ChatDraft) TableName() string {
+	return "chat_draft"
+}
+
+// Key 与 ImChat.Key 相同
+func (d ChatDraft) Key() string {
+	return fmt.Sprintf("%d_%d_%d", d.UserId, d.ChatId, d.ChatType)
+}
+
+// SaveDraft 保存会话的草稿, content 为空时删除; 内容没有变化时不更新时间, 避免会话在列表中的位置变化
+func SaveDraft(ctx context.Context, chatId int64, chatType int32, content string) error {
+	if content == "" {
+		return DeleteDraft(ctx, chatId, chatType)
+	}
+	old, err := GetDraft(ctx, chatId, chatType)
+	if err != nil {
+		return err
+	}
+	if old != nil && old.DraftContent == content {
+		return nil
+	}
+	draft := &ChatDraft{
+		UserId:          conf.UserId,
+		ChatId:          chatId,
+		ChatType:        chatType,
+		DraftContent:    content,
+		UpdateTimestamp: time.Now().UnixMilli(),
+	}
+	return DB.WithContext(ctx).Save(draft).Error
+}
+
+// GetDraft 会话的草稿, 没有草稿时返回 nil
+func GetDraft(ctx context.Context, chatId int64, chatType int32) (*ChatDraft, error) {
+	draft := &ChatDraft{}
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND chat_id = ? AND chat_type = ?", conf.UserId, chatId, chatType).
+		First(draft).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func DeleteDraft(ctx context.Context, chatId int64, chatType int32) error {
+	return DB.WithContext(ctx).
+		Where("user_id = ? AND chat_id = ? AND chat_type = ?", conf.UserId, chatId, chatType).
+		Delete(&ChatDraft{}).Error
+}
+
+// MultiGetDraft 当前用户的所有草稿, key 为 ChatDraft.Key
+func MultiGetDraft(ctx context.Context) (map[string]*ChatDraft, error) {
+	var drafts []*ChatDraft
+	if err := DB.WithContext(ctx).Where("user_id = ?", conf.UserId).Find(&drafts).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]*ChatDraft, len(drafts))
+	for _, draft := range drafts {
+		result[draft.Key()] = draft
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestDraft(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	dir := t.TempDir()
+	openTestDB(t, filepath.Join(dir, "data.db"), KeySource{KeyFile: filepath.Join(dir, "db.key")})
+	ctx := context.Background()
+
+	chats := []*ImChat{NewImChat(1, 2, 1), NewImChat(1, 3, 1)}
+	chats[0].UpdateTimestamp = 2000
+	chats[1].UpdateTimestamp = 1000
+	for _, chat := range chats {
+		if err := SaveChat(ctx, chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := SaveDraft(ctx, 3, 1, "未发送的内容"); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := GetDraft(ctx, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft == nil || draft.DraftContent != "未发送的内容" {
+		t.Fatalf("draft = %v", draft)
+	}
+	if raw := rawColumn(t, "SELECT draft_content FROM chat_draft WHERE chat_id = ?", 3); raw == draft.DraftContent {
+		t.Fatalf("draft_content should be encrypted: %q", raw)
+	}
+
+	// 有草稿的会话按草稿时间排序
+	list, err := MultiGetChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ChatId != 3 {
+		t.Fatalf("chats = %v", list)
+	}
+
+	// 内容没有变化时不更新时间
+	if err = SaveDraft(ctx, 3, 1, "未发送的内容"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := GetDraft(ctx, 3, 1); again.UpdateTimestamp != draft.UpdateTimestamp {
+		t.Fatalf("timestamp changed: %d -> %d", draft.UpdateTimestamp, again.UpdateTimestamp)
+	}
+
+	drafts, err := MultiGetDraft(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts) != 1 || drafts[chats[1].Key()] == nil {
+		t.Fatalf("drafts = %v", drafts)
+	}
+
+	// 清空输入框即删除草稿
+	if err = SaveDraft(ctx, 3, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if draft, err = GetDraft(ctx, 3, 1); err != nil || draft != nil {
+		t.Fatalf("draft = %v, err = %v", draft, err)
+	}
+	if list, _ = MultiGetChat(ctx); list[0].ChatId != 2 {
+		t.Fatalf("chats = %v", list)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	"github.com/xuning888/helloIMClient/conf"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -19,7 +20,7 @@ type ChatMessage struct {
 	ToUserType    int32  `gorm:"default:0;column:to_user_type" json:"toUserType"`
 	GroupID       int64  `gorm:"default:0;column:group_id" json:"groupId"`
 	MsgSeq        int32  `gorm:"default:0;column:msg_seq" json:"msgSeq"`
-	MsgContent    string `gorm:"type:text;column:msg_content" json:"msgContent"`
+	MsgContent    string `gorm:"type:text;column:msg_content;serializer:encrypted" json:"msgContent"`
 	ContentType   int32  `gorm:"default:0;column:content_type" json:"contentType"`
 	CmdID         int32  `gorm:"default:0;column:cmd_id" json:"cmdId"`
 	SendTime      int64  `gorm:"default:0;column:send_time" json:"sendTime"`
@@ -68,24 +69,61 @@ func NewMessage(chatType int32, chatId, msgId int64,
 	return message
 }
 
-func SaveOrUpdateMessage(ctx context.Context, message *ChatMessage) error {
-	if message.MsgFrom == conf.UserId {
-		message.ChatID = message.MsgTo
-	} else {
-		message.ChatID = message.MsgFrom
+// ResolveChatId 消息所属的会话: 群聊是群id, 单聊是对方的uid.
+// 自己发出的消息, 包括在其他设备上发出后同步过来的, 属于接收方的会话
+func ResolveChatId(chatType int32, msgFrom, msgTo int64) int64 {
+	if chatType == 2 || msgFrom == conf.UserId {
+		return msgTo
 	}
-	err := DB.WithContext(ctx).Clauses(
-		clause.OnConflict{
-			Columns: []clause.Column{
-				{Name: "chat_id"}, {Name: "msg_id"}, {Name: "chat_type"},
+	return msgFrom
+}
+
+func SaveOrUpdateMessage(ctx context.Context, message *ChatMessage) error {
+	message.ChatID = ResolveChatId(message.ChatType, message.MsgFrom, message.MsgTo)
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(
+			clause.OnConflict{
+				Columns: []clause.Column{
+					{Name: "chat_id"}, {Name: "msg_id"}, {Name: "chat_type"},
+				},
+				UpdateAll: true,
 			},
-			UpdateAll: true,
-		},
-	).Create(message).Error
-	if err != nil {
-		return err
-	}
-	return nil
+		).Create(message).Error
+		if err != nil {
+			return err
+		}
+		return indexMessage(tx, message)
+	})
+}
+
+// SaveMessageIfAbsent 消息不存在时保存, 已经存在时不修改并返回 false, 用于丢弃服务端重复推送的消息
+func SaveMessageIfAbsent(ctx context.Context, message *ChatMessage) (bool, error) {
+	message.ChatID = ResolveChatId(message.ChatType, message.MsgFrom, message.MsgTo)
+	created := false
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		created = true
+		return indexMessage(tx, message)
+	})
+	return created, err
+}
+
+// DeleteMessagesBefore 删除会话中 sendTime 之前(包含)的消息, 用于删除会话时清空历史
+func DeleteMessagesBefore(ctx context.Context, chatId int64, chatType int32, sendTime int64) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&ChatMessage{}).
+			Where("chat_id = ? AND chat_type = ? AND send_time <= ?", chatId, chatType, sendTime)
+		if err := unindexMessages(tx, query.Session(&gorm.Session{}).Select("rowid")); err != nil {
+			return err
+		}
+		return query.Delete(&ChatMessage{}).Error
+	})
 }
 
 func GetLastMessage(ctx context.Context, chatId int64) (*ChatMessage, error) {
@@ -131,10 +169,35 @@ func GetMessagesBySeq(ctx context.Context, chatId int64, minServerSeq, maxServer
 	msgs := make([]*ChatMessage, 0)
 	err := DB.WithContext(ctx).Model(&ChatMessage{}).
 		Where("chat_id = ? and server_seq >= ? and server_seq <= ?", chatId, minServerSeq, maxServerSeq).
-		Find(&msgs).
-		Order("server_seq").Error
+		Order("server_seq").
+		Find(&msgs).Error
 	if err != nil {
 		return nil, err
 	}
 	return msgs, nil
 }
+
+// GetMessagesAround 获取 serverSeq 及之前的 limit 条消息和之后的 limit 条消息, 按 server_seq 升序返回
+func GetMessagesAround(ctx context.Context, chatId int64, chatType int32, serverSeq int64, limit int) ([]*ChatMessage, error) {
+	before := make([]*ChatMessage, 0)
+	err := DB.WithContext(ctx).Model(&ChatMessage{}).
+		Where("chat_id = ? and chat_type = ? and server_seq <= ?", chatId, chatType, serverSeq).
+		Order("server_seq desc").
+		Limit(limit).Find(&before).Error
+	if err != nil {
+		return nil, err
+	}
+	after := make([]*ChatMessage, 0)
+	err = DB.WithContext(ctx).Model(&ChatMessage{}).
+		Where("chat_id = ? and chat_type = ? and server_seq > ?", chatId, chatType, serverSeq).
+		Order("server_seq").
+		Limit(limit).Find(&after).Error
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*ChatMessage, 0, len(before)+len(after))
+	for i := len(before) - 1; i >= 0; i-- {
+		msgs = append(msgs, before[i])
+	}
+	return append(msgs, after...), nil
+}
@@ -0,0 +1,112 @@
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestSaveOrUpdateMessage_ChatId(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		chatType int32
+		from, to int64
+		chatId   int64
+	}{
+		{"received", 1, 2, 1, 2},
+		{"sent from other device", 1, 1, 3, 3},
+		{"group", 2, 4, 100, 100},
+		{"group sent from other device", 2, 1, 100, 100},
+	}
+	for i, c := range cases {
+		msg := NewMessage(c.chatType, 0, int64(i+1), c.from, c.to, 0, 0, 0, c.name, 0, 0, int64(i), 0, int64(i+1))
+		if err := SaveOrUpdateMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		last, err := GetLastMessage(ctx, c.chatId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last.MsgContent != c.name {
+			t.Fatalf("%s: last message of chat %d is %q", c.name, c.chatId, last.MsgContent)
+		}
+	}
+}
+
+func TestDeleteMessagesBefore(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		msg := NewMessage(1, 2, int64(i), 2, 1, 0, 0, 0, "hello world", 0, 0, int64(i*1000), 0, int64(i))
+		if err := SaveOrUpdateMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := NewMessage(1, 3, 4, 3, 1, 0, 0, 0, "hello world", 0, 0, 1000, 0, 1)
+	if err := SaveOrUpdateMessage(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteMessagesBefore(ctx, 2, 1, 2000); err != nil {
+		t.Fatal(err)
+	}
+	results, err := SearchMessages(ctx, MessageSearchOptions{Keyword: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %v", results)
+	}
+	for _, r := range results {
+		if r.Message.ChatID == 2 && r.Message.MsgID != 3 {
+			t.Fatalf("message %d should be deleted", r.Message.MsgID)
+		}
+	}
+}
+
+func TestSaveMessageIfAbsent(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	ctx := context.Background()
+
+	msg := NewMessage(1, 0, 1, 2, 1, 0, 0, 0, "first", 0, 0, 1000, 0, 1)
+	created, err := SaveMessageIfAbsent(ctx, msg)
+	if err != nil || !created {
+		t.Fatalf("created = %v, err = %v", created, err)
+	}
+	duplicate := NewMessage(1, 0, 1, 2, 1, 0, 0, 0, "second", 0, 0, 2000, 0, 1)
+	created, err = SaveMessageIfAbsent(ctx, duplicate)
+	if err != nil || created {
+		t.Fatalf("created = %v, err = %v", created, err)
+	}
+	last, err := GetLastMessage(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.MsgContent != "first" {
+		t.Fatalf("duplicate message overwrote %q", last.MsgContent)
+	}
+	results, err := SearchMessages(ctx, MessageSearchOptions{Keyword: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("results = %v", results)
+	}
+}
@@ -0,0 +1,314 @@
+package sqllite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// 搜索结果摘要中关键字的高亮标记, 由展示层替换为具体的样式
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+const (
+	messageIndexTable  = "chat_message_fts"
+	defaultSearchLimit = 50
+	// trigram 分词器要求关键字至少 3 个字符才能使用索引
+	minMatchRunes = 3
+	snippetRunes  = 16
+)
+
+// messageIndexEnabled FTS5 索引是否可用
+// Note: go-sqlite3 需要使用 -tags sqlite_fts5 编译才支持 FTS5, 不支持时退化为 LIKE 查询
+var messageIndexEnabled bool
+
+// MessageSearchOptions 消息搜索条件, 零值表示不限制
+type MessageSearchOptions struct {
+	Keyword      string
+	ChatID       int64
+	ChatType     int32
+	From         int64
+	StartTime    int64 // 发送时间下限(毫秒, 包含)
+	EndTime      int64 // 发送时间上限(毫秒, 不包含)
+	ContentTypes []int32
+	Limit        int
+}
+
+// MessageSearchResult 消息搜索结果
+type MessageSearchResult struct {
+	Message *ChatMessage
+	// Snippet 命中关键字的上下文, 关键字由 HighlightStart 和 HighlightEnd 包裹
+	Snippet string
+}
+
+// initMessageIndex 创建消息的全文索引, 新建索引时把已有的消息写入索引.
+// 数据库加密时不使用索引, 避免消息内容以明文保存在索引中
+func initMessageIndex(db *gorm.DB) {
+	if dbCipher != nil {
+		messageIndexEnabled = false
+		logger.Infof("message index disabled: database is encrypted, search decrypts messages one by one")
+		return
+	}
+	var exists int64
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", messageIndexTable).Scan(&exists)
+	err := db.Exec(fmt.Sprintf(
+		"CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(content, tokenize = 'trigram')", messageIndexTable)).Error
+	if err != nil {
+		messageIndexEnabled = false
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			logger.Warnf("message index disabled: sqlite is built without FTS5 (build with -tags sqlite_fts5), " +
+				"message search falls back to LIKE and scans all messages")
+			return
+		}
+		logger.Warnf("message index disabled, message search falls back to LIKE: %v", err)
+		return
+	}
+	logger.Infof("message index enabled: %s", messageIndexTable)
+	messageIndexEnabled = true
+	if exists > 0 {
+		return
+	}
+	var messages []*ChatMessage
+	err = db.Model(&ChatMessage{}).FindInBatches(&messages, 500, func(tx *gorm.DB, batch int) error {
+		for _, msg := range messages {
+			if err := indexMessage(db, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		logger.Errorf("build message index error: %v", err)
+	}
+}
+
+// dropMessageIndex 删除全文索引
+func dropMessageIndex(tx *gorm.DB) error {
+	messageIndexEnabled = false
+	return tx.Exec("DROP TABLE IF EXISTS " + messageIndexTable).Error
+}
+
+// indexMessage 更新一条消息的全文索引, 需要在保存消息之后调用
+func indexMessage(tx *gorm.DB, msg *ChatMessage) error {
+	if !messageIndexEnabled {
+		return nil
+	}
+	var rowid int64
+	err := tx.Raw("SELECT rowid FROM chat_message WHERE chat_id = ? AND msg_id = ? AND chat_type = ?",
+		msg.ChatID, msg.MsgID, msg.ChatType).Scan(&rowid).Error
+	if err != nil {
+		return err
+	}
+	if err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", messageIndexTable), rowid).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("INSERT INTO %s(rowid, content) VALUES(?, ?)", messageIndexTable),
+		rowid, indexContent(msg)).Error
+}
+
+// unindexMessages 删除消息的全文索引, rowids 是查询消息 rowid 的子查询, 需要在删除消息之前调用
+func unindexMessages(tx *gorm.DB, rowids *gorm.DB) error {
+	if !messageIndexEnabled {
+		return nil
+	}
+	return tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid IN (?)", messageIndexTable), rowids).Error
+}
+
+// indexContent 被索引的文本, 结构化消息使用摘要
+func indexContent(msg *ChatMessage) string {
+	return payload.Summary(msg.ContentType, msg.MsgContent)
+}
+
+// SearchMessages 按关键字搜索所有会话的消息, 按发送时间倒序返回
+func SearchMessages(ctx context.Context, opts MessageSearchOptions) ([]*MessageSearchResult, error) {
+	terms := strings.Fields(opts.Keyword)
+	if len(terms) == 0 {
+		return []*MessageSearchResult{}, nil
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if dbCipher != nil {
+		return searchDecrypted(ctx, opts, terms, limit)
+	}
+	type row struct {
+		ChatMessage
+		Snippet string `gorm:"column:snippet"`
+	}
+	var rows []*row
+	var query *gorm.DB
+	if messageIndexEnabled {
+		query = DB.WithContext(ctx).Table(messageIndexTable + " AS f").
+			Joins("JOIN chat_message AS m ON m.rowid = f.rowid")
+		if canMatch(terms) {
+			query = query.Select(fmt.Sprintf("m.*, snippet(%s, 0, ?, ?, '...', %d) AS snippet", messageIndexTable, snippetRunes),
+				HighlightStart, HighlightEnd).
+				Where(messageIndexTable+" MATCH ?", matchQuery(terms))
+		} else {
+			query = query.Select("m.*, f.content AS snippet")
+			for _, term := range terms {
+				query = query.Where("f.content LIKE ? ESCAPE '\\'", likePattern(term))
+			}
+		}
+	} else {
+		query = DB.WithContext(ctx).Table("chat_message AS m").Select("m.*, m.msg_content AS snippet")
+		for _, term := range terms {
+			query = query.Where("m.msg_content LIKE ? ESCAPE '\\'", likePattern(term))
+		}
+	}
+	if err := filterMessages(query, opts).Order("m.send_time desc").Limit(limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	results := make([]*MessageSearchResult, 0, len(rows))
+	for _, r := range rows {
+		msg := r.ChatMessage
+		snippet := r.Snippet
+		if !messageIndexEnabled || !canMatch(terms) {
+			snippet = makeSnippet(snippet, terms)
+		}
+		results = append(results, &MessageSearchResult{Message: &msg, Snippet: snippet})
+	}
+	return results, nil
+}
+
+// filterMessages 关键字以外的搜索条件, chat_message 的别名为 m
+func filterMessages(query *gorm.DB, opts MessageSearchOptions) *gorm.DB {
+	if opts.ChatID != 0 {
+		query = query.Where("m.chat_id = ?", opts.ChatID)
+	}
+	if opts.ChatType != 0 {
+		query = query.Where("m.chat_type = ?", opts.ChatType)
+	}
+	if opts.From != 0 {
+		query = query.Where("m.msg_from = ?", opts.From)
+	}
+	if opts.StartTime > 0 {
+		query = query.Where("m.send_time >= ?", opts.StartTime)
+	}
+	if opts.EndTime > 0 {
+		query = query.Where("m.send_time < ?", opts.EndTime)
+	}
+	if len(opts.ContentTypes) > 0 {
+		query = query.Where("m.content_type IN ?", opts.ContentTypes)
+	}
+	return query
+}
+
+// searchDecrypted 数据库加密时无法在 SQL 中匹配关键字, 按发送时间倒序分批读取消息, 解密后逐条匹配
+func searchDecrypted(ctx context.Context, opts MessageSearchOptions, terms []string, limit int) ([]*MessageSearchResult, error) {
+	const batchSize = 500
+	lowerTerms := make([]string, 0, len(terms))
+	for _, term := range terms {
+		lowerTerms = append(lowerTerms, strings.ToLower(term))
+	}
+	results := make([]*MessageSearchResult, 0)
+	for offset := 0; ; offset += batchSize {
+		var messages []*ChatMessage
+		query := filterMessages(DB.WithContext(ctx).Table("chat_message AS m"), opts)
+		err := query.Order("m.send_time desc").Offset(offset).Limit(batchSize).Find(&messages).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			content := indexContent(msg)
+			if !containsAll(strings.ToLower(content), lowerTerms) {
+				continue
+			}
+			results = append(results, &MessageSearchResult{Message: msg, Snippet: makeSnippet(content, terms)})
+			if len(results) >= limit {
+				return results, nil
+			}
+		}
+		if len(messages) < batchSize {
+			return results, nil
+		}
+	}
+}
+
+func containsAll(s string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(s, term) {
+			return false
+		}
+	}
+	return true
+}
+
+func canMatch(terms []string) bool {
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < minMatchRunes {
+			return false
+		}
+	}
+	return true
+}
+
+// matchQuery 每个关键字作为一个短语, 短语之间为 AND 关系
+func matchQuery(terms []string) string {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(phrases, " ")
+}
+
+func likePattern(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(term) + "%"
+}
+
+// makeSnippet 截取最先出现的关键字附近的文本并高亮所有关键字, 用于无法使用 snippet() 的查询
+func makeSnippet(content string, terms []string) string {
+	runes := []rune(content)
+	lower := strings.ToLower(content)
+	start := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, strings.ToLower(term)); i >= 0 {
+			// ToLower 可能改变字节长度, 按 lower 计算字符的位置
+			if n := min(utf8.RuneCountInString(lower[:i]), len(runes)); start < 0 || n < start {
+				start = n
+			}
+		}
+	}
+	from := max(start-snippetRunes/2, 0)
+	to := min(from+snippetRunes*2, len(runes))
+	// 关键字靠近结尾时向前多截取一些, 保持摘要的长度
+	from = max(to-snippetRunes*2, 0)
+	snippet := string(runes[from:to])
+	for _, term := range terms {
+		snippet = highlight(snippet, term)
+	}
+	if from > 0 {
+		snippet = "..." + snippet
+	}
+	if to < len(runes) {
+		snippet += "..."
+	}
+	return snippet
+}
+
+// highlight 不区分大小写地给 term 加上高亮标记
+func highlight(s, term string) string {
+	if term == "" {
+		return s
+	}
+	var b strings.Builder
+	lower, lowerTerm := strings.ToLower(s), strings.ToLower(term)
+	for {
+		i := strings.Index(lower, lowerTerm)
+		if i < 0 || len(lower) != len(s) {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i] + HighlightStart + s[i:i+len(term)] + HighlightEnd)
+		s, lower = s[i+len(term):], lower[i+len(term):]
+	}
+}
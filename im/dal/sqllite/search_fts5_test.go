@@ -0,0 +1,58 @@
+//go:build sqlite_fts5
+
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestSearchMessages_FTS5(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	if !messageIndexEnabled {
+		t.Fatal("message index should be enabled with -tags sqlite_fts5")
+	}
+	saveSearchMessages(t)
+
+	snippets := func(keyword string) []string {
+		results, err := SearchMessages(context.Background(), MessageSearchOptions{Keyword: keyword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, r := range results {
+			s = append(s, r.Snippet)
+		}
+		return s
+	}
+	// trigram 索引的 snippet() 高亮命中的中文
+	if got := snippets("北京故宫"); len(got) != 1 || !strings.Contains(got[0], hl("北京故宫")) {
+		t.Fatalf("snippets = %q", got)
+	}
+	// 结构化消息按摘要索引, 不会命中 JSON 中的字段名
+	if got := snippets("人民广场"); len(got) != 1 || got[0] != "[位置] "+hl("人民广场") {
+		t.Fatalf("snippets = %q", got)
+	}
+	if got := snippets("title"); len(got) != 0 {
+		t.Fatalf("snippets = %q", got)
+	}
+
+	// 删除索引后重新创建时写入已有的消息
+	if err := dropMessageIndex(DB); err != nil {
+		t.Fatal(err)
+	}
+	initMessageIndex(DB)
+	if !messageIndexEnabled {
+		t.Fatal("message index not rebuilt")
+	}
+	assertMsgIds(t, "hello", searchMsgIds(t, MessageSearchOptions{Keyword: "hello"}), 2, 1)
+}
@@ -0,0 +1,23 @@
+//go:build !sqlite_fts5
+
+package sqllite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// TestSearchMessages_Fallback 没有 FTS5 时退化为 LIKE 查询, 使用 -tags sqlite_fts5 运行索引的测试
+func TestSearchMessages_Fallback(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	if messageIndexEnabled {
+		t.Fatal("message index should be disabled without -tags sqlite_fts5")
+	}
+}
@@ -0,0 +1,146 @@
+package sqllite
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// hl 给 s 加上高亮标记
+func hl(s string) string {
+	return HighlightStart + s + HighlightEnd
+}
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		s, term, want string
+	}{
+		{"hello world", "world", "hello " + hl("world")},
+		{"Hello HELLO hello", "hello", hl("Hello") + " " + hl("HELLO") + " " + hl("hello")},
+		{"中文和中文", "中文", hl("中文") + "和" + hl("中文")},
+		{"nothing here", "xyz", "nothing here"},
+		{"empty term", "", "empty term"},
+	}
+	for _, c := range cases {
+		if got := highlight(c.s, c.term); got != c.want {
+			t.Fatalf("highlight(%q, %q) = %q, want %q", c.s, c.term, got, c.want)
+		}
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	long := strings.Repeat("a", 30) + "needle" + strings.Repeat("b", 30)
+	cases := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{"short", "hello world", []string{"world"}, "hello " + hl("world")},
+		{"case insensitive", "Hello WORLD", []string{"world"}, "Hello " + hl("WORLD")},
+		{"multiple terms", "hello big world", []string{"world", "hello"}, hl("hello") + " big " + hl("world")},
+		{"long content", long, []string{"needle"},
+			"..." + strings.Repeat("a", 8) + hl("needle") + strings.Repeat("b", 18) + "..."},
+		{"cjk", "今天我们一起去北京的故宫博物院参观, 然后去吃烤鸭, 晚上回酒店休息一下再出发", []string{"故宫"},
+			"...我们一起去北京的" + hl("故宫") + "博物院参观, 然后去吃烤鸭, 晚上回酒店休息..."},
+		{"term near end", strings.Repeat("a", 60) + "end", []string{"end"}, "..." + strings.Repeat("a", 29) + hl("end")},
+		{"no match", long, []string{"missing"}, strings.Repeat("a", 30) + "ne..."},
+	}
+	for _, c := range cases {
+		if got := makeSnippet(c.content, c.terms); got != c.want {
+			t.Fatalf("%s: makeSnippet = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// saveSearchMessages 保存搜索使用的消息, 发送时间与 msgId 一致
+func saveSearchMessages(t *testing.T) {
+	t.Helper()
+	location, locationType := payload.ExtractContent(payload.NewLocationMessage(31.2, 121.4, "人民广场", "上海", false, nil))
+	messages := []*ChatMessage{
+		NewMessage(1, 2, 1, 2, 1, 0, 0, 0, "hello world", int32(helloim_proto.PayloadType_TEXT), 0, 1, 0, 1),
+		NewMessage(1, 3, 2, 3, 1, 0, 0, 0, "Hello there", int32(helloim_proto.PayloadType_TEXT), 0, 2, 0, 1),
+		NewMessage(1, 2, 3, 2, 1, 0, 0, 0, "今天去北京故宫博物院", int32(helloim_proto.PayloadType_TEXT), 0, 3, 0, 2),
+		NewMessage(1, 3, 4, 3, 1, 0, 0, 0, location, locationType, 0, 4, 0, 2),
+		NewMessage(1, 2, 5, 2, 1, 0, 0, 0, "100% done", int32(helloim_proto.PayloadType_TEXT), 0, 5, 0, 3),
+		NewMessage(1, 2, 6, 2, 1, 0, 0, 0, "a_b and aXb", int32(helloim_proto.PayloadType_TEXT), 0, 6, 0, 4),
+	}
+	for _, msg := range messages {
+		if err := SaveOrUpdateMessage(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func searchMsgIds(t *testing.T, opts MessageSearchOptions) []int64 {
+	t.Helper()
+	results, err := SearchMessages(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.Message.MsgID)
+	}
+	return ids
+}
+
+func assertMsgIds(t *testing.T, keyword string, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("search %q: got %v, want %v", keyword, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("search %q: got %v, want %v", keyword, got, want)
+		}
+	}
+}
+
+// TestSearchMessages 使用默认编译的 LIKE 查询和 -tags sqlite_fts5 的索引时结果一致
+func TestSearchMessages(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	openTestDB(t, filepath.Join(t.TempDir(), "data.db"), KeySource{})
+	saveSearchMessages(t)
+
+	cases := []struct {
+		opts MessageSearchOptions
+		want []int64
+	}{
+		{MessageSearchOptions{Keyword: "hello"}, []int64{2, 1}},
+		{MessageSearchOptions{Keyword: "hello world"}, []int64{1}},
+		{MessageSearchOptions{Keyword: "hello", ChatID: 3}, []int64{2}},
+		{MessageSearchOptions{Keyword: "hello", Limit: 1}, []int64{2}},
+		{MessageSearchOptions{Keyword: "hello", StartTime: 2}, []int64{2}},
+		{MessageSearchOptions{Keyword: "hello", EndTime: 2}, []int64{1}},
+		// 中文关键字, 少于 3 个字时不能使用 trigram 索引
+		{MessageSearchOptions{Keyword: "北京"}, []int64{3}},
+		{MessageSearchOptions{Keyword: "北京故宫"}, []int64{3}},
+		{MessageSearchOptions{Keyword: "人民广场", ContentTypes: []int32{int32(helloim_proto.PayloadType_LOCATION)}}, []int64{4}},
+		// LIKE 的通配符按普通字符匹配
+		{MessageSearchOptions{Keyword: "0%"}, []int64{5}},
+		{MessageSearchOptions{Keyword: "a_b"}, []int64{6}},
+		{MessageSearchOptions{Keyword: "   "}, []int64{}},
+		{MessageSearchOptions{Keyword: "missing"}, []int64{}},
+	}
+	for _, c := range cases {
+		assertMsgIds(t, c.opts.Keyword, searchMsgIds(t, c.opts), c.want...)
+	}
+
+	results, err := SearchMessages(context.Background(), MessageSearchOptions{Keyword: "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Snippet != "hello "+hl("world") {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
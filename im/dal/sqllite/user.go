@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/xuning888/helloIMClient/pkg/logger"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -14,9 +16,13 @@ type ImUser struct {
 	UserType   int    `gorm:"column:user_type;not null;default:0" json:"userType"`
 	UserName   string `gorm:"column:user_name;not null;default:''" json:"userName"`
 	Icon       string `gorm:"column:icon;not null;default:''" json:"icon"`
-	Mobile     string `gorm:"column:mobile;not null;default:''" json:"mobile"`
-	Extra      string `gorm:"column:extra;not null;default:''" json:"extra"`
+	Mobile     string `gorm:"column:mobile;not null;default:'';serializer:encrypted" json:"mobile"`
+	Extra      string `gorm:"column:extra;not null;default:'';serializer:encrypted" json:"extra"`
 	UserStatus int    `gorm:"column:user_status;not null;default:0" json:"userStatus"`
+	// Version 服务端修改用户资料时递增, 用于增量同步
+	Version int64 `gorm:"column:version;not null;default:0" json:"version"`
+	// FetchTimestamp 本地最近一次从服务端获取资料的时间(毫秒), 用于判断资料是否过期
+	FetchTimestamp int64 `gorm:"column:fetch_timestamp;not null;default:0" json:"-"`
 }
 
 func (ImUser) TableName() string {
@@ -59,6 +65,18 @@ func GetUserById(ctx context.Context, userId int64) (*ImUser, error) {
 	return user, nil
 }
 
+func GetUsersByIds(ctx context.Context, userIds []int64) ([]*ImUser, error) {
+	users := make([]*ImUser, 0, len(userIds))
+	if len(userIds) == 0 {
+		return users, nil
+	}
+	err := DB.WithContext(ctx).Where("user_id IN ?", userIds).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func GetAllUsers(ctx context.Context) ([]*ImUser, error) {
 	var users []*ImUser
 	err := DB.WithContext(ctx).
@@ -74,12 +92,22 @@ func BatchUpsertUsers(ctx context.Context, users []*ImUser) error {
 	if len(users) == 0 {
 		return nil
 	}
-	return DB.WithContext(ctx).Clauses(
+	return upsertUsers(DB.WithContext(ctx), users)
+}
+
+// upsertUsers 保存从服务端获取的用户资料, 不会用旧版本的资料覆盖新版本
+func upsertUsers(db *gorm.DB, users []*ImUser) error {
+	now := time.Now().UnixMilli()
+	for _, user := range users {
+		user.FetchTimestamp = now
+	}
+	return db.Clauses(
 		clause.OnConflict{
 			Columns: []clause.Column{{Name: "user_id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
-				"user_type", "user_name", "icon", "mobile", "extra", "user_status",
+				"user_type", "user_name", "icon", "mobile", "extra", "user_status", "version", "fetch_timestamp",
 			}),
+			Where: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "excluded.version >= im_user.version"}}},
 		},
 	).Create(&users).Error
 }
@@ -0,0 +1,55 @@
+package im
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	pb "github.com/xuning888/helloIMClient/im/proto"
+)
+
+// KickedOut 被服务端踢下线的原因, 通过 KickedOutEvent.Info 发出
+type KickedOut struct {
+	Reason  pb.KickoutReason
+	Message string
+	// DeviceId Platform 挤掉当前设备的新设备, 其他原因时为空
+	DeviceId string
+	Platform string
+}
+
+// defaultPlatform 认证时上报的平台
+func defaultPlatform() string {
+	return "cli-" + runtime.GOOS
+}
+
+// loadOrCreateDeviceId 设备id保存在 ~/.helloIm/device_id, 第一次运行时生成, 同一台机器上的账号共用
+func loadOrCreateDeviceId() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(homeDir, ".helloIm", "device_id")
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return "", err
+	}
+	deviceId := hex.EncodeToString(id)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, []byte(deviceId), 0600); err != nil {
+		return "", err
+	}
+	return deviceId, nil
+}
@@ -2,76 +2,345 @@ package im
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"sync/atomic"
 
+	"github.com/hashicorp/golang-lru/v2"
+	"github.com/xuning888/helloIMClient/conf"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/e2e"
 	"github.com/xuning888/helloIMClient/im/payload"
 	pb "github.com/xuning888/helloIMClient/im/proto"
 	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/chatsync"
+	"github.com/xuning888/helloIMClient/im/protocol/contact"
+	"github.com/xuning888/helloIMClient/im/protocol/dump"
 	"github.com/xuning888/helloIMClient/im/protocol/push"
+	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/service"
+	"github.com/xuning888/helloIMClient/im/transport"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
+// pushWindowSize 内存中记录最近处理过的推送消息的数量, 窗口之外的重复消息由数据库的主键识别
+const pushWindowSize = 4096
+
+// pushKey 推送消息的唯一标识, 与 chat_message 表的主键一致
+type pushKey struct {
+	chatId   int64
+	chatType int32
+	msgId    int64
+}
+
 type dispatcher struct {
 	store  *Store
-	events *callbackRegistry
+	events *eventBus
+	// seen 最近处理过的推送消息, 服务端没有及时收到 ACK 时会重复推送
+	seen *lru.Cache[pushKey, struct{}]
+	// duplicates 丢弃的重复推送的消息数
+	duplicates atomic.Int64
+	// sent 本设备最近发出的消息, 服务端把自己发出的消息推送回来时不算重复推送
+	sent *lru.Cache[pushKey, struct{}]
+	// e2e 没有开启端到端加密时为 nil, 收到的加密消息按密文保存
+	e2e *e2e.Session
+	// handler 依次经过去重、解密和接收拦截器后处理推送的聊天消息, 控制消息不经过拦截器
+	handler ReceiveHandler
+	// onKickout 被踢下线时调用
+	onKickout func(*KickedOut)
 }
 
-func newDispatcher(store *Store, events *callbackRegistry) *dispatcher {
-	return &dispatcher{
+func newDispatcher(store *Store, events *eventBus, session *e2e.Session, interceptors ...ReceiveInterceptor) *dispatcher {
+	seen, _ := lru.New[pushKey, struct{}](pushWindowSize)
+	sent, _ := lru.New[pushKey, struct{}](pushWindowSize)
+	d := &dispatcher{
 		store:  store,
 		events: events,
+		seen:   seen,
+		sent:   sent,
+		e2e:    session,
 	}
+	d.handler = chainReceive(d.handle, append([]ReceiveInterceptor{d.dedup, d.decrypt}, interceptors...)...)
+	return d
 }
 
 func (d *dispatcher) dispatch(msg protocol.Message) {
 	if msg == nil {
 		return
 	}
+	handler := d.handler
+	if msg.CmdId() != int32(pb.CmdId_CMD_ID_PUSH) {
+		// 踢下线、会话同步这样的控制消息不交给接收拦截器, 避免被拦截器丢弃
+		handler = d.handle
+	}
+	if err := handler(context.Background(), msg); err != nil {
+		logger.Errorf("dispatcher: handle cmdId: %d, error: %v", msg.CmdId(), err)
+		d.events.publish(ErrorEvent{Err: err})
+	}
+}
+
+func (d *dispatcher) handle(ctx context.Context, msg protocol.Message) error {
 	switch msg.CmdId() {
 	case int32(pb.CmdId_CMD_ID_PUSH):
-		d.handlePush(msg)
+		d.handlePush(ctx, msg)
+	case int32(pb.CmdId_CMD_ID_KICKOUT):
+		d.handleKickout(msg)
+	case int32(pb.CmdId_CMD_ID_CHAT_SYNC):
+		d.handleChatSync(ctx, msg)
+	case int32(pb.CmdId_CMD_ID_FRIEND_NOTIFY):
+		d.handleFriendNotify(ctx, msg)
 	default:
-		logger.Infof("dispatcher: unhandled push message, cmdId: %d", msg.CmdId())
+		logger.Infof("dispatcher: unhandled push message, cmdId: %d, message: %s", msg.CmdId(), dump.Message(msg))
 	}
+	return nil
 }
 
-func (d *dispatcher) handlePush(resp protocol.Message) {
-	response, ok := resp.(*push.RecvMsg)
+// dedup 丢弃最近处理过的推送消息, 不再经过后面的拦截器
+func (d *dispatcher) dedup(ctx context.Context, msg protocol.Message, next ReceiveHandler) error {
+	if response, ok := msg.(*push.RecvMsg); ok {
+		if key, msgFrom, _, err := resolvePush(response); err == nil && d.seen.Contains(key) {
+			d.dropDuplicate(key, msgFrom)
+			return nil
+		}
+	}
+	return next(ctx, msg)
+}
+
+// decrypt 解密端到端加密的消息, 后面的拦截器看到的是明文. 解密失败时按密文保存, 展示为加密消息
+func (d *dispatcher) decrypt(ctx context.Context, msg protocol.Message, next ReceiveHandler) error {
+	response, ok := msg.(*push.RecvMsg)
+	if !ok || d.e2e == nil || response.GetPayload().GetPayloadType() != pb.PayloadType_ENCRYPTED {
+		return next(ctx, msg)
+	}
+	_, msgFrom, msgTo, err := resolvePush(response)
+	if err != nil {
+		return next(ctx, msg)
+	}
+	if decrypted, err := d.e2e.Decrypt(ctx, msgFrom, msgTo, response.GetPayload()); err != nil {
+		logger.Errorf("dispatcher Push: decrypt msgId: %v, error: %v", response.MsgId(), err)
+		peer := msgFrom
+		if msgFrom == conf.UserId {
+			peer = msgTo
+		}
+		if pinned, pending, ok := d.e2e.PendingPeerKey(peer); errors.Is(err, e2e.ErrKeyChanged) && ok {
+			d.events.publish(KeyChangedEvent{Peer: peer, Pinned: pinned, Pending: pending})
+		} else {
+			d.events.publish(ErrorEvent{Err: err})
+		}
+	} else {
+		response.Payload = decrypted
+	}
+	return next(ctx, msg)
+}
+
+// markSent 记录本设备发出并收到 ACK 的消息
+func (d *dispatcher) markSent(msg protocol.Message, ack protocol.Message) {
+	request, ok := msg.(*send.SendMsg)
 	if !ok {
 		return
 	}
+	sendAck, ok := ack.(*send.SendAck)
+	if !ok {
+		return
+	}
+	msgTo, err := strconv.ParseInt(request.GetChatId(), 10, 64)
+	if err != nil {
+		return
+	}
+	chatType := request.GetChatType()
+	d.sent.Add(pushKey{
+		chatId:   sqllite.ResolveChatId(chatType, conf.UserId, msgTo),
+		chatType: chatType,
+		msgId:    sendAck.MsgId(),
+	}, struct{}{})
+}
 
-	msgTo, err := strconv.ParseInt(response.GetChatId(), 10, 64)
+// resolvePush 推送消息的唯一标识和发送方、接收方
+func resolvePush(response *push.RecvMsg) (key pushKey, msgFrom, msgTo int64, err error) {
+	msgTo, err = strconv.ParseInt(response.GetChatId(), 10, 64)
 	if err != nil {
-		logger.Errorf("dispatcher Push: parse chatId error: %v", err)
+		return key, 0, 0, fmt.Errorf("parse chatId: %w", err)
+	}
+	msgFrom, err = strconv.ParseInt(response.GetFrom(), 10, 64)
+	if err != nil {
+		return key, 0, 0, fmt.Errorf("parse from: %w", err)
+	}
+	chatType := response.GetChatType()
+	key = pushKey{
+		chatId:   sqllite.ResolveChatId(chatType, msgFrom, msgTo),
+		chatType: chatType,
+		msgId:    response.MsgId(),
+	}
+	return key, msgFrom, msgTo, nil
+}
+
+// dispatchKey 推送消息的分片键, 同一个会话的消息和会话设置按收到的顺序处理.
+// 不属于某个会话的消息, 以及同时修改多个会话的设置, 都在分片 0 中处理
+func (d *dispatcher) dispatchKey(msg protocol.Message) int64 {
+	switch m := msg.(type) {
+	case *push.RecvMsg:
+		key, _, _, err := resolvePush(m)
+		if err != nil {
+			return 0
+		}
+		return key.chatId
+	case *chatsync.SyncMsg:
+		if len(m.GetSettings()) != 1 {
+			return 0
+		}
+		chatId, _ := strconv.ParseInt(m.GetSettings()[0].GetChatId(), 10, 64)
+		return chatId
+	}
+	return 0
+}
+
+func (d *dispatcher) handleKickout(msg protocol.Message) {
+	kickout, ok := msg.(*transport.Kickout)
+	if !ok {
 		return
 	}
+	logger.Infof("dispatcher: kicked out, reason: %s, message: %s, device: %s %s",
+		kickout.GetReason(), kickout.GetMessage(), kickout.GetDeviceId(), kickout.GetPlatform())
+	info := &KickedOut{
+		Reason:   kickout.GetReason(),
+		Message:  kickout.GetMessage(),
+		DeviceId: kickout.GetDeviceId(),
+		Platform: kickout.GetPlatform(),
+	}
+	if d.onKickout != nil {
+		d.onKickout(info)
+	}
+}
 
-	msgFrom, err := strconv.ParseInt(response.GetFrom(), 10, 64)
+func (d *dispatcher) handlePush(ctx context.Context, resp protocol.Message) {
+	response, ok := resp.(*push.RecvMsg)
+	if !ok {
+		return
+	}
+
+	key, msgFrom, msgTo, err := resolvePush(response)
 	if err != nil {
-		logger.Errorf("dispatcher Push: parse from error: %v", err)
+		logger.Errorf("dispatcher Push: %v", err)
 		return
 	}
 
-	logger.Infof("dispatcher Push: received message, msgId: %v, chatType: %d", response.MsgId(), response.GetChatType())
+	// 黑名单中的用户的消息已经回复了 ACK, 直接丢弃
+	if msgFrom != conf.UserId {
+		if blocked, err := d.store.Contacts.IsBlocked(ctx, msgFrom); err != nil {
+			logger.Errorf("dispatcher Push: check blocked error: %v", err)
+		} else if blocked {
+			logger.Infof("dispatcher Push: drop message from blocked user %d, msgId: %v", msgFrom, response.MsgId())
+			return
+		}
+	}
+
+	chatType, chatId := key.chatType, key.chatId
+	if msgFrom == conf.UserId {
+		logger.Infof("dispatcher Push: message sent from other device, msgId: %v, chatId: %d", response.MsgId(), chatId)
+	} else {
+		logger.Infof("dispatcher Push: received message, msgId: %v, chatType: %d", response.MsgId(), chatType)
+	}
 
 	content, contentType := payload.ExtractContent(response.GetPayload())
-	chatType := response.GetChatType()
 
-	message := sqllite.NewMessage(chatType, msgFrom, response.MsgId(),
+	message := sqllite.NewMessage(chatType, chatId, response.MsgId(),
 		msgFrom, msgTo,
 		response.GetFromUserType(), response.GetToUserType(),
 		response.MsgSeq(), content, contentType,
 		response.CmdId(),
 		response.GetSendTimestamp(), 0, response.ServerSeq())
 
-	if err := d.store.Messages.Save(context.Background(), message); err != nil {
+	created, err := d.store.Messages.SaveIfAbsent(ctx, message)
+	if err != nil {
 		logger.Errorf("dispatcher Push: save message error: %v", err)
-		d.events.fire(Event{Type: EventError, Data: err})
+		d.events.publish(ErrorEvent{Err: err})
+		return
+	}
+	d.seen.Add(key, struct{}{})
+	if !created {
+		d.dropDuplicate(key, msgFrom)
+		return
+	}
+
+	d.store.Chats.UpdateVersion(ctx, chatId, chatType)
+	d.events.publish(MessageReceivedEvent{Message: message})
+	if msgFrom == conf.UserId {
+		return
+	}
+	if chat, err := d.store.Chats.GetOrCreate(ctx, chatId, chatType); err == nil && !chat.ChatMute {
+		d.events.publish(NotificationEvent{Message: message})
+	}
+}
+
+// dropDuplicate 丢弃已经保存过的推送消息, 本设备发出的消息被推送回来时不计入重复推送
+func (d *dispatcher) dropDuplicate(key pushKey, msgFrom int64) {
+	if msgFrom == conf.UserId && d.sent.Contains(key) {
+		logger.Infof("dispatcher Push: drop echo of message sent from this device, msgId: %d, chatId: %d", key.msgId, key.chatId)
 		return
 	}
+	d.duplicates.Add(1)
+	logger.Infof("dispatcher Push: drop duplicate message, msgId: %d, chatId: %d, chatType: %d", key.msgId, key.chatId, key.chatType)
+}
+
+// handleChatSync 其他设备修改了会话的设置, 例如已读游标、置顶、免打扰和删除
+func (d *dispatcher) handleChatSync(ctx context.Context, msg protocol.Message) {
+	sync, ok := msg.(*chatsync.SyncMsg)
+	if !ok {
+		return
+	}
+	for _, settings := range sync.GetSettings() {
+		chatId, err := strconv.ParseInt(settings.GetChatId(), 10, 64)
+		if err != nil {
+			logger.Errorf("dispatcher ChatSync: parse chatId error: %v", err)
+			continue
+		}
+		chat, err := d.store.Chats.GetOrCreate(ctx, chatId, settings.GetChatType())
+		if err != nil {
+			logger.Errorf("dispatcher ChatSync: get chat error: %v", err)
+			continue
+		}
+		delTimestamp := chat.DelTimestamp
+		applyChatSettings(chat, settings)
+		if err = d.store.Chats.Save(ctx, chat); err != nil {
+			logger.Errorf("dispatcher ChatSync: save chat error: %v", err)
+			d.events.publish(ErrorEvent{Err: err})
+			continue
+		}
+		// 其他设备删除了会话, 清空本地的历史消息
+		if chat.DelTimestamp > delTimestamp {
+			if err = d.store.Messages.DeleteBefore(ctx, chatId, chat.ChatType, chat.DelTimestamp); err != nil {
+				logger.Errorf("dispatcher ChatSync: delete messages error: %v", err)
+			}
+		}
+		logger.Infof("dispatcher ChatSync: chat updated by other device: %v", chat)
+		d.events.publish(ChatUpdatedEvent{Info: chat})
+	}
+}
 
-	d.store.Chats.UpdateVersion(context.Background(), msgFrom, chatType)
-	d.events.fire(Event{Type: EventMessageReceived, Data: message})
+// handleFriendNotify 收到新的好友申请, 或者发出的申请被对方处理
+func (d *dispatcher) handleFriendNotify(ctx context.Context, msg protocol.Message) {
+	notify, ok := msg.(*contact.FriendNotify)
+	if !ok || notify.GetRequest() == nil {
+		return
+	}
+	request, err := friendRequest(notify.GetRequest())
+	if err != nil {
+		logger.Errorf("dispatcher FriendNotify: %v", err)
+		return
+	}
+	// 黑名单中的用户发来的申请不再提醒
+	if request.FromUserId != conf.UserId {
+		if blocked, _ := d.store.Contacts.IsBlocked(ctx, request.FromUserId); blocked {
+			logger.Infof("dispatcher FriendNotify: drop request from blocked user %d", request.FromUserId)
+			return
+		}
+	}
+	if err = service.HandleFriendNotify(ctx, request); err != nil {
+		logger.Errorf("dispatcher FriendNotify: save friend request error: %v", err)
+		d.events.publish(ErrorEvent{Err: err})
+		return
+	}
+	logger.Infof("dispatcher FriendNotify: friend request: %+v", request)
+	d.events.publish(FriendRequestEvent{Request: request})
 }
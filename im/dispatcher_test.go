@@ -0,0 +1,125 @@
+package im
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	pb "github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol/push"
+	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestDispatcher_DuplicatePush(t *testing.T) {
+	if err := logger.InitLogger(); err != nil {
+		t.Fatal(err)
+	}
+	conf.UserId = 1
+	if err := sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}); err != nil {
+		t.Fatal(err)
+	}
+	store := newStore()
+	events := newEventBus()
+	sub := events.subscribe(context.Background(), WithEventTypes(EventMessageReceived))
+	defer sub.Close()
+
+	msg := &push.RecvMsg{PushPktRequest: &pb.PushPktRequest{
+		From:      "2",
+		ChatId:    "1",
+		ChatType:  1,
+		MsgId:     100,
+		ServerSeq: 1,
+		Payload: &pb.Payload{
+			PayloadType: pb.PayloadType_TEXT,
+			Content:     &pb.Payload_Text{Text: &pb.TextPayload{Content: "hello"}},
+		},
+	}}
+	d := newDispatcher(store, events, nil)
+	d.dispatch(msg)
+	// 窗口内的重复推送
+	d.dispatch(msg)
+	if got := d.duplicates.Load(); got != 1 {
+		t.Fatalf("duplicates = %d", got)
+	}
+	// 窗口之外的重复推送由数据库识别, 例如重启之后
+	restarted := newDispatcher(store, events, nil)
+	restarted.dispatch(msg)
+	if got := restarted.duplicates.Load(); got != 1 {
+		t.Fatalf("duplicates after restart = %d", got)
+	}
+
+	if len(sub.Events()) != 1 {
+		t.Fatalf("received %d events", len(sub.Events()))
+	}
+	evt := (<-sub.Events()).(MessageReceivedEvent)
+	if evt.Message.ChatID != 2 || evt.Message.MsgID != 100 {
+		t.Fatalf("unexpected message %v", evt.Message)
+	}
+}
+
+func TestDispatcher_KeyChanged(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	conf.UserId = 1
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	ctx := context.Background()
+	directory := &memoryKeyDirectory{keys: make(map[int64][]byte)}
+	alice := newTestSession(t, 2, directory)
+	bob := newTestSession(t, 1, directory)
+	pinned, err := bob.PeerFingerprint(ctx, 2)
+	assert.Nil(t, err)
+
+	events := newEventBus()
+	sub := events.subscribe(ctx, WithEventTypes(EventKeyChanged, EventError))
+	defer sub.Close()
+	d := newDispatcher(newStore(), events, bob)
+
+	// alice 换了新的身份密钥, 保留原来的公钥并发出 KeyChangedEvent
+	alice = newTestSession(t, 2, directory)
+	encrypted, err := alice.Encrypt(ctx, 1, payload.NewTextMessage("hello", false, nil))
+	assert.Nil(t, err)
+	d.dispatch(&push.RecvMsg{PushPktRequest: &pb.PushPktRequest{
+		From: "2", ChatId: "1", ChatType: 1, MsgId: 1, ServerSeq: 1, Payload: encrypted,
+	}})
+	assert.Equal(t, 1, len(sub.Events()))
+	changed := (<-sub.Events()).(KeyChangedEvent)
+	assert.Equal(t, KeyChangedEvent{Peer: 2, Pinned: pinned, Pending: alice.Fingerprint()}, changed)
+	fingerprint, err := bob.PeerFingerprint(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, pinned, fingerprint)
+}
+
+func TestDispatcher_SelfEcho(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	conf.UserId = 1
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	ctx := context.Background()
+	store := newStore()
+	d := newDispatcher(store, newEventBus(), nil)
+	newPush := func(from, chatId string, msgId int64) *push.RecvMsg {
+		return &push.RecvMsg{PushPktRequest: &pb.PushPktRequest{
+			From: from, ChatId: chatId, ChatType: 1, MsgId: msgId, ServerSeq: msgId,
+			Payload: payload.NewTextMessage("hello", false, nil),
+		}}
+	}
+
+	// 本设备发出并保存的消息被推送回来, 不算重复推送
+	request := send.NewSendMsg(1, 2, 1, payload.NewTextMessage("hello", false, nil), 0, 0)
+	d.markSent(request, &send.SendAck{SendPktResponse: &pb.SendPktResponse{MsgId: 1, ServerSeq: 1}})
+	assert.Nil(t, store.Messages.Save(ctx, sqllite.NewMessage(1, 2, 1, 1, 2, 0, 0, 0, "hello",
+		int32(pb.PayloadType_TEXT), int32(pb.CmdId_CMD_ID_SEND), 0, 0, 1)))
+	d.dispatch(newPush("1", "2", 1))
+	d.dispatch(newPush("1", "2", 1))
+	assert.Equal(t, int64(0), d.duplicates.Load())
+
+	// 其他设备发出的消息和对方的消息重复推送时计数
+	d.dispatch(newPush("1", "2", 2))
+	d.dispatch(newPush("1", "2", 2))
+	d.dispatch(newPush("2", "1", 3))
+	d.dispatch(newPush("2", "1", 3))
+	assert.Equal(t, int64(2), d.duplicates.Load())
+}
@@ -0,0 +1,124 @@
+package im
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/xuning888/helloIMClient/im/e2e"
+	http2 "github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/service"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpKeyDirectory 通过 WebAPI 上传和获取身份公钥
+type httpKeyDirectory struct{}
+
+func (httpKeyDirectory) PublishKey(ctx context.Context, uid int64, publicKey []byte) error {
+	return http2.UploadPublicKey(ctx, uid, publicKey)
+}
+
+func (httpKeyDirectory) LookupKey(ctx context.Context, uid int64) ([]byte, error) {
+	return http2.GetPublicKey(ctx, uid)
+}
+
+// newE2ESession 身份私钥保存在 ~/.helloIm/<uid>/identity.key, 第一次开启时生成.
+// 对方的公钥保存在同一目录的 peers.json
+func newE2ESession(uid int64, directory e2e.KeyDirectory) (*e2e.Session, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(homeDir, ".helloIm", fmt.Sprintf("%d", uid))
+	identity, err := e2e.LoadOrCreateIdentity(filepath.Join(dir, "identity.key"))
+	if err != nil {
+		return nil, err
+	}
+	session, err := e2e.NewSession(uid, identity, directory, e2e.WithPeersFile(filepath.Join(dir, "peers.json")))
+	if err != nil {
+		return nil, err
+	}
+	service.SetMessageDecryptor(session.DecryptMessage)
+	return session, nil
+}
+
+// E2EEnabled 是否开启了单聊的端到端加密
+func (c *Client) E2EEnabled() bool {
+	return c.e2e != nil
+}
+
+// encrypt 发送拦截器链的最后一环, 加密单聊消息. 只修改副本, 调用方的消息保持明文
+func (c *Client) encrypt(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+	request, ok := msg.(*send.SendMsg)
+	if !ok || c.e2e == nil || request.GetChatType() != 1 ||
+		request.GetPayload().GetPayloadType() == helloim_proto.PayloadType_ENCRYPTED {
+		return next(ctx, msg)
+	}
+	chatId, err := strconv.ParseInt(request.GetChatId(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse chatId: %w", err)
+	}
+	encrypted, err := c.e2e.Encrypt(ctx, chatId, request.GetPayload())
+	if err != nil {
+		return nil, err
+	}
+	pkt := proto.Clone(request.SendPktRequest).(*helloim_proto.SendPktRequest)
+	pkt.Payload = encrypted
+	return next(ctx, &send.SendMsg{SendPktRequest: pkt})
+}
+
+// EncryptPayload 开启端到端加密时加密单聊消息, 群聊或者没有开启时原样返回.
+// SendMessage 会自动加密, 只在需要自己处理密文时调用. 对方没有上传公钥时返回 e2e.ErrNoPeerKey
+func (c *Client) EncryptPayload(ctx context.Context, chatId int64, chatType int32, p *helloim_proto.Payload) (*helloim_proto.Payload, error) {
+	if c.e2e == nil || chatType != 1 {
+		return p, nil
+	}
+	return c.e2e.Encrypt(ctx, chatId, p)
+}
+
+// Fingerprint 自己的公钥指纹, 没有开启端到端加密时返回空字符串
+func (c *Client) Fingerprint() string {
+	if c.e2e == nil {
+		return ""
+	}
+	return c.e2e.Fingerprint()
+}
+
+// PeerFingerprint 单聊对方的公钥指纹, 用于双方核对
+func (c *Client) PeerFingerprint(ctx context.Context, uid int64) (string, error) {
+	if c.e2e == nil {
+		return "", fmt.Errorf("e2e not enabled")
+	}
+	return c.e2e.PeerFingerprint(ctx, uid)
+}
+
+// PendingPeerKey 单聊对方的公钥变更后还没有确认时, 返回当前使用的和变更后的公钥指纹
+func (c *Client) PendingPeerKey(uid int64) (pinned, pending string, ok bool) {
+	if c.e2e == nil {
+		return "", "", false
+	}
+	return c.e2e.PendingPeerKey(uid)
+}
+
+// TrustPeerKey 用户核对对方变更后的指纹后调用, 之后使用新的公钥收发消息
+func (c *Client) TrustPeerKey(uid int64, fingerprint string) error {
+	if c.e2e == nil {
+		return fmt.Errorf("e2e not enabled")
+	}
+	return c.e2e.TrustPeerKey(uid, fingerprint)
+}
+
+// publishKey 连接成功后上传公钥, 失败时不影响连接, 对方暂时无法给自己发送加密消息
+func (c *Client) publishKey(ctx context.Context) {
+	if c.e2e == nil {
+		return
+	}
+	if err := c.e2e.Publish(ctx); err != nil {
+		c.events.publish(ErrorEvent{Err: fmt.Errorf("上传公钥失败: %w", err)})
+	}
+}
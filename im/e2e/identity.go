@@ -0,0 +1,87 @@
+package e2e
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Identity 用户的身份密钥对, 使用 X25519
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity 生成新的身份密钥对
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// LoadOrCreateIdentity 从 path 读取私钥, 文件不存在时生成新的密钥对并保存, 文件只有本人可读写
+func LoadOrCreateIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := ecdh.X25519().NewPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("identity key %s: %w", path, err)
+		}
+		return &Identity{key: key}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	identity, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// O_EXCL 避免多个进程同时生成时互相覆盖
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return LoadOrCreateIdentity(path)
+		}
+		return nil, err
+	}
+	if _, err = f.Write(identity.key.Bytes()); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// PublicKey 公钥, 上传到服务端供其他用户加密
+func (i *Identity) PublicKey() []byte {
+	return i.key.PublicKey().Bytes()
+}
+
+// Fingerprint 公钥的指纹
+func (i *Identity) Fingerprint() string {
+	return Fingerprint(i.PublicKey())
+}
+
+// Fingerprint 公钥 SHA-256 的前 16 个字节, 每 4 个十六进制字符一组, 便于双方当面或者通过其他渠道核对
+func Fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	digits := strings.ToUpper(hex.EncodeToString(sum[:16]))
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
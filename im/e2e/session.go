@@ -0,0 +1,349 @@
+package e2e
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrNoPeerKey   = errors.New("peer has not published an identity key")
+	ErrKeyMismatch = errors.New("identity key fingerprint mismatch")
+	ErrDecrypt     = errors.New("decrypt failed")
+	// ErrKeyChanged 目录中对方的公钥与缓存的不同, 用户核对新的指纹并调用 TrustPeerKey 之前不收发加密消息
+	ErrKeyChanged = errors.New("peer identity key changed")
+)
+
+// KeyDirectory 身份公钥的目录, 由服务端的 WebAPI 提供
+type KeyDirectory interface {
+	PublishKey(ctx context.Context, uid int64, publicKey []byte) error
+	// LookupKey 用户没有上传过公钥时返回 nil
+	LookupKey(ctx context.Context, uid int64) ([]byte, error)
+}
+
+// Session 端到端加密单聊消息.
+// 密钥由双方身份密钥的 X25519 协商结果经 HKDF-SHA256 派生, 消息使用 AES-256-GCM 加密,
+// 发送方和接收方的 uid 作为附加数据, 服务端不能把密文转发到其他会话.
+// Note: 使用长期的身份密钥协商, 没有前向安全, 身份私钥泄漏后历史消息都可以被解密
+type Session struct {
+	uid       int64
+	identity  *Identity
+	directory KeyDirectory
+
+	mu sync.Mutex
+	// peers 对方的公钥, 首次使用时从目录获取, 之后一直使用缓存, 避免服务端在会话中途替换公钥
+	peers map[int64]*ecdh.PublicKey
+	// pending 目录中与缓存不同的新公钥, 用户确认之前不替换缓存
+	pending map[int64]*ecdh.PublicKey
+	// peersPath 缓存的对方公钥保存的文件, 为空时只保存在内存中
+	peersPath string
+}
+
+type SessionOption func(s *Session)
+
+// WithPeersFile 把缓存的对方公钥保存到 path, 重启后继续使用第一次获取的公钥
+func WithPeersFile(path string) SessionOption {
+	return func(s *Session) {
+		s.peersPath = path
+	}
+}
+
+func NewSession(uid int64, identity *Identity, directory KeyDirectory, opts ...SessionOption) (*Session, error) {
+	s := &Session{
+		uid:       uid,
+		identity:  identity,
+		directory: directory,
+		peers:     make(map[int64]*ecdh.PublicKey),
+		pending:   make(map[int64]*ecdh.PublicKey),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.loadPeers(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadPeers 读取保存的对方公钥, 文件不存在时不做处理
+func (s *Session) loadPeers() error {
+	if s.peersPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.peersPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]string
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("peer keys %s: %w", s.peersPath, err)
+	}
+	for uid, hexKey := range saved {
+		peer, err := strconv.ParseInt(uid, 10, 64)
+		if err != nil {
+			return fmt.Errorf("peer keys %s: %w", s.peersPath, err)
+		}
+		raw, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return fmt.Errorf("peer keys %s: %w", s.peersPath, err)
+		}
+		key, err := ecdh.X25519().NewPublicKey(raw)
+		if err != nil {
+			return fmt.Errorf("peer keys %s: %w", s.peersPath, err)
+		}
+		s.peers[peer] = key
+	}
+	return nil
+}
+
+// savePeers 保存缓存的对方公钥, 先写临时文件再替换, 避免写到一半时丢失已有的公钥. 调用方持有 s.mu
+func (s *Session) savePeers() error {
+	if s.peersPath == "" {
+		return nil
+	}
+	saved := make(map[string]string, len(s.peers))
+	for peer, key := range s.peers {
+		saved[strconv.FormatInt(peer, 10)] = hex.EncodeToString(key.Bytes())
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.peersPath), 0700); err != nil {
+		return err
+	}
+	tmp := s.peersPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.peersPath)
+}
+
+// Publish 上传自己的身份公钥
+func (s *Session) Publish(ctx context.Context) error {
+	return s.directory.PublishKey(ctx, s.uid, s.identity.PublicKey())
+}
+
+// Fingerprint 自己的公钥指纹
+func (s *Session) Fingerprint() string {
+	return s.identity.Fingerprint()
+}
+
+// PeerFingerprint 对方的公钥指纹
+func (s *Session) PeerFingerprint(ctx context.Context, peer int64) (string, error) {
+	key, err := s.peerKey(ctx, peer, false)
+	if err != nil {
+		return "", err
+	}
+	return Fingerprint(key.Bytes()), nil
+}
+
+// PendingPeerKey 对方变更后还没有确认的公钥指纹, 以及当前使用的公钥指纹
+func (s *Session) PendingPeerKey(peer int64) (pinned, pending string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.pending[peer]
+	if !ok {
+		return "", "", false
+	}
+	return Fingerprint(s.peers[peer].Bytes()), Fingerprint(key.Bytes()), true
+}
+
+// TrustPeerKey 用户通过其他渠道核对对方的新指纹后, 用新的公钥替换缓存.
+// fingerprint 必须与变更后的公钥一致, 避免确认的不是用户核对过的公钥
+func (s *Session) TrustPeerKey(peer int64, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.pending[peer]
+	if !ok {
+		return fmt.Errorf("identity key of %d has not changed", peer)
+	}
+	if normalizeFingerprint(fingerprint) != normalizeFingerprint(Fingerprint(key.Bytes())) {
+		return fmt.Errorf("%w: peer %d, expected %s", ErrKeyMismatch, peer, Fingerprint(key.Bytes()))
+	}
+	s.peers[peer] = key
+	delete(s.pending, peer)
+	return s.savePeers()
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(fingerprint), ""))
+}
+
+// peerKey 获取对方的公钥, refresh 为 true 时忽略缓存重新获取.
+// 目录中的公钥与缓存的不同时保留缓存, 返回 ErrKeyChanged, 由用户确认是否使用新的公钥
+func (s *Session) peerKey(ctx context.Context, peer int64, refresh bool) (*ecdh.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.peers[peer]
+	s.mu.Unlock()
+	if ok && !refresh {
+		return key, nil
+	}
+	data, err := s.directory.LookupKey(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrNoPeerKey, peer)
+	}
+	if key, err = ecdh.X25519().NewPublicKey(data); err != nil {
+		return nil, fmt.Errorf("peer %d identity key: %w", peer, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.peers[peer]; ok {
+		if old.Equal(key) {
+			delete(s.pending, peer)
+			return old, nil
+		}
+		s.pending[peer] = key
+		logger.Warnf("e2e: identity key of %d changed: %s -> %s", peer, Fingerprint(old.Bytes()), Fingerprint(key.Bytes()))
+		return nil, fmt.Errorf("%w: peer %d, pinned %s, directory %s",
+			ErrKeyChanged, peer, Fingerprint(old.Bytes()), Fingerprint(key.Bytes()))
+	}
+	s.peers[peer] = key
+	if err = s.savePeers(); err != nil {
+		logger.Errorf("e2e: save identity key of %d: %v", peer, err)
+	}
+	return key, nil
+}
+
+// aead 与 peer 之间的消息密钥, 双方协商出的结果相同
+func (s *Session) aead(peer int64, peerKey *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := s.identity.key.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	info := fmt.Sprintf("helloIm e2e v1 %d %d", min(s.uid, peer), max(s.uid, peer))
+	key, err := hkdf.Key(sha256.New, shared, nil, info, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData(from, to int64) []byte {
+	return fmt.Appendf(nil, "%d>%d", from, to)
+}
+
+// Encrypt 加密发给 peer 的消息, 对方没有上传公钥时返回 ErrNoPeerKey,
+// 对方的公钥变更后还没有确认时返回 ErrKeyChanged
+func (s *Session) Encrypt(ctx context.Context, peer int64, p *helloim_proto.Payload) (*helloim_proto.Payload, error) {
+	if _, pending, ok := s.PendingPeerKey(peer); ok {
+		return nil, fmt.Errorf("%w: peer %d, unconfirmed %s", ErrKeyChanged, peer, pending)
+	}
+	peerKey, err := s.peerKey(ctx, peer, false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(peer, peerKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := proto.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return payload.NewEncryptedMessage(&helloim_proto.EncryptedPayload{
+		Nonce:                nonce,
+		Ciphertext:           aead.Seal(nil, nonce, plaintext, additionalData(s.uid, peer)),
+		SenderFingerprint:    s.Fingerprint(),
+		RecipientFingerprint: Fingerprint(peerKey.Bytes()),
+	}), nil
+}
+
+// Decrypt 解密 from 发给 to 的消息, 自己发出的消息也可以解密.
+// 消息中对方的指纹与缓存的公钥不同时重新获取一次公钥, 目录中的公钥变更时返回 ErrKeyChanged,
+// 仍然使用缓存的公钥时返回 ErrKeyMismatch
+func (s *Session) Decrypt(ctx context.Context, from, to int64, p *helloim_proto.Payload) (*helloim_proto.Payload, error) {
+	encrypted := p.GetEncrypted()
+	if encrypted == nil {
+		return nil, fmt.Errorf("%w: not an encrypted payload", ErrDecrypt)
+	}
+	peer, ownFingerprint, peerFingerprint := from, encrypted.GetRecipientFingerprint(), encrypted.GetSenderFingerprint()
+	if from == s.uid {
+		peer, ownFingerprint, peerFingerprint = to, encrypted.GetSenderFingerprint(), encrypted.GetRecipientFingerprint()
+	}
+	if ownFingerprint != s.Fingerprint() {
+		return nil, fmt.Errorf("%w: encrypted for %s, own key is %s", ErrKeyMismatch, ownFingerprint, s.Fingerprint())
+	}
+	peerKey, err := s.peerKey(ctx, peer, false)
+	if err != nil {
+		return nil, err
+	}
+	if Fingerprint(peerKey.Bytes()) != peerFingerprint {
+		if peerKey, err = s.peerKey(ctx, peer, true); err != nil {
+			return nil, err
+		}
+		if Fingerprint(peerKey.Bytes()) != peerFingerprint {
+			return nil, fmt.Errorf("%w: peer %d, message %s, directory %s",
+				ErrKeyMismatch, peer, peerFingerprint, Fingerprint(peerKey.Bytes()))
+		}
+	}
+	aead, err := s.aead(peer, peerKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted.GetNonce()) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrDecrypt)
+	}
+	plaintext, err := aead.Open(nil, encrypted.GetNonce(), encrypted.GetCiphertext(), additionalData(from, to))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	decrypted := &helloim_proto.Payload{}
+	if err = proto.Unmarshal(plaintext, decrypted); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	if decrypted.GetPayloadType() == helloim_proto.PayloadType_ENCRYPTED {
+		return nil, fmt.Errorf("%w: nested encrypted payload", ErrDecrypt)
+	}
+	return decrypted, nil
+}
+
+// DecryptMessage 解密从服务端拉取的消息, 替换 MsgContent 和 ContentType, 不是加密消息时不做处理
+func (s *Session) DecryptMessage(ctx context.Context, msg *sqllite.ChatMessage) error {
+	if msg == nil || msg.ContentType != int32(helloim_proto.PayloadType_ENCRYPTED) {
+		return nil
+	}
+	encrypted, err := payload.ParseEncrypted(msg.MsgContent)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	decrypted, err := s.Decrypt(ctx, msg.MsgFrom, msg.MsgTo, payload.NewEncryptedMessage(encrypted))
+	if err != nil {
+		return err
+	}
+	msg.MsgContent, msg.ContentType = payload.ExtractContent(decrypted)
+	return nil
+}
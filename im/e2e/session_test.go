@@ -0,0 +1,221 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// fakeDirectory 内存中的公钥目录
+type fakeDirectory struct {
+	mu   sync.Mutex
+	keys map[int64][]byte
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{keys: make(map[int64][]byte)}
+}
+
+func (d *fakeDirectory) PublishKey(ctx context.Context, uid int64, publicKey []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys[uid] = publicKey
+	return nil
+}
+
+func (d *fakeDirectory) LookupKey(ctx context.Context, uid int64) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.keys[uid], nil
+}
+
+func newSession(t *testing.T, uid int64, directory KeyDirectory) *Session {
+	identity, err := GenerateIdentity()
+	assert.Nil(t, err)
+	session, err := NewSession(uid, identity, directory)
+	assert.Nil(t, err)
+	assert.Nil(t, session.Publish(context.Background()))
+	return session
+}
+
+func TestLoadOrCreateIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1", "identity.key")
+	identity, err := LoadOrCreateIdentity(path)
+	assert.Nil(t, err)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := LoadOrCreateIdentity(path)
+	assert.Nil(t, err)
+	assert.Equal(t, identity.PublicKey(), loaded.PublicKey())
+	assert.Len(t, identity.Fingerprint(), 39)
+
+	assert.Nil(t, os.WriteFile(path, []byte("short"), 0600))
+	_, err = LoadOrCreateIdentity(path)
+	assert.NotNil(t, err)
+}
+
+func TestSession_EncryptDecrypt(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	directory := newFakeDirectory()
+	alice, bob := newSession(t, 1, directory), newSession(t, 2, directory)
+
+	plain := payload.NewTextMessage("hello bob", false, nil)
+	encrypted, err := alice.Encrypt(ctx, 2, plain)
+	assert.Nil(t, err)
+	assert.Equal(t, helloim_proto.PayloadType_ENCRYPTED, encrypted.GetPayloadType())
+	assert.NotContains(t, string(encrypted.GetEncrypted().GetCiphertext()), "hello bob")
+
+	decrypted, err := bob.Decrypt(ctx, 1, 2, encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello bob", decrypted.GetText().GetContent())
+
+	// 发送方也可以解密自己发出的消息
+	decrypted, err = alice.Decrypt(ctx, 1, 2, encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello bob", decrypted.GetText().GetContent())
+
+	// 发送方和接收方是附加数据, 不能转发到其他会话
+	carol := newSession(t, 3, directory)
+	_, err = bob.Decrypt(ctx, 3, 2, encrypted)
+	assert.True(t, errors.Is(err, ErrKeyMismatch) || errors.Is(err, ErrDecrypt))
+	_, err = carol.Decrypt(ctx, 1, 3, encrypted)
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+
+	// 篡改密文
+	encrypted.GetEncrypted().Ciphertext[0] ^= 1
+	_, err = bob.Decrypt(ctx, 1, 2, encrypted)
+	assert.True(t, errors.Is(err, ErrDecrypt))
+}
+
+func TestSession_NoPeerKey(t *testing.T) {
+	directory := newFakeDirectory()
+	alice := newSession(t, 1, directory)
+	_, err := alice.Encrypt(context.Background(), 2, payload.NewTextMessage("hello", false, nil))
+	assert.True(t, errors.Is(err, ErrNoPeerKey))
+}
+
+func TestSession_KeyChanged(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	directory := newFakeDirectory()
+	alice, bob := newSession(t, 1, directory), newSession(t, 2, directory)
+	fingerprint, err := bob.PeerFingerprint(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Fingerprint(), fingerprint)
+
+	// alice 换了新的身份密钥, bob 保留缓存的旧公钥, 确认之前不收发加密消息
+	oldFingerprint := alice.Fingerprint()
+	alice = newSession(t, 1, directory)
+	encrypted, err := alice.Encrypt(ctx, 2, payload.NewTextMessage("new key", false, nil))
+	assert.Nil(t, err)
+	_, err = bob.Decrypt(ctx, 1, 2, encrypted)
+	assert.True(t, errors.Is(err, ErrKeyChanged), err)
+	fingerprint, err = bob.PeerFingerprint(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, oldFingerprint, fingerprint)
+	pinned, pending, ok := bob.PendingPeerKey(1)
+	assert.True(t, ok)
+	assert.Equal(t, oldFingerprint, pinned)
+	assert.Equal(t, alice.Fingerprint(), pending)
+	_, err = bob.Encrypt(ctx, 1, payload.NewTextMessage("hello", false, nil))
+	assert.True(t, errors.Is(err, ErrKeyChanged), err)
+
+	// 确认的指纹必须是变更后的公钥
+	assert.True(t, errors.Is(bob.TrustPeerKey(1, oldFingerprint), ErrKeyMismatch))
+	assert.NotNil(t, bob.TrustPeerKey(3, alice.Fingerprint()))
+	assert.Nil(t, bob.TrustPeerKey(1, strings.ToLower(alice.Fingerprint())))
+	_, _, ok = bob.PendingPeerKey(1)
+	assert.False(t, ok)
+	decrypted, err := bob.Decrypt(ctx, 1, 2, encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "new key", decrypted.GetText().GetContent())
+
+	// 服务端替换了目录中的公钥, 已经缓存的公钥不受影响
+	mallory, err := GenerateIdentity()
+	assert.Nil(t, err)
+	assert.Nil(t, directory.PublishKey(ctx, 1, mallory.PublicKey()))
+	_, err = bob.Decrypt(ctx, 1, 2, encrypted)
+	assert.Nil(t, err)
+
+	// 没有缓存时, 目录中的公钥与消息中的指纹不一致
+	restarted, err := NewSession(2, bob.identity, directory)
+	assert.Nil(t, err)
+	_, err = restarted.Decrypt(ctx, 1, 2, encrypted)
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+}
+
+func TestSession_PeersFile(t *testing.T) {
+	logger.InitLogger()
+	ctx := context.Background()
+	directory := newFakeDirectory()
+	path := filepath.Join(t.TempDir(), "2", "peers.json")
+	alice := newSession(t, 1, directory)
+	bob, err := GenerateIdentity()
+	assert.Nil(t, err)
+	session, err := NewSession(2, bob, directory, WithPeersFile(path))
+	assert.Nil(t, err)
+	fingerprint, err := session.PeerFingerprint(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Fingerprint(), fingerprint)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// 重启后目录中的公钥被替换, 仍然使用第一次获取的公钥
+	mallory, err := GenerateIdentity()
+	assert.Nil(t, err)
+	assert.Nil(t, directory.PublishKey(ctx, 1, mallory.PublicKey()))
+	restarted, err := NewSession(2, bob, directory, WithPeersFile(path))
+	assert.Nil(t, err)
+	fingerprint, err = restarted.PeerFingerprint(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Fingerprint(), fingerprint)
+	encrypted, err := restarted.Encrypt(ctx, 1, payload.NewTextMessage("hello", false, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Fingerprint(), encrypted.GetEncrypted().GetRecipientFingerprint())
+
+	// 确认新的公钥后, 再次重启使用确认的公钥
+	_, err = restarted.peerKey(ctx, 1, true)
+	assert.True(t, errors.Is(err, ErrKeyChanged), err)
+	assert.Nil(t, restarted.TrustPeerKey(1, Fingerprint(mallory.PublicKey())))
+	trusted, err := NewSession(2, bob, directory, WithPeersFile(path))
+	assert.Nil(t, err)
+	fingerprint, err = trusted.PeerFingerprint(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, Fingerprint(mallory.PublicKey()), fingerprint)
+
+	assert.Nil(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err = NewSession(2, bob, directory, WithPeersFile(path))
+	assert.NotNil(t, err)
+}
+
+func TestSession_DecryptMessage(t *testing.T) {
+	ctx := context.Background()
+	directory := newFakeDirectory()
+	alice, bob := newSession(t, 1, directory), newSession(t, 2, directory)
+	encrypted, err := alice.Encrypt(ctx, 2, payload.NewMarkdownMessage("**hi**", false, nil))
+	assert.Nil(t, err)
+	content, contentType := payload.ExtractContent(encrypted)
+	msg := &sqllite.ChatMessage{MsgFrom: 1, MsgTo: 2, MsgContent: content, ContentType: contentType}
+
+	assert.Nil(t, bob.DecryptMessage(ctx, msg))
+	assert.Equal(t, "**hi**", msg.MsgContent)
+	assert.Equal(t, int32(helloim_proto.PayloadType_MARKDOWN), msg.ContentType)
+
+	// 不是加密消息时不做处理
+	assert.Nil(t, bob.DecryptMessage(ctx, msg))
+	assert.Equal(t, "**hi**", msg.MsgContent)
+}
@@ -1,8 +1,13 @@
 package im
 
 import (
+	"context"
+	"slices"
 	"sync"
 	"sync/atomic"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/protocol"
 )
 
 // EventType 事件类型
@@ -15,45 +20,311 @@ const (
 	EventMessageReceived
 	EventMessageSent
 	EventError
+	// EventKickedOut 被服务端踢下线, 不再自动重连
+	EventKickedOut
+	// EventChatUpdated 会话的设置被其他设备修改
+	EventChatUpdated
+	// EventNotification 收到其他人的新消息并且会话没有开启免打扰, 在 EventMessageReceived 之后发出
+	EventNotification
+	// EventFriendRequest 收到新的好友申请, 或者发出的申请被处理
+	EventFriendRequest
+	// EventKeyChanged 单聊对方的身份公钥变更, 用户核对新的指纹并调用 TrustPeerKey 之前不收发加密消息
+	EventKeyChanged
+	// EventUsersFetched 本地没有或者过期的用户资料在后台获取完成, 界面可以重新渲染用户名
+	EventUsersFetched
 )
 
-// Event SDK 事件
-type Event struct {
-	Type EventType
-	Data interface{}
+// Event SDK 事件, 按具体的类型断言, 例如 MessageReceivedEvent
+type Event interface {
+	Type() EventType
+}
+
+// ChatEvent 属于某个会话的事件, 可以按会话订阅
+type ChatEvent interface {
+	Event
+	Chat() (chatId int64, chatType int32)
+}
+
+type ConnectedEvent struct{}
+
+type DisconnectedEvent struct{}
+
+type ConnectingEvent struct{}
+
+// MessageReceivedEvent 收到推送的消息, 包括自己在其他设备上发出的消息
+type MessageReceivedEvent struct {
+	Message *sqllite.ChatMessage
+}
+
+// MessageSentEvent 上行消息收到 ACK
+type MessageSentEvent struct {
+	Ack protocol.Message
+}
+
+type ErrorEvent struct {
+	Err error
+}
+
+type KickedOutEvent struct {
+	Info *KickedOut
+}
+
+type ChatUpdatedEvent struct {
+	Info *sqllite.ImChat
+}
+
+type NotificationEvent struct {
+	Message *sqllite.ChatMessage
 }
 
+type FriendRequestEvent struct {
+	Request *sqllite.FriendRequest
+}
+
+// KeyChangedEvent Pinned 是当前使用的公钥指纹, Pending 是目录中变更后的公钥指纹
+type KeyChangedEvent struct {
+	Peer    int64
+	Pinned  string
+	Pending string
+}
+
+type UsersFetchedEvent struct {
+	Users []*sqllite.ImUser
+}
+
+func (ConnectedEvent) Type() EventType       { return EventConnected }
+func (DisconnectedEvent) Type() EventType    { return EventDisconnected }
+func (ConnectingEvent) Type() EventType      { return EventConnecting }
+func (MessageReceivedEvent) Type() EventType { return EventMessageReceived }
+func (MessageSentEvent) Type() EventType     { return EventMessageSent }
+func (ErrorEvent) Type() EventType           { return EventError }
+func (KickedOutEvent) Type() EventType       { return EventKickedOut }
+func (ChatUpdatedEvent) Type() EventType     { return EventChatUpdated }
+func (NotificationEvent) Type() EventType    { return EventNotification }
+func (FriendRequestEvent) Type() EventType   { return EventFriendRequest }
+func (KeyChangedEvent) Type() EventType      { return EventKeyChanged }
+func (UsersFetchedEvent) Type() EventType    { return EventUsersFetched }
+
+func (e MessageReceivedEvent) Chat() (int64, int32) { return e.Message.ChatID, e.Message.ChatType }
+func (e NotificationEvent) Chat() (int64, int32)    { return e.Message.ChatID, e.Message.ChatType }
+func (e ChatUpdatedEvent) Chat() (int64, int32)     { return e.Info.ChatId, e.Info.ChatType }
+func (e KeyChangedEvent) Chat() (int64, int32)      { return e.Peer, 1 }
+
 // EventCallback 事件回调函数
 type EventCallback func(Event)
 
-type callbackRegistry struct {
-	mu        sync.RWMutex
-	listeners map[int64]EventCallback
-	counter   atomic.Int64
+// OverflowPolicy 订阅者的缓冲区满时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest 丢弃缓冲区中最旧的事件, 默认的处理方式
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest 丢弃新的事件
+	OverflowDropNewest
+	// OverflowBlock 阻塞发布事件的协程直到缓冲区有空位或者退订, 会拖慢消息的处理, 只用于不能丢失事件的订阅者
+	OverflowBlock
+)
+
+const defaultEventBufferSize = 256
+
+// SubscribeOptions 订阅的配置
+type SubscribeOptions struct {
+	Types      []EventType    // 订阅的事件类型, 为空时订阅所有类型
+	ChatId     int64          // 不为 0 时只订阅这个会话的 ChatEvent
+	ChatType   int32          // 与 ChatId 一起使用
+	BufferSize int            // 缓冲区的大小
+	Overflow   OverflowPolicy // 缓冲区满时的处理方式
+}
+
+func NewSubscribeOptions() *SubscribeOptions {
+	return &SubscribeOptions{
+		BufferSize: defaultEventBufferSize,
+		Overflow:   OverflowDropOldest,
+	}
+}
+
+type SubscribeOption func(opt *SubscribeOptions)
+
+func WithEventTypes(types ...EventType) SubscribeOption {
+	return func(opt *SubscribeOptions) {
+		opt.Types = append(opt.Types, types...)
+	}
+}
+
+func WithChat(chatId int64, chatType int32) SubscribeOption {
+	return func(opt *SubscribeOptions) {
+		opt.ChatId = chatId
+		opt.ChatType = chatType
+	}
+}
+
+func WithBufferSize(size int) SubscribeOption {
+	return func(opt *SubscribeOptions) {
+		opt.BufferSize = size
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(opt *SubscribeOptions) {
+		opt.Overflow = policy
+	}
+}
+
+// Subscription 一个订阅者, 事件按发布的顺序写入 Events 返回的 channel, 退订后 channel 被关闭
+type Subscription struct {
+	opts    *SubscribeOptions
+	ch      chan Event
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	closed  bool
+	dropped atomic.Int64
+	bus     *eventBus
+}
+
+// Events 接收事件的 channel
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped 缓冲区满时丢弃的事件数
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close 退订, 可以重复调用
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.remove(s)
+		// 等待正在进行的投递结束后再关闭 channel
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
 }
 
-func newCallbackRegistry() *callbackRegistry {
-	return &callbackRegistry{
-		listeners: make(map[int64]EventCallback),
+func (s *Subscription) match(evt Event) bool {
+	if len(s.opts.Types) > 0 && !slices.Contains(s.opts.Types, evt.Type()) {
+		return false
+	}
+	if s.opts.ChatId == 0 {
+		return true
+	}
+	chatEvent, ok := evt.(ChatEvent)
+	if !ok {
+		return false
 	}
+	chatId, chatType := chatEvent.Chat()
+	return chatId == s.opts.ChatId && chatType == s.opts.ChatType
+}
+
+// deliver 按缓冲区满时的处理方式投递事件
+func (s *Subscription) deliver(evt Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	switch s.opts.Overflow {
+	case OverflowBlock:
+		select {
+		case s.ch <- evt:
+		case <-s.done:
+		}
+	case OverflowDropNewest:
+		select {
+		case s.ch <- evt:
+		default:
+			s.dropped.Add(1)
+		}
+	default:
+		for {
+			select {
+			case s.ch <- evt:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// eventBus 事件总线, 发布时不持有锁, 订阅者在回调中订阅和退订不会死锁
+type eventBus struct {
+	mu   sync.RWMutex
+	subs []*Subscription
 }
 
-func (r *callbackRegistry) subscribe(cb EventCallback) func() {
-	id := r.counter.Add(1)
-	r.mu.Lock()
-	r.listeners[id] = cb
-	r.mu.Unlock()
-	return func() {
-		r.mu.Lock()
-		delete(r.listeners, id)
-		r.mu.Unlock()
+func newEventBus() *eventBus {
+	return &eventBus{}
+}
+
+// subscribe ctx 取消时自动退订
+func (b *eventBus) subscribe(ctx context.Context, opts ...SubscribeOption) *Subscription {
+	options := NewSubscribeOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = 1
+	}
+	sub := &Subscription{
+		opts: options,
+		ch:   make(chan Event, options.BufferSize),
+		done: make(chan struct{}),
+		bus:  b,
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+			case <-sub.done:
+			}
+		}()
 	}
+	return sub
 }
 
-func (r *callbackRegistry) fire(evt Event) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	for _, cb := range r.listeners {
-		cb(evt)
+func (b *eventBus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = slices.DeleteFunc(b.subs, func(s *Subscription) bool { return s == sub })
+}
+
+func (b *eventBus) publish(evt Event) {
+	b.mu.RLock()
+	subs := slices.Clone(b.subs)
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		if sub.match(evt) {
+			sub.deliver(evt)
+		}
 	}
 }
+
+// listen 订阅事件并在新的协程中按顺序调用 cb, 返回退订函数.
+// 退订后缓冲区中剩余的事件不再回调, 只有退订时正在执行的回调会执行完
+func (b *eventBus) listen(cb EventCallback, opts ...SubscribeOption) func() {
+	sub := b.subscribe(context.Background(), opts...)
+	go func() {
+		for evt := range sub.Events() {
+			select {
+			case <-sub.done:
+				return
+			default:
+			}
+			cb(evt)
+		}
+	}()
+	return sub.Close
+}
@@ -0,0 +1,167 @@
+package im
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+)
+
+func receivedEvent(chatId int64, chatType int32) MessageReceivedEvent {
+	return MessageReceivedEvent{Message: &sqllite.ChatMessage{ChatID: chatId, ChatType: chatType}}
+}
+
+func TestEventBus_Filter(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(context.Background(), WithEventTypes(EventMessageReceived), WithChat(1, 1))
+	defer sub.Close()
+
+	bus.publish(ConnectedEvent{})
+	bus.publish(receivedEvent(2, 1))
+	bus.publish(receivedEvent(1, 2))
+	bus.publish(receivedEvent(1, 1))
+
+	select {
+	case evt := <-sub.Events():
+		e, ok := evt.(MessageReceivedEvent)
+		if !ok || e.Message.ChatID != 1 || e.Message.ChatType != 1 {
+			t.Fatalf("unexpected event: %#v", evt)
+		}
+	default:
+		t.Fatal("expected an event")
+	}
+	if len(sub.Events()) != 0 {
+		t.Fatalf("expected no more events, got %d", len(sub.Events()))
+	}
+}
+
+func TestEventBus_Overflow(t *testing.T) {
+	bus := newEventBus()
+	oldest := bus.subscribe(context.Background(), WithBufferSize(2))
+	newest := bus.subscribe(context.Background(), WithBufferSize(2), WithOverflowPolicy(OverflowDropNewest))
+	defer oldest.Close()
+	defer newest.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		bus.publish(receivedEvent(i, 1))
+	}
+
+	chatIds := func(sub *Subscription) []int64 {
+		var ids []int64
+		for len(sub.Events()) > 0 {
+			ids = append(ids, (<-sub.Events()).(MessageReceivedEvent).Message.ChatID)
+		}
+		return ids
+	}
+	if ids := chatIds(oldest); len(ids) != 2 || ids[0] != 4 || ids[1] != 5 {
+		t.Fatalf("drop oldest: unexpected events %v", ids)
+	}
+	if ids := chatIds(newest); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("drop newest: unexpected events %v", ids)
+	}
+	if oldest.Dropped() != 3 || newest.Dropped() != 3 {
+		t.Fatalf("unexpected dropped: %d %d", oldest.Dropped(), newest.Dropped())
+	}
+}
+
+func TestEventBus_BlockUntilClose(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(context.Background(), WithBufferSize(1), WithOverflowPolicy(OverflowBlock))
+
+	done := make(chan struct{})
+	go func() {
+		bus.publish(ConnectedEvent{})
+		bus.publish(ConnectedEvent{})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("publish should block while the buffer is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	sub.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish still blocked after close")
+	}
+}
+
+func TestEventBus_ContextCancel(t *testing.T) {
+	bus := newEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := bus.subscribe(ctx)
+	cancel()
+
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Fatal("expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed after cancel")
+	}
+	bus.publish(ConnectedEvent{})
+}
+
+func TestEventBus_ListenUnsubscribe(t *testing.T) {
+	bus := newEventBus()
+	release := make(chan struct{})
+	var calls atomic.Int64
+	unsubscribe := bus.listen(func(evt Event) {
+		calls.Add(1)
+		<-release
+	})
+	bus.publish(ConnectedEvent{})
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 第一个回调执行期间缓冲了更多的事件, 退订后不再回调
+	bus.publish(ConnectedEvent{})
+	bus.publish(ConnectedEvent{})
+	unsubscribe()
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("callback called %d times after unsubscribe", got)
+	}
+}
+
+func TestEventBus_Listen(t *testing.T) {
+	bus := newEventBus()
+	release := make(chan struct{})
+	nested := make(chan Event, 1)
+	// 在回调中订阅不会死锁, 处理得慢的回调不会阻塞发布
+	unsubscribe := bus.listen(func(evt Event) {
+		if _, ok := evt.(ConnectedEvent); ok {
+			sub := bus.subscribe(context.Background(), WithEventTypes(EventDisconnected))
+			go func() { nested <- <-sub.Events() }()
+		}
+		<-release
+	})
+	defer unsubscribe()
+
+	published := make(chan struct{})
+	go func() {
+		bus.publish(ConnectedEvent{})
+		time.Sleep(50 * time.Millisecond)
+		bus.publish(DisconnectedEvent{})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by a slow listener")
+	}
+	close(release)
+	select {
+	case evt := <-nested:
+		if evt.Type() != EventDisconnected {
+			t.Fatalf("unexpected event: %#v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nested subscription received nothing")
+	}
+}
@@ -2,8 +2,11 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/pkg"
@@ -20,13 +23,81 @@ type RestResult[T any] struct {
 
 var (
 	ipListPath                   = "/index/iplist"
-	allUserPath                  = "/user/allUser"
+	syncUserPath                 = "/user/sync"
+	batchGetUserPath             = "/user/batchGet"
 	allChatPath                  = "/chat/getAllChat"
 	lastMessagePath              = "/chat/lastMessage"
 	pullOfflineMsgPath           = "/message/pullOfflineMsg"
 	getLatestOfflineMessagesPath = "/message/getLatestOfflineMessages"
+	uploadFilePath               = "/file/upload"
+	uploadPublicKeyPath          = "/key/upload"
+	getPublicKeyPath             = "/key/get"
+	loginPath                    = "/auth/login"
+	refreshTokenPath             = "/auth/refresh"
+	chatTopPath                  = "/chat/top"
+	chatMutePath                 = "/chat/mute"
+	chatArchivePath              = "/chat/archive"
+	chatDeletePath               = "/chat/delete"
+	searchUserPath               = "/user/search"
+	contactListPath              = "/contact/list"
+	contactBlockPath             = "/contact/block"
+	friendRequestListPath        = "/contact/request/list"
+	friendRequestSendPath        = "/contact/request/send"
+	friendRequestAcceptPath      = "/contact/request/accept"
+	friendRequestRejectPath      = "/contact/request/reject"
 )
 
+// ErrUnauthorized 用户名密码错误或者 refresh token 失效, 需要重新登录
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Token 登录返回的 token, ExpiresAt 为 access token 的过期时间(毫秒)
+type Token struct {
+	UserId       int64  `json:"userId"`
+	UserName     string `json:"userName"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+// ChatSetting 修改会话设置的请求, 每个接口只使用对应的字段
+type ChatSetting struct {
+	UserId       int64 `json:"userId"`
+	ChatId       int64 `json:"chatId"`
+	ChatType     int32 `json:"chatType"`
+	Top          bool  `json:"top"`
+	Mute         bool  `json:"mute"`
+	Archive      bool  `json:"archive"`
+	DelTimestamp int64 `json:"delTimestamp"`
+}
+
+// UserSyncResult 增量同步用户的一页, Version 是这一页之后的游标, HasMore 为 true 时继续拉取
+type UserSyncResult struct {
+	Users   []*sqllite.ImUser `json:"users"`
+	Version int64             `json:"version"`
+	HasMore bool              `json:"hasMore"`
+}
+
+// FriendRequestAction 发送、同意和拒绝好友申请的请求, 发送时使用 ToUserId 和 Message, 处理时使用 RequestId
+type FriendRequestAction struct {
+	UserId    int64  `json:"userId"`
+	ToUserId  int64  `json:"toUserId"`
+	Message   string `json:"message"`
+	RequestId int64  `json:"requestId"`
+}
+
+// BlockSetting 拉黑或者取消拉黑的请求
+type BlockSetting struct {
+	UserId    int64 `json:"userId"`
+	ContactId int64 `json:"contactId"`
+	Blocked   bool  `json:"blocked"`
+}
+
+// PublicKey 端到端加密的身份公钥, JSON 中按 base64 编码
+type PublicKey struct {
+	UserId    int64  `json:"userId"`
+	PublicKey []byte `json:"publicKey"`
+}
+
 // IpList 服务发现获取长连接公网IP地址
 // path: /index/iplist
 func IpList(ctx context.Context) ([]string, error) {
@@ -47,23 +118,52 @@ func IpList(ctx context.Context) ([]string, error) {
 	return ips, nil
 }
 
-// Users 拉去所有用户信息
-func Users(ctx context.Context) ([]*sqllite.ImUser, error) {
-	var result pkg.RestResult[[]*sqllite.ImUser]
-	var url = baseUrl + allUserPath
+// SyncUsers 分页拉取 version 之后修改过的用户, 按版本升序, 每页最多 limit 个
+// path: /user/sync
+func SyncUsers(ctx context.Context, version int64, limit int) (*UserSyncResult, error) {
+	var result pkg.RestResult[*UserSyncResult]
+	var url = baseUrl + syncUserPath + fmt.Sprintf("?version=%d&limit=%d", version, limit)
 	resp, err := restClient.R().SetContext(ctx).SetResult(&result).Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("allUser 请求失败: %w", err)
+		return nil, fmt.Errorf("SyncUsers 请求失败: %w", err)
 	}
 	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("allUser HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+		return nil, fmt.Errorf("SyncUsers HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
 	}
 	if result.Code != 0 {
-		return nil, fmt.Errorf("allUser 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+		return nil, fmt.Errorf("SyncUsers 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	if result.Data == nil {
+		return nil, fmt.Errorf("SyncUsers: empty result")
 	}
-	users := result.Data
-	logger.Infof("批量获取用户信息成功, users.size: %d", len(users))
-	return users, nil
+	logger.Infof("增量同步用户信息成功, version: %d, users.size: %d, hasMore: %v",
+		result.Data.Version, len(result.Data.Users), result.Data.HasMore)
+	return result.Data, nil
+}
+
+// GetUsers 批量获取用户资料, 不存在的用户不在结果中
+// path: /user/batchGet
+func GetUsers(ctx context.Context, userIds []int64) ([]*sqllite.ImUser, error) {
+	ids := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	var result pkg.RestResult[[]*sqllite.ImUser]
+	var url = baseUrl + batchGetUserPath
+	resp, err := restClient.R().SetContext(ctx).
+		SetQueryParam("userIds", strings.Join(ids, ",")).
+		SetResult(&result).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("GetUsers 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("GetUsers HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("GetUsers 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
 }
 
 func GetAllChat(userId int64) ([]*sqllite.ImChat, error) {
@@ -136,3 +236,283 @@ func GetLatestOfflineMessages(fromUserId int64, chatId int64, chatType int32, si
 	}
 	return result.Data, nil
 }
+
+// UploadFile 上传媒体文件(文件/语音), 返回文件的访问地址
+// path: /file/upload
+func UploadFile(ctx context.Context, filePath string) (string, error) {
+	var result pkg.RestResult[string]
+	var url = baseUrl + uploadFilePath
+	resp, err := restClient.R().SetContext(ctx).
+		SetFile("file", filePath).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return "", fmt.Errorf("UploadFile 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return "", fmt.Errorf("UploadFile HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return "", fmt.Errorf("UploadFile 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// DownloadFile 下载媒体文件到本地路径 dst
+func DownloadFile(ctx context.Context, fileUrl, dst string) error {
+	resp, err := restClient.R().SetContext(ctx).SetOutput(dst).Get(fileUrl)
+	if err != nil {
+		return fmt.Errorf("DownloadFile 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("DownloadFile HTTP错误: %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// UploadPublicKey 上传端到端加密的身份公钥, 覆盖之前上传的公钥
+// path: /key/upload
+func UploadPublicKey(ctx context.Context, userId int64, publicKey []byte) error {
+	var result pkg.RestResult[any]
+	var url = baseUrl + uploadPublicKeyPath
+	resp, err := restClient.R().SetContext(ctx).
+		SetBody(&PublicKey{UserId: userId, PublicKey: publicKey}).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return fmt.Errorf("UploadPublicKey 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("UploadPublicKey HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("UploadPublicKey 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// GetPublicKey 获取用户的身份公钥, 用户没有上传过公钥时返回 nil
+// path: /key/get
+func GetPublicKey(ctx context.Context, userId int64) ([]byte, error) {
+	var result pkg.RestResult[[]byte]
+	var url = baseUrl + getPublicKeyPath + fmt.Sprintf("?userId=%d", userId)
+	resp, err := restClient.R().SetContext(ctx).SetResult(&result).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("GetPublicKey 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("GetPublicKey HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("GetPublicKey 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// Login 使用用户名和密码登录, 密码错误时返回 ErrUnauthorized
+// path: /auth/login
+func Login(ctx context.Context, userName, password string) (*Token, error) {
+	body := map[string]string{"userName": userName, "password": password}
+	return requestToken(ctx, "Login", loginPath, body)
+}
+
+// RefreshToken 用 refresh token 换取新的 token, 旧的 refresh token 随之失效.
+// refresh token 过期或者被吊销时返回 ErrUnauthorized
+// path: /auth/refresh
+func RefreshToken(ctx context.Context, refreshToken string) (*Token, error) {
+	body := map[string]string{"refreshToken": refreshToken}
+	return requestToken(ctx, "RefreshToken", refreshTokenPath, body)
+}
+
+func requestToken(ctx context.Context, name, path string, body any) (*Token, error) {
+	var result pkg.RestResult[*Token]
+	var url = baseUrl + path
+	resp, err := restClient.R().SetContext(withoutToken(ctx)).
+		SetBody(body).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s 请求失败: %w", name, err)
+	}
+	if resp.StatusCode() == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnauthorized)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("%s HTTP错误: %d, 响应: %s", name, resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("%s 业务异常: code=%d, msg=%s", name, result.Code, result.Msg)
+	}
+	if result.Data == nil || result.Data.AccessToken == "" {
+		return nil, fmt.Errorf("%s: empty token", name)
+	}
+	return result.Data, nil
+}
+
+// SetChatTop 置顶或取消置顶会话, 服务端同步给其他设备
+// path: /chat/top
+func SetChatTop(ctx context.Context, userId, chatId int64, chatType int32, top bool) error {
+	setting := &ChatSetting{UserId: userId, ChatId: chatId, ChatType: chatType, Top: top}
+	return postChatSetting(ctx, "SetChatTop", chatTopPath, setting)
+}
+
+// SetChatMute 开启或关闭会话的免打扰
+// path: /chat/mute
+func SetChatMute(ctx context.Context, userId, chatId int64, chatType int32, mute bool) error {
+	setting := &ChatSetting{UserId: userId, ChatId: chatId, ChatType: chatType, Mute: mute}
+	return postChatSetting(ctx, "SetChatMute", chatMutePath, setting)
+}
+
+// SetChatArchive 归档或取消归档会话
+// path: /chat/archive
+func SetChatArchive(ctx context.Context, userId, chatId int64, chatType int32, archive bool) error {
+	setting := &ChatSetting{UserId: userId, ChatId: chatId, ChatType: chatType, Archive: archive}
+	return postChatSetting(ctx, "SetChatArchive", chatArchivePath, setting)
+}
+
+// DeleteChat 删除会话, delTimestamp 之前的消息不再显示, 收到新消息后会话重新出现
+// path: /chat/delete
+func DeleteChat(ctx context.Context, userId, chatId int64, chatType int32, delTimestamp int64) error {
+	setting := &ChatSetting{UserId: userId, ChatId: chatId, ChatType: chatType, DelTimestamp: delTimestamp}
+	return postChatSetting(ctx, "DeleteChat", chatDeletePath, setting)
+}
+
+func postChatSetting(ctx context.Context, name, path string, setting *ChatSetting) error {
+	var result pkg.RestResult[any]
+	var url = baseUrl + path
+	resp, err := restClient.R().SetContext(ctx).
+		SetBody(setting).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return fmt.Errorf("%s 请求失败: %w", name, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("%s HTTP错误: %d, 响应: %s", name, resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("%s 业务异常: code=%d, msg=%s", name, result.Code, result.Msg)
+	}
+	return nil
+}
+
+// SearchUsers 在服务端按用户名搜索用户, 用于添加好友
+// path: /user/search
+func SearchUsers(ctx context.Context, keyword string) ([]*sqllite.ImUser, error) {
+	var result pkg.RestResult[[]*sqllite.ImUser]
+	var url = baseUrl + searchUserPath
+	resp, err := restClient.R().SetContext(ctx).
+		SetQueryParam("keyword", keyword).
+		SetResult(&result).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("SearchUsers 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("SearchUsers HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("SearchUsers 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// Contacts 用户的好友和黑名单, 附带联系人的用户资料
+// path: /contact/list
+func Contacts(ctx context.Context, userId int64) ([]*sqllite.Contact, error) {
+	var result pkg.RestResult[[]*sqllite.Contact]
+	var url = baseUrl + contactListPath + fmt.Sprintf("?userId=%d", userId)
+	resp, err := restClient.R().SetContext(ctx).SetResult(&result).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Contacts 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("Contacts HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("Contacts 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// SetBlocked 拉黑或者取消拉黑用户
+// path: /contact/block
+func SetBlocked(ctx context.Context, userId, contactId int64, blocked bool) error {
+	var result pkg.RestResult[any]
+	var url = baseUrl + contactBlockPath
+	resp, err := restClient.R().SetContext(ctx).
+		SetBody(&BlockSetting{UserId: userId, ContactId: contactId, Blocked: blocked}).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return fmt.Errorf("SetBlocked 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("SetBlocked HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("SetBlocked 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// FriendRequests 用户发出和收到的好友申请
+// path: /contact/request/list
+func FriendRequests(ctx context.Context, userId int64) ([]*sqllite.FriendRequest, error) {
+	var result pkg.RestResult[[]*sqllite.FriendRequest]
+	var url = baseUrl + friendRequestListPath + fmt.Sprintf("?userId=%d", userId)
+	resp, err := restClient.R().SetContext(ctx).SetResult(&result).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("FriendRequests 请求失败: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("FriendRequests HTTP错误: %d, 响应: %s", resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("FriendRequests 业务异常: code=%d, msg=%s", result.Code, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// SendFriendRequest 向 toUserId 发送好友申请, 服务端通过 CMD_ID_FRIEND_NOTIFY 通知对方
+// path: /contact/request/send
+func SendFriendRequest(ctx context.Context, userId, toUserId int64, message string) (*sqllite.FriendRequest, error) {
+	action := &FriendRequestAction{UserId: userId, ToUserId: toUserId, Message: message}
+	return postFriendRequest(ctx, "SendFriendRequest", friendRequestSendPath, action)
+}
+
+// AcceptFriendRequest 同意好友申请, 双方互相成为好友
+// path: /contact/request/accept
+func AcceptFriendRequest(ctx context.Context, userId, requestId int64) (*sqllite.FriendRequest, error) {
+	action := &FriendRequestAction{UserId: userId, RequestId: requestId}
+	return postFriendRequest(ctx, "AcceptFriendRequest", friendRequestAcceptPath, action)
+}
+
+// RejectFriendRequest 拒绝好友申请
+// path: /contact/request/reject
+func RejectFriendRequest(ctx context.Context, userId, requestId int64) (*sqllite.FriendRequest, error) {
+	action := &FriendRequestAction{UserId: userId, RequestId: requestId}
+	return postFriendRequest(ctx, "RejectFriendRequest", friendRequestRejectPath, action)
+}
+
+func postFriendRequest(ctx context.Context, name, path string, action *FriendRequestAction) (*sqllite.FriendRequest, error) {
+	var result pkg.RestResult[*sqllite.FriendRequest]
+	var url = baseUrl + path
+	resp, err := restClient.R().SetContext(ctx).
+		SetBody(action).
+		SetResult(&result).
+		Post(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s 请求失败: %w", name, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("%s HTTP错误: %d, 响应: %s", name, resp.StatusCode(), resp.String())
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("%s 业务异常: code=%d, msg=%s", name, result.Code, result.Msg)
+	}
+	if result.Data == nil {
+		return nil, fmt.Errorf("%s: empty friend request", name)
+	}
+	return result.Data, nil
+}
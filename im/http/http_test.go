@@ -2,22 +2,126 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
+var server *testserver.Server
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	server = testserver.New(testserver.WithUsers(
+		&sqllite.ImUser{UserID: 1, UserName: "user1"},
+		&sqllite.ImUser{UserID: 2, UserName: "user2"},
+	))
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "start testserver: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := server.Deliver(2, 1, 1, payload.NewTextMessage("hello", false, nil)); err != nil {
+		fmt.Fprintf(os.Stderr, "deliver message: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
 func TestClient_Users(t *testing.T) {
-	Init("http://127.0.0.1:8087", time.Second*3)
-	users, err := Users(context.Background())
+	Init(server.URL(), time.Second*3)
+	ctx := context.Background()
+	result, err := SyncUsers(ctx, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Users))
+	assert.True(t, result.HasMore)
+	result, err = SyncUsers(ctx, result.Version, 1)
 	assert.Nil(t, err)
-	t.Log(users)
+	assert.Equal(t, 1, len(result.Users))
+	assert.False(t, result.HasMore)
+	assert.Equal(t, server.UserVersion(), result.Version)
+
+	users, err := GetUsers(ctx, []int64{2, 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, "user2", users[0].UserName)
 }
 
 func TestClient_LastMessage(t *testing.T) {
-	Init("http://127.0.0.1:8087", time.Second*3)
+	Init(server.URL(), time.Second*3)
 	message, err := LastMessage(1, 2, 1)
 	assert.Nil(t, err)
 	t.Log(message)
 }
+
+func TestClient_PublicKey(t *testing.T) {
+	Init(server.URL(), time.Second*3)
+	key, err := GetPublicKey(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+
+	assert.Nil(t, UploadPublicKey(context.Background(), 2, []byte{1, 2, 3}))
+	key, err = GetPublicKey(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, key)
+}
+
+func TestClient_ChatSettings(t *testing.T) {
+	Init(server.URL(), time.Second*3)
+	ctx := context.Background()
+	assert.Nil(t, SetChatTop(ctx, 1, 2, 1, true))
+	assert.Nil(t, SetChatMute(ctx, 1, 2, 1, true))
+	assert.Nil(t, SetChatArchive(ctx, 1, 2, 1, true))
+	chat := server.Chat(1, 2, 1)
+	assert.True(t, chat.ChatTop && chat.ChatMute && chat.ChatArchive)
+
+	assert.Nil(t, SetChatTop(ctx, 1, 2, 1, false))
+	assert.Nil(t, DeleteChat(ctx, 1, 2, 1, 1000))
+	chats, err := GetAllChat(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(chats))
+	assert.False(t, chats[0].ChatTop)
+	assert.True(t, chats[0].ChatDel)
+	assert.Equal(t, int64(1000), chats[0].DelTimestamp)
+	// 另一个用户的会话不受影响
+	assert.False(t, server.Chat(2, 1, 1).ChatDel)
+}
+
+func TestClient_Contacts(t *testing.T) {
+	Init(server.URL(), time.Second*3)
+	ctx := context.Background()
+	users, err := SearchUsers(ctx, "USER")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(users))
+
+	request, err := SendFriendRequest(ctx, 1, 2, "hi")
+	assert.Nil(t, err)
+	assert.Equal(t, sqllite.FriendRequestPending, request.Status)
+	assert.Equal(t, "user1", request.FromUserName)
+	_, err = AcceptFriendRequest(ctx, 1, request.RequestId)
+	assert.NotNil(t, err, "only the receiver can accept")
+
+	request, err = AcceptFriendRequest(ctx, 2, request.RequestId)
+	assert.Nil(t, err)
+	assert.Equal(t, sqllite.FriendRequestAccepted, request.Status)
+	contacts, err := Contacts(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(contacts))
+	assert.True(t, contacts[0].Friend)
+	assert.Equal(t, "user2", contacts[0].User.UserName)
+
+	requests, err := FriendRequests(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(requests))
+
+	assert.Nil(t, SetBlocked(ctx, 1, 2, true))
+	contacts, _ = Contacts(ctx, 1)
+	assert.True(t, contacts[0].Blocked)
+}
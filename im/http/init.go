@@ -1,20 +1,90 @@
 package http
 
 import (
+	"context"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
 var (
 	baseUrl    string
 	restClient *resty.Client
+	tokens     atomic.Pointer[TokenSource]
 )
 
+// TokenSource 请求使用的 access token, 由上层注入
+type TokenSource interface {
+	AccessToken() string
+	// Refresh 收到 401 时刷新 token, stale 为请求使用的 token, 已经被其他请求刷新过时直接返回
+	Refresh(ctx context.Context, stale string) error
+}
+
 func Init(serverUrl string, timeout time.Duration) {
 	baseUrl = serverUrl
 	restClient = resty.New().
 		SetBaseURL(baseUrl).
 		SetTimeout(timeout).
-		SetHeader("Accept", "application/json")
+		SetHeader("Accept", "application/json").
+		OnBeforeRequest(setAuthorization).
+		// 只在 401 时重试一次, 重试之前刷新 token
+		SetRetryCount(1).
+		SetRetryWaitTime(10 * time.Millisecond).
+		AddRetryCondition(refreshOnUnauthorized)
+}
+
+// SetTokenSource 设置请求使用的 token, 为 nil 时不带 Authorization
+func SetTokenSource(ts TokenSource) {
+	if ts == nil {
+		tokens.Store(nil)
+		return
+	}
+	tokens.Store(&ts)
+}
+
+func tokenSource() TokenSource {
+	if ts := tokens.Load(); ts != nil {
+		return *ts
+	}
+	return nil
+}
+
+type withoutTokenKey struct{}
+
+// withoutToken 登录和刷新 token 的请求不带 Authorization, 401 时也不刷新
+func withoutToken(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutTokenKey{}, true)
+}
+
+func skipToken(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(withoutTokenKey{}) != nil
+}
+
+func setAuthorization(_ *resty.Client, r *resty.Request) error {
+	ts := tokenSource()
+	if ts == nil || skipToken(r.Context()) {
+		return nil
+	}
+	if token := ts.AccessToken(); token != "" {
+		r.SetAuthToken(token)
+	}
+	return nil
+}
+
+func refreshOnUnauthorized(resp *resty.Response, err error) bool {
+	if err != nil || resp == nil || resp.StatusCode() != http.StatusUnauthorized {
+		return false
+	}
+	ts := tokenSource()
+	if ts == nil || skipToken(resp.Request.Context()) {
+		return false
+	}
+	if err = ts.Refresh(resp.Request.Context(), resp.Request.Token); err != nil {
+		logger.Errorf("refresh token error: %v", err)
+		return false
+	}
+	return true
 }
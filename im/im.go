@@ -2,13 +2,18 @@ package im
 
 import (
 	"context"
+	"slices"
 
 	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/capture"
 	"github.com/xuning888/helloIMClient/im/dal"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/e2e"
 	http2 "github.com/xuning888/helloIMClient/im/http"
 	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/service"
 	"github.com/xuning888/helloIMClient/im/transport"
+	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
 // Client IM SDK 客户端，SDK 的唯一入口
@@ -16,7 +21,13 @@ type Client struct {
 	addr   string
 	opts   *Options
 	store  *Store
-	events *callbackRegistry
+	events *eventBus
+	// e2e 单聊的端到端加密, 没有开启时为 nil
+	e2e *e2e.Session
+	// dispatcher 处理服务端推送的消息
+	dispatcher *dispatcher
+	// send 依次经过发送拦截器和端到端加密后发送上行消息
+	send SendHandler
 	*msgManager
 	*connManager
 }
@@ -32,16 +43,27 @@ func New(addr string, opts ...Option) (*Client, error) {
 	conf.UserId = options.UID
 	conf.ServerUrl = addr
 
+	if options.DeviceId == "" {
+		deviceId, err := loadOrCreateDeviceId()
+		if err != nil {
+			logger.Errorf("load device id error: %v", err)
+		}
+		options.DeviceId = deviceId
+	}
+	if options.Platform == "" {
+		options.Platform = defaultPlatform()
+	}
+
 	// 初始化 HTTP 客户端
 	http2.Init(addr, options.ConnectTimeout)
 
 	// 初始化 SQLite
-	if err := dal.Init(); err != nil {
+	if err := dal.Init(options.DBKey); err != nil {
 		return nil, err
 	}
 
 	// 创建事件总线
-	events := newCallbackRegistry()
+	events := newEventBus()
 
 	// 创建存储
 	store := newStore()
@@ -53,22 +75,54 @@ func New(addr string, opts ...Option) (*Client, error) {
 		events: events,
 	}
 
+	// 后台获取到用户资料后通知界面
+	service.SetUsersFetched(func(users []*sqllite.ImUser) {
+		events.publish(UsersFetchedEvent{Users: users})
+	})
+
+	// 端到端加密
+	if options.E2E {
+		session, err := newE2ESession(options.UID, httpKeyDirectory{})
+		if err != nil {
+			return nil, err
+		}
+		cli.e2e = session
+	}
+
 	// 创建分发器
-	dispatcher := newDispatcher(store, events)
+	dispatcher := newDispatcher(store, events, cli.e2e, options.ReceiveInterceptors...)
+	cli.dispatcher = dispatcher
+
+	// 抓包
+	if options.CaptureFile != "" {
+		transport.SetRecorder(capture.NewRecorder(options.CaptureFile, 50, 5))
+	}
+	transport.SetTraceFrames(options.TraceFrames)
 
 	// 创建 transport
 	tr := transport.NewClient(dispatcher.dispatch, &defaultAddrProvider{}, sqllite.GetSeq)
+	tr.SetMaxFrameSize(options.MaxFrameSize)
+	tr.SetCompression(options.CompressionThreshold, options.Compression...)
+	cli.setupTokens(tr)
+	tr.SetDevice(options.DeviceId, options.Platform)
+	tr.SetDispatchKey(dispatcher.dispatchKey)
 
 	// 创建子管理器
 	cli.msgManager = newMsgManager(cli)
 	cli.connManager = newConnManager(tr, events)
+	dispatcher.onKickout = cli.connManager.kickedOut
+	cli.send = chainSend(tr.Send, append(slices.Clone(options.SendInterceptors), recordSent, cli.encrypt)...)
 
 	return cli, nil
 }
 
 // Connect 建立连接
 func (c *Client) Connect(ctx context.Context) error {
-	return c.connManager.Connect(ctx)
+	if err := c.connManager.Connect(ctx); err != nil {
+		return err
+	}
+	c.publishKey(ctx)
+	return nil
 }
 
 // Disconnect 断开连接
@@ -81,14 +135,32 @@ func (c *Client) State() ConnState {
 	return c.connManager.State()
 }
 
-// SendMessage 发送上行消息并同步等待 ACK
-func (c *Client) SendMessage(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
-	ack, err := c.connManager.transport.Send(ctx, msg)
+// SendMessage 发送上行消息并同步等待 ACK, 消息依次经过发送拦截器和端到端加密.
+// sent 是经过发送拦截器之后、加密之前的消息, 保存到本地时使用 sent, 拦截器没有继续发送时为 msg.
+// 开启端到端加密时单聊消息自动加密, 对方没有上传公钥时返回 e2e.ErrNoPeerKey
+func (c *Client) SendMessage(ctx context.Context, msg protocol.Message) (ack, sent protocol.Message, err error) {
+	sent = msg
+	ack, err = c.send(context.WithValue(ctx, sentKey{}, &sent), msg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	c.dispatcher.markSent(sent, ack)
+	c.events.publish(MessageSentEvent{Ack: ack})
+	return ack, sent, nil
+}
+
+// Stats SDK 的统计
+type Stats struct {
+	transport.Stats       // 传输层的统计, 包含压缩率和压缩耗时
+	DuplicatePushes int64 // 丢弃的重复推送的消息数
+}
+
+// Stats 当前的统计值, 从客户端创建开始累计
+func (c *Client) Stats() Stats {
+	return Stats{
+		Stats:           c.connManager.transport.Stats(),
+		DuplicatePushes: c.dispatcher.duplicates.Load(),
 	}
-	c.events.fire(Event{Type: EventMessageSent, Data: ack})
-	return ack, nil
 }
 
 // Storage 获取存储管理器
@@ -96,9 +168,15 @@ func (c *Client) Storage() *Store {
 	return c.store
 }
 
-// OnEvent 注册事件回调，返回取消订阅函数
-func (c *Client) OnEvent(cb EventCallback) func() {
-	return c.events.subscribe(cb)
+// Subscribe 订阅事件, ctx 取消或者调用 Subscription.Close 时退订.
+// 每个订阅者有自己的缓冲区, 处理得慢不会阻塞消息的接收, 缓冲区满时按 WithOverflowPolicy 处理
+func (c *Client) Subscribe(ctx context.Context, opts ...SubscribeOption) *Subscription {
+	return c.events.subscribe(ctx, opts...)
+}
+
+// OnEvent 注册事件回调, 回调在这个订阅者自己的协程中按事件发布的顺序调用, 返回取消订阅函数
+func (c *Client) OnEvent(cb EventCallback, opts ...SubscribeOption) func() {
+	return c.events.listen(cb, opts...)
 }
 
 // GetUID 获取当前用户 ID
@@ -0,0 +1,55 @@
+package im
+
+import (
+	"context"
+
+	"github.com/xuning888/helloIMClient/im/protocol"
+)
+
+// SendHandler 发送上行消息并返回服务端的 ACK
+type SendHandler func(ctx context.Context, msg protocol.Message) (protocol.Message, error)
+
+// SendInterceptor 发送拦截器, 调用 next 继续发送. 可以修改或者替换 msg,
+// 不调用 next 时直接返回的 ACK 作为发送的结果, 返回错误时消息不会发出
+type SendInterceptor func(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error)
+
+// ReceiveHandler 处理服务端推送的消息
+type ReceiveHandler func(ctx context.Context, msg protocol.Message) error
+
+// ReceiveInterceptor 接收拦截器, 调用 next 继续处理. 推送的消息已经回复了 ACK,
+// 不调用 next 时消息被丢弃, 返回错误时丢弃消息并发出 ErrorEvent.
+// 只有推送的聊天消息经过接收拦截器, 踢下线、会话同步和好友通知这样的控制消息不经过
+type ReceiveInterceptor func(ctx context.Context, msg protocol.Message, next ReceiveHandler) error
+
+// sentKey ctx 中记录经过发送拦截器之后的消息
+type sentKey struct{}
+
+// recordSent 在所有发送拦截器之后、加密之前执行, 记录实际发出的明文消息
+func recordSent(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+	if sent, ok := ctx.Value(sentKey{}).(*protocol.Message); ok {
+		*sent = msg
+	}
+	return next(ctx, msg)
+}
+
+// chainSend 按顺序组合拦截器, 第一个拦截器最先执行, 最后调用 handler
+func chainSend(handler SendHandler, interceptors ...SendInterceptor) SendHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
+			return interceptor(ctx, msg, next)
+		}
+	}
+	return handler
+}
+
+// chainReceive 按顺序组合拦截器, 第一个拦截器最先执行, 最后调用 handler
+func chainReceive(handler ReceiveHandler, interceptors ...ReceiveInterceptor) ReceiveHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, msg protocol.Message) error {
+			return interceptor(ctx, msg, next)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,222 @@
+package im
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/e2e"
+	"github.com/xuning888/helloIMClient/im/payload"
+	pb "github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/push"
+	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/transport"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func textOf(msg protocol.Message) string {
+	switch m := msg.(type) {
+	case *send.SendMsg:
+		return m.GetPayload().GetText().GetContent()
+	case *push.RecvMsg:
+		return m.GetPayload().GetText().GetContent()
+	}
+	return ""
+}
+
+func TestChainSend(t *testing.T) {
+	var order []string
+	record := func(name string) SendInterceptor {
+		return func(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+			order = append(order, name)
+			return next(ctx, msg)
+		}
+	}
+	// 修改消息内容
+	upper := func(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+		request := msg.(*send.SendMsg)
+		request.Payload = payload.NewTextMessage(strings.ToUpper(textOf(request)), false, nil)
+		return next(ctx, request)
+	}
+	// 拒绝和直接返回 ACK
+	errRejected := errors.New("rejected")
+	filter := func(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+		switch textOf(msg) {
+		case "SPAM":
+			return nil, errRejected
+		case "LOCAL":
+			return &send.SendAck{SendPktResponse: &pb.SendPktResponse{MsgId: -1}}, nil
+		}
+		return next(ctx, msg)
+	}
+	var sent []string
+	handler := chainSend(func(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
+		sent = append(sent, textOf(msg))
+		return &send.SendAck{SendPktResponse: &pb.SendPktResponse{MsgId: 1}}, nil
+	}, record("first"), upper, record("second"), filter)
+
+	ctx := context.Background()
+	ack, err := handler(ctx, send.NewSendMsg(1, 2, 1, payload.NewTextMessage("hello", false, nil), 0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ack.(*send.SendAck).MsgId())
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	_, err = handler(ctx, send.NewSendMsg(1, 2, 1, payload.NewTextMessage("spam", false, nil), 0, 0))
+	assert.ErrorIs(t, err, errRejected)
+	ack, err = handler(ctx, send.NewSendMsg(1, 2, 1, payload.NewTextMessage("local", false, nil), 0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), ack.(*send.SendAck).MsgId())
+	assert.Equal(t, []string{"HELLO"}, sent)
+}
+
+type memoryKeyDirectory struct {
+	mu   sync.Mutex
+	keys map[int64][]byte
+}
+
+func (d *memoryKeyDirectory) PublishKey(ctx context.Context, uid int64, publicKey []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys[uid] = publicKey
+	return nil
+}
+
+func (d *memoryKeyDirectory) LookupKey(ctx context.Context, uid int64) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.keys[uid], nil
+}
+
+func newTestSession(t *testing.T, uid int64, directory e2e.KeyDirectory) *e2e.Session {
+	identity, err := e2e.GenerateIdentity()
+	assert.Nil(t, err)
+	session, err := e2e.NewSession(uid, identity, directory)
+	assert.Nil(t, err)
+	assert.Nil(t, session.Publish(context.Background()))
+	return session
+}
+
+func TestClient_Encrypt(t *testing.T) {
+	directory := &memoryKeyDirectory{keys: make(map[int64][]byte)}
+	alice := newTestSession(t, 2, directory)
+	newTestSession(t, 1, directory)
+	c := &Client{e2e: alice}
+
+	request := send.NewSendMsg(2, 1, 1, payload.NewTextMessage("hello", false, nil), 0, 0)
+	var sent protocol.Message
+	_, err := c.encrypt(context.Background(), request, func(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
+		sent = msg
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.PayloadType_ENCRYPTED, sent.(*send.SendMsg).GetPayload().GetPayloadType())
+	// 调用方的消息保持明文, 用于保存到本地
+	assert.Equal(t, "hello", textOf(request))
+
+	// 对方没有公钥时不发送
+	_, err = c.encrypt(context.Background(), send.NewSendMsg(2, 3, 1, payload.NewTextMessage("hello", false, nil), 0, 0),
+		func(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
+			t.Fatal("message should not be sent")
+			return nil, nil
+		})
+	assert.ErrorIs(t, err, e2e.ErrNoPeerKey)
+}
+
+func TestDispatcher_ReceiveInterceptor(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	conf.UserId = 1
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	directory := &memoryKeyDirectory{keys: make(map[int64][]byte)}
+	alice := newTestSession(t, 2, directory)
+	bob := newTestSession(t, 1, directory)
+
+	events := newEventBus()
+	sub := events.subscribe(context.Background(), WithEventTypes(EventMessageReceived, EventError))
+	defer sub.Close()
+
+	var seen []string
+	errRejected := errors.New("rejected")
+	translate := func(ctx context.Context, msg protocol.Message, next ReceiveHandler) error {
+		response, ok := msg.(*push.RecvMsg)
+		if !ok {
+			return next(ctx, msg)
+		}
+		// 解密之后才经过拦截器, 重复的消息不会经过拦截器
+		seen = append(seen, textOf(response))
+		if textOf(response) == "spam" {
+			return errRejected
+		}
+		response.Payload = payload.NewTextMessage("translated: "+textOf(response), false, nil)
+		return next(ctx, msg)
+	}
+	d := newDispatcher(newStore(), events, bob, translate)
+
+	encrypted, err := alice.Encrypt(context.Background(), 1, payload.NewTextMessage("hello", false, nil))
+	assert.Nil(t, err)
+	newPush := func(msgId int64, p *pb.Payload) *push.RecvMsg {
+		return &push.RecvMsg{PushPktRequest: &pb.PushPktRequest{
+			From: "2", ChatId: "1", ChatType: 1, MsgId: msgId, ServerSeq: msgId, Payload: p,
+		}}
+	}
+	d.dispatch(newPush(1, encrypted))
+	d.dispatch(newPush(1, encrypted))
+	d.dispatch(newPush(2, payload.NewTextMessage("spam", false, nil)))
+	assert.Equal(t, []string{"hello", "spam"}, seen)
+
+	assert.Equal(t, 2, len(sub.Events()))
+	received := (<-sub.Events()).(MessageReceivedEvent)
+	assert.Equal(t, "translated: hello", received.Message.MsgContent)
+	rejected := (<-sub.Events()).(ErrorEvent)
+	assert.ErrorIs(t, rejected.Err, errRejected)
+	messages, err := sqllite.GetRecentMessage(context.Background(), 2, 1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+}
+
+func TestDispatcher_ControlBypassesInterceptors(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	// 丢弃所有消息的拦截器不影响踢下线这样的控制消息
+	var intercepted int
+	dropAll := func(ctx context.Context, msg protocol.Message, next ReceiveHandler) error {
+		intercepted++
+		return nil
+	}
+	d := newDispatcher(newStore(), newEventBus(), nil, dropAll)
+	var kicked *KickedOut
+	d.onKickout = func(info *KickedOut) { kicked = info }
+
+	d.dispatch(&transport.Kickout{KickoutRequest: &pb.KickoutRequest{
+		Reason: pb.KickoutReason_KICKOUT_OTHER_DEVICE, Message: "other device",
+	}})
+	assert.NotNil(t, kicked)
+	assert.Equal(t, "other device", kicked.Message)
+	assert.Equal(t, 0, intercepted)
+}
+
+func TestClient_SendMessageReturnsSent(t *testing.T) {
+	conf.UserId = 1
+	events := newEventBus()
+	// 发送拦截器替换了消息, 返回的是替换后、加密之前的消息
+	replace := func(ctx context.Context, msg protocol.Message, next SendHandler) (protocol.Message, error) {
+		return next(ctx, send.NewSendMsg(1, 2, 1, payload.NewTextMessage("replaced", false, nil), 0, 0))
+	}
+	c := &Client{events: events, dispatcher: newDispatcher(newStore(), events, nil)}
+	c.send = chainSend(func(ctx context.Context, msg protocol.Message) (protocol.Message, error) {
+		return &send.SendAck{SendPktResponse: &pb.SendPktResponse{MsgId: 1}}, nil
+	}, replace, recordSent, c.encrypt)
+
+	request := send.NewSendMsg(1, 2, 1, payload.NewTextMessage("hello", false, nil), 0, 0)
+	ack, sent, err := c.SendMessage(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ack.(*send.SendAck).MsgId())
+	assert.Equal(t, "replaced", textOf(sent))
+	assert.Equal(t, "hello", textOf(request))
+	assert.True(t, c.dispatcher.sent.Contains(pushKey{chatId: 2, chatType: 1, msgId: 1}))
+}
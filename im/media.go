@@ -0,0 +1,24 @@
+package im
+
+import (
+	"context"
+
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/service"
+)
+
+// NewVoiceMessage 上传本地音频文件并构造语音消息
+// Note: 终端无法录音, 语音消息只能从已有的音频文件发送; duration <= 0 时由 SDK 尝试解析
+func (c *Client) NewVoiceMessage(ctx context.Context, filePath string, duration int32) (*helloim_proto.Payload, error) {
+	voice, err := service.UploadVoice(ctx, filePath, duration)
+	if err != nil {
+		return nil, err
+	}
+	return payload.NewVoiceMessage(voice.GetVoiceUrl(), voice.GetDuration(), voice.GetCodec(), false, nil), nil
+}
+
+// DownloadMedia 下载媒体消息的文件到本地, 返回本地路径
+func (c *Client) DownloadMedia(ctx context.Context, fileUrl string) (string, error) {
+	return service.DownloadMedia(ctx, fileUrl)
+}
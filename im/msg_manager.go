@@ -17,23 +17,16 @@ func newMsgManager(cli *Client) *msgManager {
 
 // Send 通过SDK发送消息
 func (mm *msgManager) Send(ctx context.Context, request protocol.Message) (protocol.Message, error) {
-	return mm.cli.SendMessage(ctx, request)
+	ack, _, err := mm.cli.SendMessage(ctx, request)
+	return ack, err
 }
 
 // AddNewMsgListener 注册新消息回调，返回取消函数
 func (mm *msgManager) AddNewMsgListener(cb EventCallback) func() {
-	return mm.cli.events.subscribe(func(evt Event) {
-		if evt.Type == EventMessageReceived {
-			cb(evt)
-		}
-	})
+	return mm.cli.events.listen(cb, WithEventTypes(EventMessageReceived))
 }
 
 // AddOnSendMsgListener 注册消息发送回调
 func (mm *msgManager) AddOnSendMsgListener(cb EventCallback) func() {
-	return mm.cli.events.subscribe(func(evt Event) {
-		if evt.Type == EventMessageSent {
-			cb(evt)
-		}
-	})
+	return mm.cli.events.listen(cb, WithEventTypes(EventMessageSent))
 }
@@ -1,14 +1,36 @@
 package im
 
-import "time"
+import (
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/protocol"
+)
 
 // Options SDK 配置
 type Options struct {
 	UID              int64         // 用户ID
-	Token            string        // 认证token
+	Token            string        // 认证token, 不能刷新, 设置了 TokenSource 时忽略
+	TokenSource      TokenSource   // 认证token的来源, 认证失败或者 WebAPI 返回 401 时刷新
 	ConnectTimeout   time.Duration // 连接超时
 	Reconnect        bool          // 是否自动重连
 	KeepLiveInterval time.Duration // 心跳间隔
+	CaptureFile      string        // 抓包文件, 为空时不抓包
+	TraceFrames      bool          // 把收发的帧解码后输出到日志
+	MaxFrameSize     int           // 接收的帧长度上限, 包含消息头
+	// Compression 认证时提供给服务端的压缩算法, 按优先级排列, 为空时不压缩
+	Compression          []protocol.Compression
+	CompressionThreshold int  // 消息体超过该长度时压缩
+	E2E                  bool // 单聊开启端到端加密
+	// DeviceId 认证时上报的设备id, 为空时使用 ~/.helloIm/device_id
+	DeviceId string
+	Platform string // 认证时上报的平台, 默认为 cli-<GOOS>
+	// DBKey 本地数据库的密钥, 为空时消息内容等以明文保存
+	DBKey sqllite.KeySource
+	// SendInterceptors 发送拦截器, 按顺序执行, 在端到端加密之前
+	SendInterceptors []SendInterceptor
+	// ReceiveInterceptors 接收拦截器, 按顺序执行, 在去重和端到端解密之后
+	ReceiveInterceptors []ReceiveInterceptor
 }
 
 func NewOptions() *Options {
@@ -16,6 +38,9 @@ func NewOptions() *Options {
 		ConnectTimeout:   time.Second * 5,
 		KeepLiveInterval: time.Second * 10,
 		Reconnect:        true,
+		MaxFrameSize:     protocol.DefaultMaxFrameSize,
+
+		CompressionThreshold: protocol.DefaultCompressionThreshold,
 	}
 }
 
@@ -33,6 +58,12 @@ func WithToken(token string) Option {
 	}
 }
 
+func WithTokenSource(ts TokenSource) Option {
+	return func(opt *Options) {
+		opt.TokenSource = ts
+	}
+}
+
 func WithConnectTimeout(connectTimeout time.Duration) Option {
 	return func(opt *Options) {
 		opt.ConnectTimeout = connectTimeout
@@ -50,3 +81,64 @@ func WithKeepLiveInterval(keepLiveInterval time.Duration) Option {
 		opt.KeepLiveInterval = keepLiveInterval
 	}
 }
+
+func WithCaptureFile(captureFile string) Option {
+	return func(opt *Options) {
+		opt.CaptureFile = captureFile
+	}
+}
+
+func WithTraceFrames(traceFrames bool) Option {
+	return func(opt *Options) {
+		opt.TraceFrames = traceFrames
+	}
+}
+
+func WithMaxFrameSize(maxFrameSize int) Option {
+	return func(opt *Options) {
+		opt.MaxFrameSize = maxFrameSize
+	}
+}
+
+func WithCompression(compressions ...protocol.Compression) Option {
+	return func(opt *Options) {
+		opt.Compression = compressions
+	}
+}
+
+func WithCompressionThreshold(threshold int) Option {
+	return func(opt *Options) {
+		opt.CompressionThreshold = threshold
+	}
+}
+
+func WithE2E(enabled bool) Option {
+	return func(opt *Options) {
+		opt.E2E = enabled
+	}
+}
+
+func WithDBKey(key sqllite.KeySource) Option {
+	return func(opt *Options) {
+		opt.DBKey = key
+	}
+}
+
+func WithDevice(deviceId, platform string) Option {
+	return func(opt *Options) {
+		opt.DeviceId = deviceId
+		opt.Platform = platform
+	}
+}
+
+func WithSendInterceptor(interceptors ...SendInterceptor) Option {
+	return func(opt *Options) {
+		opt.SendInterceptors = append(opt.SendInterceptors, interceptors...)
+	}
+}
+
+func WithReceiveInterceptor(interceptors ...ReceiveInterceptor) Option {
+	return func(opt *Options) {
+		opt.ReceiveInterceptors = append(opt.ReceiveInterceptors, interceptors...)
+	}
+}
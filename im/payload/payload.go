@@ -1,7 +1,11 @@
 package payload
 
 import (
+	"fmt"
+
 	"github.com/xuning888/helloIMClient/im/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 // NewTextMessage 构造文本消息
@@ -17,6 +21,19 @@ func NewTextMessage(content string, at bool, atUid []string) *helloim_proto.Payl
 	return payload
 }
 
+// NewMarkdownMessage 构造 markdown 格式的文本消息
+func NewMarkdownMessage(content string, at bool, atUid []string) *helloim_proto.Payload {
+	payload := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_MARKDOWN,
+		At:          at,
+		AtUid:       atUid,
+		Content: &helloim_proto.Payload_Text{
+			Text: NewTextPayload(content),
+		},
+	}
+	return payload
+}
+
 // NewImageMessage 构造图片消息
 func NewImageMessage(imgUrl string, at bool, atUid []string) *helloim_proto.Payload {
 	payload := &helloim_proto.Payload{
@@ -43,6 +60,45 @@ func NewFileMessage(filename, fileUrl string, at bool, atUid []string) *helloim_
 	return payload
 }
 
+// NewVoiceMessage 构造语音消息
+func NewVoiceMessage(voiceUrl string, duration int32, codec string, at bool, atUid []string) *helloim_proto.Payload {
+	payload := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_VOICE,
+		At:          at,
+		AtUid:       atUid,
+		Content: &helloim_proto.Payload_Voice{
+			Voice: NewVoicePayload(voiceUrl, duration, codec),
+		},
+	}
+	return payload
+}
+
+// NewLocationMessage 构造位置消息
+func NewLocationMessage(lat, lng float64, title, address string, at bool, atUid []string) *helloim_proto.Payload {
+	payload := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_LOCATION,
+		At:          at,
+		AtUid:       atUid,
+		Content: &helloim_proto.Payload_Location{
+			Location: NewLocationPayload(lat, lng, title, address),
+		},
+	}
+	return payload
+}
+
+// NewContactCardMessage 构造名片消息
+func NewContactCardMessage(userId int64, userName, icon string, at bool, atUid []string) *helloim_proto.Payload {
+	payload := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_CONTACT_CARD,
+		At:          at,
+		AtUid:       atUid,
+		Content: &helloim_proto.Payload_ContactCard{
+			ContactCard: NewContactCardPayload(userId, userName, icon),
+		},
+	}
+	return payload
+}
+
 // NewReceiptMessage 构造已读回执
 func NewReceiptMessage(msgId, serverSeq int64) *helloim_proto.Payload {
 	payload := &helloim_proto.Payload{
@@ -56,6 +112,17 @@ func NewReceiptMessage(msgId, serverSeq int64) *helloim_proto.Payload {
 	return payload
 }
 
+// NewEncryptedMessage 构造端到端加密消息, at 等字段也在密文中, 外层不再携带
+func NewEncryptedMessage(encrypted *helloim_proto.EncryptedPayload) *helloim_proto.Payload {
+	payload := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_ENCRYPTED,
+		Content: &helloim_proto.Payload_Encrypted{
+			Encrypted: encrypted,
+		},
+	}
+	return payload
+}
+
 func NewTextPayload(content string) *helloim_proto.TextPayload {
 	return &helloim_proto.TextPayload{
 		Content: content,
@@ -75,10 +142,36 @@ func NewFilePayload(filename, fileUrl string) *helloim_proto.FilePayload {
 	}
 }
 
+func NewVoicePayload(voiceUrl string, duration int32, codec string) *helloim_proto.VoicePayload {
+	return &helloim_proto.VoicePayload{
+		VoiceUrl: voiceUrl,
+		Duration: duration,
+		Codec:    codec,
+	}
+}
+
+func NewLocationPayload(lat, lng float64, title, address string) *helloim_proto.LocationPayload {
+	return &helloim_proto.LocationPayload{
+		Lat:     lat,
+		Lng:     lng,
+		Title:   title,
+		Address: address,
+	}
+}
+
+func NewContactCardPayload(userId int64, userName, icon string) *helloim_proto.ContactCardPayload {
+	return &helloim_proto.ContactCardPayload{
+		UserId:   userId,
+		UserName: userName,
+		Icon:     icon,
+	}
+}
+
 // ExtractContent 从 Payload 中提取内容和类型
+// Note: 语音、位置、名片等结构化消息以 JSON 的形式保存到 MsgContent
 func ExtractContent(p *helloim_proto.Payload) (string, int32) {
 	switch p.GetPayloadType() {
-	case helloim_proto.PayloadType_TEXT:
+	case helloim_proto.PayloadType_TEXT, helloim_proto.PayloadType_MARKDOWN:
 		if t := p.GetText(); t != nil {
 			return t.GetContent(), int32(p.GetPayloadType())
 		}
@@ -90,6 +183,22 @@ func ExtractContent(p *helloim_proto.Payload) (string, int32) {
 		if f := p.GetFile(); f != nil {
 			return f.GetFileUrl(), int32(p.GetPayloadType())
 		}
+	case helloim_proto.PayloadType_VOICE:
+		if v := p.GetVoice(); v != nil {
+			return marshalContent(v), int32(p.GetPayloadType())
+		}
+	case helloim_proto.PayloadType_LOCATION:
+		if l := p.GetLocation(); l != nil {
+			return marshalContent(l), int32(p.GetPayloadType())
+		}
+	case helloim_proto.PayloadType_CONTACT_CARD:
+		if c := p.GetContactCard(); c != nil {
+			return marshalContent(c), int32(p.GetPayloadType())
+		}
+	case helloim_proto.PayloadType_ENCRYPTED:
+		if e := p.GetEncrypted(); e != nil {
+			return marshalContent(e), int32(p.GetPayloadType())
+		}
 	}
 	return "", int32(p.GetPayloadType())
 }
@@ -104,3 +213,75 @@ func NewReceiptPayload(msgId, serverSeq int64) *helloim_proto.ReceiptPayload {
 		},
 	}
 }
+
+// ParseVoice 从 MsgContent 中解析语音消息
+func ParseVoice(content string) (*helloim_proto.VoicePayload, error) {
+	voice := &helloim_proto.VoicePayload{}
+	if err := protojson.Unmarshal([]byte(content), voice); err != nil {
+		return nil, err
+	}
+	return voice, nil
+}
+
+// ParseLocation 从 MsgContent 中解析位置消息
+func ParseLocation(content string) (*helloim_proto.LocationPayload, error) {
+	location := &helloim_proto.LocationPayload{}
+	if err := protojson.Unmarshal([]byte(content), location); err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// ParseContactCard 从 MsgContent 中解析名片消息
+func ParseContactCard(content string) (*helloim_proto.ContactCardPayload, error) {
+	card := &helloim_proto.ContactCardPayload{}
+	if err := protojson.Unmarshal([]byte(content), card); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// ParseEncrypted 从 MsgContent 中解析无法解密的加密消息
+func ParseEncrypted(content string) (*helloim_proto.EncryptedPayload, error) {
+	encrypted := &helloim_proto.EncryptedPayload{}
+	if err := protojson.Unmarshal([]byte(content), encrypted); err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// Summary 消息的文本摘要, 用于会话列表等只展示一行文本的场景
+func Summary(contentType int32, content string) string {
+	switch helloim_proto.PayloadType(contentType) {
+	case helloim_proto.PayloadType_IMAGE:
+		return "[图片]"
+	case helloim_proto.PayloadType_FILE:
+		return "[文件]"
+	case helloim_proto.PayloadType_VOICE:
+		if v, err := ParseVoice(content); err == nil {
+			return fmt.Sprintf("[语音] %d\"", v.GetDuration())
+		}
+		return "[语音]"
+	case helloim_proto.PayloadType_LOCATION:
+		if l, err := ParseLocation(content); err == nil {
+			return "[位置] " + l.GetTitle()
+		}
+		return "[位置]"
+	case helloim_proto.PayloadType_CONTACT_CARD:
+		if c, err := ParseContactCard(content); err == nil {
+			return "[名片] " + c.GetUserName()
+		}
+		return "[名片]"
+	case helloim_proto.PayloadType_ENCRYPTED:
+		return "[加密消息]"
+	}
+	return content
+}
+
+func marshalContent(m proto.Message) string {
+	bytes, err := protojson.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(bytes)
+}
@@ -0,0 +1,92 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+func TestVoice(t *testing.T) {
+	content, contentType := ExtractContent(NewVoiceMessage("http://host/a.wav", 12, "wav", false, nil))
+	assert.Equal(t, int32(helloim_proto.PayloadType_VOICE), contentType)
+	voice, err := ParseVoice(content)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://host/a.wav", voice.GetVoiceUrl())
+	assert.Equal(t, int32(12), voice.GetDuration())
+	assert.Equal(t, "wav", voice.GetCodec())
+	assert.Equal(t, "[语音] 12\"", Summary(contentType, content))
+
+	cases := []struct {
+		name    string
+		content string
+		summary string
+	}{
+		{"empty", "", "[语音]"},
+		{"invalid json", "{", "[语音]"},
+		{"unknown field", `{"foo":1}`, "[语音]"},
+		{"no duration", `{"voiceUrl":"u"}`, "[语音] 0\""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.summary, Summary(int32(helloim_proto.PayloadType_VOICE), c.content), c.name)
+	}
+	_, err = ParseVoice("{")
+	assert.NotNil(t, err)
+}
+
+func TestLocation(t *testing.T) {
+	p := NewLocationMessage(31.2304, 121.4737, "人民广场", "上海市黄浦区", false, nil)
+	assert.Equal(t, helloim_proto.PayloadType_LOCATION, p.GetPayloadType())
+	content, contentType := ExtractContent(p)
+	assert.Equal(t, int32(helloim_proto.PayloadType_LOCATION), contentType)
+	location, err := ParseLocation(content)
+	assert.Nil(t, err)
+	assert.Equal(t, 31.2304, location.GetLat())
+	assert.Equal(t, 121.4737, location.GetLng())
+	assert.Equal(t, "人民广场", location.GetTitle())
+	assert.Equal(t, "上海市黄浦区", location.GetAddress())
+	assert.Equal(t, "[位置] 人民广场", Summary(contentType, content))
+	assert.Equal(t, "[位置]", Summary(contentType, "not json"))
+}
+
+func TestContactCard(t *testing.T) {
+	p := NewContactCardMessage(42, "user42", "http://host/icon.png", true, []string{"1"})
+	assert.Equal(t, helloim_proto.PayloadType_CONTACT_CARD, p.GetPayloadType())
+	assert.True(t, p.GetAt())
+	content, contentType := ExtractContent(p)
+	assert.Equal(t, int32(helloim_proto.PayloadType_CONTACT_CARD), contentType)
+	card, err := ParseContactCard(content)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), card.GetUserId())
+	assert.Equal(t, "user42", card.GetUserName())
+	assert.Equal(t, "http://host/icon.png", card.GetIcon())
+	assert.Equal(t, "[名片] user42", Summary(contentType, content))
+	assert.Equal(t, "[名片]", Summary(contentType, ""))
+}
+
+func TestExtractContent(t *testing.T) {
+	cases := []struct {
+		name        string
+		payload     *helloim_proto.Payload
+		content     string
+		contentType helloim_proto.PayloadType
+		summary     string
+	}{
+		{"text", NewTextMessage("**hi**", false, nil), "**hi**", helloim_proto.PayloadType_TEXT, "**hi**"},
+		{"markdown", NewMarkdownMessage("# title", false, nil), "# title", helloim_proto.PayloadType_MARKDOWN, "# title"},
+		{"image", NewImageMessage("http://host/a.png", false, nil), "http://host/a.png", helloim_proto.PayloadType_IMAGE, "[图片]"},
+		{"file", NewFileMessage("a.txt", "http://host/a.txt", false, nil), "http://host/a.txt", helloim_proto.PayloadType_FILE, "[文件]"},
+		// 类型和内容不一致时内容为空
+		{"mismatched content", &helloim_proto.Payload{
+			PayloadType: helloim_proto.PayloadType_LOCATION,
+			Content:     &helloim_proto.Payload_Text{Text: NewTextPayload("x")},
+		}, "", helloim_proto.PayloadType_LOCATION, "[位置]"},
+		{"nil", nil, "", helloim_proto.PayloadType(0), ""},
+	}
+	for _, c := range cases {
+		content, contentType := ExtractContent(c.payload)
+		assert.Equal(t, c.content, content, c.name)
+		assert.Equal(t, int32(c.contentType), contentType, c.name)
+		assert.Equal(t, c.summary, Summary(contentType, content), c.name)
+	}
+}
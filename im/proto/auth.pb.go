@@ -22,14 +22,121 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// 帧的压缩算法, 与 v2 消息头 flags 中的取值一致
+type CompressionType int32
+
+const (
+	CompressionType_COMPRESSION_NONE   CompressionType = 0
+	CompressionType_COMPRESSION_SNAPPY CompressionType = 1
+	CompressionType_COMPRESSION_ZSTD   CompressionType = 2
+)
+
+// Enum value maps for CompressionType.
+var (
+	CompressionType_name = map[int32]string{
+		0: "COMPRESSION_NONE",
+		1: "COMPRESSION_SNAPPY",
+		2: "COMPRESSION_ZSTD",
+	}
+	CompressionType_value = map[string]int32{
+		"COMPRESSION_NONE":   0,
+		"COMPRESSION_SNAPPY": 1,
+		"COMPRESSION_ZSTD":   2,
+	}
+)
+
+func (x CompressionType) Enum() *CompressionType {
+	p := new(CompressionType)
+	*p = x
+	return p
+}
+
+func (x CompressionType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CompressionType) Descriptor() protoreflect.EnumDescriptor {
+	return file_auth_proto_enumTypes[0].Descriptor()
+}
+
+func (CompressionType) Type() protoreflect.EnumType {
+	return &file_auth_proto_enumTypes[0]
+}
+
+func (x CompressionType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CompressionType.Descriptor instead.
+func (CompressionType) EnumDescriptor() ([]byte, []int) {
+	return file_auth_proto_rawDescGZIP(), []int{0}
+}
+
+// 被踢下线的原因
+type KickoutReason int32
+
+const (
+	KickoutReason_KICKOUT_UNKNOWN          KickoutReason = 0
+	KickoutReason_KICKOUT_OTHER_DEVICE     KickoutReason = 1 // 账号在其他设备登录
+	KickoutReason_KICKOUT_SESSION_REVOKED  KickoutReason = 2 // 服务端吊销了会话, 例如修改了密码
+	KickoutReason_KICKOUT_ACCOUNT_DISABLED KickoutReason = 3 // 账号被禁用
+)
+
+// Enum value maps for KickoutReason.
+var (
+	KickoutReason_name = map[int32]string{
+		0: "KICKOUT_UNKNOWN",
+		1: "KICKOUT_OTHER_DEVICE",
+		2: "KICKOUT_SESSION_REVOKED",
+		3: "KICKOUT_ACCOUNT_DISABLED",
+	}
+	KickoutReason_value = map[string]int32{
+		"KICKOUT_UNKNOWN":          0,
+		"KICKOUT_OTHER_DEVICE":     1,
+		"KICKOUT_SESSION_REVOKED":  2,
+		"KICKOUT_ACCOUNT_DISABLED": 3,
+	}
+)
+
+func (x KickoutReason) Enum() *KickoutReason {
+	p := new(KickoutReason)
+	*p = x
+	return p
+}
+
+func (x KickoutReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (KickoutReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_auth_proto_enumTypes[1].Descriptor()
+}
+
+func (KickoutReason) Type() protoreflect.EnumType {
+	return &file_auth_proto_enumTypes[1]
+}
+
+func (x KickoutReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use KickoutReason.Descriptor instead.
+func (KickoutReason) EnumDescriptor() ([]byte, []int) {
+	return file_auth_proto_rawDescGZIP(), []int{1}
+}
+
 // 认证的上行消息
 type AuthRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
-	UserType      int32                  `protobuf:"varint,2,opt,name=userType,proto3" json:"userType,omitempty"`
-	Token         string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Uid             string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	UserType        int32                  `protobuf:"varint,2,opt,name=userType,proto3" json:"userType,omitempty"`
+	Token           string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	ProtocolVersion int32                  `protobuf:"varint,4,opt,name=protocolVersion,proto3" json:"protocolVersion,omitempty"`                                        // 客户端支持的最高帧协议版本, 0 表示只支持 v1
+	Compressions    []CompressionType      `protobuf:"varint,5,rep,packed,name=compressions,proto3,enum=helloim.protocol.CompressionType" json:"compressions,omitempty"` // 客户端支持的压缩算法, 按优先级排列
+	DeviceId        string                 `protobuf:"bytes,6,opt,name=deviceId,proto3" json:"deviceId,omitempty"`                                                       // 设备id, 同一个账号可以在多个设备同时登录
+	Platform        string                 `protobuf:"bytes,7,opt,name=platform,proto3" json:"platform,omitempty"`                                                       // 设备的平台, 例如 cli-linux
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *AuthRequest) Reset() {
@@ -83,14 +190,44 @@ func (x *AuthRequest) GetToken() string {
 	return ""
 }
 
+func (x *AuthRequest) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *AuthRequest) GetCompressions() []CompressionType {
+	if x != nil {
+		return x.Compressions
+	}
+	return nil
+}
+
+func (x *AuthRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *AuthRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
 // 认证的结果
 type AuthResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Uid           string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
-	UserType      int32                  `protobuf:"varint,2,opt,name=userType,proto3" json:"userType,omitempty"`
-	Success       bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Uid             string                 `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	UserType        int32                  `protobuf:"varint,2,opt,name=userType,proto3" json:"userType,omitempty"`
+	Success         bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	ProtocolVersion int32                  `protobuf:"varint,4,opt,name=protocolVersion,proto3" json:"protocolVersion,omitempty"`                               // 协商后的帧协议版本, 旧的服务端不设置, 按 v1 处理
+	Compression     CompressionType        `protobuf:"varint,5,opt,name=compression,proto3,enum=helloim.protocol.CompressionType" json:"compression,omitempty"` // 协商后的压缩算法, 只在 v2 消息头中生效
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *AuthResponse) Reset() {
@@ -144,20 +281,123 @@ func (x *AuthResponse) GetSuccess() bool {
 	return false
 }
 
+func (x *AuthResponse) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *AuthResponse) GetCompression() CompressionType {
+	if x != nil {
+		return x.Compression
+	}
+	return CompressionType_COMPRESSION_NONE
+}
+
+// 服务端踢下线的下行消息, 发送后服务端断开连接
+type KickoutRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        KickoutReason          `protobuf:"varint,1,opt,name=reason,proto3,enum=helloim.protocol.KickoutReason" json:"reason,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`   // 展示给用户的说明
+	DeviceId      string                 `protobuf:"bytes,3,opt,name=deviceId,proto3" json:"deviceId,omitempty"` // 挤掉当前设备的新设备
+	Platform      string                 `protobuf:"bytes,4,opt,name=platform,proto3" json:"platform,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickoutRequest) Reset() {
+	*x = KickoutRequest{}
+	mi := &file_auth_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickoutRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickoutRequest) ProtoMessage() {}
+
+func (x *KickoutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_auth_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickoutRequest.ProtoReflect.Descriptor instead.
+func (*KickoutRequest) Descriptor() ([]byte, []int) {
+	return file_auth_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *KickoutRequest) GetReason() KickoutReason {
+	if x != nil {
+		return x.Reason
+	}
+	return KickoutReason_KICKOUT_UNKNOWN
+}
+
+func (x *KickoutRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *KickoutRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *KickoutRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
 var File_auth_proto protoreflect.FileDescriptor
 
 const file_auth_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"auth.proto\x12\x10helloim.protocol\"Q\n" +
+	"auth.proto\x12\x10helloim.protocol\"\xfa\x01\n" +
 	"\vAuthRequest\x12\x10\n" +
 	"\x03uid\x18\x01 \x01(\tR\x03uid\x12\x1a\n" +
 	"\buserType\x18\x02 \x01(\x05R\buserType\x12\x14\n" +
-	"\x05token\x18\x03 \x01(\tR\x05token\"V\n" +
+	"\x05token\x18\x03 \x01(\tR\x05token\x12(\n" +
+	"\x0fprotocolVersion\x18\x04 \x01(\x05R\x0fprotocolVersion\x12E\n" +
+	"\fcompressions\x18\x05 \x03(\x0e2!.helloim.protocol.CompressionTypeR\fcompressions\x12\x1a\n" +
+	"\bdeviceId\x18\x06 \x01(\tR\bdeviceId\x12\x1a\n" +
+	"\bplatform\x18\a \x01(\tR\bplatform\"\xc5\x01\n" +
 	"\fAuthResponse\x12\x10\n" +
 	"\x03uid\x18\x01 \x01(\tR\x03uid\x12\x1a\n" +
 	"\buserType\x18\x02 \x01(\x05R\buserType\x12\x18\n" +
-	"\asuccess\x18\x03 \x01(\bR\asuccessBu\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12(\n" +
+	"\x0fprotocolVersion\x18\x04 \x01(\x05R\x0fprotocolVersion\x12C\n" +
+	"\vcompression\x18\x05 \x01(\x0e2!.helloim.protocol.CompressionTypeR\vcompression\"\x9b\x01\n" +
+	"\x0eKickoutRequest\x127\n" +
+	"\x06reason\x18\x01 \x01(\x0e2\x1f.helloim.protocol.KickoutReasonR\x06reason\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1a\n" +
+	"\bdeviceId\x18\x03 \x01(\tR\bdeviceId\x12\x1a\n" +
+	"\bplatform\x18\x04 \x01(\tR\bplatform*U\n" +
+	"\x0fCompressionType\x12\x14\n" +
+	"\x10COMPRESSION_NONE\x10\x00\x12\x16\n" +
+	"\x12COMPRESSION_SNAPPY\x10\x01\x12\x14\n" +
+	"\x10COMPRESSION_ZSTD\x10\x02*y\n" +
+	"\rKickoutReason\x12\x13\n" +
+	"\x0fKICKOUT_UNKNOWN\x10\x00\x12\x18\n" +
+	"\x14KICKOUT_OTHER_DEVICE\x10\x01\x12\x1b\n" +
+	"\x17KICKOUT_SESSION_REVOKED\x10\x02\x12\x1c\n" +
+	"\x18KICKOUT_ACCOUNT_DISABLED\x10\x03Bu\n" +
 	",com.github.xuning888.helloim.common.protobufB\x04AuthZ?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
 
 var (
@@ -172,17 +412,24 @@ func file_auth_proto_rawDescGZIP() []byte {
 	return file_auth_proto_rawDescData
 }
 
-var file_auth_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_auth_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_auth_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_auth_proto_goTypes = []any{
-	(*AuthRequest)(nil),  // 0: helloim.protocol.AuthRequest
-	(*AuthResponse)(nil), // 1: helloim.protocol.AuthResponse
+	(CompressionType)(0),   // 0: helloim.protocol.CompressionType
+	(KickoutReason)(0),     // 1: helloim.protocol.KickoutReason
+	(*AuthRequest)(nil),    // 2: helloim.protocol.AuthRequest
+	(*AuthResponse)(nil),   // 3: helloim.protocol.AuthResponse
+	(*KickoutRequest)(nil), // 4: helloim.protocol.KickoutRequest
 }
 var file_auth_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: helloim.protocol.AuthRequest.compressions:type_name -> helloim.protocol.CompressionType
+	0, // 1: helloim.protocol.AuthResponse.compression:type_name -> helloim.protocol.CompressionType
+	1, // 2: helloim.protocol.KickoutRequest.reason:type_name -> helloim.protocol.KickoutReason
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_auth_proto_init() }
@@ -195,13 +442,14 @@ func file_auth_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_auth_proto_rawDesc), len(file_auth_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_auth_proto_goTypes,
 		DependencyIndexes: file_auth_proto_depIdxs,
+		EnumInfos:         file_auth_proto_enumTypes,
 		MessageInfos:      file_auth_proto_msgTypes,
 	}.Build()
 	File_auth_proto = out.File
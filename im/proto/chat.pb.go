@@ -0,0 +1,276 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v6.33.2
+// source: chat.proto
+
+package helloim_proto
+
+import (
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// 会话设置, 在同一个账号的多个设备之间同步, 每次携带完整的设置
+type ChatSettings struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChatId        string                 `protobuf:"bytes,1,opt,name=chatId,proto3" json:"chatId,omitempty"`                // 会话id, 单聊为对方的uid
+	ChatType      int32                  `protobuf:"varint,2,opt,name=chatType,proto3" json:"chatType,omitempty"`           // 会话类型
+	Top           bool                   `protobuf:"varint,3,opt,name=top,proto3" json:"top,omitempty"`                     // 置顶
+	Mute          bool                   `protobuf:"varint,4,opt,name=mute,proto3" json:"mute,omitempty"`                   // 免打扰
+	Del           bool                   `protobuf:"varint,5,opt,name=del,proto3" json:"del,omitempty"`                     // 已删除, 收到新消息后重新显示
+	DelTimestamp  int64                  `protobuf:"varint,6,opt,name=delTimestamp,proto3" json:"delTimestamp,omitempty"`   // 删除的时间, 之前的消息不再显示
+	LastReadMsgId int64                  `protobuf:"varint,7,opt,name=lastReadMsgId,proto3" json:"lastReadMsgId,omitempty"` // 已读游标, 只会前进
+	Archive       bool                   `protobuf:"varint,8,opt,name=archive,proto3" json:"archive,omitempty"`             // 已归档, 不在会话列表中显示
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatSettings) Reset() {
+	*x = ChatSettings{}
+	mi := &file_chat_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatSettings) ProtoMessage() {}
+
+func (x *ChatSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_chat_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatSettings.ProtoReflect.Descriptor instead.
+func (*ChatSettings) Descriptor() ([]byte, []int) {
+	return file_chat_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ChatSettings) GetChatId() string {
+	if x != nil {
+		return x.ChatId
+	}
+	return ""
+}
+
+func (x *ChatSettings) GetChatType() int32 {
+	if x != nil {
+		return x.ChatType
+	}
+	return 0
+}
+
+func (x *ChatSettings) GetTop() bool {
+	if x != nil {
+		return x.Top
+	}
+	return false
+}
+
+func (x *ChatSettings) GetMute() bool {
+	if x != nil {
+		return x.Mute
+	}
+	return false
+}
+
+func (x *ChatSettings) GetDel() bool {
+	if x != nil {
+		return x.Del
+	}
+	return false
+}
+
+func (x *ChatSettings) GetDelTimestamp() int64 {
+	if x != nil {
+		return x.DelTimestamp
+	}
+	return 0
+}
+
+func (x *ChatSettings) GetLastReadMsgId() int64 {
+	if x != nil {
+		return x.LastReadMsgId
+	}
+	return 0
+}
+
+func (x *ChatSettings) GetArchive() bool {
+	if x != nil {
+		return x.Archive
+	}
+	return false
+}
+
+// 会话设置同步, 上行时服务端保存后转发给同一个账号的其他设备, 下行是其他设备上的修改
+type ChatSyncRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Settings      []*ChatSettings        `protobuf:"bytes,1,rep,name=settings,proto3" json:"settings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatSyncRequest) Reset() {
+	*x = ChatSyncRequest{}
+	mi := &file_chat_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatSyncRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatSyncRequest) ProtoMessage() {}
+
+func (x *ChatSyncRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_chat_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatSyncRequest.ProtoReflect.Descriptor instead.
+func (*ChatSyncRequest) Descriptor() ([]byte, []int) {
+	return file_chat_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChatSyncRequest) GetSettings() []*ChatSettings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+type ChatSyncResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatSyncResponse) Reset() {
+	*x = ChatSyncResponse{}
+	mi := &file_chat_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatSyncResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatSyncResponse) ProtoMessage() {}
+
+func (x *ChatSyncResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_chat_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatSyncResponse.ProtoReflect.Descriptor instead.
+func (*ChatSyncResponse) Descriptor() ([]byte, []int) {
+	return file_chat_proto_rawDescGZIP(), []int{2}
+}
+
+var File_chat_proto protoreflect.FileDescriptor
+
+const file_chat_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"chat.proto\x12\x10helloim.protocol\"\xde\x01\n" +
+	"\fChatSettings\x12\x16\n" +
+	"\x06chatId\x18\x01 \x01(\tR\x06chatId\x12\x1a\n" +
+	"\bchatType\x18\x02 \x01(\x05R\bchatType\x12\x10\n" +
+	"\x03top\x18\x03 \x01(\bR\x03top\x12\x12\n" +
+	"\x04mute\x18\x04 \x01(\bR\x04mute\x12\x10\n" +
+	"\x03del\x18\x05 \x01(\bR\x03del\x12\"\n" +
+	"\fdelTimestamp\x18\x06 \x01(\x03R\fdelTimestamp\x12$\n" +
+	"\rlastReadMsgId\x18\a \x01(\x03R\rlastReadMsgId\x12\x18\n" +
+	"\aarchive\x18\b \x01(\bR\aarchive\"M\n" +
+	"\x0fChatSyncRequest\x12:\n" +
+	"\bsettings\x18\x01 \x03(\v2\x1e.helloim.protocol.ChatSettingsR\bsettings\"\x12\n" +
+	"\x10ChatSyncResponseB|\n" +
+	",com.github.xuning888.helloim.common.protobufB\tChatProtoP\x01Z?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
+
+var (
+	file_chat_proto_rawDescOnce sync.Once
+	file_chat_proto_rawDescData []byte
+)
+
+func file_chat_proto_rawDescGZIP() []byte {
+	file_chat_proto_rawDescOnce.Do(func() {
+		file_chat_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_chat_proto_rawDesc), len(file_chat_proto_rawDesc)))
+	})
+	return file_chat_proto_rawDescData
+}
+
+var file_chat_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_chat_proto_goTypes = []any{
+	(*ChatSettings)(nil),     // 0: helloim.protocol.ChatSettings
+	(*ChatSyncRequest)(nil),  // 1: helloim.protocol.ChatSyncRequest
+	(*ChatSyncResponse)(nil), // 2: helloim.protocol.ChatSyncResponse
+}
+var file_chat_proto_depIdxs = []int32{
+	0, // 0: helloim.protocol.ChatSyncRequest.settings:type_name -> helloim.protocol.ChatSettings
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_chat_proto_init() }
+func file_chat_proto_init() {
+	if File_chat_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_chat_proto_rawDesc), len(file_chat_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_chat_proto_goTypes,
+		DependencyIndexes: file_chat_proto_depIdxs,
+		MessageInfos:      file_chat_proto_msgTypes,
+	}.Build()
+	File_chat_proto = out.File
+	file_chat_proto_goTypes = nil
+	file_chat_proto_depIdxs = nil
+}
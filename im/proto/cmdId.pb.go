@@ -25,12 +25,15 @@ const (
 type CmdId int32
 
 const (
-	CmdId_CMD_ID_DEFAULT   CmdId = 0
-	CmdId_CMD_ID_ECHO      CmdId = 1    // echo
-	CmdId_CMD_ID_AUTH      CmdId = 2    // AUTH
-	CmdId_CMD_ID_HEARTBEAT CmdId = 3    // 心跳
-	CmdId_CMD_ID_SEND      CmdId = 1010 // send上行
-	CmdId_CMD_ID_PUSH      CmdId = 1011 // push下行
+	CmdId_CMD_ID_DEFAULT       CmdId = 0
+	CmdId_CMD_ID_ECHO          CmdId = 1    // echo
+	CmdId_CMD_ID_AUTH          CmdId = 2    // AUTH
+	CmdId_CMD_ID_HEARTBEAT     CmdId = 3    // 心跳
+	CmdId_CMD_ID_KICKOUT       CmdId = 4    // 服务端踢下线, 下行
+	CmdId_CMD_ID_SEND          CmdId = 1010 // send上行
+	CmdId_CMD_ID_PUSH          CmdId = 1011 // push下行
+	CmdId_CMD_ID_CHAT_SYNC     CmdId = 1012 // 会话设置多端同步, 上行和下行
+	CmdId_CMD_ID_FRIEND_NOTIFY CmdId = 1013 // 好友申请通知, 下行
 )
 
 // Enum value maps for CmdId.
@@ -40,16 +43,22 @@ var (
 		1:    "CMD_ID_ECHO",
 		2:    "CMD_ID_AUTH",
 		3:    "CMD_ID_HEARTBEAT",
+		4:    "CMD_ID_KICKOUT",
 		1010: "CMD_ID_SEND",
 		1011: "CMD_ID_PUSH",
+		1012: "CMD_ID_CHAT_SYNC",
+		1013: "CMD_ID_FRIEND_NOTIFY",
 	}
 	CmdId_value = map[string]int32{
-		"CMD_ID_DEFAULT":   0,
-		"CMD_ID_ECHO":      1,
-		"CMD_ID_AUTH":      2,
-		"CMD_ID_HEARTBEAT": 3,
-		"CMD_ID_SEND":      1010,
-		"CMD_ID_PUSH":      1011,
+		"CMD_ID_DEFAULT":       0,
+		"CMD_ID_ECHO":          1,
+		"CMD_ID_AUTH":          2,
+		"CMD_ID_HEARTBEAT":     3,
+		"CMD_ID_KICKOUT":       4,
+		"CMD_ID_SEND":          1010,
+		"CMD_ID_PUSH":          1011,
+		"CMD_ID_CHAT_SYNC":     1012,
+		"CMD_ID_FRIEND_NOTIFY": 1013,
 	}
 )
 
@@ -84,14 +93,17 @@ var File_cmdId_proto protoreflect.FileDescriptor
 
 const file_cmdId_proto_rawDesc = "" +
 	"\n" +
-	"\vcmdId.proto\x12\x10helloim.protocol*w\n" +
+	"\vcmdId.proto\x12\x10helloim.protocol*\xbd\x01\n" +
 	"\x05CmdId\x12\x12\n" +
 	"\x0eCMD_ID_DEFAULT\x10\x00\x12\x0f\n" +
 	"\vCMD_ID_ECHO\x10\x01\x12\x0f\n" +
 	"\vCMD_ID_AUTH\x10\x02\x12\x14\n" +
-	"\x10CMD_ID_HEARTBEAT\x10\x03\x12\x10\n" +
+	"\x10CMD_ID_HEARTBEAT\x10\x03\x12\x12\n" +
+	"\x0eCMD_ID_KICKOUT\x10\x04\x12\x10\n" +
 	"\vCMD_ID_SEND\x10\xf2\a\x12\x10\n" +
-	"\vCMD_ID_PUSH\x10\xf3\aBw\n" +
+	"\vCMD_ID_PUSH\x10\xf3\a\x12\x15\n" +
+	"\x10CMD_ID_CHAT_SYNC\x10\xf4\a\x12\x19\n" +
+	"\x14CMD_ID_FRIEND_NOTIFY\x10\xf5\aBw\n" +
 	",com.github.xuning888.helloim.common.protobufB\x06MsgCmdZ?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
 
 var (
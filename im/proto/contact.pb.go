@@ -0,0 +1,266 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v6.33.2
+// source: contact.proto
+
+package helloim_proto
+
+import (
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// 好友申请
+type FriendRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     int64                  `protobuf:"varint,1,opt,name=requestId,proto3" json:"requestId,omitempty"`      // 申请id
+	From          string                 `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`                 // 申请人的uid
+	To            string                 `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`                     // 被申请人的uid
+	FromUserName  string                 `protobuf:"bytes,4,opt,name=fromUserName,proto3" json:"fromUserName,omitempty"` // 申请人的用户名
+	Message       string                 `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`           // 附言
+	Status        int32                  `protobuf:"varint,6,opt,name=status,proto3" json:"status,omitempty"`            // 0 待处理, 1 已同意, 2 已拒绝
+	Timestamp     int64                  `protobuf:"varint,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`      // 申请或者处理的时间
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FriendRequest) Reset() {
+	*x = FriendRequest{}
+	mi := &file_contact_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FriendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FriendRequest) ProtoMessage() {}
+
+func (x *FriendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_contact_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FriendRequest.ProtoReflect.Descriptor instead.
+func (*FriendRequest) Descriptor() ([]byte, []int) {
+	return file_contact_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FriendRequest) GetRequestId() int64 {
+	if x != nil {
+		return x.RequestId
+	}
+	return 0
+}
+
+func (x *FriendRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *FriendRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *FriendRequest) GetFromUserName() string {
+	if x != nil {
+		return x.FromUserName
+	}
+	return ""
+}
+
+func (x *FriendRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *FriendRequest) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *FriendRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// 好友申请通知, 下行, 收到新的申请或者申请被处理时推送给双方的所有设备
+type FriendNotifyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Request       *FriendRequest         `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FriendNotifyRequest) Reset() {
+	*x = FriendNotifyRequest{}
+	mi := &file_contact_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FriendNotifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FriendNotifyRequest) ProtoMessage() {}
+
+func (x *FriendNotifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_contact_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FriendNotifyRequest.ProtoReflect.Descriptor instead.
+func (*FriendNotifyRequest) Descriptor() ([]byte, []int) {
+	return file_contact_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *FriendNotifyRequest) GetRequest() *FriendRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+type FriendNotifyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FriendNotifyResponse) Reset() {
+	*x = FriendNotifyResponse{}
+	mi := &file_contact_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FriendNotifyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FriendNotifyResponse) ProtoMessage() {}
+
+func (x *FriendNotifyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_contact_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FriendNotifyResponse.ProtoReflect.Descriptor instead.
+func (*FriendNotifyResponse) Descriptor() ([]byte, []int) {
+	return file_contact_proto_rawDescGZIP(), []int{2}
+}
+
+var File_contact_proto protoreflect.FileDescriptor
+
+const file_contact_proto_rawDesc = "" +
+	"\n" +
+	"\rcontact.proto\x12\x10helloim.protocol\"\xc5\x01\n" +
+	"\rFriendRequest\x12\x1c\n" +
+	"\trequestId\x18\x01 \x01(\x03R\trequestId\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\x12\"\n" +
+	"\ffromUserName\x18\x04 \x01(\tR\ffromUserName\x12\x18\n" +
+	"\amessage\x18\x05 \x01(\tR\amessage\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\x05R\x06status\x12\x1c\n" +
+	"\ttimestamp\x18\a \x01(\x03R\ttimestamp\"P\n" +
+	"\x13FriendNotifyRequest\x129\n" +
+	"\arequest\x18\x01 \x01(\v2\x1f.helloim.protocol.FriendRequestR\arequest\"\x16\n" +
+	"\x14FriendNotifyResponseB\x7f\n" +
+	",com.github.xuning888.helloim.common.protobufB\fContactProtoP\x01Z?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
+
+var (
+	file_contact_proto_rawDescOnce sync.Once
+	file_contact_proto_rawDescData []byte
+)
+
+func file_contact_proto_rawDescGZIP() []byte {
+	file_contact_proto_rawDescOnce.Do(func() {
+		file_contact_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_contact_proto_rawDesc), len(file_contact_proto_rawDesc)))
+	})
+	return file_contact_proto_rawDescData
+}
+
+var file_contact_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_contact_proto_goTypes = []any{
+	(*FriendRequest)(nil),        // 0: helloim.protocol.FriendRequest
+	(*FriendNotifyRequest)(nil),  // 1: helloim.protocol.FriendNotifyRequest
+	(*FriendNotifyResponse)(nil), // 2: helloim.protocol.FriendNotifyResponse
+}
+var file_contact_proto_depIdxs = []int32{
+	0, // 0: helloim.protocol.FriendNotifyRequest.request:type_name -> helloim.protocol.FriendRequest
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_contact_proto_init() }
+func file_contact_proto_init() {
+	if File_contact_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_contact_proto_rawDesc), len(file_contact_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_contact_proto_goTypes,
+		DependencyIndexes: file_contact_proto_depIdxs,
+		MessageInfos:      file_contact_proto_msgTypes,
+	}.Build()
+	File_contact_proto = out.File
+	file_contact_proto_goTypes = nil
+	file_contact_proto_depIdxs = nil
+}
@@ -165,6 +165,197 @@ func (x *FilePayload) GetFileUrl() string {
 	return ""
 }
 
+// 语音消息
+type VoicePayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VoiceUrl      string                 `protobuf:"bytes,1,opt,name=voiceUrl,proto3" json:"voiceUrl,omitempty"`  // 语音文件地址
+	Duration      int32                  `protobuf:"varint,2,opt,name=duration,proto3" json:"duration,omitempty"` // 时长, 单位秒
+	Codec         string                 `protobuf:"bytes,3,opt,name=codec,proto3" json:"codec,omitempty"`        // 编码格式, 如 amr/aac/opus
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VoicePayload) Reset() {
+	*x = VoicePayload{}
+	mi := &file_payload_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VoicePayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VoicePayload) ProtoMessage() {}
+
+func (x *VoicePayload) ProtoReflect() protoreflect.Message {
+	mi := &file_payload_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VoicePayload.ProtoReflect.Descriptor instead.
+func (*VoicePayload) Descriptor() ([]byte, []int) {
+	return file_payload_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *VoicePayload) GetVoiceUrl() string {
+	if x != nil {
+		return x.VoiceUrl
+	}
+	return ""
+}
+
+func (x *VoicePayload) GetDuration() int32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *VoicePayload) GetCodec() string {
+	if x != nil {
+		return x.Codec
+	}
+	return ""
+}
+
+// 位置消息
+type LocationPayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`       // 纬度
+	Lng           float64                `protobuf:"fixed64,2,opt,name=lng,proto3" json:"lng,omitempty"`       // 经度
+	Title         string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`     // 地点名称
+	Address       string                 `protobuf:"bytes,4,opt,name=address,proto3" json:"address,omitempty"` // 详细地址
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationPayload) Reset() {
+	*x = LocationPayload{}
+	mi := &file_payload_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationPayload) ProtoMessage() {}
+
+func (x *LocationPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_payload_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationPayload.ProtoReflect.Descriptor instead.
+func (*LocationPayload) Descriptor() ([]byte, []int) {
+	return file_payload_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LocationPayload) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *LocationPayload) GetLng() float64 {
+	if x != nil {
+		return x.Lng
+	}
+	return 0
+}
+
+func (x *LocationPayload) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *LocationPayload) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+// 名片消息
+type ContactCardPayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        int64                  `protobuf:"varint,1,opt,name=userId,proto3" json:"userId,omitempty"`    // 名片对应的用户id
+	UserName      string                 `protobuf:"bytes,2,opt,name=userName,proto3" json:"userName,omitempty"` // 用户名
+	Icon          string                 `protobuf:"bytes,3,opt,name=icon,proto3" json:"icon,omitempty"`         // 头像
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ContactCardPayload) Reset() {
+	*x = ContactCardPayload{}
+	mi := &file_payload_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ContactCardPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContactCardPayload) ProtoMessage() {}
+
+func (x *ContactCardPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_payload_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContactCardPayload.ProtoReflect.Descriptor instead.
+func (*ContactCardPayload) Descriptor() ([]byte, []int) {
+	return file_payload_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ContactCardPayload) GetUserId() int64 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *ContactCardPayload) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+func (x *ContactCardPayload) GetIcon() string {
+	if x != nil {
+		return x.Icon
+	}
+	return ""
+}
+
 // 已读回执
 type ReceiptPayload struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -175,7 +366,7 @@ type ReceiptPayload struct {
 
 func (x *ReceiptPayload) Reset() {
 	*x = ReceiptPayload{}
-	mi := &file_payload_proto_msgTypes[3]
+	mi := &file_payload_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -187,7 +378,7 @@ func (x *ReceiptPayload) String() string {
 func (*ReceiptPayload) ProtoMessage() {}
 
 func (x *ReceiptPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_payload_proto_msgTypes[3]
+	mi := &file_payload_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -200,7 +391,7 @@ func (x *ReceiptPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ReceiptPayload.ProtoReflect.Descriptor instead.
 func (*ReceiptPayload) Descriptor() ([]byte, []int) {
-	return file_payload_proto_rawDescGZIP(), []int{3}
+	return file_payload_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *ReceiptPayload) GetReceipts() []*ReceiptPayload_Data {
@@ -210,6 +401,75 @@ func (x *ReceiptPayload) GetReceipts() []*ReceiptPayload_Data {
 	return nil
 }
 
+// 端到端加密消息, 使用双方身份密钥协商的密钥以 AES-256-GCM 加密原始的 Payload
+type EncryptedPayload struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Nonce                []byte                 `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`                               // GCM 的随机数
+	Ciphertext           []byte                 `protobuf:"bytes,2,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`                     // 密文和认证标签
+	SenderFingerprint    string                 `protobuf:"bytes,3,opt,name=senderFingerprint,proto3" json:"senderFingerprint,omitempty"`       // 发送方公钥的指纹
+	RecipientFingerprint string                 `protobuf:"bytes,4,opt,name=recipientFingerprint,proto3" json:"recipientFingerprint,omitempty"` // 加密时使用的接收方公钥的指纹
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *EncryptedPayload) Reset() {
+	*x = EncryptedPayload{}
+	mi := &file_payload_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncryptedPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptedPayload) ProtoMessage() {}
+
+func (x *EncryptedPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_payload_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptedPayload.ProtoReflect.Descriptor instead.
+func (*EncryptedPayload) Descriptor() ([]byte, []int) {
+	return file_payload_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *EncryptedPayload) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EncryptedPayload) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
+func (x *EncryptedPayload) GetSenderFingerprint() string {
+	if x != nil {
+		return x.SenderFingerprint
+	}
+	return ""
+}
+
+func (x *EncryptedPayload) GetRecipientFingerprint() string {
+	if x != nil {
+		return x.RecipientFingerprint
+	}
+	return ""
+}
+
 type Payload struct {
 	state       protoimpl.MessageState `protogen:"open.v1"`
 	PayloadType PayloadType            `protobuf:"varint,1,opt,name=payloadType,proto3,enum=helloim.protocol.PayloadType" json:"payloadType,omitempty"` // 消息类型
@@ -221,6 +481,10 @@ type Payload struct {
 	//	*Payload_Image
 	//	*Payload_File
 	//	*Payload_Receipt
+	//	*Payload_Voice
+	//	*Payload_Location
+	//	*Payload_ContactCard
+	//	*Payload_Encrypted
 	Content       isPayload_Content `protobuf_oneof:"Content"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -228,7 +492,7 @@ type Payload struct {
 
 func (x *Payload) Reset() {
 	*x = Payload{}
-	mi := &file_payload_proto_msgTypes[4]
+	mi := &file_payload_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -240,7 +504,7 @@ func (x *Payload) String() string {
 func (*Payload) ProtoMessage() {}
 
 func (x *Payload) ProtoReflect() protoreflect.Message {
-	mi := &file_payload_proto_msgTypes[4]
+	mi := &file_payload_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -253,7 +517,7 @@ func (x *Payload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Payload.ProtoReflect.Descriptor instead.
 func (*Payload) Descriptor() ([]byte, []int) {
-	return file_payload_proto_rawDescGZIP(), []int{4}
+	return file_payload_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *Payload) GetPayloadType() PayloadType {
@@ -320,6 +584,42 @@ func (x *Payload) GetReceipt() *ReceiptPayload {
 	return nil
 }
 
+func (x *Payload) GetVoice() *VoicePayload {
+	if x != nil {
+		if x, ok := x.Content.(*Payload_Voice); ok {
+			return x.Voice
+		}
+	}
+	return nil
+}
+
+func (x *Payload) GetLocation() *LocationPayload {
+	if x != nil {
+		if x, ok := x.Content.(*Payload_Location); ok {
+			return x.Location
+		}
+	}
+	return nil
+}
+
+func (x *Payload) GetContactCard() *ContactCardPayload {
+	if x != nil {
+		if x, ok := x.Content.(*Payload_ContactCard); ok {
+			return x.ContactCard
+		}
+	}
+	return nil
+}
+
+func (x *Payload) GetEncrypted() *EncryptedPayload {
+	if x != nil {
+		if x, ok := x.Content.(*Payload_Encrypted); ok {
+			return x.Encrypted
+		}
+	}
+	return nil
+}
+
 type isPayload_Content interface {
 	isPayload_Content()
 }
@@ -340,6 +640,22 @@ type Payload_Receipt struct {
 	Receipt *ReceiptPayload `protobuf:"bytes,7,opt,name=receipt,proto3,oneof"`
 }
 
+type Payload_Voice struct {
+	Voice *VoicePayload `protobuf:"bytes,8,opt,name=voice,proto3,oneof"`
+}
+
+type Payload_Location struct {
+	Location *LocationPayload `protobuf:"bytes,9,opt,name=location,proto3,oneof"`
+}
+
+type Payload_ContactCard struct {
+	ContactCard *ContactCardPayload `protobuf:"bytes,10,opt,name=contactCard,proto3,oneof"`
+}
+
+type Payload_Encrypted struct {
+	Encrypted *EncryptedPayload `protobuf:"bytes,11,opt,name=encrypted,proto3,oneof"`
+}
+
 func (*Payload_Text) isPayload_Content() {}
 
 func (*Payload_Image) isPayload_Content() {}
@@ -348,6 +664,14 @@ func (*Payload_File) isPayload_Content() {}
 
 func (*Payload_Receipt) isPayload_Content() {}
 
+func (*Payload_Voice) isPayload_Content() {}
+
+func (*Payload_Location) isPayload_Content() {}
+
+func (*Payload_ContactCard) isPayload_Content() {}
+
+func (*Payload_Encrypted) isPayload_Content() {}
+
 type ReceiptPayload_Data struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	MsgId         int64                  `protobuf:"varint,1,opt,name=msgId,proto3" json:"msgId,omitempty"`         // 已读的消息id
@@ -358,7 +682,7 @@ type ReceiptPayload_Data struct {
 
 func (x *ReceiptPayload_Data) Reset() {
 	*x = ReceiptPayload_Data{}
-	mi := &file_payload_proto_msgTypes[5]
+	mi := &file_payload_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -370,7 +694,7 @@ func (x *ReceiptPayload_Data) String() string {
 func (*ReceiptPayload_Data) ProtoMessage() {}
 
 func (x *ReceiptPayload_Data) ProtoReflect() protoreflect.Message {
-	mi := &file_payload_proto_msgTypes[5]
+	mi := &file_payload_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -383,7 +707,7 @@ func (x *ReceiptPayload_Data) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ReceiptPayload_Data.ProtoReflect.Descriptor instead.
 func (*ReceiptPayload_Data) Descriptor() ([]byte, []int) {
-	return file_payload_proto_rawDescGZIP(), []int{3, 0}
+	return file_payload_proto_rawDescGZIP(), []int{6, 0}
 }
 
 func (x *ReceiptPayload_Data) GetMsgId() int64 {
@@ -411,12 +735,32 @@ const file_payload_proto_rawDesc = "" +
 	"\bimageUrl\x18\x01 \x01(\tR\bimageUrl\"C\n" +
 	"\vFilePayload\x12\x1a\n" +
 	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x18\n" +
-	"\afileUrl\x18\x02 \x01(\tR\afileUrl\"\x8f\x01\n" +
+	"\afileUrl\x18\x02 \x01(\tR\afileUrl\"\\\n" +
+	"\fVoicePayload\x12\x1a\n" +
+	"\bvoiceUrl\x18\x01 \x01(\tR\bvoiceUrl\x12\x1a\n" +
+	"\bduration\x18\x02 \x01(\x05R\bduration\x12\x14\n" +
+	"\x05codec\x18\x03 \x01(\tR\x05codec\"e\n" +
+	"\x0fLocationPayload\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lng\x18\x02 \x01(\x01R\x03lng\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12\x18\n" +
+	"\aaddress\x18\x04 \x01(\tR\aaddress\"\\\n" +
+	"\x12ContactCardPayload\x12\x16\n" +
+	"\x06userId\x18\x01 \x01(\x03R\x06userId\x12\x1a\n" +
+	"\buserName\x18\x02 \x01(\tR\buserName\x12\x12\n" +
+	"\x04icon\x18\x03 \x01(\tR\x04icon\"\x8f\x01\n" +
 	"\x0eReceiptPayload\x12A\n" +
 	"\breceipts\x18\x01 \x03(\v2%.helloim.protocol.ReceiptPayload.DataR\breceipts\x1a:\n" +
 	"\x04Data\x12\x14\n" +
 	"\x05msgId\x18\x01 \x01(\x03R\x05msgId\x12\x1c\n" +
-	"\tserverSeq\x18\x02 \x01(\x03R\tserverSeq\"\xdb\x02\n" +
+	"\tserverSeq\x18\x02 \x01(\x03R\tserverSeq\"\xaa\x01\n" +
+	"\x10EncryptedPayload\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\fR\x05nonce\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x02 \x01(\fR\n" +
+	"ciphertext\x12,\n" +
+	"\x11senderFingerprint\x18\x03 \x01(\tR\x11senderFingerprint\x122\n" +
+	"\x14recipientFingerprint\x18\x04 \x01(\tR\x14recipientFingerprint\"\xe2\x04\n" +
 	"\aPayload\x12?\n" +
 	"\vpayloadType\x18\x01 \x01(\x0e2\x1d.helloim.protocol.PayloadTypeR\vpayloadType\x12\x0e\n" +
 	"\x02at\x18\x02 \x01(\bR\x02at\x12\x14\n" +
@@ -424,7 +768,12 @@ const file_payload_proto_rawDesc = "" +
 	"\x04text\x18\x04 \x01(\v2\x1d.helloim.protocol.TextPayloadH\x00R\x04text\x126\n" +
 	"\x05image\x18\x05 \x01(\v2\x1e.helloim.protocol.ImagePayloadH\x00R\x05image\x123\n" +
 	"\x04file\x18\x06 \x01(\v2\x1d.helloim.protocol.FilePayloadH\x00R\x04file\x12<\n" +
-	"\areceipt\x18\a \x01(\v2 .helloim.protocol.ReceiptPayloadH\x00R\areceiptB\t\n" +
+	"\areceipt\x18\a \x01(\v2 .helloim.protocol.ReceiptPayloadH\x00R\areceipt\x126\n" +
+	"\x05voice\x18\b \x01(\v2\x1e.helloim.protocol.VoicePayloadH\x00R\x05voice\x12?\n" +
+	"\blocation\x18\t \x01(\v2!.helloim.protocol.LocationPayloadH\x00R\blocation\x12H\n" +
+	"\vcontactCard\x18\n" +
+	" \x01(\v2$.helloim.protocol.ContactCardPayloadH\x00R\vcontactCard\x12B\n" +
+	"\tencrypted\x18\v \x01(\v2\".helloim.protocol.EncryptedPayloadH\x00R\tencryptedB\t\n" +
 	"\aContentB\x7f\n" +
 	",com.github.xuning888.helloim.common.protobufB\fPayloadProtoP\x01Z?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
 
@@ -440,28 +789,36 @@ func file_payload_proto_rawDescGZIP() []byte {
 	return file_payload_proto_rawDescData
 }
 
-var file_payload_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_payload_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_payload_proto_goTypes = []any{
 	(*TextPayload)(nil),         // 0: helloim.protocol.TextPayload
 	(*ImagePayload)(nil),        // 1: helloim.protocol.ImagePayload
 	(*FilePayload)(nil),         // 2: helloim.protocol.FilePayload
-	(*ReceiptPayload)(nil),      // 3: helloim.protocol.ReceiptPayload
-	(*Payload)(nil),             // 4: helloim.protocol.Payload
-	(*ReceiptPayload_Data)(nil), // 5: helloim.protocol.ReceiptPayload.Data
-	(PayloadType)(0),            // 6: helloim.protocol.PayloadType
+	(*VoicePayload)(nil),        // 3: helloim.protocol.VoicePayload
+	(*LocationPayload)(nil),     // 4: helloim.protocol.LocationPayload
+	(*ContactCardPayload)(nil),  // 5: helloim.protocol.ContactCardPayload
+	(*ReceiptPayload)(nil),      // 6: helloim.protocol.ReceiptPayload
+	(*EncryptedPayload)(nil),    // 7: helloim.protocol.EncryptedPayload
+	(*Payload)(nil),             // 8: helloim.protocol.Payload
+	(*ReceiptPayload_Data)(nil), // 9: helloim.protocol.ReceiptPayload.Data
+	(PayloadType)(0),            // 10: helloim.protocol.PayloadType
 }
 var file_payload_proto_depIdxs = []int32{
-	5, // 0: helloim.protocol.ReceiptPayload.receipts:type_name -> helloim.protocol.ReceiptPayload.Data
-	6, // 1: helloim.protocol.Payload.payloadType:type_name -> helloim.protocol.PayloadType
-	0, // 2: helloim.protocol.Payload.text:type_name -> helloim.protocol.TextPayload
-	1, // 3: helloim.protocol.Payload.image:type_name -> helloim.protocol.ImagePayload
-	2, // 4: helloim.protocol.Payload.file:type_name -> helloim.protocol.FilePayload
-	3, // 5: helloim.protocol.Payload.receipt:type_name -> helloim.protocol.ReceiptPayload
-	6, // [6:6] is the sub-list for method output_type
-	6, // [6:6] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	9,  // 0: helloim.protocol.ReceiptPayload.receipts:type_name -> helloim.protocol.ReceiptPayload.Data
+	10, // 1: helloim.protocol.Payload.payloadType:type_name -> helloim.protocol.PayloadType
+	0,  // 2: helloim.protocol.Payload.text:type_name -> helloim.protocol.TextPayload
+	1,  // 3: helloim.protocol.Payload.image:type_name -> helloim.protocol.ImagePayload
+	2,  // 4: helloim.protocol.Payload.file:type_name -> helloim.protocol.FilePayload
+	6,  // 5: helloim.protocol.Payload.receipt:type_name -> helloim.protocol.ReceiptPayload
+	3,  // 6: helloim.protocol.Payload.voice:type_name -> helloim.protocol.VoicePayload
+	4,  // 7: helloim.protocol.Payload.location:type_name -> helloim.protocol.LocationPayload
+	5,  // 8: helloim.protocol.Payload.contactCard:type_name -> helloim.protocol.ContactCardPayload
+	7,  // 9: helloim.protocol.Payload.encrypted:type_name -> helloim.protocol.EncryptedPayload
+	10, // [10:10] is the sub-list for method output_type
+	10, // [10:10] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_payload_proto_init() }
@@ -470,11 +827,15 @@ func file_payload_proto_init() {
 		return
 	}
 	file_payload_type_proto_init()
-	file_payload_proto_msgTypes[4].OneofWrappers = []any{
+	file_payload_proto_msgTypes[8].OneofWrappers = []any{
 		(*Payload_Text)(nil),
 		(*Payload_Image)(nil),
 		(*Payload_File)(nil),
 		(*Payload_Receipt)(nil),
+		(*Payload_Voice)(nil),
+		(*Payload_Location)(nil),
+		(*Payload_ContactCard)(nil),
+		(*Payload_Encrypted)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -482,7 +843,7 @@ func file_payload_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_payload_proto_rawDesc), len(file_payload_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -25,10 +25,15 @@ const (
 type PayloadType int32
 
 const (
-	PayloadType_TEXT    PayloadType = 0 // 文本
-	PayloadType_IMAGE   PayloadType = 1 // 图片消息
-	PayloadType_RECEIPT PayloadType = 2 // 已读回执
-	PayloadType_FILE    PayloadType = 3 // 文件消息
+	PayloadType_TEXT         PayloadType = 0 // 文本
+	PayloadType_IMAGE        PayloadType = 1 // 图片消息
+	PayloadType_RECEIPT      PayloadType = 2 // 已读回执
+	PayloadType_FILE         PayloadType = 3 // 文件消息
+	PayloadType_VOICE        PayloadType = 4 // 语音消息
+	PayloadType_LOCATION     PayloadType = 5 // 位置消息
+	PayloadType_CONTACT_CARD PayloadType = 6 // 名片消息
+	PayloadType_MARKDOWN     PayloadType = 7 // markdown 格式的文本消息, 内容复用 TextPayload
+	PayloadType_ENCRYPTED    PayloadType = 8 // 端到端加密的单聊消息, 密文中是原始的 Payload
 )
 
 // Enum value maps for PayloadType.
@@ -38,12 +43,22 @@ var (
 		1: "IMAGE",
 		2: "RECEIPT",
 		3: "FILE",
+		4: "VOICE",
+		5: "LOCATION",
+		6: "CONTACT_CARD",
+		7: "MARKDOWN",
+		8: "ENCRYPTED",
 	}
 	PayloadType_value = map[string]int32{
-		"TEXT":    0,
-		"IMAGE":   1,
-		"RECEIPT": 2,
-		"FILE":    3,
+		"TEXT":         0,
+		"IMAGE":        1,
+		"RECEIPT":      2,
+		"FILE":         3,
+		"VOICE":        4,
+		"LOCATION":     5,
+		"CONTACT_CARD": 6,
+		"MARKDOWN":     7,
+		"ENCRYPTED":    8,
 	}
 )
 
@@ -78,12 +93,17 @@ var File_payload_type_proto protoreflect.FileDescriptor
 
 const file_payload_type_proto_rawDesc = "" +
 	"\n" +
-	"\x12payload_type.proto\x12\x10helloim.protocol*9\n" +
+	"\x12payload_type.proto\x12\x10helloim.protocol*\x81\x01\n" +
 	"\vPayloadType\x12\b\n" +
 	"\x04TEXT\x10\x00\x12\t\n" +
 	"\x05IMAGE\x10\x01\x12\v\n" +
 	"\aRECEIPT\x10\x02\x12\b\n" +
-	"\x04FILE\x10\x03B\x83\x01\n" +
+	"\x04FILE\x10\x03\x12\t\n" +
+	"\x05VOICE\x10\x04\x12\f\n" +
+	"\bLOCATION\x10\x05\x12\x10\n" +
+	"\fCONTACT_CARD\x10\x06\x12\f\n" +
+	"\bMARKDOWN\x10\a\x12\r\n" +
+	"\tENCRYPTED\x10\bB\x83\x01\n" +
 	",com.github.xuning888.helloim.common.protobufB\x10PayloadTypeProtoP\x01Z?github.com/xuning888/helloIMClient/internal/proto;helloim_protob\x06proto3"
 
 var (
@@ -0,0 +1,47 @@
+package chatsync
+
+import (
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+// SyncMsg 会话设置同步, 上行和服务端转发的下行共用 CMD_ID_CHAT_SYNC
+type SyncMsg struct {
+	*helloim_proto.ChatSyncRequest
+	msgSeq int32
+}
+
+func (m *SyncMsg) CmdId() int32  { return int32(helloim_proto.CmdId_CMD_ID_CHAT_SYNC) }
+func (m *SyncMsg) MsgSeq() int32 { return m.msgSeq }
+
+// SyncAck 上行同步的 ACK
+type SyncAck struct {
+	*helloim_proto.ChatSyncResponse
+}
+
+func (m *SyncAck) CmdId() int32 { return int32(helloim_proto.CmdId_CMD_ID_CHAT_SYNC) }
+
+func NewSyncMsg(settings ...*helloim_proto.ChatSettings) *SyncMsg {
+	return &SyncMsg{ChatSyncRequest: &helloim_proto.ChatSyncRequest{Settings: settings}}
+}
+
+// decodeSync RES 是上行的 ACK, REQ 是其他设备的修改
+func decodeSync(frame *protocol.Frame) (protocol.Message, error) {
+	if frame.Header.Req == protocol.RES {
+		resp := &helloim_proto.ChatSyncResponse{}
+		if err := proto.Unmarshal(frame.Body, resp); err != nil {
+			return nil, err
+		}
+		return &SyncAck{ChatSyncResponse: resp}, nil
+	}
+	req := &helloim_proto.ChatSyncRequest{}
+	if err := proto.Unmarshal(frame.Body, req); err != nil {
+		return nil, err
+	}
+	return &SyncMsg{ChatSyncRequest: req, msgSeq: frame.Header.Seq}, nil
+}
+
+func init() {
+	protocol.RegisterDecoder(int32(helloim_proto.CmdId_CMD_ID_CHAT_SYNC), decodeSync)
+}
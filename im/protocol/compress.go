@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression 消息体的压缩算法, 记录在 v2 消息头 flags 的低 4 位
+type Compression uint16
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+)
+
+// FlagCompressionMask flags 中表示压缩算法的位
+const FlagCompressionMask uint16 = 0x000F
+
+// DefaultCompressionThreshold 消息体超过该长度时才压缩, 小消息压缩的收益不抵开销
+const DefaultCompressionThreshold = 1024
+
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression(%d)", uint16(c))
+}
+
+// ParseCompression 解析 none、snappy、zstd
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("%w: %s", ErrUnsupportedCompression, s)
+}
+
+// FrameCompression 帧的消息体使用的压缩算法, v1 消息头不支持压缩
+func FrameCompression(h *MsgHeader) Compression {
+	if h.Version < Version2 {
+		return CompressionNone
+	}
+	return Compression(h.Flags & FlagCompressionMask)
+}
+
+// zstd 的编码器和解码器可以并发使用, 首次使用时创建
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(DefaultMaxFrameSize))
+	})
+}
+
+func compress(c Compression, src []byte) ([]byte, error) {
+	switch c {
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, src), nil
+	case CompressionZstd:
+		initZstd()
+		return zstdEncoder.EncodeAll(src, nil), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, c)
+}
+
+// decompress 解压后的长度不能超过 DefaultMaxFrameSize, 避免压缩炸弹
+func decompress(c Compression, src []byte) ([]byte, error) {
+	switch c {
+	case CompressionSnappy:
+		n, err := s2.DecodedLen(src)
+		if err != nil {
+			return nil, err
+		}
+		if n > DefaultMaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+		return s2.Decode(nil, src)
+	case CompressionZstd:
+		initZstd()
+		return zstdDecoder.DecodeAll(src, nil)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, c)
+}
+
+// Decompress 解压帧的消息体, 解压后清除 flags 中的压缩算法, 未压缩的帧不做处理
+func Decompress(frame *Frame) error {
+	c := FrameCompression(frame.Header)
+	if c == CompressionNone {
+		return nil
+	}
+	body, err := decompress(c, frame.Body)
+	if err != nil {
+		return &ProtocolError{Err: fmt.Errorf("decompress %s: %w", c, err), Header: frame.Header}
+	}
+	frame.Body = body
+	frame.Header.BodyLength = int32(len(body))
+	frame.Header.Flags &^= FlagCompressionMask
+	return nil
+}
+
+// CompressionStats 压缩和解压的统计, 可以并发更新
+type CompressionStats struct {
+	compressed   compressionCounter
+	decompressed compressionCounter
+}
+
+type compressionCounter struct {
+	frames    atomic.Int64
+	rawBytes  atomic.Int64
+	wireBytes atomic.Int64
+	nanos     atomic.Int64
+}
+
+func (c *compressionCounter) add(raw, wire int, elapsed time.Duration) {
+	c.frames.Add(1)
+	c.rawBytes.Add(int64(raw))
+	c.wireBytes.Add(int64(wire))
+	c.nanos.Add(int64(elapsed))
+}
+
+func (c *compressionCounter) snapshot() CompressionCounter {
+	return CompressionCounter{
+		Frames:    c.frames.Load(),
+		RawBytes:  c.rawBytes.Load(),
+		WireBytes: c.wireBytes.Load(),
+		CPUTime:   time.Duration(c.nanos.Load()),
+	}
+}
+
+// CompressionCounter 压缩或者解压的累计值
+type CompressionCounter struct {
+	Frames    int64         // 帧数
+	RawBytes  int64         // 压缩前的字节数
+	WireBytes int64         // 压缩后的字节数
+	CPUTime   time.Duration // 压缩或者解压的耗时
+}
+
+// Ratio 压缩率, 压缩前与压缩后的字节数之比
+func (c CompressionCounter) Ratio() float64 {
+	if c.WireBytes == 0 {
+		return 0
+	}
+	return float64(c.RawBytes) / float64(c.WireBytes)
+}
+
+// Snapshot 当前的统计值
+func (s *CompressionStats) Snapshot() (compressed, decompressed CompressionCounter) {
+	return s.compressed.snapshot(), s.decompressed.snapshot()
+}
+
+// Codec 一个连接按认证时协商的结果编码帧, 零值使用 v1 消息头且不压缩
+type Codec struct {
+	Version     byte
+	Compression Compression
+	Threshold   int               // 消息体超过 Threshold 字节时压缩
+	Stats       *CompressionStats // 为 nil 时不统计
+}
+
+// EncodeMessageToFrame 编码消息, 消息体超过阈值并且压缩后更小时才压缩
+func (c *Codec) EncodeMessageToFrame(seq int32, req byte, message Message) (*Frame, error) {
+	frame, err := EncodeMessageToFrame(seq, req, message)
+	if err != nil {
+		return nil, err
+	}
+	frame.Header.Version = max(c.Version, Version1)
+	if c.Version < Version2 || c.Compression == CompressionNone || len(frame.Body) <= c.Threshold {
+		return frame, nil
+	}
+	start := time.Now()
+	body, err := compress(c.Compression, frame.Body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Stats != nil {
+		c.Stats.compressed.add(len(frame.Body), len(body), time.Since(start))
+	}
+	if len(body) >= len(frame.Body) {
+		return frame, nil
+	}
+	frame.Body = body
+	frame.Header.BodyLength = int32(len(body))
+	frame.Header.Flags |= uint16(c.Compression)
+	return frame, nil
+}
+
+// EncodeMessageToBytes 编码消息为字节
+func (c *Codec) EncodeMessageToBytes(seq int32, req byte, message Message) ([]byte, error) {
+	frame, err := c.EncodeMessageToFrame(seq, req, message)
+	if err != nil {
+		return nil, err
+	}
+	return ToBytes(frame), nil
+}
+
+// Decompress 解压收到的帧并统计
+func (c *Codec) Decompress(frame *Frame) error {
+	if FrameCompression(frame.Header) == CompressionNone {
+		return nil
+	}
+	wire := len(frame.Body)
+	start := time.Now()
+	if err := Decompress(frame); err != nil {
+		return err
+	}
+	if c.Stats != nil {
+		c.Stats.decompressed.add(len(frame.Body), wire, time.Since(start))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+type echoMessage struct {
+	*helloim_proto.EchoRequest
+}
+
+func (m echoMessage) CmdId() int32 { return int32(helloim_proto.CmdId_CMD_ID_ECHO) }
+
+func newEcho(size int) echoMessage {
+	return echoMessage{&helloim_proto.EchoRequest{Msg: strings.Repeat("hello im ", size/9+1)[:size]}}
+}
+
+func TestCodec_Compress(t *testing.T) {
+	for _, c := range []Compression{CompressionSnappy, CompressionZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			stats := &CompressionStats{}
+			codec := &Codec{Version: Version2, Compression: c, Threshold: 64, Stats: stats}
+			msg := newEcho(4096)
+			frame, err := codec.EncodeMessageToFrame(1, REQ, msg)
+			assert.Nil(t, err)
+			assert.Equal(t, c, FrameCompression(frame.Header))
+			assert.Less(t, len(frame.Body), 4096)
+
+			// 经过编码和解码后, 消息体与压缩前相同
+			parsed, err := ParseFrame(ToBytes(frame))
+			assert.Nil(t, err)
+			assert.Nil(t, codec.Decompress(parsed))
+			assert.Equal(t, CompressionNone, FrameCompression(parsed.Header))
+			assert.Equal(t, int32(len(parsed.Body)), parsed.Header.BodyLength)
+			decoded := &helloim_proto.EchoRequest{}
+			assert.Nil(t, proto.Unmarshal(parsed.Body, decoded))
+			assert.Equal(t, msg.GetMsg(), decoded.GetMsg())
+
+			compressed, decompressed := stats.Snapshot()
+			assert.Equal(t, int64(1), compressed.Frames)
+			assert.Equal(t, int64(1), decompressed.Frames)
+			assert.Equal(t, compressed.RawBytes, decompressed.RawBytes)
+			assert.Greater(t, compressed.Ratio(), 1.0)
+		})
+	}
+}
+
+func TestCodec_NotCompressed(t *testing.T) {
+	// 小于阈值
+	codec := &Codec{Version: Version2, Compression: CompressionZstd, Threshold: 1024}
+	frame, err := codec.EncodeMessageToFrame(1, REQ, newEcho(100))
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionNone, FrameCompression(frame.Header))
+
+	// v1 消息头不能标记压缩
+	codec = &Codec{Version: Version1, Compression: CompressionZstd}
+	frame, err = codec.EncodeMessageToFrame(1, REQ, newEcho(4096))
+	assert.Nil(t, err)
+	assert.Equal(t, Version1, frame.Header.Version)
+	assert.Equal(t, CompressionNone, FrameCompression(frame.Header))
+
+	// 零值的 Codec 使用 v1
+	frame, err = (&Codec{}).EncodeMessageToFrame(1, REQ, newEcho(10))
+	assert.Nil(t, err)
+	assert.Equal(t, Version1, frame.Header.Version)
+}
+
+func TestDecodeMessage_Decompress(t *testing.T) {
+	cmdId := int32(helloim_proto.CmdId_CMD_ID_ECHO)
+	old := decoders[cmdId]
+	defer func() { decoders[cmdId] = old }()
+	RegisterDecoder(cmdId, func(frame *Frame) (Message, error) {
+		msg := &helloim_proto.EchoRequest{}
+		if err := proto.Unmarshal(frame.Body, msg); err != nil {
+			return nil, err
+		}
+		return echoMessage{msg}, nil
+	})
+
+	codec := &Codec{Version: Version2, Compression: CompressionSnappy}
+	frame, err := codec.EncodeMessageToFrame(1, REQ, newEcho(2048))
+	assert.Nil(t, err)
+	msg, err := DecodeMessage(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, 2048, len(msg.(echoMessage).GetMsg()))
+
+	// 损坏的压缩数据
+	frame, err = codec.EncodeMessageToFrame(1, REQ, newEcho(2048))
+	assert.Nil(t, err)
+	frame.Body = frame.Body[:len(frame.Body)/2]
+	_, err = DecodeMessage(frame)
+	var protocolErr *ProtocolError
+	assert.True(t, errors.As(err, &protocolErr))
+
+	// 未知的压缩算法
+	frame, err = codec.EncodeMessageToFrame(1, REQ, newEcho(2048))
+	assert.Nil(t, err)
+	frame.Header.Flags = frame.Header.Flags&^FlagCompressionMask | 0x0F
+	_, err = DecodeMessage(frame)
+	assert.True(t, errors.Is(err, ErrUnsupportedCompression))
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		parsed, err := ParseCompression(c.String())
+		assert.Nil(t, err)
+		assert.Equal(t, c, parsed)
+	}
+	_, err := ParseCompression("gzip")
+	assert.True(t, errors.Is(err, ErrUnsupportedCompression))
+}
@@ -0,0 +1,28 @@
+package contact
+
+import (
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+// FriendNotify 好友申请通知, 只有下行
+type FriendNotify struct {
+	*helloim_proto.FriendNotifyRequest
+	msgSeq int32
+}
+
+func (m *FriendNotify) CmdId() int32  { return int32(helloim_proto.CmdId_CMD_ID_FRIEND_NOTIFY) }
+func (m *FriendNotify) MsgSeq() int32 { return m.msgSeq }
+
+func decodeFriendNotify(frame *protocol.Frame) (protocol.Message, error) {
+	req := &helloim_proto.FriendNotifyRequest{}
+	if err := proto.Unmarshal(frame.Body, req); err != nil {
+		return nil, err
+	}
+	return &FriendNotify{FriendNotifyRequest: req, msgSeq: frame.Header.Seq}, nil
+}
+
+func init() {
+	protocol.RegisterDecoder(int32(helloim_proto.CmdId_CMD_ID_FRIEND_NOTIFY), decodeFriendNotify)
+}
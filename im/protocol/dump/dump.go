@@ -0,0 +1,350 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Types 一个 cmdId 的请求和响应类型, 未知时为 nil
+type Types struct {
+	Request  protoreflect.MessageType
+	Response protoreflect.MessageType
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[int32]Types)
+)
+
+// 命名不符合 CMD_ID_XXX -> XxxRequest/XxxResponse 约定的命令
+var aliases = map[helloim_proto.CmdId]string{
+	helloim_proto.CmdId_CMD_ID_HEARTBEAT: "Empty",
+	helloim_proto.CmdId_CMD_ID_SEND:      "SendPkt",
+	helloim_proto.CmdId_CMD_ID_PUSH:      "PushPkt",
+}
+
+func init() {
+	values := helloim_proto.CmdId(0).Descriptor().Values()
+	pkg := helloim_proto.CmdId(0).Descriptor().ParentFile().Package()
+	for i := 0; i < values.Len(); i++ {
+		value := values.Get(i)
+		name, ok := aliases[helloim_proto.CmdId(value.Number())]
+		if !ok {
+			name = typePrefix(string(value.Name()))
+		}
+		Register(int32(value.Number()),
+			findType(pkg.Append(protoreflect.Name(name+"Request"))),
+			findType(pkg.Append(protoreflect.Name(name+"Response"))))
+	}
+}
+
+// typePrefix CMD_ID_FOO_BAR -> FooBar
+func typePrefix(enumName string) string {
+	var sb strings.Builder
+	for _, part := range strings.Split(strings.TrimPrefix(enumName, "CMD_ID_"), "_") {
+		if part == "" {
+			continue
+		}
+		sb.WriteString(part[:1])
+		sb.WriteString(strings.ToLower(part[1:]))
+	}
+	return sb.String()
+}
+
+func findType(name protoreflect.FullName) protoreflect.MessageType {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(name)
+	if err != nil {
+		return nil
+	}
+	return mt
+}
+
+// Register 注册 cmdId 的请求和响应类型, 覆盖按命名约定找到的类型
+func Register(cmdId int32, request, response protoreflect.MessageType) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[cmdId] = Types{Request: request, Response: response}
+}
+
+// Lookup 查找 cmdId 的请求和响应类型
+func Lookup(cmdId int32) (Types, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	types, ok := registry[cmdId]
+	return types, ok
+}
+
+// MessageType 帧的消息体类型, REQ 帧是请求, RES 帧是响应, 未知时返回 nil
+func MessageType(header *protocol.MsgHeader) protoreflect.MessageType {
+	types, _ := Lookup(header.CmdId)
+	if header.Req == protocol.RES {
+		return types.Response
+	}
+	return types.Request
+}
+
+// Frame 把帧输出为一行 JSON, 类型未知的消息体和未知字段按字段号输出
+func Frame(frame *protocol.Frame) string {
+	data, err := json.Marshal(FrameValue(frame))
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
+
+// FrameValue 帧的 JSON 对象, 用于嵌入到其他 JSON 中输出
+func FrameValue(frame *protocol.Frame) json.Marshaler {
+	h := frame.Header
+	req := "REQ"
+	if h.Req == protocol.RES {
+		req = "RES"
+	}
+	obj := object{
+		{"req", req},
+		{"seq", h.Seq},
+		{"cmdId", h.CmdId},
+		{"cmd", helloim_proto.CmdId(h.CmdId).String()},
+		{"bodyLength", h.BodyLength},
+	}
+	if h.Version >= protocol.Version2 {
+		exts := make([]object, 0, len(h.Extensions))
+		for _, ext := range h.Extensions {
+			exts = append(exts, object{{"type", ext.Type}, {"value", base64.StdEncoding.EncodeToString(ext.Value)}})
+		}
+		obj = append(obj, field{"version", h.Version}, field{"flags", h.Flags}, field{"extensions", exts})
+	}
+	body := frame.Body
+	if c := protocol.FrameCompression(h); c != protocol.CompressionNone {
+		// 解压副本, 不修改调用方的帧
+		header := *h
+		plain := &protocol.Frame{Header: &header, Body: body}
+		if err := protocol.Decompress(plain); err != nil {
+			return append(obj, field{"compression", c.String()}, field{"error", err.Error()})
+		}
+		obj = append(obj, field{"compression", c.String()}, field{"rawLength", header.BodyLength})
+		body = plain.Body
+	}
+	mt := MessageType(h)
+	if mt == nil {
+		return append(obj, field{"body", Wire(body)})
+	}
+	obj = append(obj, field{"type", string(mt.Descriptor().FullName())})
+	m := mt.New()
+	if err := proto.Unmarshal(body, m.Interface()); err != nil {
+		return append(obj, field{"error", err.Error()}, field{"body", Wire(body)})
+	}
+	return append(obj, field{"body", MessageValue(m)})
+}
+
+// Message 把 protobuf 消息输出为一行 JSON, 包含未知字段
+func Message(m proto.Message) string {
+	data, err := json.Marshal(MessageValue(m.ProtoReflect()))
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
+
+// MessageValue protobuf 消息的 JSON 对象, 字段按字段号排序, 未知字段输出在 "_unknown" 中
+func MessageValue(m protoreflect.Message) json.Marshaler {
+	var fds []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Number() < fds[j].Number() })
+	var obj object
+	for _, fd := range fds {
+		obj = append(obj, field{fd.JSONName(), fieldValue(fd, m.Get(fd))})
+	}
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		obj = append(obj, field{"_unknown", Wire(unknown)})
+	}
+	if obj == nil {
+		obj = object{}
+	}
+	return obj
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]any, list.Len())
+		for i := range values {
+			values[i] = singularValue(fd, list.Get(i))
+		}
+		return values
+	case fd.IsMap():
+		var obj object
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			obj = append(obj, field{k.String(), singularValue(fd.MapValue(), v)})
+			return true
+		})
+		sort.Slice(obj, func(i, j int) bool { return obj[i].key < obj[j].key })
+		return obj
+	}
+	return singularValue(fd, v)
+}
+
+func singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return MessageValue(v.Message())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int32(v.Enum())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	}
+	return v.Interface()
+}
+
+// Wire 不依赖类型, 按字段号输出 wire format 编码的消息
+func Wire(b []byte) json.Marshaler {
+	return wireValue(b, 0)
+}
+
+// maxWireDepth 按字段号解码时, 嵌套消息的最大层数
+const maxWireDepth = 16
+
+// wireValue 不依赖类型按 wire format 解码: 变长整数和定长整数输出数字,
+// 长度前缀的字段能完整解析成消息时按消息输出, 否则按 UTF-8 字符串或 base64 输出
+func wireValue(b []byte, depth int) json.Marshaler {
+	var obj object
+	index := make(map[string]int)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return append(obj, field{"_error", protowire.ParseError(n).Error()})
+		}
+		b = b[n:]
+		var value any
+		switch typ {
+		case protowire.VarintType:
+			v, n2 := protowire.ConsumeVarint(b)
+			value, n = v, n2
+		case protowire.Fixed32Type:
+			v, n2 := protowire.ConsumeFixed32(b)
+			value, n = v, n2
+		case protowire.Fixed64Type:
+			v, n2 := protowire.ConsumeFixed64(b)
+			value, n = v, n2
+		case protowire.BytesType:
+			v, n2 := protowire.ConsumeBytes(b)
+			value, n = bytesValue(v, depth), n2
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			value = fmt.Sprintf("<wire type %d>", typ)
+		}
+		if n < 0 {
+			return append(obj, field{"_error", protowire.ParseError(n).Error()})
+		}
+		b = b[n:]
+		key := strconv.Itoa(int(num))
+		// 重复出现的字段合并成数组
+		if i, ok := index[key]; ok {
+			if values, ok := obj[i].value.([]any); ok {
+				obj[i].value = append(values, value)
+			} else {
+				obj[i].value = []any{obj[i].value, value}
+			}
+			continue
+		}
+		index[key] = len(obj)
+		obj = append(obj, field{key, value})
+	}
+	if obj == nil {
+		obj = object{}
+	}
+	return obj
+}
+
+// bytesValue 可打印的文本优先按字符串输出, 嵌套消息的 tag 和长度通常包含控制字符
+func bytesValue(b []byte, depth int) any {
+	if printable(b) {
+		return string(b)
+	}
+	if depth < maxWireDepth && validMessage(b) {
+		return wireValue(b, depth+1)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && r != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// validMessage b 能否完整地按 wire format 解析, 字段号为 0 或者剩余字节不足都认为不是消息
+func validMessage(b []byte) bool {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || num == 0 {
+			return false
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
+
+// field JSON 对象的一个字段
+type field struct {
+	key   string
+	value any
+}
+
+// object 保持字段顺序的 JSON 对象
+type object []field
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
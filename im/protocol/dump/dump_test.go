@@ -0,0 +1,83 @@
+package dump
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func frameOf(req byte, cmdId int32, body []byte) *protocol.Frame {
+	return &protocol.Frame{
+		Header: &protocol.MsgHeader{HeaderLength: protocol.DefaultHeaderSize, Req: req, Seq: 7, CmdId: cmdId, BodyLength: int32(len(body))},
+		Body:   body,
+	}
+}
+
+func TestLookup(t *testing.T) {
+	types, ok := Lookup(int32(helloim_proto.CmdId_CMD_ID_AUTH))
+	assert.True(t, ok)
+	assert.Equal(t, "helloim.protocol.AuthRequest", string(types.Request.Descriptor().FullName()))
+	assert.Equal(t, "helloim.protocol.AuthResponse", string(types.Response.Descriptor().FullName()))
+
+	types, _ = Lookup(int32(helloim_proto.CmdId_CMD_ID_PUSH))
+	assert.Equal(t, "helloim.protocol.PushPktRequest", string(types.Request.Descriptor().FullName()))
+	types, _ = Lookup(int32(helloim_proto.CmdId_CMD_ID_HEARTBEAT))
+	assert.Equal(t, "helloim.protocol.EmptyResponse", string(types.Response.Descriptor().FullName()))
+}
+
+func TestFrame(t *testing.T) {
+	body, _ := proto.Marshal(&helloim_proto.SendPktRequest{
+		From:     "1",
+		ChatId:   "2",
+		ChatType: 1,
+		Payload:  &helloim_proto.Payload{Content: &helloim_proto.Payload_Text{Text: &helloim_proto.TextPayload{Content: "hi"}}},
+	})
+	// 服务端新增的字段
+	body = protowire.AppendTag(body, 99, protowire.VarintType)
+	body = protowire.AppendVarint(body, 5)
+	got := Frame(frameOf(protocol.REQ, int32(helloim_proto.CmdId_CMD_ID_SEND), body))
+	assert.Equal(t, `{"req":"REQ","seq":7,"cmdId":1010,"cmd":"CMD_ID_SEND","bodyLength":19,`+
+		`"type":"helloim.protocol.SendPktRequest","body":{"from":"1","chatId":"2","chatType":1,`+
+		`"payload":{"text":{"content":"hi"}},"_unknown":{"99":5}}}`, got)
+}
+
+func TestFrame_UnknownCmdId(t *testing.T) {
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.BytesType)
+	body = protowire.AppendString(body, "hello")
+	nested := protowire.AppendTag(nil, 1, protowire.VarintType)
+	nested = protowire.AppendVarint(nested, 300)
+	body = protowire.AppendTag(body, 2, protowire.BytesType)
+	body = protowire.AppendBytes(body, nested)
+	body = protowire.AppendTag(body, 3, protowire.VarintType)
+	body = protowire.AppendVarint(body, 1)
+	body = protowire.AppendTag(body, 3, protowire.VarintType)
+	body = protowire.AppendVarint(body, 2)
+	got := Frame(frameOf(protocol.REQ, 2000, body))
+	assert.Equal(t, `{"req":"REQ","seq":7,"cmdId":2000,"cmd":"2000","bodyLength":16,`+
+		`"body":{"1":"hello","2":{"1":300},"3":[1,2]}}`, got)
+}
+
+type echoMessage struct {
+	*helloim_proto.EchoRequest
+}
+
+func (m echoMessage) CmdId() int32 { return int32(helloim_proto.CmdId_CMD_ID_ECHO) }
+
+func TestFrame_Compressed(t *testing.T) {
+	codec := &protocol.Codec{Version: protocol.Version2, Compression: protocol.CompressionZstd}
+	frame, err := codec.EncodeMessageToFrame(7, protocol.REQ, echoMessage{&helloim_proto.EchoRequest{Msg: strings.Repeat("a", 2000)}})
+	assert.Nil(t, err)
+	bodyLength := frame.Header.BodyLength
+	out := Frame(frame)
+	assert.Contains(t, out, `"compression":"zstd","rawLength":2003`)
+	assert.Contains(t, out, `"type":"helloim.protocol.EchoRequest","body":{"msg":"aaa`)
+	// 不修改原来的帧
+	assert.Equal(t, protocol.CompressionZstd, protocol.FrameCompression(frame.Header))
+	assert.Equal(t, bodyLength, frame.Header.BodyLength)
+}
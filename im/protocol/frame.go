@@ -2,24 +2,146 @@ package protocol
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 )
 
-// DefaultHeaderSize 固定消息头的长度
+// DefaultHeaderSize 固定消息头的长度, 也是 v1 消息头的长度
 var DefaultHeaderSize byte = 14
 
+// 消息头的版本. v1 是 14 字节的固定消息头;
+// v2 在 v1 之后追加版本号(1)、flags(2) 和 TLV 扩展, HeaderLength 为整个消息头的长度.
+// 旧的服务端只认识 v1, 由认证时协商决定是否使用 v2
+const (
+	Version1 byte = 1
+	Version2 byte = 2
+
+	// CurrentVersion 客户端支持的最高版本
+	CurrentVersion = Version2
+)
+
+const (
+	// HeaderSizeV2 不带扩展的 v2 消息头长度
+	HeaderSizeV2 = 17
+	// MaxHeaderSize HeaderLength 只有一个字节
+	MaxHeaderSize = 255
+	// extensionHeaderSize 每个扩展的 type(1) 和 length(1)
+	extensionHeaderSize = 2
+)
+
+// DefaultMaxFrameSize 默认的帧长度上限, 包含消息头
+const DefaultMaxFrameSize = 4 << 20
+
+var (
+	ErrInvalidHeaderLength = errors.New("invalid header length")
+	ErrInvalidBodyLength   = errors.New("invalid body length")
+	ErrFrameTooLarge       = errors.New("frame too large")
+	ErrUnsupportedVersion  = errors.New("unsupported header version")
+	ErrInvalidExtension    = errors.New("invalid header extension")
+	ErrHeaderTooLarge      = errors.New("header too large")
+)
+
+// ProtocolError 收到不合法的帧, 之后的数据无法再按帧解析, 需要断开连接
+type ProtocolError struct {
+	Err    error
+	Header *MsgHeader
+}
+
+func (e *ProtocolError) Error() string {
+	h := e.Header
+	return fmt.Sprintf("protocol error: %v (headerLength: %d, version: %d, req: %d, seq: %d, cmdId: %d, bodyLength: %d)",
+		e.Err, h.HeaderLength, h.Version, h.Req, h.Seq, h.CmdId, h.BodyLength)
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateHeaderLength 校验消息头第一个字节的 HeaderLength, 只有 v1 的长度或者 v2 的长度范围合法
+func ValidateHeaderLength(headerLength byte) error {
+	if headerLength == DefaultHeaderSize || headerLength >= HeaderSizeV2 {
+		return nil
+	}
+	return &ProtocolError{Err: ErrInvalidHeaderLength, Header: &MsgHeader{HeaderLength: headerLength}}
+}
+
+// ValidateHeader 校验消息头的长度字段, 帧的总长度不能超过 maxFrameSize
+func ValidateHeader(h *MsgHeader, maxFrameSize int) error {
+	if ValidateHeaderLength(h.HeaderLength) != nil {
+		return &ProtocolError{Err: ErrInvalidHeaderLength, Header: h}
+	}
+	if h.BodyLength < 0 {
+		return &ProtocolError{Err: ErrInvalidBodyLength, Header: h}
+	}
+	if int64(h.BodyLength)+int64(h.HeaderLength) > int64(maxFrameSize) {
+		return &ProtocolError{Err: ErrFrameTooLarge, Header: h}
+	}
+	return nil
+}
+
 const (
 	REQ = iota
 	RES
 )
 
-// MsgHeader 固定消息头
+// Extension v2 消息头的 TLV 扩展, 不认识的扩展原样保留
+type Extension struct {
+	Type  byte
+	Value []byte
+}
+
+// MsgHeader 消息头, Version 为 0 或者 Version1 时按 v1 编码, 忽略 Flags 和 Extensions
 type MsgHeader struct {
 	HeaderLength byte
 	Req          byte
 	Seq          int32
 	CmdId        int32
 	BodyLength   int32
+
+	Version    byte
+	Flags      uint16
+	Extensions []Extension
+}
+
+// Size 编码后的消息头长度
+func (h *MsgHeader) Size() int {
+	if h.Version < Version2 {
+		return int(DefaultHeaderSize)
+	}
+	size := HeaderSizeV2
+	for _, ext := range h.Extensions {
+		size += extensionHeaderSize + len(ext.Value)
+	}
+	return size
+}
+
+// Extension 查找 typ 类型的扩展
+func (h *MsgHeader) Extension(typ byte) ([]byte, bool) {
+	for _, ext := range h.Extensions {
+		if ext.Type == typ {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetExtension 设置 typ 类型的扩展, 消息头超过 MaxHeaderSize 时返回错误
+func (h *MsgHeader) SetExtension(typ byte, value []byte) error {
+	exts := make([]Extension, 0, len(h.Extensions)+1)
+	for _, ext := range h.Extensions {
+		if ext.Type != typ {
+			exts = append(exts, ext)
+		}
+	}
+	exts = append(exts, Extension{Type: typ, Value: value})
+	old := h.Extensions
+	h.Extensions = exts
+	if h.Size() > MaxHeaderSize {
+		h.Extensions = old
+		return ErrHeaderTooLarge
+	}
+	return nil
 }
 
 // Frame 数据帧，最终转换为bytes数组发送到IM服务端
@@ -33,24 +155,110 @@ func (f *Frame) Key() string {
 	return fmt.Sprintf("%d_%d", h.Seq, h.CmdId)
 }
 
-// EncodeHeader 编码固定消息头
+// EncodeHeader 编码消息头, 第一个字节是实际编码的消息头长度
 func EncodeHeader(h *MsgHeader) []byte {
-	buf := make([]byte, DefaultHeaderSize)
-	buf[0] = DefaultHeaderSize // 固定消息头的大小
-	buf[1] = h.Req             // req or res
+	size := h.Size()
+	buf := make([]byte, DefaultHeaderSize, size)
+	buf[0] = byte(size) // 消息头的大小
+	buf[1] = h.Req      // req or res
 	binary.BigEndian.PutUint32(buf[2:6], uint32(h.Seq))
 	binary.BigEndian.PutUint32(buf[6:10], uint32(h.CmdId))
 	binary.BigEndian.PutUint32(buf[10:14], uint32(h.BodyLength))
+	if h.Version < Version2 {
+		return buf
+	}
+	buf = append(buf, h.Version)
+	buf = binary.BigEndian.AppendUint16(buf, h.Flags)
+	for _, ext := range h.Extensions {
+		buf = append(buf, ext.Type, byte(len(ext.Value)))
+		buf = append(buf, ext.Value...)
+	}
 	return buf
 }
 
-// DecodeHeader 解码固定消息头
-func DecodeHeader(data []byte) *MsgHeader {
-	return &MsgHeader{
+// DecodeHeader 解码消息头, data 至少包含 HeaderLength 个字节.
+// 调用前需要用 ValidateHeaderLength 校验 data[0], v2 的版本或者扩展不合法时返回 *ProtocolError
+func DecodeHeader(data []byte) (*MsgHeader, error) {
+	h := &MsgHeader{
 		HeaderLength: data[0],
 		Req:          data[1],
 		Seq:          int32(binary.BigEndian.Uint32(data[2:6])),
 		CmdId:        int32(binary.BigEndian.Uint32(data[6:10])),
 		BodyLength:   int32(binary.BigEndian.Uint32(data[10:14])),
+		Version:      Version1,
+	}
+	if h.HeaderLength == DefaultHeaderSize {
+		return h, nil
+	}
+	if int(h.HeaderLength) < HeaderSizeV2 || len(data) < int(h.HeaderLength) {
+		return nil, &ProtocolError{Err: ErrInvalidHeaderLength, Header: h}
+	}
+	// 更高的版本也以 v2 的布局开头, 但是语义未知, 不能继续解析
+	h.Version = data[14]
+	if h.Version != Version2 {
+		return nil, &ProtocolError{Err: ErrUnsupportedVersion, Header: h}
+	}
+	h.Flags = binary.BigEndian.Uint16(data[15:17])
+	rest := data[HeaderSizeV2:h.HeaderLength]
+	for len(rest) > 0 {
+		if len(rest) < extensionHeaderSize || len(rest) < extensionHeaderSize+int(rest[1]) {
+			return nil, &ProtocolError{Err: ErrInvalidExtension, Header: h}
+		}
+		end := extensionHeaderSize + int(rest[1])
+		h.Extensions = append(h.Extensions, Extension{
+			Type:  rest[0],
+			Value: append([]byte(nil), rest[extensionHeaderSize:end]...),
+		})
+		rest = rest[end:]
+	}
+	return h, nil
+}
+
+// ReadFrame 从 r 读取一个完整帧, 帧不合法时返回 *ProtocolError
+func ReadFrame(r io.Reader, maxFrameSize int) (*Frame, error) {
+	buf := make([]byte, DefaultHeaderSize, MaxHeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if err := ValidateHeaderLength(buf[0]); err != nil {
+		return nil, err
+	}
+	buf = buf[:buf[0]]
+	if _, err := io.ReadFull(r, buf[DefaultHeaderSize:]); err != nil {
+		return nil, err
+	}
+	header, err := DecodeHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateHeader(header, maxFrameSize); err != nil {
+		return nil, err
+	}
+	body := make([]byte, header.BodyLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &Frame{Header: header, Body: body}, nil
+}
+
+// ParseFrame 解析一个完整帧的字节
+func ParseFrame(data []byte) (*Frame, error) {
+	if len(data) < int(DefaultHeaderSize) {
+		return nil, fmt.Errorf("frame too short: %d", len(data))
+	}
+	if err := ValidateHeaderLength(data[0]); err != nil {
+		return nil, err
+	}
+	if len(data) < int(data[0]) {
+		return nil, fmt.Errorf("frame too short: %d, header length: %d", len(data), data[0])
+	}
+	header, err := DecodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	size := len(data) - int(header.HeaderLength)
+	if int(header.BodyLength) != size {
+		return nil, fmt.Errorf("body length mismatch: header %d, actual %d", header.BodyLength, size)
 	}
+	return &Frame{Header: header, Body: data[header.HeaderLength:]}, nil
 }
@@ -0,0 +1,158 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader_V1(t *testing.T) {
+	h := &MsgHeader{Req: RES, Seq: 3, CmdId: 1010, BodyLength: 12, Flags: 1, Extensions: []Extension{{Type: 1}}}
+	data := EncodeHeader(h)
+	assert.Equal(t, []byte{14, 1, 0, 0, 0, 3, 0, 0, 3, 242, 0, 0, 0, 12}, data)
+
+	decoded, err := DecodeHeader(data)
+	assert.Nil(t, err)
+	// v1 不编码 flags 和扩展
+	assert.Equal(t, &MsgHeader{HeaderLength: 14, Req: RES, Seq: 3, CmdId: 1010, BodyLength: 12, Version: Version1}, decoded)
+}
+
+func TestHeader_V2(t *testing.T) {
+	h := &MsgHeader{Req: REQ, Seq: 7, CmdId: 1011, BodyLength: 5, Version: Version2, Flags: 0x8001}
+	assert.Nil(t, h.SetExtension(1, []byte("abc")))
+	assert.Nil(t, h.SetExtension(9, nil))
+	data := EncodeHeader(h)
+	assert.Equal(t, []byte{
+		24, 0, 0, 0, 0, 7, 0, 0, 3, 243, 0, 0, 0, 5,
+		2, 0x80, 0x01,
+		1, 3, 'a', 'b', 'c',
+		9, 0,
+	}, data)
+	assert.Equal(t, len(data), h.Size())
+
+	decoded, err := DecodeHeader(data)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(24), decoded.HeaderLength)
+	assert.Equal(t, Version2, decoded.Version)
+	assert.Equal(t, uint16(0x8001), decoded.Flags)
+	value, ok := decoded.Extension(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("abc"), value)
+	_, ok = decoded.Extension(2)
+	assert.False(t, ok)
+	assert.Equal(t, data, EncodeHeader(decoded))
+
+	// 替换已有的扩展
+	assert.Nil(t, h.SetExtension(1, []byte("x")))
+	assert.Len(t, h.Extensions, 2)
+	value, _ = h.Extension(1)
+	assert.Equal(t, []byte("x"), value)
+	assert.Equal(t, ErrHeaderTooLarge, h.SetExtension(2, make([]byte, 240)))
+	assert.Len(t, h.Extensions, 2)
+}
+
+func TestDecodeHeader_Invalid(t *testing.T) {
+	v2 := EncodeHeader(&MsgHeader{Version: Version2, Extensions: []Extension{{Type: 1, Value: []byte("ab")}}})
+	unsupported := append([]byte(nil), v2...)
+	unsupported[14] = 3
+	truncated := append([]byte(nil), v2...)
+	truncated[0]--
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"unsupported version", unsupported, ErrUnsupportedVersion},
+		{"truncated extension", truncated[:truncated[0]], ErrInvalidExtension},
+		{"short header", append([]byte{15}, v2[1:15]...), ErrInvalidHeaderLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeHeader(tt.data)
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+		})
+	}
+	assert.NotNil(t, ValidateHeaderLength(15))
+	assert.Nil(t, ValidateHeaderLength(14))
+	assert.Nil(t, ValidateHeaderLength(17))
+}
+
+func TestReadFrame_BothVersions(t *testing.T) {
+	v1 := ToBytes(&Frame{Header: &MsgHeader{Req: REQ, Seq: 1, CmdId: 1, BodyLength: 2}, Body: []byte("v1")})
+	h := &MsgHeader{Req: RES, Seq: 2, CmdId: 2, BodyLength: 2, Version: Version2}
+	h.SetExtension(5, []byte{1, 2, 3})
+	v2 := ToBytes(&Frame{Header: h, Body: []byte("v2")})
+
+	r := bytes.NewReader(append(append([]byte(nil), v1...), v2...))
+	frame, err := ReadFrame(r, DefaultMaxFrameSize)
+	assert.Nil(t, err)
+	assert.Equal(t, Version1, frame.Header.Version)
+	assert.Equal(t, []byte("v1"), frame.Body)
+	frame, err = ReadFrame(r, DefaultMaxFrameSize)
+	assert.Nil(t, err)
+	assert.Equal(t, Version2, frame.Header.Version)
+	assert.Equal(t, []Extension{{Type: 5, Value: []byte{1, 2, 3}}}, frame.Header.Extensions)
+	assert.Equal(t, []byte("v2"), frame.Body)
+
+	parsed, err := ParseFrame(v2)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, parsed)
+
+	// 推送的 ACK 使用与推送相同的版本
+	ack, err := ParseFrame(MakeResFrame(frame))
+	assert.Nil(t, err)
+	assert.Equal(t, Version2, ack.Header.Version)
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	f.Add(EncodeHeader(&MsgHeader{Req: REQ, Seq: 1, CmdId: 1010, BodyLength: 10}))
+	f.Add(EncodeHeader(&MsgHeader{Req: RES, Seq: -1, CmdId: 2, BodyLength: -1}))
+	f.Add(EncodeHeader(&MsgHeader{Version: Version2, Extensions: []Extension{{Type: 1, Value: []byte("ab")}}}))
+	f.Add([]byte{14, 0, 0, 0, 0, 1, 0, 0, 3, 242, 127, 255, 255, 255})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < int(DefaultHeaderSize) || ValidateHeaderLength(data[0]) != nil || len(data) < int(data[0]) {
+			return
+		}
+		var protoErr *ProtocolError
+		h, err := DecodeHeader(data)
+		if err != nil {
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("unexpected error type: %T", err)
+			}
+			return
+		}
+		if !bytes.Equal(EncodeHeader(h), data[:h.HeaderLength]) {
+			t.Fatalf("header round trip mismatch: %+v", h)
+		}
+		if err = ValidateHeader(h, DefaultMaxFrameSize); err != nil {
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("unexpected error type: %T", err)
+			}
+			return
+		}
+		if h.BodyLength < 0 || int(h.BodyLength)+int(h.HeaderLength) > DefaultMaxFrameSize {
+			t.Fatalf("invalid header passed validation: %+v", h)
+		}
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	valid := ToBytes(&Frame{Header: &MsgHeader{Req: REQ, Seq: 1, CmdId: 1, BodyLength: 3}, Body: []byte("abc")})
+	f.Add(valid)
+	f.Add(valid[:10])
+	f.Add(append(valid, valid...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			frame, err := ReadFrame(r, 1024)
+			if err != nil {
+				return
+			}
+			if int(frame.Header.BodyLength) != len(frame.Body) || len(frame.Body)+int(frame.Header.HeaderLength) > 1024 {
+				t.Fatalf("invalid frame: %+v, body: %d", frame.Header, len(frame.Body))
+			}
+		}
+	})
+}
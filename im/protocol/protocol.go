@@ -25,27 +25,23 @@ func DecodeMessage(frame *Frame) (Message, error) {
 	if decode == nil {
 		return nil, fmt.Errorf("unsupported cmdId: %d", frame.Header.CmdId)
 	}
+	// 已经解压过的帧 flags 中不再有压缩算法, 不会重复解压
+	if err := Decompress(frame); err != nil {
+		return nil, err
+	}
 	return decode(frame)
 }
 
+// EncodeMessageToBytes 使用 v1 消息头编码
 func EncodeMessageToBytes(seq int32, req byte, message Message) ([]byte, error) {
-	body, err := proto.Marshal(message)
+	frame, err := EncodeMessageToFrame(seq, req, message)
 	if err != nil {
 		return nil, err
 	}
-	h := &MsgHeader{
-		HeaderLength: DefaultHeaderSize,
-		Req:          req,
-		Seq:          seq,
-		CmdId:        message.CmdId(),
-		BodyLength:   int32(len(body)),
-	}
-	bytes := make([]byte, int(DefaultHeaderSize)+len(body))
-	copy(bytes, EncodeHeader(h))
-	copy(bytes[DefaultHeaderSize:], body)
-	return bytes, nil
+	return ToBytes(frame), nil
 }
 
+// EncodeMessageToFrame 使用 v1 消息头构造帧, 需要 v2 时修改 Header.Version
 func EncodeMessageToFrame(seq int32, req byte, message Message) (*Frame, error) {
 	body, err := proto.Marshal(message)
 	if err != nil {
@@ -57,10 +53,12 @@ func EncodeMessageToFrame(seq int32, req byte, message Message) (*Frame, error)
 		Seq:          seq,
 		CmdId:        message.CmdId(),
 		BodyLength:   int32(len(body)),
+		Version:      Version1,
 	}
 	return &Frame{Header: h, Body: body}, nil
 }
 
+// MakeResFrame 构造空消息体的响应, 消息头的版本与请求相同
 func MakeResFrame(frame *Frame) []byte {
 	h := frame.Header
 	header := &MsgHeader{
@@ -69,16 +67,15 @@ func MakeResFrame(frame *Frame) []byte {
 		Seq:          h.Seq,
 		CmdId:        h.CmdId,
 		BodyLength:   0,
+		Version:      h.Version,
 	}
-	bytes := make([]byte, int(DefaultHeaderSize))
-	copy(bytes, EncodeHeader(header))
-	return bytes
+	return EncodeHeader(header)
 }
 
 func ToBytes(frame *Frame) []byte {
-	h, body := frame.Header, frame.Body
-	bytes := make([]byte, int(DefaultHeaderSize)+len(body))
-	copy(bytes, EncodeHeader(h))
-	copy(bytes[DefaultHeaderSize:], body)
+	header := EncodeHeader(frame.Header)
+	bytes := make([]byte, len(header)+len(frame.Body))
+	copy(bytes, header)
+	copy(bytes[len(header):], frame.Body)
 	return bytes
 }
@@ -47,9 +47,18 @@ func UpdateChatsFromRemote() {
 		logger.Errorf("GetAllChat error: %v", err)
 		return
 	}
-	if err2 := sqllite.BatchUpdate(context.Background(), chats); err2 != nil {
+	ctx := context.Background()
+	if err2 := sqllite.BatchUpdate(ctx, chats); err2 != nil {
 		logger.Errorf("updateChat error: %v", err)
 	}
+	// 在其他设备上删除的会话, 清空本地的历史消息
+	for _, chat := range chats {
+		if chat.ChatDel {
+			if err := sqllite.DeleteMessagesBefore(ctx, chat.ChatId, chat.ChatType, chat.DelTimestamp); err != nil {
+				logger.Errorf("DeleteMessagesBefore chatId: %d, error: %v", chat.ChatId, err)
+			}
+		}
+	}
 }
 
 func UpdateChatVersion(chatId int64, chatType int32) {
@@ -64,9 +73,18 @@ func UpdateChatVersion(chatId int64, chatType int32) {
 		logger.Infof("UpdateChatVersion.LastMessage error: %v", err)
 		return
 	}
+	changed := false
 	if lastMsg.SendTime > chat.UpdateTimestamp {
 		chat.UpdateTimestamp = lastMsg.SendTime
 		chat.LastReadMsgId = lastMsg.MsgID
+		changed = true
+	}
+	// 删除的会话收到新消息后重新显示
+	if chat.ChatDel && lastMsg.SendTime > chat.DelTimestamp {
+		chat.ChatDel = false
+		changed = true
+	}
+	if changed {
 		err = sqllite.BatchUpdate(ctx, append([]*sqllite.ImChat{}, chat))
 		if err != nil {
 			logger.Errorf("UpdateChatVersion.BatchUpdate error: %v", err)
@@ -74,3 +92,61 @@ func UpdateChatVersion(chatId int64, chatType int32) {
 		logger.Infof("UpdateChatVersion success: %v", chat)
 	}
 }
+
+// GetArchivedChat 已归档的会话
+func GetArchivedChat(ctx context.Context) ([]*sqllite.ImChat, error) {
+	return sqllite.MultiGetArchivedChat(ctx)
+}
+
+// SetChatTop 置顶或取消置顶会话, 服务端保存成功后修改本地的会话
+func SetChatTop(ctx context.Context, chatId int64, chatType int32, top bool) error {
+	if err := http.SetChatTop(ctx, conf.UserId, chatId, chatType, top); err != nil {
+		return err
+	}
+	return updateChat(ctx, chatId, chatType, func(chat *sqllite.ImChat) { chat.ChatTop = top })
+}
+
+// SetChatMute 开启或关闭会话的免打扰
+func SetChatMute(ctx context.Context, chatId int64, chatType int32, mute bool) error {
+	if err := http.SetChatMute(ctx, conf.UserId, chatId, chatType, mute); err != nil {
+		return err
+	}
+	return updateChat(ctx, chatId, chatType, func(chat *sqllite.ImChat) { chat.ChatMute = mute })
+}
+
+// SetChatArchive 归档或取消归档会话
+func SetChatArchive(ctx context.Context, chatId int64, chatType int32, archive bool) error {
+	if err := http.SetChatArchive(ctx, conf.UserId, chatId, chatType, archive); err != nil {
+		return err
+	}
+	return updateChat(ctx, chatId, chatType, func(chat *sqllite.ImChat) { chat.ChatArchive = archive })
+}
+
+// DeleteChat 删除会话并清空本地的历史消息, 收到新消息后会话重新显示.
+// Note: 使用最后一条消息的发送时间作为删除时间, 发送时间由服务端生成, 不受本地时钟影响
+func DeleteChat(ctx context.Context, chatId int64, chatType int32) error {
+	var delTimestamp int64
+	if lastMsg, err := LastMessage(ctx, chatId, chatType); err == nil && lastMsg != nil {
+		delTimestamp = lastMsg.SendTime
+	}
+	if err := http.DeleteChat(ctx, conf.UserId, chatId, chatType, delTimestamp); err != nil {
+		return err
+	}
+	err := updateChat(ctx, chatId, chatType, func(chat *sqllite.ImChat) {
+		chat.ChatDel = true
+		chat.DelTimestamp = delTimestamp
+	})
+	if err != nil {
+		return err
+	}
+	return sqllite.DeleteMessagesBefore(ctx, chatId, chatType, delTimestamp)
+}
+
+func updateChat(ctx context.Context, chatId int64, chatType int32, update func(chat *sqllite.ImChat)) error {
+	chat, err := GetOrCreateChat(ctx, chatId, chatType)
+	if err != nil {
+		return err
+	}
+	update(chat)
+	return sqllite.SaveChat(ctx, chat)
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+)
+
+func TestDeleteChat_ServerTime(t *testing.T) {
+	server := startMsgServer(t, 5)
+	ctx := context.Background()
+	msgs := remoteMessages(server)
+	for _, msg := range msgs {
+		assert.Nil(t, sqllite.SaveOrUpdateMessage(ctx, msg))
+	}
+
+	// 删除时间使用最后一条消息的发送时间, 不使用本地时钟
+	assert.Nil(t, DeleteChat(ctx, 2, 1))
+	chat, err := sqllite.SelectChat(ctx, conf.UserId, 2)
+	assert.Nil(t, err)
+	assert.True(t, chat.ChatDel)
+	assert.Equal(t, msgs[len(msgs)-1].SendTime, chat.DelTimestamp)
+	saved, err := sqllite.GetMessagesBySeq(ctx, 2, 1, 5)
+	assert.Nil(t, err)
+	assert.Empty(t, saved)
+}
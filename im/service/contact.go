@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/http"
+)
+
+// SyncContacts 从服务端同步联系人、黑名单和好友申请, 覆盖本地的联系人
+func SyncContacts(ctx context.Context) error {
+	contacts, err := http.Contacts(ctx, conf.UserId)
+	if err != nil {
+		return err
+	}
+	if err = sqllite.ReplaceContacts(ctx, contacts); err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		if contact.User != nil {
+			cache.Add(contact.User.UserID, contact.User)
+		}
+	}
+	requests, err := http.FriendRequests(ctx, conf.UserId)
+	if err != nil {
+		return err
+	}
+	return sqllite.SaveFriendRequests(ctx, requests...)
+}
+
+func SendFriendRequest(ctx context.Context, toUserId int64, message string) (*sqllite.FriendRequest, error) {
+	request, err := http.SendFriendRequest(ctx, conf.UserId, toUserId, message)
+	if err != nil {
+		return nil, err
+	}
+	if err = sqllite.SaveFriendRequests(ctx, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// AcceptFriendRequest 同意后双方成为好友, 重新同步联系人以获取对方的资料
+func AcceptFriendRequest(ctx context.Context, requestId int64) error {
+	request, err := http.AcceptFriendRequest(ctx, conf.UserId, requestId)
+	if err != nil {
+		return err
+	}
+	if err = sqllite.SaveFriendRequests(ctx, request); err != nil {
+		return err
+	}
+	return SyncContacts(ctx)
+}
+
+func RejectFriendRequest(ctx context.Context, requestId int64) error {
+	request, err := http.RejectFriendRequest(ctx, conf.UserId, requestId)
+	if err != nil {
+		return err
+	}
+	return sqllite.SaveFriendRequests(ctx, request)
+}
+
+// HandleFriendNotify 保存服务端推送的好友申请, 申请被同意时同步联系人
+func HandleFriendNotify(ctx context.Context, request *sqllite.FriendRequest) error {
+	if err := sqllite.SaveFriendRequests(ctx, request); err != nil {
+		return err
+	}
+	if request.Status == sqllite.FriendRequestAccepted {
+		return SyncContacts(ctx)
+	}
+	return nil
+}
+
+// SetBlocked 先修改服务端的黑名单, 成功后再修改本地
+func SetBlocked(ctx context.Context, contactId int64, blocked bool) error {
+	if err := http.SetBlocked(ctx, conf.UserId, contactId, blocked); err != nil {
+		return err
+	}
+	contact, err := sqllite.GetContact(ctx, contactId)
+	if err != nil {
+		return err
+	}
+	if contact == nil {
+		contact = &sqllite.Contact{ContactId: contactId}
+	}
+	contact.Blocked = blocked
+	contact.UpdateTimestamp = time.Now().UnixMilli()
+	return sqllite.SaveContact(ctx, contact)
+}
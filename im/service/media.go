@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// UploadFile 上传文件并构造文件消息体
+func UploadFile(ctx context.Context, filePath string) (*helloim_proto.FilePayload, error) {
+	fileUrl, err := uploadMedia(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return payload.NewFilePayload(filepath.Base(filePath), fileUrl), nil
+}
+
+// UploadVoice 上传本地的音频文件并构造语音消息体
+// Note: duration <= 0 时尝试从文件头中解析时长, 目前只支持 wav
+func UploadVoice(ctx context.Context, filePath string, duration int32) (*helloim_proto.VoicePayload, error) {
+	codec := voiceCodec(filePath)
+	if duration <= 0 {
+		d, err := wavDuration(filePath)
+		if err != nil {
+			logger.Warnf("UploadVoice parse duration, file: %s, error: %v", filePath, err)
+		}
+		duration = d
+	}
+	voiceUrl, err := uploadMedia(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return payload.NewVoicePayload(voiceUrl, duration, codec), nil
+}
+
+// DownloadMedia 下载媒体文件到本地的媒体目录, 已经下载过的文件直接返回
+func DownloadMedia(ctx context.Context, fileUrl string) (string, error) {
+	dir, err := mediaDir()
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum([]byte(fileUrl))
+	name := hex.EncodeToString(sum[:]) + path.Ext(fileUrl)
+	dst := filepath.Join(dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+	if err := http.DownloadFile(ctx, fileUrl, dst); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+func uploadMedia(ctx context.Context, filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", filePath)
+	}
+	return http.UploadFile(ctx, filePath)
+}
+
+func mediaDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, ".helloIm", fmt.Sprintf("%d", conf.UserId), "media")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create dir: %w", err)
+	}
+	return dir, nil
+}
+
+// voiceCodec 根据文件扩展名推断编码格式
+func voiceCodec(filePath string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	switch ext {
+	case "m4a":
+		return "aac"
+	case "ogg":
+		return "opus"
+	}
+	return ext
+}
+
+// maxWavFmtSize fmt 块长度的上限, 避免按损坏的文件头分配过大的内存
+const maxWavFmtSize = 1024
+
+// wavDuration 从 wav 文件头中解析时长(秒), 不足一秒按一秒计算
+func wavDuration(filePath string) (int32, error) {
+	if voiceCodec(filePath) != "wav" {
+		return 0, fmt.Errorf("unsupported codec: %s", voiceCodec(filePath))
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, fmt.Errorf("invalid wav header")
+	}
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, fmt.Errorf("missing data chunk")
+			}
+			return 0, err
+		}
+		id, size := string(chunk[0:4]), binary.LittleEndian.Uint32(chunk[4:8])
+		switch id {
+		case "fmt ":
+			if size < 12 || size > maxWavFmtSize {
+				return 0, fmt.Errorf("invalid fmt chunk size: %d", size)
+			}
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(f, fmtChunk); err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+			if byteRate == 0 {
+				return 0, fmt.Errorf("invalid byte rate: 0")
+			}
+		case "data":
+			if byteRate == 0 {
+				return 0, fmt.Errorf("missing fmt chunk")
+			}
+			return int32((uint64(size) + uint64(byteRate) - 1) / uint64(byteRate)), nil
+		default:
+			if _, err := f.Seek(int64(size+size%2), io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wavChunk 构造 wav 文件中的一个块
+func wavChunk(id string, data []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// fmtChunk 16 字节的 PCM fmt 块
+func fmtChunk(byteRate uint32) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint16(data[0:2], 1)
+	binary.LittleEndian.PutUint16(data[2:4], 1)
+	binary.LittleEndian.PutUint32(data[4:8], 8000)
+	binary.LittleEndian.PutUint32(data[8:12], byteRate)
+	return wavChunk("fmt ", data)
+}
+
+func wavFile(chunks ...[]byte) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	header := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)+4))...)
+	return append(append(header, "WAVE"...), body...)
+}
+
+func TestWavDuration(t *testing.T) {
+	cases := []struct {
+		name     string
+		file     string
+		data     []byte
+		duration int32
+		wantErr  bool
+	}{
+		{"exact seconds", "a.wav", wavFile(fmtChunk(16000), wavChunk("data", make([]byte, 32000))), 2, false},
+		{"round up", "a.wav", wavFile(fmtChunk(16000), wavChunk("data", make([]byte, 16001))), 2, false},
+		{"skip unknown chunk", "a.WAV", wavFile(fmtChunk(8000), wavChunk("LIST", []byte("odd")), wavChunk("data", make([]byte, 8000))), 1, false},
+		{"missing data chunk", "a.wav", wavFile(fmtChunk(16000)), 0, true},
+		{"zero byte rate", "a.wav", wavFile(fmtChunk(0), wavChunk("data", make([]byte, 100))), 0, true},
+		{"data before fmt", "a.wav", wavFile(wavChunk("data", make([]byte, 100)), fmtChunk(16000)), 0, true},
+		{"short fmt chunk", "a.wav", wavFile(wavChunk("fmt ", make([]byte, 4)), wavChunk("data", make([]byte, 100))), 0, true},
+		{"truncated header", "a.wav", []byte("RIFF\x00\x00"), 0, true},
+		{"not wave", "a.wav", wavFile(fmtChunk(16000))[:8], 0, true},
+		{"not wav file", "a.mp3", wavFile(fmtChunk(16000), wavChunk("data", make([]byte, 16000))), 0, true},
+	}
+	dir := t.TempDir()
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+"-"+c.file)
+			assert.Nil(t, os.WriteFile(path, c.data, 0600))
+			duration, err := wavDuration(path)
+			if c.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.duration, duration)
+		})
+	}
+}
+
+func TestVoiceCodec(t *testing.T) {
+	cases := map[string]string{
+		"a.wav":      "wav",
+		"a.M4A":      "aac",
+		"a.ogg":      "opus",
+		"a.mp3":      "mp3",
+		"dir.x/file": "",
+	}
+	for file, codec := range cases {
+		assert.Equal(t, codec, voiceCodec(file), file)
+	}
+}
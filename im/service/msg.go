@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"sort"
+	"sync/atomic"
 
 	"github.com/xuning888/helloIMClient/conf"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
@@ -10,6 +11,33 @@ import (
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
+// MessageDecryptor 解密从服务端拉取的端到端加密消息, 替换消息的内容和类型
+type MessageDecryptor func(ctx context.Context, msg *sqllite.ChatMessage) error
+
+var messageDecryptor atomic.Pointer[MessageDecryptor]
+
+// SetMessageDecryptor 开启端到端加密时设置, 传 nil 时不解密
+func SetMessageDecryptor(decrypt MessageDecryptor) {
+	if decrypt == nil {
+		messageDecryptor.Store(nil)
+		return
+	}
+	messageDecryptor.Store(&decrypt)
+}
+
+// decryptRemote 解密从服务端拉取的消息, 解密失败时保留密文, 展示为加密消息
+func decryptRemote(ctx context.Context, msgs ...*sqllite.ChatMessage) {
+	decrypt := messageDecryptor.Load()
+	if decrypt == nil {
+		return
+	}
+	for _, msg := range msgs {
+		if err := (*decrypt)(ctx, msg); err != nil {
+			logger.Errorf("decrypt message msgId: %d, chatId: %d, error: %v", msg.MsgID, msg.ChatID, err)
+		}
+	}
+}
+
 func LastMessage(ctx context.Context, chatId int64, chatType int32) (*sqllite.ChatMessage, error) {
 	lastMsg, err := sqllite.GetLastMessage(ctx, chatId)
 	if err == nil {
@@ -23,6 +51,7 @@ func LastMessage(ctx context.Context, chatId int64, chatType int32) (*sqllite.Ch
 		logger.Errorf("http.LastMessage error: %v", err)
 		return nil, err2
 	} else {
+		decryptRemote(ctx, lastMsg)
 		// 保存消息到数据库
 		if err3 := sqllite.SaveOrUpdateMessage(ctx, lastMsg); err3 != nil {
 			logger.Errorf("SaveOrUpdateMessage error: %v", err3)
@@ -36,6 +65,7 @@ func LastMessageFromRemote(ctx context.Context, chatId int64, chatType int32) (*
 	if err != nil {
 		return nil, err
 	}
+	decryptRemote(ctx, lastMessage)
 	return lastMessage, nil
 }
 
@@ -82,10 +112,19 @@ func PullOfflineMsg(ctx context.Context,
 				chatId, chatType, minServerSeq, maxServerSeq, err)
 			return nil, err
 		} else {
+			decryptRemote(ctx, messages...)
 			return messages, nil
 		}
 	}
+	sortMessages(messages)
 	minSeq, maxSeq := checkMissingMessage(messages)
+	// 本地最早的消息晚于 minServerSeq 时, 前面缺失的部分也需要从服务端补齐
+	if first := messages[0].ServerSeq; first > minServerSeq {
+		if minSeq == maxSeq {
+			maxSeq = first
+		}
+		minSeq = minServerSeq
+	}
 	if minSeq == maxSeq {
 		return messages, nil
 	}
@@ -95,7 +134,16 @@ func PullOfflineMsg(ctx context.Context,
 			chatId, chatType, minServerSeq, maxServerSeq, err)
 		return messages, nil
 	} else {
+		decryptRemote(ctx, msgs...)
+		// 补齐的区间包含缺口两端本地已有的消息
+		local := make(map[int64]struct{}, len(messages))
+		for _, msg := range messages {
+			local[msg.ServerSeq] = struct{}{}
+		}
 		for _, msg := range msgs {
+			if _, exists := local[msg.ServerSeq]; exists {
+				continue
+			}
 			messages = append(messages, msg)
 		}
 		sort.Slice(messages, func(i, j int) bool {
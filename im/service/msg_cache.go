@@ -11,21 +11,31 @@ import (
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
-const maxCachedMessages = 30 // 最大缓存消息数
+const (
+	maxCachedMessages = 30  // 每页加载的消息数
+	maxWindowMessages = 300 // 缓存窗口的最大消息数
+)
 
+// MsgCache 会话的消息窗口, 支持向前和向后翻页
 type MsgCache struct {
 	mux     sync.RWMutex
 	chat    *sqllite.ImChat
 	message []*sqllite.ChatMessage
 	dup     map[int64]struct{}
+	// hasOlder 是否还有更早的消息
+	hasOlder bool
+	// atLatest 窗口是否包含最新的消息, 定位到历史消息后为 false, 此时新消息只保存不进入窗口
+	atLatest bool
 }
 
 func NewMsgCache(chat *sqllite.ImChat) *MsgCache {
 	cache := &MsgCache{
-		mux:     sync.RWMutex{},
-		chat:    chat,
-		message: make([]*sqllite.ChatMessage, 0, maxCachedMessages),
-		dup:     map[int64]struct{}{},
+		mux:      sync.RWMutex{},
+		chat:     chat,
+		message:  make([]*sqllite.ChatMessage, 0, maxCachedMessages),
+		dup:      map[int64]struct{}{},
+		hasOlder: true,
+		atLatest: true,
 	}
 	cache.loadInitialMessages()
 	return cache
@@ -46,6 +56,7 @@ func (m *MsgCache) loadInitialMessages() {
 		if err != nil {
 			logger.Errorf("GetLatestOfflineMessages chatId: %v, chatType: %v, error: %v", chatId, chatType, err)
 		}
+		decryptRemote(ctx, messages...)
 	}
 	if len(messages) > 0 {
 		// 保存到数据库并更新缓存
@@ -62,12 +73,21 @@ func (m *MsgCache) loadInitialMessages() {
 		}
 	}
 	m.checkMissingMessageAndSort(ctx)
+	m.updateHasOlder()
 }
 
 func (m *MsgCache) UpdateMessage(msgs []*sqllite.ChatMessage) {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 	ctx := context.Background()
+	if !m.atLatest {
+		for _, msg := range msgs {
+			if err := sqllite.SaveOrUpdateMessage(ctx, msg); err != nil {
+				logger.Errorf("SaveOrUpdateMessage error: %v", err)
+			}
+		}
+		return
+	}
 	if len(msgs) == 0 {
 		lastMessage, err := LastMessage(ctx, m.chat.ChatId, m.chat.ChatType)
 		if err != nil {
@@ -82,6 +102,129 @@ func (m *MsgCache) UpdateMessage(msgs []*sqllite.ChatMessage) {
 	m.checkMissingMessageAndSort(ctx)
 }
 
+// LoadAround 把缓存替换为 serverSeq 附近的消息, 用于定位到某条历史消息
+func (m *MsgCache) LoadAround(serverSeq int64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ctx := context.Background()
+	chatId, chatType := m.chat.ChatId, m.chat.ChatType
+	messages, err := sqllite.GetMessagesAround(ctx, chatId, chatType, serverSeq, maxCachedMessages/2)
+	if err != nil {
+		logger.Errorf("LoadAround chatId: %v, chatType: %v, serverSeq: %v, error: %v", chatId, chatType, serverSeq, err)
+		return
+	}
+	m.message = make([]*sqllite.ChatMessage, 0, maxCachedMessages)
+	m.dup = map[int64]struct{}{}
+	newer := 0
+	for _, msg := range messages {
+		m.dup[msg.MsgID] = struct{}{}
+		m.message = append(m.message, msg)
+		if msg.ServerSeq > serverSeq {
+			newer++
+		}
+	}
+	m.atLatest = newer < maxCachedMessages/2
+	m.checkMissingMessageAndSort(ctx)
+	m.updateHasOlder()
+}
+
+// LoadOlder 在窗口前面加载一页更早的消息, 返回加载的消息数.
+// Note: 优先从本地按 ServerSeq 查询, 本地有缺失时从服务端补齐. 请求期间不持有锁, 不阻塞界面读取窗口
+func (m *MsgCache) LoadOlder() (int, error) {
+	m.mux.RLock()
+	if !m.hasOlder || len(m.message) == 0 {
+		m.mux.RUnlock()
+		return 0, nil
+	}
+	first := m.message[0].ServerSeq
+	m.mux.RUnlock()
+
+	maxSeq := first - 1
+	minSeq := max(maxSeq-maxCachedMessages+1, 1)
+	msgs, err := PullOfflineMsg(context.Background(), m.chat.ChatId, m.chat.ChatType, minSeq, maxSeq)
+	if err != nil {
+		logger.Errorf("LoadOlder chatId: %v, minSeq: %v, maxSeq: %v, error: %v", m.chat.ChatId, minSeq, maxSeq, err)
+		return 0, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	// 请求期间窗口被替换, 例如定位到了其他消息, 丢弃这一页
+	if len(m.message) == 0 || m.message[0].ServerSeq != first {
+		return 0, nil
+	}
+	before := len(m.message)
+	m.addMessages(msgs)
+	m.sortMessage()
+	loaded := len(m.message) - before
+	if loaded == 0 {
+		m.hasOlder = false
+	} else {
+		m.updateHasOlder()
+	}
+	if len(m.message) > maxWindowMessages {
+		m.message = m.truncateNewer(m.message)
+		m.atLatest = false
+	}
+	return loaded, nil
+}
+
+// LoadNewer 在窗口后面加载一页更新的消息, 返回加载的消息数. 请求期间不持有锁
+func (m *MsgCache) LoadNewer() (int, error) {
+	m.mux.RLock()
+	if m.atLatest || len(m.message) == 0 {
+		m.mux.RUnlock()
+		return 0, nil
+	}
+	last := m.message[len(m.message)-1].ServerSeq
+	m.mux.RUnlock()
+
+	minSeq := last + 1
+	maxSeq := minSeq + maxCachedMessages - 1
+	msgs, err := PullOfflineMsg(context.Background(), m.chat.ChatId, m.chat.ChatType, minSeq, maxSeq)
+	if err != nil {
+		logger.Errorf("LoadNewer chatId: %v, minSeq: %v, maxSeq: %v, error: %v", m.chat.ChatId, minSeq, maxSeq, err)
+		return 0, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if len(m.message) == 0 || m.message[len(m.message)-1].ServerSeq != last {
+		return 0, nil
+	}
+	before := len(m.message)
+	m.addMessages(msgs)
+	m.sortMessage()
+	loaded := len(m.message) - before
+	if loaded < maxCachedMessages {
+		m.atLatest = true
+	}
+	if len(m.message) > maxWindowMessages {
+		m.message = m.truncateMessages(m.message)
+		m.hasOlder = true
+	}
+	return loaded, nil
+}
+
+// HasOlder 是否还有更早的消息可以加载
+func (m *MsgCache) HasOlder() bool {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.hasOlder
+}
+
+// AtLatest 窗口是否已经包含最新的消息
+func (m *MsgCache) AtLatest() bool {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.atLatest
+}
+
+// updateHasOlder ServerSeq 从 1 开始, 窗口的第一条消息之前没有消息时不再向前翻页
+func (m *MsgCache) updateHasOlder() {
+	m.hasOlder = len(m.message) > 0 && m.message[0].ServerSeq > 1
+}
+
 func (m *MsgCache) checkMissingMessageAndSort(ctx context.Context) {
 	m.sortMessage()
 	minSeq, maxSeq := checkMissingMessage(m.message)
@@ -105,7 +248,10 @@ func (m *MsgCache) checkMissingMessageAndSort(ctx context.Context) {
 	}
 	m.addMessages(msgs)
 	m.sortMessage()
-	m.message = m.truncateMessages(m.message)
+	if len(m.message) > maxWindowMessages {
+		m.message = m.truncateMessages(m.message)
+		m.hasOlder = true
+	}
 }
 
 func (m *MsgCache) sortMessage() {
@@ -136,6 +282,10 @@ func (m *MsgCache) addMessages(messages []*sqllite.ChatMessage) {
 		if _, exists := m.dup[msg.MsgID]; exists {
 			continue
 		}
+		// 删除会话时清空的历史消息, 从服务端拉取时也不再显示
+		if m.chat.DelTimestamp > 0 && msg.SendTime <= m.chat.DelTimestamp {
+			continue
+		}
 		m.dup[msg.MsgID] = struct{}{}
 		if err := sqllite.SaveOrUpdateMessage(ctx, msg); err != nil {
 			logger.Errorf("SaveOrUpdateMessage error: %v", err)
@@ -154,15 +304,28 @@ func sortMessages(messages []*sqllite.ChatMessage) {
 }
 
 func (m *MsgCache) truncateMessages(messages []*sqllite.ChatMessage) []*sqllite.ChatMessage {
-	if len(messages) <= maxCachedMessages {
+	if len(messages) <= maxWindowMessages {
 		return messages
 	}
-	// 返回最新的 maxCachedMessages 条消息
-	startIndex := len(messages) - maxCachedMessages
-	msgs := make([]*sqllite.ChatMessage, maxCachedMessages)
+	// 返回最新的 maxWindowMessages 条消息
+	startIndex := len(messages) - maxWindowMessages
+	msgs := make([]*sqllite.ChatMessage, maxWindowMessages)
 	copy(msgs, messages[startIndex:])
 	for _, msg := range messages[:startIndex] {
 		delete(m.dup, msg.MsgID)
 	}
 	return msgs
 }
+
+// truncateNewer 向前翻页后丢弃窗口末尾较新的消息
+func (m *MsgCache) truncateNewer(messages []*sqllite.ChatMessage) []*sqllite.ChatMessage {
+	if len(messages) <= maxWindowMessages {
+		return messages
+	}
+	msgs := make([]*sqllite.ChatMessage, maxWindowMessages)
+	copy(msgs, messages[:maxWindowMessages])
+	for _, msg := range messages[maxWindowMessages:] {
+		delete(m.dup, msg.MsgID)
+	}
+	return msgs
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+// startMsgServer 启动测试服务端, 用户 2 给用户 1 发送 count 条消息, 本地使用临时数据库
+func startMsgServer(t *testing.T, count int) *testserver.Server {
+	assert.Nil(t, logger.InitLogger())
+	conf.UserId = 1
+	server := testserver.New()
+	assert.Nil(t, server.Start())
+	t.Cleanup(server.Close)
+	http.Init(server.URL(), 3*time.Second)
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	deliverMessages(t, server, count)
+	return server
+}
+
+func deliverMessages(t *testing.T, server *testserver.Server, count int) {
+	for i := 0; i < count; i++ {
+		_, err := server.Deliver(2, 1, 1, payload.NewTextMessage(fmt.Sprintf("msg %d", i), false, nil))
+		assert.Nil(t, err)
+	}
+}
+
+// remoteMessages 用户 1 在服务端看到的和用户 2 的消息
+func remoteMessages(server *testserver.Server) []*sqllite.ChatMessage {
+	return server.Messages(1, 2, 1)
+}
+
+func newTestMsgCache(server *testserver.Server) *MsgCache {
+	msgs := remoteMessages(server)
+	chat := sqllite.NewImChat(1, 2, 1)
+	chat.LastReadMsgId = msgs[len(msgs)-1].MsgID
+	return NewMsgCache(chat)
+}
+
+func serverSeqs(msgs []*sqllite.ChatMessage) []int64 {
+	seqs := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		seqs = append(seqs, msg.ServerSeq)
+	}
+	return seqs
+}
+
+func seqRange(from, to int64) []int64 {
+	seqs := make([]int64, 0, to-from+1)
+	for seq := from; seq <= to; seq++ {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func mustLoad(t *testing.T, load func() (int, error)) int {
+	loaded, err := load()
+	assert.Nil(t, err)
+	return loaded
+}
+
+func TestMsgCache_Paging(t *testing.T) {
+	server := startMsgServer(t, 400)
+	cache := newTestMsgCache(server)
+
+	// 本地没有消息时从服务端拉取最新的一页
+	assert.Equal(t, seqRange(371, 400), serverSeqs(cache.GetMessages()))
+	assert.True(t, cache.HasOlder())
+	assert.True(t, cache.AtLatest())
+	loaded, err := cache.LoadNewer()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, loaded)
+
+	// 向前翻页, 窗口超过 300 条后丢弃末尾较新的消息
+	for i := 0; i < 9; i++ {
+		assert.Equal(t, maxCachedMessages, mustLoad(t, cache.LoadOlder))
+	}
+	assert.Equal(t, seqRange(101, 400), serverSeqs(cache.GetMessages()))
+	assert.True(t, cache.AtLatest())
+	assert.Equal(t, maxCachedMessages, mustLoad(t, cache.LoadOlder))
+	assert.Equal(t, seqRange(71, 370), serverSeqs(cache.GetMessages()))
+	assert.False(t, cache.AtLatest())
+	for cache.HasOlder() {
+		mustLoad(t, cache.LoadOlder)
+	}
+	assert.Equal(t, seqRange(1, 300), serverSeqs(cache.GetMessages()))
+	assert.Equal(t, 0, mustLoad(t, cache.LoadOlder))
+
+	// 向后翻页从本地加载, 窗口超过 300 条后丢弃前面较早的消息
+	assert.Equal(t, maxCachedMessages, mustLoad(t, cache.LoadNewer))
+	assert.Equal(t, seqRange(31, 330), serverSeqs(cache.GetMessages()))
+	assert.True(t, cache.HasOlder())
+	assert.False(t, cache.AtLatest())
+	for !cache.AtLatest() {
+		mustLoad(t, cache.LoadNewer)
+	}
+	assert.Equal(t, seqRange(101, 400), serverSeqs(cache.GetMessages()))
+	assert.True(t, cache.HasOlder())
+}
+
+func TestMsgCache_LoadError(t *testing.T) {
+	server := startMsgServer(t, 60)
+	cache := newTestMsgCache(server)
+	assert.Equal(t, seqRange(31, 60), serverSeqs(cache.GetMessages()))
+
+	// 服务端不可用时返回错误, 窗口和翻页状态保持不变
+	server.Close()
+	loaded, err := cache.LoadOlder()
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, loaded)
+	assert.True(t, cache.HasOlder())
+	assert.Equal(t, seqRange(31, 60), serverSeqs(cache.GetMessages()))
+}
+
+func TestMsgCache_UpdateMessage(t *testing.T) {
+	server := startMsgServer(t, 60)
+	cache := newTestMsgCache(server)
+	ctx := context.Background()
+
+	// 定位到历史消息后, 新消息只保存不进入窗口
+	cache.LoadAround(10)
+	assert.False(t, cache.AtLatest())
+	before := serverSeqs(cache.GetMessages())
+	deliverMessages(t, server, 1)
+	msgs := remoteMessages(server)
+	cache.UpdateMessage(msgs[len(msgs)-1:])
+	assert.Equal(t, before, serverSeqs(cache.GetMessages()))
+	saved, err := sqllite.GetMessagesBySeq(ctx, 2, 61, 61)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{61}, serverSeqs(saved))
+
+	// 回到最新的消息后, 新消息进入窗口
+	for !cache.AtLatest() {
+		mustLoad(t, cache.LoadNewer)
+	}
+	deliverMessages(t, server, 1)
+	msgs = remoteMessages(server)
+	cache.UpdateMessage(msgs[len(msgs)-1:])
+	window := serverSeqs(cache.GetMessages())
+	assert.Equal(t, int64(62), window[len(window)-1])
+}
+
+func TestMsgCache_FillGap(t *testing.T) {
+	server := startMsgServer(t, 30)
+	cache := newTestMsgCache(server)
+	assert.Equal(t, seqRange(1, 30), serverSeqs(cache.GetMessages()))
+
+	// 只收到了最后一条推送, 中间缺失的消息从服务端补齐
+	deliverMessages(t, server, 5)
+	msgs := remoteMessages(server)
+	cache.UpdateMessage(msgs[len(msgs)-1:])
+	assert.Equal(t, seqRange(1, 35), serverSeqs(cache.GetMessages()))
+}
+
+func TestPullOfflineMsg_FillGap(t *testing.T) {
+	server := startMsgServer(t, 20)
+	ctx := context.Background()
+	// 本地只有 5-8 和 13-20
+	for _, msg := range remoteMessages(server) {
+		if (msg.ServerSeq >= 5 && msg.ServerSeq <= 8) || msg.ServerSeq >= 13 {
+			assert.Nil(t, sqllite.SaveOrUpdateMessage(ctx, msg))
+		}
+	}
+
+	tests := []struct {
+		name     string
+		min, max int64
+	}{
+		{name: "middle gap", min: 5, max: 20},
+		{name: "leading gap", min: 1, max: 8},
+		{name: "both", min: 1, max: 20},
+		{name: "local only", min: 13, max: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := PullOfflineMsg(ctx, 2, 1, tt.min, tt.max)
+			assert.Nil(t, err)
+			assert.Equal(t, seqRange(tt.min, tt.max), serverSeqs(msgs))
+		})
+	}
+}
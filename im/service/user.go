@@ -2,15 +2,58 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/golang-lru/v2"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/im/http"
 	"github.com/xuning888/helloIMClient/pkg/logger"
+	"gorm.io/gorm"
 )
 
+const (
+	// userSyncCursor 用户增量同步的游标名
+	userSyncCursor   = "user"
+	userSyncPageSize = 200
+	// userFetchTimeout 后台刷新用户资料的超时时间
+	userFetchTimeout = 5 * time.Second
+)
+
+var (
+	// UserProfileTTL 本地用户资料的有效期, 过期后读取时先返回本地的资料, 同时在后台刷新
+	UserProfileTTL = 24 * time.Hour
+	// UserFetchInterval 同一个用户两次从服务端获取资料的最小间隔, 避免不存在的用户和请求失败时反复请求
+	UserFetchInterval = time.Minute
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
 var cache *lru.Cache[int64, *sqllite.ImUser]
 
+// fetching 最近一次从服务端获取用户资料的时间
+var fetching = struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}{last: make(map[int64]time.Time)}
+
+// UsersFetched 在后台从服务端获取到用户资料后调用, 用于通知界面重新渲染
+type UsersFetched func(users []*sqllite.ImUser)
+
+var usersFetched atomic.Pointer[UsersFetched]
+
+// SetUsersFetched 设置后台获取到用户资料后的回调, 传 nil 时不通知
+func SetUsersFetched(fn UsersFetched) {
+	if fn == nil {
+		usersFetched.Store(nil)
+		return
+	}
+	usersFetched.Store(&fn)
+}
+
 func init() {
 	var err error
 	cache, err = lru.New[int64, *sqllite.ImUser](500)
@@ -19,30 +62,158 @@ func init() {
 	}
 }
 
+// GetUserById 依次从缓存、本地数据库和服务端获取用户资料, 资料过期时在后台刷新
 func GetUserById(ctx context.Context, userId int64) (*sqllite.ImUser, error) {
 	value, ok := cache.Get(userId)
 	if ok {
+		refreshIfStale(value)
 		return value, nil
 	}
 	user, err := sqllite.GetUserById(ctx, userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fetchUser(ctx, userId)
+	}
 	if err != nil {
 		return nil, err
 	}
 	cache.Add(userId, user)
+	refreshIfStale(user)
 	return user, nil
 }
 
-func UpdateUsers() {
-	users, err := http.Users(context.Background())
-	if err != nil {
-		logger.Errorf("http.Users error: %v", err)
+// PeekUser 从缓存和本地数据库获取用户资料, 不等待网络请求, 可以在界面渲染时调用.
+// 本地没有时返回 false, 同时在后台从服务端获取, 获取到以后通过 SetUsersFetched 设置的回调通知
+func PeekUser(ctx context.Context, userId int64) (*sqllite.ImUser, bool) {
+	if value, ok := cache.Get(userId); ok {
+		refreshIfStale(value)
+		return value, true
+	}
+	user, err := sqllite.GetUserById(ctx, userId)
+	if err == nil {
+		cache.Add(userId, user)
+		refreshIfStale(user)
+		return user, true
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("PeekUser %d: %v", userId, err)
+		return nil, false
+	}
+	if shouldFetch(userId) {
+		go fetchInBackground(userId)
+	}
+	return nil, false
+}
+
+// fetchUser 本地没有的用户从服务端获取
+func fetchUser(ctx context.Context, userId int64) (*sqllite.ImUser, error) {
+	if !shouldFetch(userId) {
+		return nil, fmt.Errorf("user %d: %w", userId, ErrUserNotFound)
+	}
+	if err := FetchUsers(ctx, userId); err != nil {
+		return nil, err
+	}
+	if user, ok := cache.Get(userId); ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("user %d: %w", userId, ErrUserNotFound)
+}
+
+// refreshIfStale 用户资料过期时在后台刷新
+func refreshIfStale(user *sqllite.ImUser) {
+	if time.Since(time.UnixMilli(user.FetchTimestamp)) < UserProfileTTL || !shouldFetch(user.UserID) {
 		return
 	}
-	if err := sqllite.BatchUpsertUsers(context.Background(), users); err != nil {
-		logger.Errorf("BatchUpsertUsers error: %v", err)
+	go fetchInBackground(user.UserID)
+}
+
+// fetchInBackground 在后台获取用户资料, 获取到以后通知界面
+func fetchInBackground(userId int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), userFetchTimeout)
+	defer cancel()
+	if err := FetchUsers(ctx, userId); err != nil {
+		logger.Warnf("fetch user %d: %v", userId, err)
 		return
 	}
+	user, ok := cache.Get(userId)
+	if !ok {
+		return
+	}
+	if fn := usersFetched.Load(); fn != nil {
+		(*fn)([]*sqllite.ImUser{user})
+	}
+}
+
+// shouldFetch 距离上次获取 userId 超过 UserFetchInterval 时返回 true, 并记录本次获取的时间.
+// 同时删除已经超过间隔的记录, 避免不存在的用户一直留在 map 中
+func shouldFetch(userId int64) bool {
+	fetching.mu.Lock()
+	defer fetching.mu.Unlock()
+	now := time.Now()
+	for id, last := range fetching.last {
+		if now.Sub(last) >= UserFetchInterval {
+			delete(fetching.last, id)
+		}
+	}
+	if _, ok := fetching.last[userId]; ok {
+		return false
+	}
+	fetching.last[userId] = now
+	return true
+}
+
+// FetchUsers 从服务端获取用户资料并保存
+func FetchUsers(ctx context.Context, userIds ...int64) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	users, err := http.GetUsers(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	return saveUsers(ctx, users)
+}
+
+// SyncUsers 从保存的游标开始分页拉取修改过的用户, 每页保存后推进游标, 中断后从断点继续
+func SyncUsers(ctx context.Context) error {
+	version, err := sqllite.GetSyncVersion(ctx, userSyncCursor)
+	if err != nil {
+		return err
+	}
+	for {
+		result, err := http.SyncUsers(ctx, version, userSyncPageSize)
+		if err != nil {
+			return err
+		}
+		if err = saveUsers(ctx, result.Users); err != nil {
+			return err
+		}
+		if result.Version > version {
+			version = result.Version
+			if err = sqllite.SaveSyncVersion(ctx, userSyncCursor, version); err != nil {
+				return err
+			}
+		}
+		if !result.HasMore || len(result.Users) == 0 {
+			return nil
+		}
+	}
+}
+
+// saveUsers 保存用户资料后从数据库重新读取再放入缓存, 版本比本地旧的资料不会覆盖数据库, 也不能进入缓存
+func saveUsers(ctx context.Context, users []*sqllite.ImUser) error {
+	if err := sqllite.BatchUpsertUsers(ctx, users); err != nil {
+		return err
+	}
+	userIds := make([]int64, 0, len(users))
 	for _, user := range users {
+		userIds = append(userIds, user.UserID)
+	}
+	saved, err := sqllite.GetUsersByIds(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	for _, user := range saved {
 		cache.Add(user.UserID, user)
 	}
+	return nil
 }
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+func TestSyncUsers(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	conf.UserId = 1
+	server := testserver.New()
+	for i := int64(1); i <= 450; i++ {
+		server.AddUser(&sqllite.ImUser{UserID: i, UserName: "user"})
+	}
+	assert.Nil(t, server.Start())
+	defer server.Close()
+	http.Init(server.URL(), 3*time.Second)
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	ctx := context.Background()
+
+	// 第一次同步分页拉取所有用户
+	assert.Nil(t, SyncUsers(ctx))
+	assert.Equal(t, int64(3), server.UserRequests())
+	version, err := sqllite.GetSyncVersion(ctx, userSyncCursor)
+	assert.Nil(t, err)
+	assert.Equal(t, server.UserVersion(), version)
+
+	// 之后只拉取修改过的用户
+	server.AddUser(&sqllite.ImUser{UserID: 2, UserName: "renamed"})
+	assert.Nil(t, SyncUsers(ctx))
+	assert.Equal(t, int64(4), server.UserRequests())
+	user, err := sqllite.GetUserById(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", user.UserName)
+
+	// 本地没有的用户从服务端获取, 不存在的用户在间隔内不再请求
+	server.AddUser(&sqllite.ImUser{UserID: 1000, UserName: "new"})
+	user, err = GetUserById(ctx, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", user.UserName)
+	_, err = GetUserById(ctx, 2000)
+	assert.True(t, errors.Is(err, ErrUserNotFound), err)
+	requests := server.UserRequests()
+	_, err = GetUserById(ctx, 2000)
+	assert.True(t, errors.Is(err, ErrUserNotFound), err)
+	assert.Equal(t, requests, server.UserRequests())
+
+	// 过期的资料先返回本地的, 在后台刷新
+	old := UserProfileTTL
+	UserProfileTTL = 0
+	defer func() { UserProfileTTL = old }()
+	server.AddUser(&sqllite.ImUser{UserID: 3, UserName: "updated"})
+	user, err = GetUserById(ctx, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", user.UserName)
+	assert.Eventually(t, func() bool {
+		user, _ = GetUserById(ctx, 3)
+		return user.UserName == "updated"
+	}, 3*time.Second, 20*time.Millisecond)
+}
+
+func TestSaveUsers_StaleVersion(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	ctx := context.Background()
+	cache.Remove(5000)
+	defer cache.Remove(5000)
+
+	assert.Nil(t, saveUsers(ctx, []*sqllite.ImUser{{UserID: 5000, UserName: "new", Version: 5}}))
+	// 晚到的旧版本资料既不覆盖数据库也不进入缓存
+	assert.Nil(t, saveUsers(ctx, []*sqllite.ImUser{{UserID: 5000, UserName: "old", Version: 3}}))
+	user, err := GetUserById(ctx, 5000)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", user.UserName)
+	assert.Equal(t, int64(5), user.Version)
+}
+
+func TestPeekUser(t *testing.T) {
+	assert.Nil(t, logger.InitLogger())
+	server := testserver.New()
+	server.AddUser(&sqllite.ImUser{UserID: 6000, UserName: "peek"})
+	assert.Nil(t, server.Start())
+	defer server.Close()
+	http.Init(server.URL(), 3*time.Second)
+	assert.Nil(t, sqllite.Init(filepath.Join(t.TempDir(), "data.db"), sqllite.KeySource{}))
+	ctx := context.Background()
+	cache.Remove(6000)
+	defer cache.Remove(6000)
+
+	// 本地没有时立即返回, 后台获取完成后通知
+	fetched := make(chan []*sqllite.ImUser, 1)
+	SetUsersFetched(func(users []*sqllite.ImUser) { fetched <- users })
+	defer SetUsersFetched(nil)
+	_, ok := PeekUser(ctx, 6000)
+	assert.False(t, ok)
+	select {
+	case users := <-fetched:
+		assert.Equal(t, "peek", users[0].UserName)
+	case <-time.After(3 * time.Second):
+		t.Fatal("users fetched callback not called")
+	}
+	user, ok := PeekUser(ctx, 6000)
+	assert.True(t, ok)
+	assert.Equal(t, "peek", user.UserName)
+}
+
+func TestShouldFetch_Prune(t *testing.T) {
+	old := UserFetchInterval
+	UserFetchInterval = 50 * time.Millisecond
+	defer func() { UserFetchInterval = old }()
+
+	assert.True(t, shouldFetch(7000))
+	assert.False(t, shouldFetch(7000))
+	// 超过间隔的记录在下次获取时删除
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, shouldFetch(7001))
+	fetching.mu.Lock()
+	_, ok := fetching.last[7000]
+	fetching.mu.Unlock()
+	assert.False(t, ok)
+	assert.True(t, shouldFetch(7000))
+}
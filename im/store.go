@@ -11,6 +11,8 @@ type Store struct {
 	Chats    ChatStore
 	Messages MessageStore
 	Users    UserStore
+	Drafts   DraftStore
+	Contacts ContactStore
 }
 
 // MsgCache 消息缓存接口
@@ -18,6 +20,12 @@ type MsgCache interface {
 	GetChat() *sqllite.ImChat
 	GetMessages() []*sqllite.ChatMessage
 	UpdateMessage(msgs []*sqllite.ChatMessage)
+	LoadAround(serverSeq int64)
+	// LoadOlder LoadNewer 加载一页更早或更新的消息, 返回加载的消息数, 拉取失败时返回错误
+	LoadOlder() (int, error)
+	LoadNewer() (int, error)
+	HasOlder() bool
+	AtLatest() bool
 }
 
 // ChatStore 会话存储接口
@@ -27,22 +35,80 @@ type ChatStore interface {
 	GetOrCreate(ctx context.Context, chatID int64, chatType int32) (*sqllite.ImChat, error)
 	SyncFromRemote(ctx context.Context) error
 	UpdateVersion(ctx context.Context, chatID int64, chatType int32) error
+	// Save 保存会话的所有设置
+	Save(ctx context.Context, chat *sqllite.ImChat) error
+	// ListArchived 已归档的会话, List 中不包含
+	ListArchived(ctx context.Context) ([]*sqllite.ImChat, error)
+	// Pin Unpin 置顶和取消置顶, 以下修改都先同步到服务端, 再由服务端同步给其他设备
+	Pin(ctx context.Context, chatID int64, chatType int32) error
+	Unpin(ctx context.Context, chatID int64, chatType int32) error
+	// Mute Unmute 免打扰的会话收到消息时不发出 EventNotification
+	Mute(ctx context.Context, chatID int64, chatType int32) error
+	Unmute(ctx context.Context, chatID int64, chatType int32) error
+	// Delete 隐藏会话并清空历史消息, 收到新消息后重新显示
+	Delete(ctx context.Context, chatID int64, chatType int32) error
+	Archive(ctx context.Context, chatID int64, chatType int32) error
+	Unarchive(ctx context.Context, chatID int64, chatType int32) error
 }
 
 // MessageStore 消息存储接口
 type MessageStore interface {
 	Recent(ctx context.Context, chatID int64, chatType int32, limit int) ([]*sqllite.ChatMessage, error)
 	Save(ctx context.Context, msg *sqllite.ChatMessage) error
+	// SaveIfAbsent 消息不存在时保存, 已经存在时返回 false
+	SaveIfAbsent(ctx context.Context, msg *sqllite.ChatMessage) (bool, error)
 	GetByServerSeq(ctx context.Context, chatID int64, minSeq, maxSeq int64) ([]*sqllite.ChatMessage, error)
 	LastMessage(ctx context.Context, chatID int64, chatType int32) (*sqllite.ChatMessage, error)
 	BatchLastMessage(ctx context.Context, chats []*sqllite.ImChat) map[string]*sqllite.ChatMessage
 	BatchLastMessageFromRemote(ctx context.Context, chats []*sqllite.ImChat) map[string]*sqllite.ChatMessage
 	NewCache(chat *sqllite.ImChat) MsgCache
+	Search(ctx context.Context, opts sqllite.MessageSearchOptions) ([]*sqllite.MessageSearchResult, error)
+	// DeleteBefore 删除会话中 sendTime 之前(包含)的消息
+	DeleteBefore(ctx context.Context, chatID int64, chatType int32, sendTime int64) error
 }
 
 // UserStore 用户存储接口
 type UserStore interface {
+	// Get 本地没有时从服务端获取, 资料过期时先返回本地的资料, 同时在后台刷新
 	Get(ctx context.Context, userID int64) (*sqllite.ImUser, error)
+	// Peek 只读取本地的资料, 不等待网络请求, 用于界面渲染.
+	// 本地没有时返回 false 并在后台获取, 获取完成后发出 EventUsersFetched
+	Peek(ctx context.Context, userID int64) (*sqllite.ImUser, bool)
 	Search(ctx context.Context, keyword string) ([]*sqllite.ImUser, error)
+	// Refresh 从上次同步的版本开始增量同步修改过的用户
 	Refresh(ctx context.Context) error
 }
+
+// DraftStore 会话草稿存储接口, 草稿保存在本地数据库, 不同的前端共用
+type DraftStore interface {
+	// Get 会话的草稿, 没有草稿时返回 nil
+	Get(ctx context.Context, chatID int64, chatType int32) (*sqllite.ChatDraft, error)
+	// Save 保存草稿, content 为空时删除, 草稿的时间参与会话列表的排序
+	Save(ctx context.Context, chatID int64, chatType int32, content string) error
+	Delete(ctx context.Context, chatID int64, chatType int32) error
+	// List 所有的草稿, key 与 ImChat.Key 相同
+	List(ctx context.Context) (map[string]*sqllite.ChatDraft, error)
+}
+
+// ContactStore 联系人存储接口, 包括好友申请和黑名单, 修改都先提交到服务端
+type ContactStore interface {
+	// List 没有被拉黑的好友
+	List(ctx context.Context) ([]*sqllite.ImUser, error)
+	// Search 在好友中按用户名搜索
+	Search(ctx context.Context, keyword string) ([]*sqllite.ImUser, error)
+	// SearchRemote 在服务端按用户名搜索所有用户, 用于添加好友
+	SearchRemote(ctx context.Context, keyword string) ([]*sqllite.ImUser, error)
+	// Sync 从服务端同步联系人、黑名单和好友申请
+	Sync(ctx context.Context) error
+	// Requests 收到的待处理的好友申请
+	Requests(ctx context.Context) ([]*sqllite.FriendRequest, error)
+	SendRequest(ctx context.Context, userID int64, message string) (*sqllite.FriendRequest, error)
+	Accept(ctx context.Context, requestID int64) error
+	Reject(ctx context.Context, requestID int64) error
+	// Block 拉黑后丢弃对方的推送, 隐藏与对方的单聊
+	Block(ctx context.Context, userID int64) error
+	Unblock(ctx context.Context, userID int64) error
+	IsBlocked(ctx context.Context, userID int64) (bool, error)
+	// Blocked 黑名单中的用户
+	Blocked(ctx context.Context) ([]*sqllite.ImUser, error)
+}
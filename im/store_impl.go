@@ -2,8 +2,10 @@ package im
 
 import (
 	"context"
+	"strings"
 
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/http"
 	"github.com/xuning888/helloIMClient/im/service"
 )
 
@@ -12,6 +14,8 @@ func newStore() *Store {
 		Chats:    &chatStoreImpl{},
 		Messages: &messageStoreImpl{},
 		Users:    &userStoreImpl{},
+		Drafts:   &draftStoreImpl{},
+		Contacts: &contactStoreImpl{},
 	}
 }
 
@@ -41,6 +45,42 @@ func (s *chatStoreImpl) UpdateVersion(ctx context.Context, chatID int64, chatTyp
 	return nil
 }
 
+func (s *chatStoreImpl) Save(ctx context.Context, chat *sqllite.ImChat) error {
+	return sqllite.SaveChat(ctx, chat)
+}
+
+func (s *chatStoreImpl) ListArchived(ctx context.Context) ([]*sqllite.ImChat, error) {
+	return service.GetArchivedChat(ctx)
+}
+
+func (s *chatStoreImpl) Pin(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatTop(ctx, chatID, chatType, true)
+}
+
+func (s *chatStoreImpl) Unpin(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatTop(ctx, chatID, chatType, false)
+}
+
+func (s *chatStoreImpl) Mute(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatMute(ctx, chatID, chatType, true)
+}
+
+func (s *chatStoreImpl) Unmute(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatMute(ctx, chatID, chatType, false)
+}
+
+func (s *chatStoreImpl) Delete(ctx context.Context, chatID int64, chatType int32) error {
+	return service.DeleteChat(ctx, chatID, chatType)
+}
+
+func (s *chatStoreImpl) Archive(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatArchive(ctx, chatID, chatType, true)
+}
+
+func (s *chatStoreImpl) Unarchive(ctx context.Context, chatID int64, chatType int32) error {
+	return service.SetChatArchive(ctx, chatID, chatType, false)
+}
+
 // ---- MessageStore ----
 
 type messageStoreImpl struct {
@@ -54,6 +94,10 @@ func (s *messageStoreImpl) Save(ctx context.Context, msg *sqllite.ChatMessage) e
 	return sqllite.SaveOrUpdateMessage(ctx, msg)
 }
 
+func (s *messageStoreImpl) SaveIfAbsent(ctx context.Context, msg *sqllite.ChatMessage) (bool, error) {
+	return sqllite.SaveMessageIfAbsent(ctx, msg)
+}
+
 func (s *messageStoreImpl) GetByServerSeq(ctx context.Context, chatID int64, minSeq, maxSeq int64) ([]*sqllite.ChatMessage, error) {
 	return sqllite.GetMessagesBySeq(ctx, chatID, minSeq, maxSeq)
 }
@@ -74,6 +118,14 @@ func (s *messageStoreImpl) NewCache(chat *sqllite.ImChat) MsgCache {
 	return service.NewMsgCache(chat)
 }
 
+func (s *messageStoreImpl) Search(ctx context.Context, opts sqllite.MessageSearchOptions) ([]*sqllite.MessageSearchResult, error) {
+	return sqllite.SearchMessages(ctx, opts)
+}
+
+func (s *messageStoreImpl) DeleteBefore(ctx context.Context, chatID int64, chatType int32, sendTime int64) error {
+	return sqllite.DeleteMessagesBefore(ctx, chatID, chatType, sendTime)
+}
+
 // ---- UserStore ----
 
 type userStoreImpl struct{}
@@ -82,11 +134,92 @@ func (s *userStoreImpl) Get(ctx context.Context, userID int64) (*sqllite.ImUser,
 	return service.GetUserById(ctx, userID)
 }
 
+func (s *userStoreImpl) Peek(ctx context.Context, userID int64) (*sqllite.ImUser, bool) {
+	return service.PeekUser(ctx, userID)
+}
+
 func (s *userStoreImpl) Search(ctx context.Context, keyword string) ([]*sqllite.ImUser, error) {
 	return sqllite.SearchUser(ctx, keyword)
 }
 
 func (s *userStoreImpl) Refresh(ctx context.Context) error {
-	service.UpdateUsers()
-	return nil
+	return service.SyncUsers(ctx)
+}
+
+// ---- DraftStore ----
+
+type draftStoreImpl struct{}
+
+func (s *draftStoreImpl) Get(ctx context.Context, chatID int64, chatType int32) (*sqllite.ChatDraft, error) {
+	return sqllite.GetDraft(ctx, chatID, chatType)
+}
+
+func (s *draftStoreImpl) Save(ctx context.Context, chatID int64, chatType int32, content string) error {
+	return sqllite.SaveDraft(ctx, chatID, chatType, content)
+}
+
+func (s *draftStoreImpl) Delete(ctx context.Context, chatID int64, chatType int32) error {
+	return sqllite.DeleteDraft(ctx, chatID, chatType)
+}
+
+func (s *draftStoreImpl) List(ctx context.Context) (map[string]*sqllite.ChatDraft, error) {
+	return sqllite.MultiGetDraft(ctx)
+}
+
+// ---- ContactStore ----
+
+type contactStoreImpl struct{}
+
+func (s *contactStoreImpl) List(ctx context.Context) ([]*sqllite.ImUser, error) {
+	return sqllite.GetFriends(ctx)
+}
+
+func (s *contactStoreImpl) Search(ctx context.Context, keyword string) ([]*sqllite.ImUser, error) {
+	if strings.TrimSpace(keyword) == "" {
+		return []*sqllite.ImUser{}, nil
+	}
+	return sqllite.SearchContacts(ctx, keyword)
+}
+
+func (s *contactStoreImpl) SearchRemote(ctx context.Context, keyword string) ([]*sqllite.ImUser, error) {
+	if strings.TrimSpace(keyword) == "" {
+		return []*sqllite.ImUser{}, nil
+	}
+	return http.SearchUsers(ctx, keyword)
+}
+
+func (s *contactStoreImpl) Sync(ctx context.Context) error {
+	return service.SyncContacts(ctx)
+}
+
+func (s *contactStoreImpl) Requests(ctx context.Context) ([]*sqllite.FriendRequest, error) {
+	return sqllite.GetPendingFriendRequests(ctx)
+}
+
+func (s *contactStoreImpl) SendRequest(ctx context.Context, userID int64, message string) (*sqllite.FriendRequest, error) {
+	return service.SendFriendRequest(ctx, userID, message)
+}
+
+func (s *contactStoreImpl) Accept(ctx context.Context, requestID int64) error {
+	return service.AcceptFriendRequest(ctx, requestID)
+}
+
+func (s *contactStoreImpl) Reject(ctx context.Context, requestID int64) error {
+	return service.RejectFriendRequest(ctx, requestID)
+}
+
+func (s *contactStoreImpl) Block(ctx context.Context, userID int64) error {
+	return service.SetBlocked(ctx, userID, true)
+}
+
+func (s *contactStoreImpl) Unblock(ctx context.Context, userID int64) error {
+	return service.SetBlocked(ctx, userID, false)
+}
+
+func (s *contactStoreImpl) IsBlocked(ctx context.Context, userID int64) (bool, error) {
+	return sqllite.IsBlocked(ctx, userID)
+}
+
+func (s *contactStoreImpl) Blocked(ctx context.Context) ([]*sqllite.ImUser, error) {
+	return sqllite.GetBlockedUsers(ctx)
 }
@@ -0,0 +1,115 @@
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+// Chat uid 的会话, 不存在时返回 nil
+func (s *Server) Chat(uid, chatId int64, chatType int32) *sqllite.ImChat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat := s.chats[uid][newConversationKey(uid, chatId, chatType)]
+	if chat == nil {
+		return nil
+	}
+	c := *chat
+	return &c
+}
+
+// SyncChat 模拟 uid 在其他设备上修改了会话的设置, 保存后推送给 uid 的所有连接
+func (s *Server) SyncChat(uid int64, settings ...*helloim_proto.ChatSettings) {
+	s.applyChatSettings(uid, settings)
+	s.pushChatSync(uid, &helloim_proto.ChatSyncRequest{Settings: settings}, nil)
+}
+
+// applyChatSettings 保存 uid 的会话设置, 已读游标只前进
+func (s *Server) applyChatSettings(uid int64, settings []*helloim_proto.ChatSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, setting := range settings {
+		chatId, err := strconv.ParseInt(setting.GetChatId(), 10, 64)
+		if err != nil {
+			continue
+		}
+		key := newConversationKey(uid, chatId, setting.GetChatType())
+		if s.chats[uid] == nil {
+			s.chats[uid] = make(map[conversationKey]*sqllite.ImChat)
+		}
+		chat := s.chats[uid][key]
+		if chat == nil {
+			chat = sqllite.NewImChat(uid, chatId, setting.GetChatType())
+			s.chats[uid][key] = chat
+		}
+		chat.ChatTop = setting.GetTop()
+		chat.ChatMute = setting.GetMute()
+		chat.ChatDel = setting.GetDel()
+		chat.ChatArchive = setting.GetArchive()
+		chat.DelTimestamp = max(chat.DelTimestamp, setting.GetDelTimestamp())
+		chat.LastReadMsgId = max(chat.LastReadMsgId, setting.GetLastReadMsgId())
+	}
+}
+
+// pushChatSync 把会话设置的修改推送给 uid 除了 origin 以外的连接
+func (s *Server) pushChatSync(uid int64, req *helloim_proto.ChatSyncRequest, origin *serverConn) {
+	message := frameMessage{Message: req, cmdId: int32(helloim_proto.CmdId_CMD_ID_CHAT_SYNC)}
+	for _, conn := range s.connsOf(uid) {
+		if conn != origin {
+			conn.pushMessage(message)
+		}
+	}
+}
+
+// chatSetting 修改会话设置的请求, 与 im/http.ChatSetting 一致
+type chatSetting struct {
+	UserId       int64 `json:"userId"`
+	ChatId       int64 `json:"chatId"`
+	ChatType     int32 `json:"chatType"`
+	Top          bool  `json:"top"`
+	Mute         bool  `json:"mute"`
+	Archive      bool  `json:"archive"`
+	DelTimestamp int64 `json:"delTimestamp"`
+}
+
+// handleChatSetting 修改会话设置的 WebAPI, 保存后把会话的完整设置推送给用户的所有连接
+func (s *Server) handleChatSetting(update func(chat *sqllite.ImChat, setting *chatSetting)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var setting chatSetting
+		if err := json.NewDecoder(r.Body).Decode(&setting); err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		key := newConversationKey(setting.UserId, setting.ChatId, setting.ChatType)
+		if s.chats[setting.UserId] == nil {
+			s.chats[setting.UserId] = make(map[conversationKey]*sqllite.ImChat)
+		}
+		chat := s.chats[setting.UserId][key]
+		if chat == nil {
+			chat = sqllite.NewImChat(setting.UserId, setting.ChatId, setting.ChatType)
+			s.chats[setting.UserId][key] = chat
+		}
+		update(chat, &setting)
+		settings := chatSettings(chat)
+		s.mu.Unlock()
+		s.pushChatSync(setting.UserId, &helloim_proto.ChatSyncRequest{Settings: []*helloim_proto.ChatSettings{settings}}, nil)
+		writeResult[any](w, nil)
+	}
+}
+
+func chatSettings(chat *sqllite.ImChat) *helloim_proto.ChatSettings {
+	return &helloim_proto.ChatSettings{
+		ChatId:        strconv.FormatInt(chat.ChatId, 10),
+		ChatType:      chat.ChatType,
+		Top:           chat.ChatTop,
+		Mute:          chat.ChatMute,
+		Del:           chat.ChatDel,
+		DelTimestamp:  chat.DelTimestamp,
+		LastReadMsgId: chat.LastReadMsgId,
+		Archive:       chat.ChatArchive,
+	}
+}
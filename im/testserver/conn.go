@@ -0,0 +1,294 @@
+package testserver
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+// frameMessage 给 protobuf 消息附上 cmdId, 用于编码成帧
+type frameMessage struct {
+	proto.Message
+	cmdId int32
+}
+
+func (m frameMessage) CmdId() int32 { return m.cmdId }
+
+// serverConn 服务端的一个长连接
+type serverConn struct {
+	s      *Server
+	conn   net.Conn
+	mu     sync.Mutex
+	uid    atomic.Int64
+	authed atomic.Bool
+	// codec 推送使用的消息头版本和压缩算法, 认证时协商
+	codec  atomic.Pointer[protocol.Codec]
+	device atomic.Pointer[device]
+	once   sync.Once
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &serverConn{s: s, conn: conn}
+		c.codec.Store(&protocol.Codec{Version: protocol.Version1})
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		if s.closed.Load() {
+			c.close()
+			continue
+		}
+		s.wg.Add(1)
+		go c.serve()
+	}
+}
+
+func (c *serverConn) serve() {
+	defer c.s.wg.Done()
+	defer c.close()
+	for {
+		frame, err := protocol.ReadFrame(c.conn, protocol.DefaultMaxFrameSize)
+		if err != nil {
+			return
+		}
+		c.s.received.Add(1)
+		if protocol.FrameCompression(frame.Header) != protocol.CompressionNone {
+			c.s.compressed.Add(1)
+			if err := protocol.Decompress(frame); err != nil {
+				return
+			}
+		}
+		if fault := c.s.currentFault(c.uid.Load(), frame.Header); fault != nil {
+			if fault.Delay > 0 {
+				time.Sleep(fault.Delay)
+			}
+			if fault.Disconnect {
+				return
+			}
+			if fault.Drop {
+				continue
+			}
+		}
+		if frame.Header.Req == protocol.RES {
+			if frame.Header.CmdId == int32(helloim_proto.CmdId_CMD_ID_PUSH) {
+				c.s.pushAcks.Add(1)
+			}
+			continue
+		}
+		if err := c.handle(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (c *serverConn) handle(frame *protocol.Frame) error {
+	cmdId := frame.Header.CmdId
+	switch helloim_proto.CmdId(cmdId) {
+	case helloim_proto.CmdId_CMD_ID_AUTH:
+		req := &helloim_proto.AuthRequest{}
+		if err := proto.Unmarshal(frame.Body, req); err != nil {
+			return err
+		}
+		uid, err := strconv.ParseInt(req.GetUid(), 10, 64)
+		success := err == nil && c.s.opts.Auth(uid, req.GetUserType(), req.GetToken())
+		if success && c.s.opts.RequireToken {
+			owner, ok := c.s.validToken(req.GetToken())
+			success = ok && owner == uid
+		}
+		resp := &helloim_proto.AuthResponse{Uid: req.GetUid(), UserType: req.GetUserType(), Success: success}
+		if success {
+			c.uid.Store(uid)
+			c.device.Store(&device{id: req.GetDeviceId(), platform: req.GetPlatform()})
+			c.authed.Store(true)
+			// 只支持 v1 的服务端不认识 protocolVersion, 不返回版本; v1 消息头不能标记压缩
+			if version := min(req.GetProtocolVersion(), int32(c.s.opts.MaxHeaderVersion)); version >= int32(protocol.Version2) {
+				compression := c.s.selectCompression(req.GetCompressions())
+				resp.ProtocolVersion = version
+				resp.Compression = helloim_proto.CompressionType(compression)
+				c.codec.Store(&protocol.Codec{
+					Version:     byte(version),
+					Compression: compression,
+					Threshold:   c.s.opts.CompressionThreshold,
+				})
+			}
+		}
+		if err := c.reply(frame, resp); err != nil || !success {
+			return err
+		}
+		c.s.onLogin(c)
+		return nil
+	case helloim_proto.CmdId_CMD_ID_HEARTBEAT:
+		return c.reply(frame, &helloim_proto.EmptyResponse{})
+	case helloim_proto.CmdId_CMD_ID_ECHO:
+		req := &helloim_proto.EchoRequest{}
+		if err := proto.Unmarshal(frame.Body, req); err != nil {
+			return err
+		}
+		return c.reply(frame, &helloim_proto.EchoResponse{Msg: req.GetMsg()})
+	case helloim_proto.CmdId_CMD_ID_SEND:
+		if !c.authed.Load() {
+			return errors.New("send before auth")
+		}
+		req := &helloim_proto.SendPktRequest{}
+		if err := proto.Unmarshal(frame.Body, req); err != nil {
+			return err
+		}
+		return c.handleSend(frame, req)
+	case helloim_proto.CmdId_CMD_ID_CHAT_SYNC:
+		if !c.authed.Load() {
+			return errors.New("chat sync before auth")
+		}
+		req := &helloim_proto.ChatSyncRequest{}
+		if err := proto.Unmarshal(frame.Body, req); err != nil {
+			return err
+		}
+		c.s.applyChatSettings(c.uid.Load(), req.GetSettings())
+		if err := c.reply(frame, &helloim_proto.ChatSyncResponse{}); err != nil {
+			return err
+		}
+		c.s.pushChatSync(c.uid.Load(), req, c)
+		return nil
+	}
+	return nil
+}
+
+func (c *serverConn) handleSend(frame *protocol.Frame, req *helloim_proto.SendPktRequest) error {
+	from, err := strconv.ParseInt(req.GetFrom(), 10, 64)
+	if err != nil {
+		from = c.uid.Load()
+	}
+	chatId, err := strconv.ParseInt(req.GetChatId(), 10, 64)
+	if err != nil {
+		return err
+	}
+	msg, receivers, err := c.s.deliver(from, chatId, req)
+	if err != nil {
+		// 发送失败不回复 ACK, 由客户端超时重试
+		return nil
+	}
+	if err := c.reply(frame, &helloim_proto.SendPktResponse{
+		MsgId:     msg.MsgID,
+		Timestamp: time.Now().UnixMilli(),
+		ServerSeq: msg.ServerSeq,
+	}); err != nil {
+		return err
+	}
+	c.s.push(receivers, msg, req, c)
+	return nil
+}
+
+// Deliver 模拟 from 在其他设备上发送一条消息, 保存后推送给在线的接收方和 from 的所有设备
+func (s *Server) Deliver(from, chatId int64, chatType int32, p *helloim_proto.Payload) (*sqllite.ChatMessage, error) {
+	req := &helloim_proto.SendPktRequest{
+		From:          strconv.FormatInt(from, 10),
+		ChatId:        strconv.FormatInt(chatId, 10),
+		ChatType:      chatType,
+		SendTimestamp: time.Now().UnixMilli(),
+		Payload:       p,
+	}
+	msg, receivers, err := s.deliver(from, chatId, req)
+	if err != nil {
+		return nil, err
+	}
+	s.push(receivers, msg, req, nil)
+	m := *msg
+	return &m, nil
+}
+
+func (s *Server) deliver(from, chatId int64, req *helloim_proto.SendPktRequest) (*sqllite.ChatMessage, []int64, error) {
+	content, contentType := payload.ExtractContent(req.GetPayload())
+	msg := sqllite.NewMessage(req.GetChatType(), chatId, 0, from, chatId,
+		req.GetFromUserType(), req.GetToUserType(), 0, content, contentType,
+		int32(helloim_proto.CmdId_CMD_ID_SEND), req.GetSendTimestamp(), 0, 0)
+	return s.storeMessage(msg)
+}
+
+// push 把消息推送给接收方的所有连接, 以及发送方除了 origin 以外的连接, 用于多端同步自己发出的消息
+func (s *Server) push(receivers []int64, msg *sqllite.ChatMessage, req *helloim_proto.SendPktRequest, origin *serverConn) {
+	pkt := &helloim_proto.PushPktRequest{
+		From:          req.GetFrom(),
+		FromUserType:  req.GetFromUserType(),
+		ChatId:        req.GetChatId(),
+		ChatType:      req.GetChatType(),
+		SendTimestamp: req.GetSendTimestamp(),
+		ToUserType:    req.GetToUserType(),
+		Payload:       req.GetPayload(),
+		Extra:         req.GetExtra(),
+		MsgId:         msg.MsgID,
+		ServerSeq:     msg.ServerSeq,
+	}
+	message := frameMessage{Message: pkt, cmdId: int32(helloim_proto.CmdId_CMD_ID_PUSH)}
+	for _, uid := range receivers {
+		for _, conn := range s.connsOf(uid) {
+			conn.pushMessage(message)
+		}
+	}
+	for _, conn := range s.connsOf(msg.MsgFrom) {
+		if conn != origin {
+			conn.pushMessage(message)
+		}
+	}
+}
+
+// pushMessage 按连接协商的消息头版本和压缩算法推送
+func (c *serverConn) pushMessage(message frameMessage) {
+	data, err := c.codec.Load().EncodeMessageToBytes(c.s.pushSeq.Add(1), protocol.REQ, message)
+	if err != nil {
+		return
+	}
+	c.write(data)
+}
+
+// reply 使用请求的 seq、cmdId 和消息头版本回复, 消息头为 v2 时按协商的算法压缩
+func (c *serverConn) reply(frame *protocol.Frame, resp proto.Message) error {
+	codec := *c.codec.Load()
+	codec.Version = frame.Header.Version
+	data, err := codec.EncodeMessageToBytes(frame.Header.Seq, protocol.RES,
+		frameMessage{Message: resp, cmdId: frame.Header.CmdId})
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// selectCompression 选择客户端提供的第一个服务端也支持的算法
+func (s *Server) selectCompression(offered []helloim_proto.CompressionType) protocol.Compression {
+	for _, o := range offered {
+		for _, c := range s.opts.Compressions {
+			if protocol.Compression(o) == c {
+				return c
+			}
+		}
+	}
+	return protocol.CompressionNone
+}
+
+func (c *serverConn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *serverConn) close() {
+	c.once.Do(func() {
+		c.conn.Close()
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+	})
+}
@@ -0,0 +1,236 @@
+package testserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+// friendRequestAction 发送和处理好友申请的请求, 与 im/http.FriendRequestAction 一致
+type friendRequestAction struct {
+	UserId    int64  `json:"userId"`
+	ToUserId  int64  `json:"toUserId"`
+	Message   string `json:"message"`
+	RequestId int64  `json:"requestId"`
+}
+
+// blockSetting 拉黑的请求, 与 im/http.BlockSetting 一致
+type blockSetting struct {
+	UserId    int64 `json:"userId"`
+	ContactId int64 `json:"contactId"`
+	Blocked   bool  `json:"blocked"`
+}
+
+// AddFriend a 和 b 互相成为好友
+func (s *Server) AddFriend(a, b int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contact(a, b).Friend = true
+	s.contact(b, a).Friend = true
+}
+
+// Contacts uid 的联系人, 按联系人的 uid 升序
+func (s *Server) Contacts(uid int64) []*sqllite.Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contacts := make([]*sqllite.Contact, 0, len(s.contacts[uid]))
+	for _, contact := range s.contacts[uid] {
+		c := *contact
+		if user, ok := s.users[c.ContactId]; ok {
+			u := *user
+			c.User = &u
+		}
+		contacts = append(contacts, &c)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].ContactId < contacts[j].ContactId
+	})
+	return contacts
+}
+
+// SendFriendRequest 模拟 from 在其他客户端上向 to 发送好友申请, 推送给双方的所有连接
+func (s *Server) SendFriendRequest(from, to int64, message string) (*sqllite.FriendRequest, error) {
+	request, err := s.createFriendRequest(from, to, message)
+	if err != nil {
+		return nil, err
+	}
+	s.pushFriendNotify(request)
+	return request, nil
+}
+
+// contact uid 的联系人 contactId, 不存在时创建, 调用方持有锁
+func (s *Server) contact(uid, contactId int64) *sqllite.Contact {
+	if s.contacts[uid] == nil {
+		s.contacts[uid] = make(map[int64]*sqllite.Contact)
+	}
+	contact := s.contacts[uid][contactId]
+	if contact == nil {
+		contact = &sqllite.Contact{UserId: uid, ContactId: contactId}
+		s.contacts[uid][contactId] = contact
+	}
+	contact.UpdateTimestamp = time.Now().UnixMilli()
+	return contact
+}
+
+func (s *Server) createFriendRequest(from, to int64, message string) (*sqllite.FriendRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[from]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	if _, ok = s.users[to]; !ok || from == to {
+		return nil, errors.New("invalid friend")
+	}
+	if contact := s.contacts[from][to]; contact != nil && contact.Friend {
+		return nil, errors.New("already friends")
+	}
+	request := &sqllite.FriendRequest{
+		RequestId:    s.requestSeq.Add(1),
+		FromUserId:   from,
+		ToUserId:     to,
+		FromUserName: user.UserName,
+		Message:      message,
+		Status:       sqllite.FriendRequestPending,
+		Timestamp:    time.Now().UnixMilli(),
+	}
+	s.friendRequests[request.RequestId] = request
+	r := *request
+	return &r, nil
+}
+
+// updateFriendRequest 被申请人 uid 同意或者拒绝申请, 同意后双方互相成为好友
+func (s *Server) updateFriendRequest(uid, requestId int64, status int32) (*sqllite.FriendRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request := s.friendRequests[requestId]
+	if request == nil || request.ToUserId != uid {
+		return nil, errors.New("friend request not found")
+	}
+	if request.Status != sqllite.FriendRequestPending {
+		return nil, errors.New("friend request already handled")
+	}
+	request.Status = status
+	request.Timestamp = time.Now().UnixMilli()
+	if status == sqllite.FriendRequestAccepted {
+		s.contact(request.FromUserId, request.ToUserId).Friend = true
+		s.contact(request.ToUserId, request.FromUserId).Friend = true
+	}
+	r := *request
+	return &r, nil
+}
+
+// pushFriendNotify 把好友申请推送给申请人和被申请人的所有连接
+func (s *Server) pushFriendNotify(request *sqllite.FriendRequest) {
+	notify := &helloim_proto.FriendNotifyRequest{Request: &helloim_proto.FriendRequest{
+		RequestId:    request.RequestId,
+		From:         strconv.FormatInt(request.FromUserId, 10),
+		To:           strconv.FormatInt(request.ToUserId, 10),
+		FromUserName: request.FromUserName,
+		Message:      request.Message,
+		Status:       request.Status,
+		Timestamp:    request.Timestamp,
+	}}
+	message := frameMessage{Message: notify, cmdId: int32(helloim_proto.CmdId_CMD_ID_FRIEND_NOTIFY)}
+	for _, uid := range []int64{request.FromUserId, request.ToUserId} {
+		for _, conn := range s.connsOf(uid) {
+			conn.pushMessage(message)
+		}
+	}
+}
+
+func (s *Server) handleSearchUser(w http.ResponseWriter, r *http.Request) {
+	keyword := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("keyword")))
+	users := make([]*sqllite.ImUser, 0)
+	if keyword != "" {
+		for _, user := range s.userList() {
+			if strings.Contains(strings.ToLower(user.UserName), keyword) {
+				users = append(users, user)
+			}
+		}
+	}
+	if len(users) > 20 {
+		users = users[:20]
+	}
+	writeResult(w, users)
+}
+
+func (s *Server) handleContactList(w http.ResponseWriter, r *http.Request) {
+	userId, err := queryInt(r, "userId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, s.Contacts(userId))
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	var setting blockSetting
+	if err := json.NewDecoder(r.Body).Decode(&setting); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	s.contact(setting.UserId, setting.ContactId).Blocked = setting.Blocked
+	s.mu.Unlock()
+	writeResult[any](w, nil)
+}
+
+func (s *Server) handleFriendRequestList(w http.ResponseWriter, r *http.Request) {
+	userId, err := queryInt(r, "userId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	requests := make([]*sqllite.FriendRequest, 0)
+	for _, request := range s.friendRequests {
+		if request.FromUserId == userId || request.ToUserId == userId {
+			r := *request
+			requests = append(requests, &r)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestId < requests[j].RequestId
+	})
+	writeResult(w, requests)
+}
+
+func (s *Server) handleSendFriendRequest(w http.ResponseWriter, r *http.Request) {
+	var action friendRequestAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		writeError(w, err)
+		return
+	}
+	request, err := s.SendFriendRequest(action.UserId, action.ToUserId, action.Message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, request)
+}
+
+func (s *Server) handleFriendRequestStatus(status int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var action friendRequestAction
+		if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+			writeError(w, err)
+			return
+		}
+		request, err := s.updateFriendRequest(action.UserId, action.RequestId, status)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.pushFriendNotify(request)
+		writeResult(w, request)
+	}
+}
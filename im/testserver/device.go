@@ -0,0 +1,53 @@
+package testserver
+
+import (
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+// device 连接认证时上报的设备信息
+type device struct {
+	id       string
+	platform string
+}
+
+// onLogin 认证成功后处理同一个账号的其他连接, 开启 SingleDevice 时其他连接被踢下线
+func (s *Server) onLogin(c *serverConn) {
+	if !s.opts.SingleDevice {
+		return
+	}
+	d := c.device.Load()
+	for _, other := range s.connsOf(c.uid.Load()) {
+		if other != c {
+			other.kickout(&helloim_proto.KickoutRequest{
+				Reason:   helloim_proto.KickoutReason_KICKOUT_OTHER_DEVICE,
+				Message:  "账号在其他设备登录",
+				DeviceId: d.id,
+				Platform: d.platform,
+			})
+		}
+	}
+}
+
+// Kickout 把 uid 的所有连接踢下线
+func (s *Server) Kickout(uid int64, reason helloim_proto.KickoutReason, message string) {
+	for _, conn := range s.connsOf(uid) {
+		conn.kickout(&helloim_proto.KickoutRequest{Reason: reason, Message: message})
+	}
+}
+
+// Devices uid 在线的设备id
+func (s *Server) Devices(uid int64) []string {
+	var devices []string
+	for _, conn := range s.connsOf(uid) {
+		if d := conn.device.Load(); d != nil {
+			devices = append(devices, d.id)
+		}
+	}
+	return devices
+}
+
+// kickout 发送踢下线的消息后断开连接
+func (c *serverConn) kickout(req *helloim_proto.KickoutRequest) {
+	c.pushMessage(frameMessage{Message: req, cmdId: int32(helloim_proto.CmdId_CMD_ID_KICKOUT)})
+	c.close()
+}
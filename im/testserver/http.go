@@ -0,0 +1,289 @@
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/pkg"
+)
+
+// httpHandler WebAPI, 路径和参数与 im/http 中的客户端保持一致
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index/iplist", s.handleIpList)
+	mux.HandleFunc("/user/sync", s.handleSyncUser)
+	mux.HandleFunc("/user/batchGet", s.handleBatchGetUser)
+	mux.HandleFunc("/chat/getAllChat", s.handleGetAllChat)
+	mux.HandleFunc("/chat/lastMessage", s.handleLastMessage)
+	mux.HandleFunc("/message/pullOfflineMsg", s.handlePullOfflineMsg)
+	mux.HandleFunc("/message/getLatestOfflineMessages", s.handleGetLatestOfflineMessages)
+	mux.HandleFunc("/file/upload", s.handleUpload)
+	mux.HandleFunc("/file/", s.handleDownload)
+	mux.HandleFunc("/key/upload", s.handleUploadPublicKey)
+	mux.HandleFunc("/key/get", s.handleGetPublicKey)
+	mux.HandleFunc("/auth/login", s.handleLogin)
+	mux.HandleFunc("/auth/refresh", s.handleRefreshToken)
+	mux.HandleFunc("/user/search", s.handleSearchUser)
+	mux.HandleFunc("/contact/list", s.handleContactList)
+	mux.HandleFunc("/contact/block", s.handleBlock)
+	mux.HandleFunc("/contact/request/list", s.handleFriendRequestList)
+	mux.HandleFunc("/contact/request/send", s.handleSendFriendRequest)
+	mux.HandleFunc("/contact/request/accept", s.handleFriendRequestStatus(sqllite.FriendRequestAccepted))
+	mux.HandleFunc("/contact/request/reject", s.handleFriendRequestStatus(sqllite.FriendRequestRejected))
+	mux.HandleFunc("/chat/top", s.handleChatSetting(func(chat *sqllite.ImChat, setting *chatSetting) {
+		chat.ChatTop = setting.Top
+	}))
+	mux.HandleFunc("/chat/mute", s.handleChatSetting(func(chat *sqllite.ImChat, setting *chatSetting) {
+		chat.ChatMute = setting.Mute
+	}))
+	mux.HandleFunc("/chat/archive", s.handleChatSetting(func(chat *sqllite.ImChat, setting *chatSetting) {
+		chat.ChatArchive = setting.Archive
+	}))
+	mux.HandleFunc("/chat/delete", s.handleChatSetting(func(chat *sqllite.ImChat, setting *chatSetting) {
+		chat.ChatDel = true
+		chat.DelTimestamp = max(chat.DelTimestamp, setting.DelTimestamp)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fault := s.currentHTTPFault(r.URL.Path); fault != nil {
+			if fault.Delay > 0 {
+				time.Sleep(fault.Delay)
+			}
+			if fault.Disconnect {
+				if hijacker, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hijacker.Hijack(); err == nil {
+						conn.Close()
+						return
+					}
+				}
+			}
+			if fault.Drop || fault.Disconnect {
+				http.Error(w, "fault injected", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if !s.checkToken(r) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleIpList(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, []string{s.Addr()})
+}
+
+// userSyncResult 增量同步用户的一页, 与 im/http.UserSyncResult 一致
+type userSyncResult struct {
+	Users   []*sqllite.ImUser `json:"users"`
+	Version int64             `json:"version"`
+	HasMore bool              `json:"hasMore"`
+}
+
+// handleSyncUser 返回 version 之后修改过的用户, 按版本升序分页
+func (s *Server) handleSyncUser(w http.ResponseWriter, r *http.Request) {
+	s.userRequests.Add(1)
+	version, err := queryInt(r, "version")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil || limit <= 0 {
+		writeError(w, fmt.Errorf("invalid limit"))
+		return
+	}
+	changed := make([]*sqllite.ImUser, 0)
+	for _, user := range s.userList() {
+		if user.Version > version {
+			changed = append(changed, user)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Version < changed[j].Version
+	})
+	result := &userSyncResult{Users: changed, Version: version}
+	if int64(len(changed)) > limit {
+		result.Users = changed[:limit]
+		result.HasMore = true
+	}
+	if len(result.Users) > 0 {
+		result.Version = result.Users[len(result.Users)-1].Version
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleBatchGetUser(w http.ResponseWriter, r *http.Request) {
+	s.userRequests.Add(1)
+	users := make([]*sqllite.ImUser, 0)
+	s.mu.Lock()
+	for _, field := range strings.Split(r.URL.Query().Get("userIds"), ",") {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		if user, ok := s.users[id]; ok {
+			u := *user
+			users = append(users, &u)
+		}
+	}
+	s.mu.Unlock()
+	writeResult(w, users)
+}
+
+func (s *Server) handleGetAllChat(w http.ResponseWriter, r *http.Request) {
+	userId, err := queryInt(r, "userId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, s.chatList(userId))
+}
+
+func (s *Server) handleLastMessage(w http.ResponseWriter, r *http.Request) {
+	userId, chatId, chatType, err := queryChat(r, "userId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	msgs := s.Messages(userId, chatId, chatType)
+	if len(msgs) == 0 {
+		writeError(w, fmt.Errorf("message not found"))
+		return
+	}
+	writeResult(w, msgs[len(msgs)-1])
+}
+
+func (s *Server) handlePullOfflineMsg(w http.ResponseWriter, r *http.Request) {
+	userId, chatId, chatType, err := queryChat(r, "fromUserId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	minSeq, err := queryInt(r, "minServerSeq")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	maxSeq, err := queryInt(r, "maxServerSeq")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := make([]*sqllite.ChatMessage, 0)
+	for _, msg := range s.Messages(userId, chatId, chatType) {
+		if msg.ServerSeq >= minSeq && msg.ServerSeq <= maxSeq {
+			result = append(result, msg)
+		}
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleGetLatestOfflineMessages(w http.ResponseWriter, r *http.Request) {
+	userId, chatId, chatType, err := queryChat(r, "fromUserId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	size, err := queryInt(r, "size")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	msgs := s.Messages(userId, chatId, chatType)
+	if int64(len(msgs)) > size {
+		msgs = msgs[int64(len(msgs))-size:]
+	}
+	writeResult(w, msgs)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	name := fmt.Sprintf("/file/%d/%s", len(s.files)+1, path.Base(header.Filename))
+	s.files[name] = data
+	s.mu.Unlock()
+	writeResult(w, s.URL()+name)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+func queryChat(r *http.Request, userKey string) (userId, chatId int64, chatType int32, err error) {
+	if userId, err = queryInt(r, userKey); err != nil {
+		return
+	}
+	if chatId, err = queryInt(r, "chatId"); err != nil {
+		return
+	}
+	var t int64
+	t, err = queryInt(r, "chatType")
+	return userId, chatId, int32(t), err
+}
+
+func queryInt(r *http.Request, key string) (int64, error) {
+	value, err := strconv.ParseInt(r.URL.Query().Get(key), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", key, r.URL.Query().Get(key))
+	}
+	return value, nil
+}
+
+// handleUploadPublicKey 保存用户的身份公钥, 只是转发公钥, 不能解密消息
+func (s *Server) handleUploadPublicKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserId    int64  `json:"userId"`
+		PublicKey []byte `json:"publicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.SetPublicKey(req.UserId, req.PublicKey)
+	writeResult[any](w, nil)
+}
+
+func (s *Server) handleGetPublicKey(w http.ResponseWriter, r *http.Request) {
+	userId, err := queryInt(r, "userId")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, s.PublicKey(userId))
+}
+
+func writeResult[T any](w http.ResponseWriter, data T) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pkg.RestResult[T]{Code: 0, Data: data, Msg: "success"})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pkg.RestResult[any]{Code: 1, Msg: err.Error()})
+}
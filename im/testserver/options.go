@@ -0,0 +1,105 @@
+package testserver
+
+import (
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/protocol"
+)
+
+// AuthFunc 校验认证请求, 返回 false 时认证失败
+type AuthFunc func(uid int64, userType int32, token string) bool
+
+// Options 服务端配置
+type Options struct {
+	Users      []*sqllite.ImUser // 初始的用户
+	Auth       AuthFunc          // 认证校验, 默认全部通过
+	FirstMsgId int64             // 分配的第一个 msgId 之前的值
+	// MaxHeaderVersion 支持的最高消息头版本, 为 protocol.Version1 时模拟旧的服务端
+	MaxHeaderVersion byte
+	// Compressions 支持的压缩算法, 为空时模拟不支持压缩的服务端
+	Compressions []protocol.Compression
+	// CompressionThreshold 回复和推送的消息体超过该长度时压缩
+	CompressionThreshold int
+	// Login 登录时校验用户名和密码, 为 nil 时用户名存在即可登录
+	Login LoginFunc
+	// RequireToken 长连接认证和 WebAPI 都需要登录签发的 access token
+	RequireToken bool
+	// TokenTTL access token 的有效期
+	TokenTTL time.Duration
+	// SingleDevice 同一个账号只允许一个设备在线, 新设备登录时踢掉其他设备
+	SingleDevice bool
+}
+
+func NewOptions() *Options {
+	return &Options{
+		Auth:                 func(uid int64, userType int32, token string) bool { return true },
+		FirstMsgId:           10000,
+		MaxHeaderVersion:     protocol.CurrentVersion,
+		Compressions:         []protocol.Compression{protocol.CompressionZstd, protocol.CompressionSnappy},
+		CompressionThreshold: protocol.DefaultCompressionThreshold,
+		TokenTTL:             time.Hour,
+	}
+}
+
+type Option func(opt *Options)
+
+func WithUsers(users ...*sqllite.ImUser) Option {
+	return func(opt *Options) {
+		opt.Users = append(opt.Users, users...)
+	}
+}
+
+func WithAuth(auth AuthFunc) Option {
+	return func(opt *Options) {
+		opt.Auth = auth
+	}
+}
+
+func WithFirstMsgId(msgId int64) Option {
+	return func(opt *Options) {
+		opt.FirstMsgId = msgId
+	}
+}
+
+func WithMaxHeaderVersion(version byte) Option {
+	return func(opt *Options) {
+		opt.MaxHeaderVersion = version
+	}
+}
+
+func WithCompressions(compressions ...protocol.Compression) Option {
+	return func(opt *Options) {
+		opt.Compressions = compressions
+	}
+}
+
+func WithCompressionThreshold(threshold int) Option {
+	return func(opt *Options) {
+		opt.CompressionThreshold = threshold
+	}
+}
+
+func WithLogin(login LoginFunc) Option {
+	return func(opt *Options) {
+		opt.Login = login
+	}
+}
+
+func WithRequireToken(require bool) Option {
+	return func(opt *Options) {
+		opt.RequireToken = require
+	}
+}
+
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(opt *Options) {
+		opt.TokenTTL = ttl
+	}
+}
+
+func WithSingleDevice(single bool) Option {
+	return func(opt *Options) {
+		opt.SingleDevice = single
+	}
+}
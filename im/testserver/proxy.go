@@ -0,0 +1,359 @@
+package testserver
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Direction 数据的流向
+type Direction int
+
+const (
+	Upstream   Direction = iota // 客户端到服务端
+	Downstream                  // 服务端到客户端
+)
+
+// Impairment 对一个方向注入的网络损伤, 零值表示原样转发
+type Impairment struct {
+	Latency     time.Duration // 每个数据块转发前的延迟
+	Bandwidth   int           // 每秒转发的字节数, 0 表示不限制
+	DropRate    float64       // 丢弃数据块的概率
+	ReorderRate float64       // 数据块和下一个数据块交换顺序的概率
+	SplitSize   int           // 数据块拆分成不超过 SplitSize 字节分多次写出, 模拟半包
+	Blackhole   bool          // 读取后直接丢弃, 模拟半开连接
+}
+
+// reorderHold 等待交换顺序的数据块最多等待的时间, 超时后原样写出
+const reorderHold = 50 * time.Millisecond
+
+// Step 故障计划中的一步, 在上一步执行 After 之后执行 Do
+type Step struct {
+	After time.Duration
+	Do    func(p *Proxy)
+}
+
+// Proxy 在客户端和服务端之间转发 TCP 数据并注入网络故障, 用于混沌测试
+type Proxy struct {
+	target string
+	addr   string
+
+	mu       sync.Mutex
+	listener net.Listener
+	links    map[*link]struct{}
+
+	impairments [2]atomic.Pointer[Impairment]
+	rngMu       sync.Mutex
+	rng         *rand.Rand
+
+	accepted atomic.Int64
+	closed   atomic.Bool
+	wg       sync.WaitGroup
+}
+
+// link 一对客户端和服务端的连接
+type link struct {
+	client net.Conn
+	server net.Conn
+	once   sync.Once
+}
+
+// NewProxy 构造转发到 target 的代理, 监听在 127.0.0.1 的随机端口上
+func NewProxy(target string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		target:   target,
+		addr:     listener.Addr().String(),
+		listener: listener,
+		links:    make(map[*link]struct{}),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.wg.Add(1)
+	go p.acceptLoop(listener)
+	return p, nil
+}
+
+// Addr 代理的监听地址
+func (p *Proxy) Addr() string {
+	return p.addr
+}
+
+// Accepted 累计接受的连接数
+func (p *Proxy) Accepted() int64 {
+	return p.accepted.Load()
+}
+
+// Connections 当前转发中的连接数
+func (p *Proxy) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.links)
+}
+
+// Seed 设置随机数种子, 使丢弃和乱序可以复现
+func (p *Proxy) Seed(seed int64) {
+	p.rngMu.Lock()
+	defer p.rngMu.Unlock()
+	p.rng = rand.New(rand.NewSource(seed))
+}
+
+// SetImpairment 设置 dir 方向的网络损伤, 对已有的连接立即生效
+func (p *Proxy) SetImpairment(dir Direction, imp Impairment) {
+	p.impairments[dir].Store(&imp)
+}
+
+// ClearImpairments 取消所有网络损伤
+func (p *Proxy) ClearImpairments() {
+	p.SetImpairment(Upstream, Impairment{})
+	p.SetImpairment(Downstream, Impairment{})
+}
+
+// ResetAll 以 RST 断开当前所有连接
+func (p *Proxy) ResetAll() {
+	for _, l := range p.snapshot() {
+		l.reset()
+	}
+}
+
+// CloseAll 正常关闭当前所有连接
+func (p *Proxy) CloseAll() {
+	for _, l := range p.snapshot() {
+		l.close()
+	}
+}
+
+// SetRefuse 为 true 时停止监听, 新的连接会被拒绝; 为 false 时在原地址上恢复监听
+func (p *Proxy) SetRefuse(refuse bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed.Load() {
+		return errors.New("proxy closed")
+	}
+	if refuse {
+		if p.listener != nil {
+			p.listener.Close()
+			p.listener = nil
+		}
+		return nil
+	}
+	if p.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	p.wg.Add(1)
+	go p.acceptLoop(listener)
+	return nil
+}
+
+// Schedule 后台执行中的故障计划
+type Schedule struct {
+	done     chan struct{}
+	finished chan struct{}
+	once     sync.Once
+}
+
+// Wait 等待计划执行完成
+func (s *Schedule) Wait() {
+	<-s.finished
+}
+
+// Stop 提前终止计划并等待正在执行的一步结束
+func (s *Schedule) Stop() {
+	s.once.Do(func() { close(s.done) })
+	<-s.finished
+}
+
+// RunSchedule 在后台按顺序执行故障计划
+func (p *Proxy) RunSchedule(steps ...Step) *Schedule {
+	schedule := &Schedule{done: make(chan struct{}), finished: make(chan struct{})}
+	go func() {
+		defer close(schedule.finished)
+		for _, step := range steps {
+			timer := time.NewTimer(step.After)
+			select {
+			case <-schedule.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			step.Do(p)
+		}
+	}()
+	return schedule
+}
+
+// Close 停止监听并断开所有连接
+func (p *Proxy) Close() {
+	if !p.closed.CompareAndSwap(false, true) {
+		return
+	}
+	p.mu.Lock()
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+	p.mu.Unlock()
+	p.CloseAll()
+	p.wg.Wait()
+}
+
+func (p *Proxy) acceptLoop(listener net.Listener) {
+	defer p.wg.Done()
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		p.accepted.Add(1)
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		l := &link{client: client, server: server}
+		p.mu.Lock()
+		p.links[l] = struct{}{}
+		p.mu.Unlock()
+		if p.closed.Load() {
+			l.close()
+		}
+		p.wg.Add(2)
+		go p.pipe(l, Upstream, client, server)
+		go p.pipe(l, Downstream, server, client)
+	}
+}
+
+// pipe 把 src 的数据按 dir 方向的网络损伤转发到 dst
+func (p *Proxy) pipe(l *link, dir Direction, src, dst net.Conn) {
+	defer p.wg.Done()
+	defer p.remove(l)
+	buf := make([]byte, 32*1024)
+	var pending []byte
+	for {
+		if pending != nil {
+			src.SetReadDeadline(time.Now().Add(reorderHold))
+		} else {
+			src.SetReadDeadline(time.Time{})
+		}
+		n, err := src.Read(buf)
+		if err != nil {
+			if pending != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+				if p.write(dir, dst, pending) != nil {
+					return
+				}
+				pending = nil
+				continue
+			}
+			if err != io.EOF {
+				l.reset()
+			}
+			l.close()
+			return
+		}
+		chunk := append([]byte(nil), buf[:n]...)
+		imp := p.impairment(dir)
+		if imp.Blackhole || p.chance(imp.DropRate) {
+			continue
+		}
+		if pending == nil && p.chance(imp.ReorderRate) {
+			pending = chunk
+			continue
+		}
+		if p.write(dir, dst, chunk) != nil {
+			return
+		}
+		if pending != nil {
+			if p.write(dir, dst, pending) != nil {
+				return
+			}
+			pending = nil
+		}
+	}
+}
+
+// write 按延迟、带宽和拆包设置写出一个数据块
+func (p *Proxy) write(dir Direction, dst net.Conn, chunk []byte) error {
+	imp := p.impairment(dir)
+	if imp.Latency > 0 {
+		time.Sleep(imp.Latency)
+	}
+	size := len(chunk)
+	if imp.SplitSize > 0 && imp.SplitSize < size {
+		size = imp.SplitSize
+	}
+	for len(chunk) > 0 {
+		n := size
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if imp.Bandwidth > 0 {
+			time.Sleep(time.Duration(n) * time.Second / time.Duration(imp.Bandwidth))
+		}
+		if _, err := dst.Write(chunk[:n]); err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+	}
+	return nil
+}
+
+func (p *Proxy) impairment(dir Direction) Impairment {
+	if imp := p.impairments[dir].Load(); imp != nil {
+		return *imp
+	}
+	return Impairment{}
+}
+
+func (p *Proxy) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	p.rngMu.Lock()
+	defer p.rngMu.Unlock()
+	return p.rng.Float64() < rate
+}
+
+func (p *Proxy) snapshot() []*link {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	links := make([]*link, 0, len(p.links))
+	for l := range p.links {
+		links = append(links, l)
+	}
+	return links
+}
+
+func (p *Proxy) remove(l *link) {
+	p.mu.Lock()
+	delete(p.links, l)
+	p.mu.Unlock()
+}
+
+// reset 设置 SO_LINGER 为 0 后关闭, 对端收到 RST
+func (l *link) reset() {
+	for _, conn := range []net.Conn{l.client, l.server} {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+	}
+	l.close()
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		l.client.Close()
+		l.server.Close()
+	})
+}
@@ -0,0 +1,366 @@
+// Package testserver 进程内的 IM 服务端, 实现了长连接的帧协议和 WebAPI, 数据保存在内存中,
+// 用于在没有真实服务端的环境下测试 SDK
+package testserver
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/protocol"
+)
+
+// Fault 注入的故障
+type Fault struct {
+	Delay      time.Duration // 处理请求前等待的时间
+	Drop       bool          // 丢弃请求, 不做任何回复
+	Disconnect bool          // 断开连接
+}
+
+// FaultFunc 根据长连接收到的帧决定注入的故障, 返回 nil 表示正常处理
+type FaultFunc func(uid int64, header *protocol.MsgHeader) *Fault
+
+// HTTPFaultFunc 根据 WebAPI 的请求路径决定注入的故障, 返回 nil 表示正常处理
+type HTTPFaultFunc func(path string) *Fault
+
+// Server 进程内的 IM 服务端
+type Server struct {
+	opts *Options
+
+	listener net.Listener
+	http     *httptest.Server
+
+	mu            sync.Mutex
+	users         map[int64]*sqllite.ImUser
+	groups        map[int64][]int64
+	conversations map[conversationKey][]*sqllite.ChatMessage
+	chats         map[int64]map[conversationKey]*sqllite.ImChat
+	conns         map[*serverConn]struct{}
+	files         map[string][]byte
+	publicKeys    map[int64][]byte
+	tokens        map[string]*token
+	// contacts uid 的联系人, key 为联系人的 uid
+	contacts       map[int64]map[int64]*sqllite.Contact
+	friendRequests map[int64]*sqllite.FriendRequest
+
+	fault     atomic.Value // FaultFunc
+	httpFault atomic.Value // HTTPFaultFunc
+
+	msgId    atomic.Int64
+	pushSeq  atomic.Int32
+	received atomic.Int64
+	pushAcks atomic.Int64
+	// compressed 收到的压缩过的帧数
+	compressed atomic.Int64
+	tokenSeq   atomic.Int64
+	requestSeq atomic.Int64
+	// userVersion 用户资料的版本, 修改用户时递增
+	userVersion  atomic.Int64
+	userRequests atomic.Int64
+	refreshes    atomic.Int64
+
+	closed atomic.Bool
+	wg     sync.WaitGroup
+}
+
+// conversationKey 单聊的双方共用一个会话, a < b; 群聊 a 为群id, b 为 0
+type conversationKey struct {
+	a, b     int64
+	chatType int32
+}
+
+func newConversationKey(from, chatId int64, chatType int32) conversationKey {
+	if chatType != 1 {
+		return conversationKey{a: chatId, chatType: chatType}
+	}
+	if from > chatId {
+		from, chatId = chatId, from
+	}
+	return conversationKey{a: from, b: chatId, chatType: chatType}
+}
+
+// peer 会话对于 uid 来说的 chatId
+func (k conversationKey) peer(uid int64) int64 {
+	if k.chatType != 1 {
+		return k.a
+	}
+	if k.a == uid {
+		return k.b
+	}
+	return k.a
+}
+
+// New 构造服务端, 需要调用 Start 启动
+func New(opts ...Option) *Server {
+	options := NewOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	s := &Server{
+		opts:           options,
+		users:          make(map[int64]*sqllite.ImUser),
+		groups:         make(map[int64][]int64),
+		conversations:  make(map[conversationKey][]*sqllite.ChatMessage),
+		chats:          make(map[int64]map[conversationKey]*sqllite.ImChat),
+		conns:          make(map[*serverConn]struct{}),
+		files:          make(map[string][]byte),
+		publicKeys:     make(map[int64][]byte),
+		tokens:         make(map[string]*token),
+		contacts:       make(map[int64]map[int64]*sqllite.Contact),
+		friendRequests: make(map[int64]*sqllite.FriendRequest),
+	}
+	s.msgId.Store(options.FirstMsgId)
+	for _, user := range options.Users {
+		s.AddUser(user)
+	}
+	return s
+}
+
+// Start 启动长连接和 WebAPI 服务, 都监听在 127.0.0.1 的随机端口上
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.http = httptest.NewServer(s.httpHandler())
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+// Close 关闭服务端并断开所有连接
+func (s *Server) Close() {
+	if !s.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.DisconnectAll()
+	s.wg.Wait()
+	if s.http != nil {
+		s.http.Close()
+	}
+}
+
+// Addr 长连接的地址
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL WebAPI 的地址
+func (s *Server) URL() string {
+	return s.http.URL
+}
+
+// SetFault 设置长连接的故障注入, nil 表示取消
+func (s *Server) SetFault(f FaultFunc) {
+	s.fault.Store(f)
+}
+
+// SetHTTPFault 设置 WebAPI 的故障注入, nil 表示取消
+func (s *Server) SetHTTPFault(f HTTPFaultFunc) {
+	s.httpFault.Store(f)
+}
+
+// AddUser 添加或者修改用户, 每次调用都分配新的版本, 用于增量同步
+func (s *Server) AddUser(user *sqllite.ImUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := *user
+	u.Version = s.userVersion.Add(1)
+	s.users[user.UserID] = &u
+}
+
+// UserVersion 用户资料的最新版本
+func (s *Server) UserVersion() int64 {
+	return s.userVersion.Load()
+}
+
+// UserRequests 增量同步和批量获取用户资料的请求数
+func (s *Server) UserRequests() int64 {
+	return s.userRequests.Load()
+}
+
+// AddGroup 添加群以及群成员
+func (s *Server) AddGroup(groupId int64, members ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[groupId] = append([]int64(nil), members...)
+}
+
+// Online uid 是否有认证通过的连接
+func (s *Server) Online(uid int64) bool {
+	return len(s.connsOf(uid)) > 0
+}
+
+// Disconnect 断开 uid 的所有连接
+func (s *Server) Disconnect(uid int64) {
+	for _, conn := range s.connsOf(uid) {
+		conn.close()
+	}
+}
+
+// DisconnectAll 断开所有连接
+func (s *Server) DisconnectAll() {
+	s.mu.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+// Received 收到的请求帧数
+func (s *Server) Received() int64 {
+	return s.received.Load()
+}
+
+// PushAcks 收到的推送 ACK 数
+func (s *Server) PushAcks() int64 {
+	return s.pushAcks.Load()
+}
+
+// SetPublicKey 设置用户的身份公钥, 测试中可以用来模拟服务端替换公钥
+func (s *Server) SetPublicKey(uid int64, publicKey []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publicKeys[uid] = publicKey
+}
+
+// PublicKey 用户上传的身份公钥, 没有上传时返回 nil
+func (s *Server) PublicKey(uid int64) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publicKeys[uid]
+}
+
+// CompressedFrames 收到的压缩过的帧数
+func (s *Server) CompressedFrames() int64 {
+	return s.compressed.Load()
+}
+
+// Messages uid 视角下的会话消息, 按 ServerSeq 升序
+func (s *Server) Messages(uid, chatId int64, chatType int32) []*sqllite.ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := newConversationKey(uid, chatId, chatType)
+	return s.copyMessages(uid, key, s.conversations[key])
+}
+
+// storeMessage 保存消息, 分配 msgId 和 ServerSeq, 返回消息和需要推送的用户
+func (s *Server) storeMessage(msg *sqllite.ChatMessage) (*sqllite.ChatMessage, []int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var receivers []int64
+	if msg.ChatType == 1 {
+		receivers = []int64{msg.MsgTo}
+	} else {
+		members, ok := s.groups[msg.ChatID]
+		if !ok {
+			return nil, nil, errors.New("group not found")
+		}
+		for _, member := range members {
+			if member != msg.MsgFrom {
+				receivers = append(receivers, member)
+			}
+		}
+	}
+	key := newConversationKey(msg.MsgFrom, msg.ChatID, msg.ChatType)
+	msg.MsgID = s.msgId.Add(1)
+	msg.ServerSeq = int64(len(s.conversations[key])) + 1
+	s.conversations[key] = append(s.conversations[key], msg)
+	now := time.Now().UnixMilli()
+	for _, uid := range append([]int64{msg.MsgFrom}, receivers...) {
+		if s.chats[uid] == nil {
+			s.chats[uid] = make(map[conversationKey]*sqllite.ImChat)
+		}
+		chat := s.chats[uid][key]
+		if chat == nil {
+			chat = sqllite.NewImChat(uid, key.peer(uid), key.chatType)
+			s.chats[uid][key] = chat
+		}
+		chat.UpdateTimestamp = now
+		chat.ChatDel = false
+		if uid == msg.MsgFrom {
+			chat.LastReadMsgId = msg.MsgID
+		}
+	}
+	return msg, receivers, nil
+}
+
+// copyMessages 复制消息, 单聊的 ChatID 改为 uid 视角下的对方
+func (s *Server) copyMessages(uid int64, key conversationKey, msgs []*sqllite.ChatMessage) []*sqllite.ChatMessage {
+	result := make([]*sqllite.ChatMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		m := *msg
+		m.ChatID = key.peer(uid)
+		result = append(result, &m)
+	}
+	return result
+}
+
+func (s *Server) chatList(uid int64) []*sqllite.ImChat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chats := make([]*sqllite.ImChat, 0, len(s.chats[uid]))
+	for _, chat := range s.chats[uid] {
+		c := *chat
+		chats = append(chats, &c)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].UpdateTimestamp > chats[j].UpdateTimestamp
+	})
+	return chats
+}
+
+func (s *Server) userList() []*sqllite.ImUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]*sqllite.ImUser, 0, len(s.users))
+	for _, user := range s.users {
+		u := *user
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+func (s *Server) connsOf(uid int64) []*serverConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var conns []*serverConn
+	for conn := range s.conns {
+		if conn.uid.Load() == uid && conn.authed.Load() {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+func (s *Server) currentFault(uid int64, header *protocol.MsgHeader) *Fault {
+	f, _ := s.fault.Load().(FaultFunc)
+	if f == nil {
+		return nil
+	}
+	return f(uid, header)
+}
+
+func (s *Server) currentHTTPFault(path string) *Fault {
+	f, _ := s.httpFault.Load().(HTTPFaultFunc)
+	if f == nil {
+		return nil
+	}
+	return f(path)
+}
@@ -0,0 +1,155 @@
+package testserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LoginFunc 校验用户名和密码, 返回用户ID
+type LoginFunc func(userName, password string) (int64, bool)
+
+// token 服务端签发的 token, access token 和 refresh token 都保存在 tokens 中
+type token struct {
+	uid       int64
+	refresh   bool
+	expiresAt time.Time
+}
+
+// issueToken 签发一对新的 token
+func (s *Server) issueToken(uid int64) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.tokenSeq.Add(1)
+	access, refresh := fmt.Sprintf("at-%d-%d", uid, n), fmt.Sprintf("rt-%d-%d", uid, n)
+	expiresAt := time.Now().Add(s.opts.TokenTTL)
+	s.tokens[access] = &token{uid: uid, expiresAt: expiresAt}
+	s.tokens[refresh] = &token{uid: uid, refresh: true, expiresAt: time.Now().Add(24 * time.Hour)}
+	userName := ""
+	if user, ok := s.users[uid]; ok {
+		userName = user.UserName
+	}
+	return map[string]any{
+		"userId":       uid,
+		"userName":     userName,
+		"accessToken":  access,
+		"refreshToken": refresh,
+		"expiresAt":    expiresAt.UnixMilli(),
+	}
+}
+
+// validToken access token 有效时返回所属的用户
+func (s *Server) validToken(value string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[value]
+	if !ok || t.refresh || time.Now().After(t.expiresAt) {
+		return 0, false
+	}
+	return t.uid, true
+}
+
+// ExpireAccessTokens 让所有的 access token 过期, 用于测试刷新
+func (s *Server) ExpireAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if !t.refresh {
+			t.expiresAt = time.Time{}
+		}
+	}
+}
+
+// RevokeTokens 吊销 uid 的所有 token, 客户端需要重新登录
+func (s *Server) RevokeTokens(uid int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for value, t := range s.tokens {
+		if t.uid == uid {
+			delete(s.tokens, value)
+		}
+	}
+}
+
+// Refreshes 刷新 token 的次数
+func (s *Server) Refreshes() int64 {
+	return s.refreshes.Load()
+}
+
+// defaultLogin 用户名存在即可登录, 不校验密码
+func (s *Server) defaultLogin(userName, password string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.UserName == userName {
+			return user.UserID, true
+		}
+	}
+	return 0, false
+}
+
+// checkToken 开启 RequireToken 时校验 WebAPI 请求的 Authorization, 登录和刷新的接口除外
+func (s *Server) checkToken(r *http.Request) bool {
+	if !s.opts.RequireToken || strings.HasPrefix(r.URL.Path, "/auth/") {
+		return true
+	}
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	_, ok = s.validToken(value)
+	return ok
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserName string `json:"userName"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err)
+		return
+	}
+	login := s.opts.Login
+	if login == nil {
+		login = s.defaultLogin
+	}
+	uid, ok := login(req.UserName, req.Password)
+	if !ok {
+		http.Error(w, "invalid user name or password", http.StatusUnauthorized)
+		return
+	}
+	writeResult(w, s.issueToken(uid))
+}
+
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err)
+		return
+	}
+	uid, err := s.useRefreshToken(req.RefreshToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	s.refreshes.Add(1)
+	writeResult(w, s.issueToken(uid))
+}
+
+// useRefreshToken refresh token 只能使用一次
+func (s *Server) useRefreshToken(value string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[value]
+	if !ok || !t.refresh || time.Now().After(t.expiresAt) {
+		return 0, errors.New("invalid refresh token")
+	}
+	delete(s.tokens, value)
+	return t.uid, nil
+}
@@ -0,0 +1,55 @@
+package im
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	http2 "github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/transport"
+)
+
+// TokenSource 提供长连接认证和 WebAPI 请求使用的 access token, auth.Manager 实现了该接口
+type TokenSource interface {
+	AccessToken() string
+	// Refresh 认证失败或者 WebAPI 返回 401 时刷新 token, stale 为被拒绝的 token
+	Refresh(ctx context.Context, stale string) error
+}
+
+// staticToken WithToken 设置的固定 token, 不能刷新
+type staticToken string
+
+func (t staticToken) AccessToken() string { return string(t) }
+
+func (t staticToken) Refresh(ctx context.Context, stale string) error {
+	return errors.New("static token can not be refreshed")
+}
+
+// setupTokens 长连接和 WebAPI 使用同一个 TokenSource, 刷新后长连接重新认证
+func (c *Client) setupTokens(tr *transport.Client) {
+	ts := c.opts.TokenSource
+	if ts == nil && c.opts.Token != "" {
+		ts = staticToken(c.opts.Token)
+	}
+	if ts == nil {
+		http2.SetTokenSource(nil)
+		return
+	}
+	http2.SetTokenSource(ts)
+	tr.SetTokenSource(ts)
+	if notifier, ok := ts.(interface{ OnRefresh(func()) }); ok {
+		notifier.OnRefresh(func() { go c.reauth(tr) })
+	}
+}
+
+// reauth token 刷新后在当前连接上重新认证, 没有连接时下次连接会使用新的 token
+func (c *Client) reauth(tr *transport.Client) {
+	if tr.State() != transport.StateConnected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Reauth(ctx); err != nil {
+		c.events.publish(ErrorEvent{Err: err})
+	}
+}
@@ -27,13 +27,33 @@ func (r *AuthResponse) MsgSeq() int32 { return r.f.Header.Seq }
 func NewAuthRequest(userId int64, userType int32, token string) *AuthRequest {
 	return &AuthRequest{
 		AuthRequest: &helloim_proto.AuthRequest{
-			Uid:      fmt.Sprintf("%d", userId),
-			UserType: userType,
-			Token:    token,
+			Uid:             fmt.Sprintf("%d", userId),
+			UserType:        userType,
+			Token:           token,
+			ProtocolVersion: int32(protocol.CurrentVersion),
 		},
 	}
 }
 
+// withDevice 设置设备信息, 服务端据此区分同一个账号的多个设备
+func (r *AuthRequest) withDevice(deviceId, platform string) {
+	r.DeviceId = deviceId
+	r.Platform = platform
+}
+
+// offerCompressions 按优先级提供客户端支持的压缩算法
+func (r *AuthRequest) offerCompressions(compressions []protocol.Compression) {
+	r.Compressions = r.Compressions[:0]
+	for _, c := range compressions {
+		r.Compressions = append(r.Compressions, helloim_proto.CompressionType(c))
+	}
+}
+
+// selectedCompression 服务端选择的压缩算法, 旧的服务端不返回时为 CompressionNone
+func (r *AuthResponse) selectedCompression() protocol.Compression {
+	return protocol.Compression(r.GetCompression())
+}
+
 func decodeAuth(frame *protocol.Frame) (protocol.Message, error) {
 	resp := &helloim_proto.AuthResponse{}
 	if err := proto.Unmarshal(frame.Body, resp); err != nil {
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/http"
+	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/testserver"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+	"go.uber.org/goleak"
+)
+
+// convergeTimeout 故障结束之后客户端恢复连接的最长时间
+const convergeTimeout = 15 * time.Second
+
+type staticAddrProvider []string
+
+func (p staticAddrProvider) GetAddr(ctx context.Context) ([]string, error) {
+	return p, nil
+}
+
+// verifyNoLeaks 测试结束时检查 goroutine 和 gnet 客户端泄漏, 需要在测试开始时调用, 保证最后执行
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	ignore := goleak.IgnoreCurrent()
+	t.Cleanup(func() {
+		assert.Eventually(t, func() bool { return liveClients.Load() == 0 }, 5*time.Second, 10*time.Millisecond,
+			"gnet client leaked: %d", liveClients.Load())
+		// lumberjack 在第一次写日志时才启动整理旧日志的 goroutine
+		goleak.VerifyNone(t, ignore, goleak.IgnoreTopFunction("gopkg.in/natefinch/lumberjack%2ev2.(*Logger).millRun"))
+	})
+}
+
+// startChaosClient 通过 addrs 连接到测试服务端
+func startChaosClient(t *testing.T, addrs ...string) *Client {
+	t.Helper()
+	client := NewClient(testDispatch, staticAddrProvider(addrs), getSeq)
+	t.Cleanup(client.Close)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func newChaosProxy(t *testing.T) *testserver.Proxy {
+	t.Helper()
+	proxy, err := testserver.NewProxy(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Seed(1)
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// assertConverged 客户端恢复到 StateConnected 并且可以正常收发消息
+func assertConverged(t *testing.T, client *Client) {
+	t.Helper()
+	converged := assert.Eventually(t, func() bool {
+		if client.State() != StateConnected {
+			return false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		resp, err := client.Send(ctx, buildMsg(0, 1))
+		_, ok := resp.(*send.SendAck)
+		return err == nil && ok
+	}, convergeTimeout, 50*time.Millisecond)
+	if converged {
+		assert.Equal(t, int32(1), liveClients.Load())
+	}
+}
+
+func TestChaos_ResetConverges(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	client := startChaosClient(t, proxy.Addr())
+	assertConverged(t, client)
+
+	proxy.RunSchedule(
+		testserver.Step{After: 100 * time.Millisecond, Do: (*testserver.Proxy).ResetAll},
+		testserver.Step{After: 800 * time.Millisecond, Do: (*testserver.Proxy).ResetAll},
+		testserver.Step{After: 10 * time.Millisecond, Do: (*testserver.Proxy).CloseAll},
+		testserver.Step{After: 1500 * time.Millisecond, Do: (*testserver.Proxy).ResetAll},
+	).Wait()
+	assertConverged(t, client)
+	assert.Greater(t, proxy.Accepted(), int64(1))
+}
+
+func TestChaos_SlowLinkAndSplitFrames(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	for _, dir := range []testserver.Direction{testserver.Upstream, testserver.Downstream} {
+		proxy.SetImpairment(dir, testserver.Impairment{
+			Latency:   5 * time.Millisecond,
+			Bandwidth: 64 * 1024,
+			SplitSize: 3,
+		})
+	}
+	client := startChaosClient(t, proxy.Addr())
+	for i := 0; i < 20; i++ {
+		resp, err := client.Send(context.Background(), buildMsg(i, 1))
+		if assert.Nil(t, err) {
+			_, ok := resp.(*send.SendAck)
+			assert.True(t, ok)
+		}
+	}
+	assertConverged(t, client)
+}
+
+func TestChaos_HalfOpenThenReset(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	client := startChaosClient(t, proxy.Addr())
+
+	// 半开连接: 服务端的回复都被丢弃, 发送只能超时
+	proxy.SetImpairment(testserver.Downstream, testserver.Impairment{Blackhole: true})
+	_, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.NotNil(t, err)
+
+	proxy.ClearImpairments()
+	proxy.ResetAll()
+	assertConverged(t, client)
+}
+
+func TestChaos_RefuseWhileReconnecting(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	client := startChaosClient(t, proxy.Addr())
+
+	assert.Nil(t, proxy.SetRefuse(true))
+	proxy.ResetAll()
+	assert.Eventually(t, func() bool { return client.State() != StateConnected }, time.Second, 10*time.Millisecond)
+	// 多次重连都被拒绝之后恢复监听
+	time.Sleep(1200 * time.Millisecond)
+	assert.Nil(t, proxy.SetRefuse(false))
+	assertConverged(t, client)
+}
+
+func TestChaos_CorruptedStream(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	client := startChaosClient(t, proxy.Addr())
+
+	schedule := proxy.RunSchedule(
+		testserver.Step{After: 0, Do: func(p *testserver.Proxy) {
+			p.SetImpairment(testserver.Upstream, testserver.Impairment{DropRate: 0.2, ReorderRate: 0.3, SplitSize: 5})
+			p.SetImpairment(testserver.Downstream, testserver.Impairment{DropRate: 0.2, ReorderRate: 0.3})
+		}},
+		testserver.Step{After: 500 * time.Millisecond, Do: (*testserver.Proxy).ResetAll},
+		testserver.Step{After: 500 * time.Millisecond, Do: func(p *testserver.Proxy) {
+			p.ClearImpairments()
+			p.ResetAll()
+		}},
+	)
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		client.Send(ctx, buildMsg(i, 1))
+		cancel()
+	}
+	schedule.Wait()
+	assertConverged(t, client)
+}
+
+func TestChaos_SlaveAfterMasterAuthFails(t *testing.T) {
+	verifyNoLeaks(t)
+	master := newChaosProxy(t)
+	slave := newChaosProxy(t)
+	// master 可以建立连接, 但认证请求没有回复
+	master.SetImpairment(testserver.Upstream, testserver.Impairment{Blackhole: true})
+	client := startChaosClient(t, master.Addr(), slave.Addr())
+	assertConverged(t, client)
+	assert.Equal(t, 1, slave.Connections())
+}
+
+func TestChaos_CloseWhileReconnecting(t *testing.T) {
+	verifyNoLeaks(t)
+	proxy := newChaosProxy(t)
+	client := startChaosClient(t, proxy.Addr())
+	proxy.ResetAll()
+	assert.Eventually(t, func() bool { return client.State() != StateConnected }, time.Second, 10*time.Millisecond)
+	// 重连的定时器还没有触发时关闭客户端, 之后不能再建立连接
+	client.Close()
+	time.Sleep(time.Second)
+	assert.Equal(t, 0, proxy.Connections())
+}
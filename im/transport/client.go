@@ -3,21 +3,28 @@ package transport
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
 	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/proto"
 	protocol2 "github.com/xuning888/helloIMClient/im/protocol"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
 var (
 	ErrClosed = errors.New("transport client closed")
+	// errAuthRejected 服务端拒绝了认证请求
+	errAuthRejected = errors.New("auth failed")
 
 	maxReconnectAttempts = 10
 	baseReconnectDelay   = 500 // ms
+
+	// liveClients 已经启动还没有停止的 gnet 客户端数, 用于检查泄漏
+	liveClients atomic.Int32
 )
 
 // ConnState 连接状态
@@ -34,6 +41,13 @@ type AddrProvider interface {
 	GetAddr(ctx context.Context) ([]string, error)
 }
 
+// TokenSource 认证使用的 access token（由上层注入）
+type TokenSource interface {
+	AccessToken() string
+	// Refresh 认证失败时刷新 token, stale 为认证使用的 token
+	Refresh(ctx context.Context, stale string) error
+}
+
 // Client 传输层客户端，管理连接生命周期 + 消息收发
 type Client struct {
 	gnet.BuiltinEventEngine
@@ -53,6 +67,8 @@ type Client struct {
 	attempt    atomic.Int32
 	closeOnce  sync.Once
 	closed     atomic.Int32
+	// kicked 被服务端踢下线, 连接断开后不再重连, 直到再次调用 Connect
+	kicked atomic.Bool
 
 	// 子组件
 	sender   *sender
@@ -62,6 +78,18 @@ type Client struct {
 	addrProvider AddrProvider
 	address      string
 
+	// 协议
+	maxFrameSize   atomic.Int64
+	protocolErrors atomic.Int64
+	// 认证时按顺序提供给服务端选择的压缩算法, 为空时不压缩
+	compressions         []protocol2.Compression
+	compressionThreshold int
+	// 认证使用的 token, 为 nil 时发送空 token
+	tokens TokenSource
+	// 认证时上报的设备信息
+	deviceId string
+	platform string
+
 	// 生命周期
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -79,12 +107,17 @@ func NewClient(dispatch func(protocol2.Message), addrProvider AddrProvider, getS
 		cancel:       cancel,
 	}
 	c.state.Store(int32(StateDisconnected))
+	c.maxFrameSize.Store(protocol2.DefaultMaxFrameSize)
+	c.compressionThreshold = protocol2.DefaultCompressionThreshold
 	c.sender = newSender(getSeq, dispatch)
 	return c
 }
 
 // Connect 建立连接
 func (c *Client) Connect(ctx context.Context) error {
+	if c.closed.Load() == 1 {
+		return ErrClosed
+	}
 	if !c.connIsNil() {
 		return nil
 	}
@@ -93,6 +126,7 @@ func (c *Client) Connect(ctx context.Context) error {
 	}
 	defer c.connecting.Store(false)
 
+	c.kicked.Store(false)
 	c.setState(StateConnecting)
 	c.closeConn()
 
@@ -123,8 +157,9 @@ func (c *Client) Close() {
 	c.closeOnce.Do(func() {
 		c.closed.Store(1)
 		c.cancel()
-		c.closeConn()
+		// 先停止分发, 不再处理队列中剩余的推送
 		c.sender.close()
+		c.closeConn()
 	})
 }
 
@@ -133,21 +168,152 @@ func (c *Client) State() ConnState {
 	return ConnState(c.state.Load())
 }
 
+// SetMaxFrameSize 设置接收的帧长度上限, 包含消息头, 超过时断开连接
+func (c *Client) SetMaxFrameSize(size int) {
+	c.maxFrameSize.Store(int64(size))
+}
+
+// ProtocolErrors 因为收到不合法的帧而断开连接的次数
+func (c *Client) ProtocolErrors() int64 {
+	return c.protocolErrors.Load()
+}
+
+// SetCompression 设置认证时提供给服务端的压缩算法, 按优先级排列, 消息体超过 threshold 字节时压缩.
+// 需要在 Connect 之前调用, 下次认证时生效
+func (c *Client) SetCompression(threshold int, compressions ...protocol2.Compression) {
+	c.compressionThreshold = threshold
+	c.compressions = compressions
+}
+
+// SetTokenSource 设置认证使用的 token, 认证失败时刷新一次后重新认证. 需要在 Connect 之前调用
+func (c *Client) SetTokenSource(ts TokenSource) {
+	c.tokens = ts
+}
+
+// SetDevice 设置认证时上报的设备信息. 需要在 Connect 之前调用
+func (c *Client) SetDevice(deviceId, platform string) {
+	c.deviceId = deviceId
+	c.platform = platform
+}
+
+// SetDispatchKey 设置推送消息的分片键, 分片键相同的消息按收到的顺序处理, 不同分片的消息并行处理.
+// 没有设置时所有推送消息按顺序处理. 需要在 Connect 之前调用
+func (c *Client) SetDispatchKey(key DispatchKey) {
+	c.sender.dispatchKey = key
+}
+
+// Kicked 是否被服务端踢下线
+func (c *Client) Kicked() bool {
+	return c.kicked.Load()
+}
+
+// Stats 传输层的统计
+type Stats struct {
+	ProtocolErrors int64                        // 因为收到不合法的帧而断开连接的次数
+	DispatchPauses int64                        // 分片的队列已满暂停读取推送的次数
+	HeaderVersion  byte                         // 当前连接的消息头版本
+	Compression    protocol2.Compression        // 当前连接协商的压缩算法
+	Compressed     protocol2.CompressionCounter // 发出的帧的压缩统计
+	Decompressed   protocol2.CompressionCounter // 收到的帧的解压统计
+}
+
+// Stats 当前的统计值, 压缩统计从客户端创建开始累计
+func (c *Client) Stats() Stats {
+	codec := c.sender.getCodec()
+	compressed, decompressed := c.sender.stats.Snapshot()
+	return Stats{
+		ProtocolErrors: c.protocolErrors.Load(),
+		DispatchPauses: c.sender.pauses.Load(),
+		HeaderVersion:  codec.Version,
+		Compression:    codec.Compression,
+		Compressed:     compressed,
+		Decompressed:   decompressed,
+	}
+}
+
 // ---- gnet.EventHandler ----
 
 func (c *Client) OnTraffic(gconn gnet.Conn) gnet.Action {
+	// 分片已满时暂停的连接, 先放入暂停的推送, 仍然没有空位时继续暂停
+	if paused, ok := gconn.Context().(*pausedRead); ok {
+		if !c.sender.dispatchItem(paused.item) {
+			c.completeBufferedAcks(gconn, paused)
+			return gnet.None
+		}
+		gconn.SetContext(nil)
+	}
 	for {
-		frame, _, action := readFrame(gconn)
+		frame, err := readFrame(gconn, int(c.maxFrameSize.Load()))
+		if err != nil {
+			c.protocolErrors.Add(1)
+			c.log.Errorf("OnTraffic: close connection: %v", err)
+			return gnet.Close
+		}
 		if frame == nil {
-			return action
+			return gnet.None
+		}
+		observeInbound(frame)
+		if err = c.sender.getCodec().Decompress(frame); err != nil {
+			c.protocolErrors.Add(1)
+			c.log.Errorf("OnTraffic: close connection: %v", err)
+			return gnet.Close
+		}
+		if frame.Header.CmdId == int32(helloim_proto.CmdId_CMD_ID_KICKOUT) {
+			// 在连接断开之前标记, OnClose 时不再重连
+			c.kicked.Store(true)
+			c.log.Infof("kicked out by server")
 		}
 		if frame.Header.Req == protocol2.RES {
 			// ACK 响应：完成 sender 中的 promise
 			c.sender.complete(frame)
-		} else {
-			// 推送消息：交接给 dispatch goroutine
-			c.sender.dispatchFrame(frame, gconn)
+			continue
+		}
+		// 推送消息：交接给 dispatch goroutine, 分片已满时暂停读取, 后面的数据留在接收缓冲区中
+		item := c.sender.decodePush(frame, gconn)
+		if item != nil && !c.sender.dispatchItem(item) {
+			paused := &pausedRead{item: item}
+			gconn.SetContext(paused)
+			c.completeBufferedAcks(gconn, paused)
+			return gnet.None
+		}
+	}
+}
+
+// pausedRead 分片已满时暂停读取的连接状态, 保存在 gnet.Conn 的 Context 中
+type pausedRead struct {
+	// item 没有放入分片的推送, 恢复读取时先放入
+	item *dispatchItem
+	// scanned 接收缓冲区中已经查找过 ACK 的长度
+	scanned int
+}
+
+// completeBufferedAcks 暂停读取期间查看接收缓冲区中的帧, 只处理其中的 ACK, 不消费缓冲区.
+// 处理推送的回调可以同步发送消息并等待 ACK, 不提前处理 ACK 时回调一直等不到, 分片也不会有空位.
+// 恢复读取后同一个 ACK 会再次到达, 这时 promise 已经完成, 不会重复处理
+func (c *Client) completeBufferedAcks(gconn gnet.Conn, paused *pausedRead) {
+	buffered := gconn.InboundBuffered()
+	if buffered <= paused.scanned {
+		return
+	}
+	data, err := gconn.Peek(buffered)
+	if err != nil {
+		return
+	}
+	conn := &memConn{buf: data[paused.scanned:]}
+	for {
+		frame, err := readFrame(conn, int(c.maxFrameSize.Load()))
+		if err != nil || frame == nil {
+			// 不合法的帧在恢复读取时再断开连接
+			return
+		}
+		paused.scanned = buffered - conn.InboundBuffered()
+		if frame.Header.Req != protocol2.RES {
+			continue
+		}
+		if err = c.sender.getCodec().Decompress(frame); err != nil {
+			return
 		}
+		c.sender.complete(frame)
 	}
 }
 
@@ -155,7 +321,7 @@ func (c *Client) OnTick() (delay time.Duration, action gnet.Action) {
 	if c.State() == StateConnected {
 		conn := c.getConn()
 		if conn != nil {
-			if err := sendPing(conn); err != nil {
+			if err := sendPing(conn, c.sender.getCodec()); err != nil {
 				c.log.Errorf("heartbeat error: %v", err)
 			}
 		}
@@ -174,6 +340,11 @@ func (c *Client) OnClose(gconn gnet.Conn, err error) gnet.Action {
 	if c.closed.Load() == 1 || c.closing.Load() {
 		return gnet.None
 	}
+	// 被踢下线，不触发重连
+	if c.kicked.Load() {
+		c.setState(StateDisconnected)
+		return gnet.None
+	}
 	c.forceReconnect()
 	return gnet.None
 }
@@ -190,6 +361,7 @@ func (c *Client) dial(ctx context.Context, addr string) error {
 		cli.Stop()
 		return err
 	}
+	liveClients.Add(1)
 
 	type dialResult struct {
 		conn gnet.Conn
@@ -205,19 +377,27 @@ func (c *Client) dial(ctx context.Context, addr string) error {
 	select {
 	case result = <-resultCh:
 	case <-ctx.Done():
-		cli.Stop()
+		stopGnetClient(cli)
 		return ctx.Err()
 	}
 
 	if result.err != nil {
-		cli.Stop()
+		stopGnetClient(cli)
 		return result.err
 	}
 
-	c.setConn(result.conn)
+	c.connMu.Lock()
+	c.conn = result.conn
 	c.gnetCli = cli
+	c.connMu.Unlock()
+	// 连接过程中客户端被关闭
+	if c.closed.Load() == 1 {
+		c.closeConn()
+		return ErrClosed
+	}
 
-	// 认证
+	// 认证, 认证请求总是使用 v1 消息头且不压缩
+	c.sender.setCodec(protocol2.Version1, protocol2.CompressionNone, 0)
 	if err := c.auth(ctx); err != nil {
 		c.closeConn()
 		// 尝试备用地址
@@ -243,33 +423,114 @@ func (c *Client) trySlave() string {
 	return ips[1]
 }
 
+// auth 新连接上的认证, 成功后按协商的结果切换消息头版本和压缩算法
 func (c *Client) auth(ctx context.Context) error {
-	msg := NewAuthRequest(conf.UserId, 0, "")
+	token := c.accessToken()
+	version, compression, err := c.authWithToken(ctx, token)
+	if errors.Is(err, errAuthRejected) && c.tokens != nil {
+		// token 过期或者被吊销, 刷新后重新认证一次
+		c.log.Infof("auth rejected, refreshing token")
+		if err = c.tokens.Refresh(ctx, token); err != nil {
+			return fmt.Errorf("%w: refresh token: %v", errAuthRejected, err)
+		}
+		version, compression, err = c.authWithToken(ctx, c.accessToken())
+	}
+	if err != nil {
+		return err
+	}
+	c.sender.setCodec(version, compression, c.compressionThreshold)
+	c.log.Infof("auth success, header version: %d, compression: %s", version, compression)
+	return nil
+}
+
+func (c *Client) accessToken() string {
+	if c.tokens == nil {
+		return ""
+	}
+	return c.tokens.AccessToken()
+}
+
+// authWithToken 发送认证请求, 返回协商的消息头版本和压缩算法, 由调用方决定是否切换
+func (c *Client) authWithToken(ctx context.Context, token string) (byte, protocol2.Compression, error) {
+	msg := NewAuthRequest(conf.UserId, 0, token)
+	msg.withDevice(c.deviceId, c.platform)
+	msg.offerCompressions(c.compressions)
 	conn := c.getConn()
 	if conn == nil {
-		return errors.New("no connection for auth")
+		return 0, protocol2.CompressionNone, errors.New("no connection for auth")
 	}
 	resp, err := c.sender.send(ctx, conn, msg, 5*time.Second)
+	if err != nil {
+		return 0, protocol2.CompressionNone, err
+	}
+	authResp, ok := resp.(*AuthResponse)
+	if !ok || !authResp.AuthResponse.Success {
+		return 0, protocol2.CompressionNone, errAuthRejected
+	}
+	version := negotiateVersion(authResp.GetProtocolVersion())
+	compression := protocol2.CompressionNone
+	if version >= protocol2.Version2 {
+		compression = negotiateCompression(c.compressions, authResp.selectedCompression())
+	}
+	return version, compression, nil
+}
+
+// Reauth 在已经建立的连接上使用当前的 token 重新认证, 用于 token 在连接期间被刷新之后.
+// Note: 连接上的读写可能正在进行, 不在连接中途切换消息头版本和压缩算法.
+// 服务端重新协商出不同的版本或算法时会按新的协商结果编码, 这时断开连接重连, 在新的连接上重新协商
+func (c *Client) Reauth(ctx context.Context) error {
+	if c.State() != StateConnected {
+		return errors.New("transport: not connected")
+	}
+	version, compression, err := c.authWithToken(ctx, c.accessToken())
 	if err != nil {
 		return err
 	}
-	if authResp, ok := resp.(*AuthResponse); ok && authResp.AuthResponse.Success {
-		return nil
+	if codec := c.sender.getCodec(); codec.Version != version || codec.Compression != compression {
+		c.log.Warnf("reauth negotiated header version: %d, compression: %s, current %d, %s, reconnect",
+			version, compression, codec.Version, codec.Compression)
+		c.closeConn()
+		c.forceReconnect()
+	}
+	return nil
+}
+
+// negotiateVersion 旧的服务端不返回版本, 按 v1 处理; 返回的版本比客户端支持的高时也按 v1 处理
+func negotiateVersion(serverVersion int32) byte {
+	if serverVersion < int32(protocol2.Version2) || serverVersion > int32(protocol2.CurrentVersion) {
+		return protocol2.Version1
 	}
-	return errors.New("auth failed")
+	return byte(serverVersion)
 }
 
+// negotiateCompression 服务端选择的算法必须是客户端提供的, 否则不压缩
+func negotiateCompression(offered []protocol2.Compression, selected protocol2.Compression) protocol2.Compression {
+	for _, c := range offered {
+		if c == selected {
+			return c
+		}
+	}
+	return protocol2.CompressionNone
+}
+
+// HeaderVersion 当前连接发送请求使用的消息头版本
+func (c *Client) HeaderVersion() byte {
+	return c.sender.getCodec().Version
+}
+
+// forceReconnect 延迟一段时间后重连, 重连失败时继续重试
+// Note: reconnect 在定时器触发之前一直为 true, 等待期间重复调用不会重复安排重连
 func (c *Client) forceReconnect() {
-	if c.closed.Load() == 1 {
+	if c.closed.Load() == 1 || c.kicked.Load() {
 		return
 	}
 	if !c.reconnect.CompareAndSwap(false, true) {
 		return
 	}
-	defer c.reconnect.Store(false)
 
 	attempt := int(c.attempt.Add(1))
 	if attempt > maxReconnectAttempts {
+		c.reconnect.Store(false)
 		c.setState(StateDisconnected)
 		c.log.Errorf("max reconnect attempts reached")
 		return
@@ -282,6 +543,10 @@ func (c *Client) forceReconnect() {
 	c.log.Infof("reconnect attempt %d, delay %dms", attempt, delay)
 
 	time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		c.reconnect.Store(false)
+		if c.closed.Load() == 1 || c.kicked.Load() {
+			return
+		}
 		c.address = ""
 		c.setState(StateDisconnected)
 		if err := c.fetchAddr(context.Background()); err != nil {
@@ -291,6 +556,7 @@ func (c *Client) forceReconnect() {
 		}
 		if err := c.Connect(context.Background()); err != nil {
 			c.log.Errorf("reconnect Connect failed: %v", err)
+			c.forceReconnect()
 		}
 	})
 }
@@ -328,14 +594,13 @@ func (c *Client) closeConn() {
 		conn.Close()
 	}
 	if cli != nil {
-		cli.Stop()
+		stopGnetClient(cli)
 	}
 }
 
-func (c *Client) setConn(conn gnet.Conn) {
-	c.connMu.Lock()
-	c.conn = conn
-	c.connMu.Unlock()
+func stopGnetClient(cli *gnet.Client) {
+	cli.Stop()
+	liveClients.Add(-1)
 }
 
 func (c *Client) getConn() gnet.Conn {
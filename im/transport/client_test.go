@@ -3,6 +3,10 @@ package transport
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -10,18 +14,38 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im/auth"
+	"github.com/xuning888/helloIMClient/im/capture"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/im/http"
 	"github.com/xuning888/helloIMClient/im/proto"
 	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/chatsync"
+	"github.com/xuning888/helloIMClient/im/protocol/contact"
+	"github.com/xuning888/helloIMClient/im/protocol/push"
 	"github.com/xuning888/helloIMClient/im/protocol/send"
+	"github.com/xuning888/helloIMClient/im/testserver"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
+var server *testserver.Server
+
+func TestMain(m *testing.M) {
+	server = testserver.New()
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "start testserver: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
 func TestNewClient(t *testing.T) {
 	logger.InitLogger()
 	conf.UserId = 1
 	conf.UserName = "user1"
-	http.Init("http://127.0.0.1:8087", time.Second*5)
+	http.Init(server.URL(), time.Second*5)
 
 	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
 	if err := client.Connect(context.Background()); err != nil {
@@ -58,7 +82,7 @@ func TestImClient_WriteMessage(t *testing.T) {
 
 func writeMessage(i int, t *testing.T) {
 	logger.InitLogger()
-	http.Init("http://127.0.0.1:8087", time.Second*5)
+	http.Init(server.URL(), time.Second*5)
 	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
 	if err := client.Connect(context.Background()); err != nil {
 		t.Fatal(err)
@@ -90,7 +114,7 @@ func testDispatch(msg protocol.Message) {
 type testAddrProvider struct{}
 
 func (p *testAddrProvider) GetAddr(ctx context.Context) ([]string, error) {
-	return []string{"127.0.0.1:9299"}, nil
+	return []string{server.Addr()}, nil
 }
 
 var seq atomic.Int32 = atomic.Int32{}
@@ -100,3 +124,528 @@ func getSeq() int32 {
 	seq.Add(1)
 	return load
 }
+
+func TestClient_SendRetryOnDrop(t *testing.T) {
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	var dropped atomic.Bool
+	server.SetFault(func(uid int64, header *protocol.MsgHeader) *testserver.Fault {
+		if header.CmdId == int32(helloim_proto.CmdId_CMD_ID_SEND) && dropped.CompareAndSwap(false, true) {
+			return &testserver.Fault{Drop: true}
+		}
+		return nil
+	})
+	defer server.SetFault(nil)
+
+	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	response, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.Nil(t, err)
+	_, ok := response.(*send.SendAck)
+	assert.True(t, ok)
+	assert.True(t, dropped.Load())
+}
+
+func TestClient_Capture(t *testing.T) {
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	filename := filepath.Join(t.TempDir(), "frames.cap")
+	rec := capture.NewRecorder(filename, 1, 1)
+	SetRecorder(rec)
+	defer SetRecorder(nil)
+
+	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.Nil(t, err)
+	client.Close()
+	assert.Nil(t, rec.Close())
+
+	records, err := capture.ReadFile(filename)
+	assert.Nil(t, err)
+	var got []string
+	for _, record := range records {
+		frame, err := protocol.ParseFrame(record.Data)
+		if assert.Nil(t, err) && frame.Header.CmdId != int32(helloim_proto.CmdId_CMD_ID_HEARTBEAT) {
+			got = append(got, fmt.Sprintf("%s %s", record.Direction, helloim_proto.CmdId(frame.Header.CmdId)))
+		}
+	}
+	assert.Equal(t, []string{"out CMD_ID_AUTH", "in CMD_ID_AUTH", "out CMD_ID_SEND", "in CMD_ID_SEND"}, got)
+}
+
+func TestClient_HeaderVersion(t *testing.T) {
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	var versions sync.Map
+	server.SetFault(func(uid int64, header *protocol.MsgHeader) *testserver.Fault {
+		versions.Store(header.CmdId, header.Version)
+		return nil
+	})
+	defer server.SetFault(nil)
+
+	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, protocol.Version2, client.HeaderVersion())
+	_, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.Nil(t, err)
+	version, _ := versions.Load(int32(helloim_proto.CmdId_CMD_ID_AUTH))
+	assert.Equal(t, protocol.Version1, version)
+	version, _ = versions.Load(int32(helloim_proto.CmdId_CMD_ID_SEND))
+	assert.Equal(t, protocol.Version2, version)
+}
+
+func TestClient_HeaderVersionOldServer(t *testing.T) {
+	old := testserver.New(testserver.WithMaxHeaderVersion(protocol.Version1))
+	if err := old.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(old.URL(), time.Second*5)
+	defer http.Init(server.URL(), time.Second*5)
+
+	client := NewClient(testDispatch, staticAddrProvider{old.Addr()}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, protocol.Version1, client.HeaderVersion())
+	resp, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.Nil(t, err)
+	_, ok := resp.(*send.SendAck)
+	assert.True(t, ok)
+}
+
+func TestClient_Compression(t *testing.T) {
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
+	client.SetCompression(128, protocol.CompressionSnappy, protocol.CompressionZstd)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// 服务端优先选择 zstd, 但是必须是客户端提供的算法
+	assert.Equal(t, protocol.CompressionSnappy, client.Stats().Compression)
+
+	before := server.CompressedFrames()
+	text := strings.Repeat("merged forward ", 1000)
+	req := NewEchoRequest()
+	req.Msg = text
+	resp, err := client.Send(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, text, resp.(*EchoResponse).GetMsg())
+	assert.Equal(t, before+1, server.CompressedFrames())
+
+	stats := client.Stats()
+	assert.Equal(t, int64(1), stats.Compressed.Frames)
+	assert.Greater(t, stats.Compressed.Ratio(), 1.0)
+	assert.Equal(t, int64(1), stats.Decompressed.Frames)
+	assert.Greater(t, stats.Decompressed.RawBytes, int64(len(text)))
+
+	// 小消息不压缩
+	req.Msg = "short"
+	_, err = client.Send(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), client.Stats().Compressed.Frames)
+}
+
+func TestClient_CompressionOldServer(t *testing.T) {
+	old := testserver.New(testserver.WithCompressions())
+	if err := old.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(old.URL(), time.Second*5)
+	defer http.Init(server.URL(), time.Second*5)
+
+	client := NewClient(testDispatch, staticAddrProvider{old.Addr()}, getSeq)
+	client.SetCompression(0, protocol.CompressionZstd)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, protocol.CompressionNone, client.Stats().Compression)
+	req := NewEchoRequest()
+	req.Msg = strings.Repeat("a", 4096)
+	_, err := client.Send(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), old.CompressedFrames())
+	assert.Equal(t, int64(0), client.Stats().Compressed.Frames)
+}
+
+func TestClient_AuthRefreshToken(t *testing.T) {
+	tokenServer := testserver.New(
+		testserver.WithUsers(&sqllite.ImUser{UserID: 1, UserName: "user1"}),
+		testserver.WithRequireToken(true),
+	)
+	if err := tokenServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer tokenServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(tokenServer.URL(), time.Second*5)
+	defer http.Init(server.URL(), time.Second*5)
+
+	manager := auth.NewManager(filepath.Join(t.TempDir(), "user1.json"))
+	if err := manager.Login(context.Background(), "user1", "password"); err != nil {
+		t.Fatal(err)
+	}
+	// access token 过期后认证被拒绝, 刷新后重新认证
+	tokenServer.ExpireAccessTokens()
+	client := NewClient(testDispatch, staticAddrProvider{tokenServer.Addr()}, getSeq)
+	client.SetTokenSource(manager)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, int64(1), tokenServer.Refreshes())
+	_, err := client.Send(context.Background(), buildMsg(0, 1))
+	assert.Nil(t, err)
+
+	// 连接期间刷新 token 后重新认证, 不切换正在使用的编解码
+	codec := client.sender.getCodec()
+	assert.Nil(t, manager.Refresh(context.Background(), manager.AccessToken()))
+	assert.Nil(t, client.Reauth(context.Background()))
+	assert.Same(t, codec, client.sender.getCodec())
+	_, err = client.Send(context.Background(), buildMsg(1, 1))
+	assert.Nil(t, err)
+}
+
+func TestClient_ReauthCodecChanged(t *testing.T) {
+	logger.InitLogger()
+	conf.UserId = 1
+	http.Init(server.URL(), time.Second*5)
+
+	client := NewClient(testDispatch, &testAddrProvider{}, getSeq)
+	client.SetCompression(128, protocol.CompressionSnappy)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Equal(t, protocol.CompressionSnappy, client.Stats().Compression)
+
+	// 重新认证协商出不同的压缩算法, 断开连接后在新的连接上使用新的算法
+	client.SetCompression(128, protocol.CompressionZstd)
+	assert.Nil(t, client.Reauth(context.Background()))
+	assert.Eventually(t, func() bool {
+		return client.State() == StateConnected && client.Stats().Compression == protocol.CompressionZstd
+	}, 5*time.Second, 10*time.Millisecond)
+	req := NewEchoRequest()
+	req.Msg = strings.Repeat("reauth ", 1000)
+	resp, err := client.Send(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, req.Msg, resp.(*EchoResponse).GetMsg())
+}
+
+func TestClient_Kickout(t *testing.T) {
+	kickServer := testserver.New(testserver.WithSingleDevice(true))
+	if err := kickServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer kickServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+
+	kicked := make(chan *helloim_proto.KickoutRequest, 1)
+	dispatch := func(msg protocol.Message) {
+		if k, ok := msg.(*Kickout); ok {
+			kicked <- k.KickoutRequest
+		}
+	}
+	first := NewClient(dispatch, staticAddrProvider{kickServer.Addr()}, getSeq)
+	first.SetDevice("device-1", "cli-test")
+	if err := first.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	assert.Equal(t, []string{"device-1"}, kickServer.Devices(1))
+
+	// 同一个账号在另一台设备登录, 旧的连接被踢下线
+	second := NewClient(testDispatch, staticAddrProvider{kickServer.Addr()}, getSeq)
+	second.SetDevice("device-2", "cli-test")
+	if err := second.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	select {
+	case req := <-kicked:
+		assert.Equal(t, helloim_proto.KickoutReason_KICKOUT_OTHER_DEVICE, req.Reason)
+		assert.Equal(t, "device-2", req.DeviceId)
+	case <-time.After(5 * time.Second):
+		t.Fatal("kickout not received")
+	}
+	assert.True(t, first.Kicked())
+	// 被踢下线后不再重连
+	time.Sleep(time.Second)
+	assert.Equal(t, []string{"device-2"}, kickServer.Devices(1))
+	_, err := first.Send(context.Background(), buildMsg(0, 1))
+	assert.NotNil(t, err)
+
+	kickServer.Kickout(1, helloim_proto.KickoutReason_KICKOUT_SESSION_REVOKED, "")
+	assert.Eventually(t, second.Kicked, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClient_MultiDeviceSync(t *testing.T) {
+	syncServer := testserver.New()
+	if err := syncServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer syncServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+
+	received := make(chan protocol.Message, 4)
+	dispatch := func(msg protocol.Message) { received <- msg }
+	first := NewClient(testDispatch, staticAddrProvider{syncServer.Addr()}, getSeq)
+	first.SetDevice("device-1", "cli-test")
+	if err := first.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second := NewClient(dispatch, staticAddrProvider{syncServer.Addr()}, getSeq)
+	second.SetDevice("device-2", "cli-test")
+	if err := second.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	// 第一台设备发出的消息推送给第二台设备, 发送方是自己, 会话是接收方
+	resp, err := first.Send(context.Background(), buildMsg(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := resp.(*send.SendAck)
+	select {
+	case msg := <-received:
+		echo, ok := msg.(*push.RecvMsg)
+		if !ok {
+			t.Fatalf("unexpected message %v", msg)
+		}
+		assert.Equal(t, "1", echo.GetFrom())
+		assert.Equal(t, "2", echo.GetChatId())
+		assert.Equal(t, ack.MsgId(), echo.MsgId())
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not synced to other device")
+	}
+
+	settings := &helloim_proto.ChatSettings{ChatId: "2", ChatType: 1, Top: true, LastReadMsgId: ack.MsgId()}
+	if _, err = first.Send(context.Background(), chatsync.NewSyncMsg(settings)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		sync, ok := msg.(*chatsync.SyncMsg)
+		if !ok {
+			t.Fatalf("unexpected message %v", msg)
+		}
+		assert.Equal(t, 1, len(sync.GetSettings()))
+		assert.True(t, sync.GetSettings()[0].GetTop())
+	case <-time.After(5 * time.Second):
+		t.Fatal("chat settings not synced to other device")
+	}
+	chat := syncServer.Chat(1, 2, 1)
+	assert.True(t, chat.ChatTop)
+	assert.Equal(t, ack.MsgId(), chat.LastReadMsgId)
+	// 发出同步的设备不会收到自己的修改
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClient_FriendNotify(t *testing.T) {
+	contactServer := testserver.New(testserver.WithUsers(
+		&sqllite.ImUser{UserID: 1, UserName: "user1"},
+		&sqllite.ImUser{UserID: 2, UserName: "user2"},
+	))
+	if err := contactServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer contactServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+
+	received := make(chan protocol.Message, 1)
+	client := NewClient(func(msg protocol.Message) { received <- msg }, staticAddrProvider{contactServer.Addr()}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	request, err := contactServer.SendFriendRequest(2, 1, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		notify, ok := msg.(*contact.FriendNotify)
+		if !ok {
+			t.Fatalf("unexpected message %v", msg)
+		}
+		assert.Equal(t, request.RequestId, notify.GetRequest().GetRequestId())
+		assert.Equal(t, "2", notify.GetRequest().GetFrom())
+		assert.Equal(t, "user2", notify.GetRequest().GetFromUserName())
+		assert.Equal(t, "hi", notify.GetRequest().GetMessage())
+	case <-time.After(5 * time.Second):
+		t.Fatal("friend request not pushed")
+	}
+}
+
+func TestClient_DispatchOrder(t *testing.T) {
+	orderServer := testserver.New()
+	if err := orderServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer orderServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+
+	const n = 50
+	release := make(chan struct{})
+	var mu sync.Mutex
+	seqs := make(map[string][]int64)
+	done := make(chan string, 2)
+	dispatch := func(msg protocol.Message) {
+		recv, ok := msg.(*push.RecvMsg)
+		if !ok {
+			return
+		}
+		// 阻塞用户 2 的会话, 不影响用户 3 的会话
+		if recv.GetFrom() == "2" {
+			<-release
+		}
+		mu.Lock()
+		seqs[recv.GetFrom()] = append(seqs[recv.GetFrom()], recv.ServerSeq())
+		if len(seqs[recv.GetFrom()]) == n {
+			done <- recv.GetFrom()
+		}
+		mu.Unlock()
+	}
+	client := NewClient(dispatch, staticAddrProvider{orderServer.Addr()}, getSeq)
+	client.SetDispatchKey(func(msg protocol.Message) int64 {
+		if recv, ok := msg.(*push.RecvMsg); ok {
+			from, _ := strconv.ParseInt(recv.GetFrom(), 10, 64)
+			return from
+		}
+		return 0
+	})
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	text := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_TEXT,
+		Content:     &helloim_proto.Payload_Text{Text: &helloim_proto.TextPayload{Content: "hi"}},
+	}
+	for i := 0; i < n; i++ {
+		for _, from := range []int64{2, 3} {
+			if _, err := orderServer.Deliver(from, 1, 1, text); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	select {
+	case from := <-done:
+		assert.Equal(t, "3", from)
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages of other chats blocked")
+	}
+	close(release)
+	select {
+	case from := <-done:
+		assert.Equal(t, "2", from)
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages not dispatched")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for from, got := range seqs {
+		for i, s := range got {
+			assert.Equal(t, int64(i+1), s, "chat of user %s out of order", from)
+		}
+	}
+}
+
+func TestClient_DispatchSaturated(t *testing.T) {
+	saturatedServer := testserver.New()
+	if err := saturatedServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer saturatedServer.Close()
+	logger.InitLogger()
+	conf.UserId = 1
+
+	// 所有推送在同一个分片, 处理第一条推送时阻塞, 分片排满后暂停读取, 队列不超过上限
+	const n = dispatchQueueSize * 2
+	delivered := make(chan struct{})
+	sent := make(chan error, 1)
+	var received atomic.Int64
+	var client *Client
+	dispatch := func(msg protocol.Message) {
+		if _, ok := msg.(*push.RecvMsg); !ok {
+			return
+		}
+		if received.Add(1) == 1 {
+			<-delivered
+			// 暂停读取期间同步发送消息, ACK 仍然可以收到
+			_, err := client.Send(context.Background(), buildMsg(0, 1))
+			sent <- err
+		}
+	}
+	client = NewClient(dispatch, staticAddrProvider{saturatedServer.Addr()}, getSeq)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	text := &helloim_proto.Payload{
+		PayloadType: helloim_proto.PayloadType_TEXT,
+		Content:     &helloim_proto.Payload_Text{Text: &helloim_proto.TextPayload{Content: "hi"}},
+	}
+	for i := 0; i < n; i++ {
+		if _, err := saturatedServer.Deliver(2, 1, 1, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shard := client.sender.shards[0]
+	assert.Eventually(t, func() bool {
+		return client.Stats().DispatchPauses > 0 && len(shard.items) == dispatchQueueSize
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), received.Load())
+	assert.LessOrEqual(t, len(shard.items), dispatchQueueSize)
+
+	close(delivered)
+	select {
+	case err := <-sent:
+		assert.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("send from a dispatch handler blocked by a paused connection")
+	}
+	// 分片有空位后恢复读取, 所有推送都按顺序处理
+	assert.Eventually(t, func() bool { return received.Load() == n }, 5*time.Second, 10*time.Millisecond)
+}
@@ -1,36 +1,137 @@
 package transport
 
 import (
+	"io"
+	"sync/atomic"
+
 	"github.com/panjf2000/gnet/v2"
+	"github.com/xuning888/helloIMClient/im/capture"
 	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/dump"
+	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
-// readFrame 从 socket 读取一个完整帧
-func readFrame(conn gnet.Conn) (*protocol.Frame, int, gnet.Action) {
-	hsize := int(protocol.DefaultHeaderSize)
+// recorder 抓包, 为 nil 时不记录
+var recorder atomic.Pointer[capture.Recorder]
+
+// SetRecorder 设置记录收发帧的 Recorder, 传 nil 停止记录
+func SetRecorder(r *capture.Recorder) {
+	recorder.Store(r)
+}
+
+// traceFrames 为 true 时把收发的帧解码后输出到日志
+var traceFrames atomic.Bool
+
+// SetTraceFrames 设置是否把收发的帧输出到日志
+func SetTraceFrames(enabled bool) {
+	traceFrames.Store(enabled)
+}
+
+// observeInbound 抓包并输出收到的帧
+func observeInbound(frame *protocol.Frame) {
+	if r := recorder.Load(); r != nil {
+		r.Record(capture.Inbound, protocol.ToBytes(frame))
+	}
+	if traceFrames.Load() {
+		logger.Infof("frame in: %s", dump.Frame(frame))
+	}
+}
+
+// observeOutbound 抓包并输出发出的帧
+func observeOutbound(data []byte) {
+	if r := recorder.Load(); r != nil {
+		r.Record(capture.Outbound, data)
+	}
+	if traceFrames.Load() {
+		if frame, err := protocol.ParseFrame(data); err == nil {
+			logger.Infof("frame out: %s", dump.Frame(frame))
+		}
+	}
+}
+
+// inbound readFrame 用到的 gnet.Conn 的方法, 便于在测试中替换成内存中的连接
+type inbound interface {
+	InboundBuffered() int
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+	Read(p []byte) (int, error)
+}
+
+// memConn 内存中的数据, 实现 readFrame 用到的 gnet.Conn 的方法, 用于从查看到的接收缓冲区中解析帧
+type memConn struct {
+	buf []byte
+}
+
+func (c *memConn) InboundBuffered() int { return len(c.buf) }
+
+func (c *memConn) Peek(n int) ([]byte, error) {
+	if n > len(c.buf) {
+		return c.buf, io.ErrShortBuffer
+	}
+	return c.buf[:n], nil
+}
+
+func (c *memConn) Discard(n int) (int, error) {
+	if n > len(c.buf) {
+		n = len(c.buf)
+	}
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *memConn) Read(p []byte) (int, error) {
+	if len(c.buf) == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readFrame 从 socket 读取一个完整帧, 数据不足时返回 nil.
+// 消息头不合法或者帧超过 maxFrameSize 时返回 *protocol.ProtocolError, 连接需要关闭
+func readFrame(conn inbound, maxFrameSize int) (*protocol.Frame, error) {
+	if conn.InboundBuffered() < int(protocol.DefaultHeaderSize) {
+		return nil, nil
+	}
+	buf, err := conn.Peek(1)
+	if err != nil {
+		return nil, nil
+	}
+	// 先校验长度, 避免按错误的长度分配内存或者一直等待永远不会到达的数据
+	if err = protocol.ValidateHeaderLength(buf[0]); err != nil {
+		return nil, err
+	}
+	hsize := int(buf[0])
 	if conn.InboundBuffered() < hsize {
-		return nil, 0, gnet.None
+		return nil, nil
 	}
-	buf, err := conn.Peek(hsize)
+	if buf, err = conn.Peek(hsize); err != nil {
+		return nil, nil
+	}
+	header, err := protocol.DecodeHeader(buf)
 	if err != nil {
-		return nil, 0, gnet.None
+		return nil, err
+	}
+	if err = protocol.ValidateHeader(header, maxFrameSize); err != nil {
+		return nil, err
 	}
-	header := protocol.DecodeHeader(buf)
 	frameSize := int(header.BodyLength) + hsize
 	if conn.InboundBuffered() < frameSize {
-		return nil, 0, gnet.None
+		return nil, nil
 	}
 	if _, err = conn.Discard(hsize); err != nil {
-		return nil, 0, gnet.Close
+		return nil, err
 	}
 	body := make([]byte, header.BodyLength)
-	if _, err = conn.Read(body); err != nil {
-		return nil, 0, gnet.Close
+	if _, err = io.ReadFull(conn, body); err != nil {
+		return nil, err
 	}
-	return &protocol.Frame{Header: header, Body: body}, frameSize, gnet.None
+	return &protocol.Frame{Header: header, Body: body}, nil
 }
 
 // writeFrame 写字节到 socket
 func writeFrame(conn gnet.Conn, data []byte) error {
+	observeOutbound(data)
 	return conn.AsyncWrite(data, nil)
 }
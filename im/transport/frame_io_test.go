@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/capture"
+	"github.com/xuning888/helloIMClient/im/protocol"
+)
+
+func encodeFrame(h *protocol.MsgHeader, body []byte) []byte {
+	return protocol.ToBytes(&protocol.Frame{Header: h, Body: body})
+}
+
+func TestReadFrame(t *testing.T) {
+	data := encodeFrame(&protocol.MsgHeader{Req: protocol.RES, Seq: 1, CmdId: 2, BodyLength: 3}, []byte("abc"))
+
+	// 半包时等待更多的数据
+	conn := &memConn{buf: data[:len(data)-1]}
+	frame, err := readFrame(conn, protocol.DefaultMaxFrameSize)
+	assert.Nil(t, frame)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data)-1, conn.InboundBuffered())
+
+	conn.buf = data
+	frame, err = readFrame(conn, protocol.DefaultMaxFrameSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), frame.Body)
+	assert.Equal(t, 0, conn.InboundBuffered())
+}
+
+func TestReadFrame_Malformed(t *testing.T) {
+	unsupported := encodeFrame(&protocol.MsgHeader{Version: protocol.Version2}, nil)
+	unsupported[14] = 3
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"negative body length", encodeFrame(&protocol.MsgHeader{BodyLength: -1}, nil), protocol.ErrInvalidBodyLength},
+		{"too large", encodeFrame(&protocol.MsgHeader{BodyLength: 1 << 30}, nil), protocol.ErrFrameTooLarge},
+		{"header length", append([]byte{15}, encodeFrame(&protocol.MsgHeader{}, nil)[1:]...), protocol.ErrInvalidHeaderLength},
+		{"header version", unsupported, protocol.ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := readFrame(&memConn{buf: tt.data}, 1024)
+			assert.Nil(t, frame)
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+			var protoErr *protocol.ProtocolError
+			assert.True(t, errors.As(err, &protoErr))
+		})
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	valid := encodeFrame(&protocol.MsgHeader{Req: protocol.REQ, Seq: 1, CmdId: 1011, BodyLength: 2}, []byte{8, 1})
+	f.Add(valid)
+	f.Add(append(valid, valid[:5]...))
+	f.Add(encodeFrame(&protocol.MsgHeader{BodyLength: 1 << 30}, nil))
+	f.Add(encodeFrame(&protocol.MsgHeader{Version: protocol.Version2, BodyLength: 1, Extensions: []protocol.Extension{{Type: 1, Value: []byte("a")}}}, []byte{0}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn := &memConn{buf: data}
+		var consumed []byte
+		for {
+			before := conn.InboundBuffered()
+			frame, err := readFrame(conn, 1024)
+			if err != nil {
+				var protoErr *protocol.ProtocolError
+				if !errors.As(err, &protoErr) {
+					t.Fatalf("unexpected error type: %T %v", err, err)
+				}
+				return
+			}
+			if frame == nil {
+				// 数据不足时不能消费任何字节
+				if conn.InboundBuffered() != before {
+					t.Fatalf("partial frame consumed %d bytes", before-conn.InboundBuffered())
+				}
+				break
+			}
+			if len(frame.Body) > 1024 {
+				t.Fatalf("frame too large: %d", len(frame.Body))
+			}
+			consumed = append(consumed, protocol.ToBytes(frame)...)
+		}
+		if !bytes.Equal(consumed, data[:len(data)-conn.InboundBuffered()]) {
+			t.Fatal("decoded frames do not match consumed bytes")
+		}
+	})
+}
+
+func TestObserveFrames(t *testing.T) {
+	data := encodeFrame(&protocol.MsgHeader{Req: protocol.REQ, Seq: 1, CmdId: 2, BodyLength: 3}, []byte("abc"))
+	frame, err := readFrame(&memConn{buf: data}, protocol.DefaultMaxFrameSize)
+	assert.Nil(t, err)
+
+	// 没有设置 Recorder 时不记录
+	SetRecorder(nil)
+	observeOutbound(data)
+	observeInbound(frame)
+
+	filename := filepath.Join(t.TempDir(), "frames.cap")
+	r := capture.NewRecorder(filename, 1, 1)
+	SetRecorder(r)
+	observeOutbound(data)
+	observeInbound(frame)
+	SetRecorder(nil)
+	assert.Nil(t, r.Close())
+
+	records, err := capture.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, capture.Outbound, records[0].Direction)
+	assert.Equal(t, capture.Inbound, records[1].Direction)
+	assert.Equal(t, data, records[0].Data)
+	assert.Equal(t, data, records[1].Data)
+}
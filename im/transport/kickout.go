@@ -0,0 +1,28 @@
+package transport
+
+import (
+	"github.com/xuning888/helloIMClient/im/proto"
+	protocol "github.com/xuning888/helloIMClient/im/protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kickout 服务端踢下线的下行消息
+type Kickout struct {
+	*helloim_proto.KickoutRequest
+	f *protocol.Frame
+}
+
+func (k *Kickout) CmdId() int32  { return int32(helloim_proto.CmdId_CMD_ID_KICKOUT) }
+func (k *Kickout) MsgSeq() int32 { return k.f.Header.Seq }
+
+func decodeKickout(frame *protocol.Frame) (protocol.Message, error) {
+	req := &helloim_proto.KickoutRequest{}
+	if err := proto.Unmarshal(frame.Body, req); err != nil {
+		return nil, err
+	}
+	return &Kickout{KickoutRequest: req, f: frame}, nil
+}
+
+func init() {
+	protocol.RegisterDecoder(int32(helloim_proto.CmdId_CMD_ID_KICKOUT), decodeKickout)
+}
@@ -3,10 +3,12 @@ package transport
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
 	"github.com/xuning888/helloIMClient/im/protocol"
+	"github.com/xuning888/helloIMClient/im/protocol/dump"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
@@ -17,44 +19,97 @@ type sender struct {
 	log      logger.Logger
 	requests sync.Map // key: int32(seq) → *promise
 	getSeq   GetSeq
+	// codec 发送请求使用的消息头版本和压缩算法, 认证成功后按协商的结果设置
+	codec atomic.Pointer[protocol.Codec]
+	stats *protocol.CompressionStats
 
-	// dispatch
-	respChan chan *dispatchItem
-	ctx      context.Context
-	cancel   context.CancelFunc
-	dispatch func(protocol.Message)
+	// dispatch 推送消息按 dispatchKey 分片, 每个分片一个队列和一个 worker, 同一个分片的消息按收到的顺序处理
+	shards      []*dispatchQueue
+	dispatchKey DispatchKey
+	ctx         context.Context
+	cancel      context.CancelFunc
+	dispatch    func(protocol.Message)
+	// pauses 分片已满暂停读取推送的次数
+	pauses atomic.Int64
 }
 
+const (
+	dispatchShards = 8
+	// dispatchQueueSize 每个分片最多排队的消息数, 满了以后暂停读取连接上的推送
+	dispatchQueueSize = 512
+)
+
+// DispatchKey 推送消息的分片键, 分片键相同的消息按收到的顺序依次处理, 例如同一个会话的消息
+type DispatchKey func(protocol.Message) int64
+
 type dispatchItem struct {
 	frame *protocol.Frame
+	msg   protocol.Message
 	conn  gnet.Conn
 }
 
+// dispatchQueue 分片的有界队列. 队列满时读取循环暂停读取推送, worker 取出消息后唤醒连接继续读取
+type dispatchQueue struct {
+	items chan *dispatchItem
+	// paused 读取循环因为这个分片已满而暂停
+	paused atomic.Bool
+}
+
+func newDispatchQueue() *dispatchQueue {
+	return &dispatchQueue{items: make(chan *dispatchItem, dispatchQueueSize)}
+}
+
+// tryPush 入队, 队列已满时返回 false
+func (q *dispatchQueue) tryPush(item *dispatchItem) bool {
+	select {
+	case q.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
 func newSender(getSeq GetSeq, dispatch func(protocol.Message)) *sender {
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &sender{
 		log:      logger.Named("sender"),
 		requests: sync.Map{},
 		getSeq:   getSeq,
-		respChan: make(chan *dispatchItem, 5000),
 		ctx:      ctx,
 		cancel:   cancel,
 		dispatch: dispatch,
+		stats:    &protocol.CompressionStats{},
 	}
-	s.startDispatchWorkers(10)
+	s.setCodec(protocol.Version1, protocol.CompressionNone, 0)
+	s.startDispatchWorkers(dispatchShards)
 	return s
 }
 
+func (s *sender) getCodec() *protocol.Codec {
+	return s.codec.Load()
+}
+
+func (s *sender) setCodec(version byte, compression protocol.Compression, threshold int) {
+	s.codec.Store(&protocol.Codec{
+		Version:     version,
+		Compression: compression,
+		Threshold:   threshold,
+		Stats:       s.stats,
+	})
+}
+
 func (s *sender) startDispatchWorkers(n int) {
-	for i := 0; i < n; i++ {
-		go s.dispatchWorker()
+	s.shards = make([]*dispatchQueue, n)
+	for i := range s.shards {
+		s.shards[i] = newDispatchQueue()
+		go s.dispatchWorker(s.shards[i])
 	}
 }
 
 // send 停等协议：分配 seq，编码写出，等待 ACK
 func (s *sender) send(ctx context.Context, conn gnet.Conn, msg protocol.Message, timeout time.Duration) (protocol.Message, error) {
 	seq := s.getSeq()
-	frame, err := protocol.EncodeMessageToFrame(seq, protocol.REQ, msg)
+	frame, err := s.getCodec().EncodeMessageToFrame(seq, protocol.REQ, msg)
 	if err != nil {
 		return nil, err
 	}
@@ -97,14 +152,38 @@ func (s *sender) complete(frame *protocol.Frame) {
 	}
 }
 
-// dispatchFrame 异步分发推送消息（非阻塞，不够缓冲时起 goroutine 写）
-func (s *sender) dispatchFrame(frame *protocol.Frame, conn gnet.Conn) {
-	item := &dispatchItem{frame: frame, conn: conn}
-	select {
-	case s.respChan <- item:
-	default:
-		go func() { s.respChan <- item }()
+// decodePush 解码推送消息, 解码失败时回复 ACK 后丢弃, 返回 nil
+func (s *sender) decodePush(frame *protocol.Frame, conn gnet.Conn) *dispatchItem {
+	msg, err := protocol.DecodeMessage(frame)
+	if err != nil {
+		// 解码失败的消息重试也无法处理, 回复 ACK 后丢弃
+		sendAck(conn, frame)
+		s.log.Errorf("decodePush: decode error: %v, frame: %s", err, dump.Frame(frame))
+		return nil
 	}
+	return &dispatchItem{frame: frame, msg: msg, conn: conn}
+}
+
+// dispatchItem 按分片键放入对应的队列, 分片已满时标记暂停并返回 false, worker 取出消息后唤醒连接
+func (s *sender) dispatchItem(item *dispatchItem) bool {
+	var key int64
+	if s.dispatchKey != nil {
+		key = s.dispatchKey(item.msg)
+	}
+	index := uint64(key) % uint64(len(s.shards))
+	queue := s.shards[index]
+	if queue.tryPush(item) {
+		return true
+	}
+	// 先标记再重试一次, 避免 worker 在标记之前取走了消息, 之后没有人唤醒连接
+	queue.paused.Store(true)
+	if queue.tryPush(item) {
+		queue.paused.Store(false)
+		return true
+	}
+	s.pauses.Add(1)
+	s.log.Warnf("dispatchItem: shard %d is full, pause reading pushes until it drains", index)
+	return false
 }
 
 // sendAck 发送推送消息的 ACK
@@ -114,32 +193,36 @@ func sendAck(conn gnet.Conn, frame *protocol.Frame) {
 }
 
 // sendPing 发送心跳
-func sendPing(conn gnet.Conn) error {
-	ping := NewHeartbeatRequest()
-	pingFrame, err := protocol.EncodeMessageToFrame(0, protocol.REQ, ping)
+func sendPing(conn gnet.Conn, codec *protocol.Codec) error {
+	data, err := codec.EncodeMessageToBytes(0, protocol.REQ, NewHeartbeatRequest())
 	if err != nil {
 		return err
 	}
-	return writeFrame(conn, protocol.ToBytes(pingFrame))
+	return writeFrame(conn, data)
 }
 
-func (s *sender) dispatchWorker() {
+func (s *sender) dispatchWorker(queue *dispatchQueue) {
 	for {
+		var item *dispatchItem
 		select {
 		case <-s.ctx.Done():
 			return
-		case item := <-s.respChan:
-			// 先回 ACK，减少服务端重试
-			sendAck(item.conn, item.frame)
-			msg, err := protocol.DecodeMessage(item.frame)
-			if err != nil {
-				s.log.Errorf("dispatchWorker: decode error: %v", err)
-				continue
-			}
-			if s.dispatch != nil {
-				s.dispatch(msg)
+		case item = <-queue.items:
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		if queue.paused.CompareAndSwap(true, false) {
+			// 队列有了空位, 唤醒连接继续读取暂停的推送
+			if err := item.conn.Wake(nil); err != nil {
+				s.log.Warnf("dispatchWorker: wake connection: %v", err)
 			}
 		}
+		// 先回 ACK，减少服务端重试
+		sendAck(item.conn, item.frame)
+		if s.dispatch != nil {
+			s.dispatch(item.msg)
+		}
 	}
 }
 
@@ -175,17 +258,28 @@ func (p *promise) await(ctx context.Context, timeout time.Duration) (protocol.Me
 	}
 }
 
+// complete 同一个 ACK 可能在暂停读取时提前处理过一次, 重复调用不做处理
 func (p *promise) complete(frame *protocol.Frame) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
 	p.resp = frame
-	p.mu.Unlock()
 	close(p.done)
 }
 
 func (p *promise) fail(err error) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
 	p.err = err
-	p.mu.Unlock()
 	close(p.done)
 }
 
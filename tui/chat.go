@@ -2,16 +2,21 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/xuning888/helloIMClient/conf"
 	"github.com/xuning888/helloIMClient/im"
 	sqllite2 "github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/e2e"
 	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
 	"github.com/xuning888/helloIMClient/im/protocol/send"
 	"github.com/xuning888/helloIMClient/pkg"
 	"github.com/xuning888/helloIMClient/pkg/logger"
@@ -24,8 +29,21 @@ type chatModel struct {
 	sdk      *im.Client
 	viewport viewport.Model
 	textarea textarea.Model
-	width    int
-	height   int
+	notice   string
+	// markdown 为 true 时发送的文本消息按 markdown 格式发送
+	markdown bool
+	// focusMsgId 从消息搜索跳转过来时定位的消息
+	focusMsgId int64
+	// scrollTo 下次渲染时滚动到的消息
+	scrollTo int64
+	// msgOffsets 上次渲染时每条消息在视口内容中的起始行
+	msgOffsets map[int64]int
+	// loadingHistory 正在加载更早或更新的消息
+	loadingHistory bool
+	// historyFailed 加载历史消息失败, 等待重试时间过去或用户再次滚动后才重新加载
+	historyFailed bool
+	width         int
+	height        int
 }
 
 func initChatModel(chat *sqllite2.ImChat, sdk *im.Client) *chatModel {
@@ -34,10 +52,25 @@ func initChatModel(chat *sqllite2.ImChat, sdk *im.Client) *chatModel {
 	ta.Focus()
 	ta.Prompt = "│ "
 	ta.ShowLineNumbers = false
-	ta.KeyMap.InsertNewline.SetEnabled(false)
+	// Enter 用于发送消息, 使用 ctrl+j 或 alt+enter 换行
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("ctrl+j", "alt+enter"))
 
 	vp := viewport.New(50, 10)
 	vp.Style = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(borderColor)
+	// 输入框获取了焦点, 视口只保留不和输入冲突的翻页按键
+	vp.KeyMap = viewport.KeyMap{
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		Up:       key.NewBinding(key.WithKeys("ctrl+up")),
+		Down:     key.NewBinding(key.WithKeys("ctrl+down")),
+	}
+
+	// 恢复上次离开会话时没有发送的内容
+	if draft, err := sdk.Storage().Drafts.Get(context.Background(), chat.ChatId, chat.ChatType); err != nil {
+		logger.Errorf("加载草稿失败: %v", err)
+	} else if draft != nil {
+		ta.SetValue(draft.DraftContent)
+	}
 
 	cache := sdk.Storage().Messages.NewCache(chat)
 	return &chatModel{
@@ -55,32 +88,126 @@ func (m chatModel) Init() tea.Cmd {
 func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 	switch msg := msg.(type) {
+	case tea.MouseMsg:
+		m.historyFailed = false
 	case tea.KeyMsg:
 		switch msg.Type {
+		case tea.KeyPgUp, tea.KeyPgDown, tea.KeyCtrlUp, tea.KeyCtrlDown:
+			// 用户主动滚动时重新允许加载历史消息
+			m.historyFailed = false
 		case tea.KeyEsc:
+			// 先保存草稿, 再刷新会话列表
+			m.saveDraft()
 			cmds = append(cmds, FetchBackToListMsg(), FetchUpdatedChatListCmd(m.sdk))
 			return m, tea.Batch(cmds...)
+		case tea.KeyCtrlT:
+			m.markdown = !m.markdown
+			if m.markdown {
+				m.notice = "Markdown 模式已开启"
+			} else {
+				m.notice = "Markdown 模式已关闭"
+			}
+			return &m, nil
 		case tea.KeyEnter:
+			if msg.Alt {
+				break
+			}
 			var message *sqllite2.ChatMessage = nil
+			if m.textarea.Focused() && isTrustCommand(m.textarea.Value()) {
+				cmd := trustKeyCmd(m.sdk, m.cache.GetChat().ChatId, m.textarea.Value())
+				m.textarea.Reset()
+				return &m, cmd
+			}
 			if m.textarea.Focused() {
-				message = m.sendMessage()
+				var err error
+				if message, err = m.sendMessage(); err != nil {
+					m.notice = err.Error()
+				} else {
+					m.notice = ""
+				}
 				m.textarea.Reset()
 				cmds = append(cmds, viewport.Sync(m.viewport))
 			}
 			if message != nil {
+				m.focusMsgId = 0
+				chat := m.cache.GetChat()
+				if err := m.sdk.Storage().Drafts.Delete(context.Background(), chat.ChatId, chat.ChatType); err != nil {
+					logger.Errorf("删除草稿失败: %v", err)
+				}
 				cmds = append(cmds, FetchUpdatedChatListCmd(m.sdk))
 				chatId := m.cache.GetChat().ChatId
 				cmds = append(cmds, FetchUpdateMessage(chatId, []*sqllite2.ChatMessage{message}))
 			}
+		case tea.KeyCtrlP, tea.KeyCtrlO:
+			voice := lastVoiceMessage(m.cache.GetMessages())
+			if voice == nil {
+				m.notice = "当前会话没有语音消息"
+				return &m, nil
+			}
+			if msg.Type == tea.KeyCtrlP {
+				m.notice = "正在播放语音..."
+				return &m, fetchPlayVoiceCmd(m.sdk, voice)
+			}
+			return &m, fetchSaveVoiceCmd(m.sdk, voice)
+		case tea.KeyCtrlE:
+			chat := m.cache.GetChat()
+			if !m.sdk.E2EEnabled() || chat.ChatType != 1 {
+				m.notice = "只有开启端到端加密的单聊可以查看指纹"
+				return &m, nil
+			}
+			return &m, fetchFingerprintCmd(m.sdk, chat.ChatId)
+		case tea.KeyCtrlK:
+			card := lastContactCard(m.cache.GetMessages())
+			if card == nil {
+				m.notice = "当前会话没有名片消息"
+				return &m, nil
+			}
+			return &m, fetchOpenChatCmd(card.GetUserId(), 1)
+		}
+	case mediaResultMsg:
+		if msg.err != nil {
+			logger.Errorf("语音消息处理失败, error: %v", msg.err)
+			m.notice = msg.err.Error()
+		} else {
+			m.notice = msg.notice
+		}
+	case fingerprintMsg:
+		if m.cache.GetChat().ChatId == msg.chatId {
+			if msg.err != nil {
+				m.notice = msg.err.Error()
+			} else {
+				m.notice = msg.notice
+			}
 		}
 	case updateMessage:
 		if m.cache.GetChat().ChatId == msg.chatId {
 			m.cache.UpdateMessage(msg.msgs)
+			m.focusMsgId = 0
+			m.refreshViewport()
+		}
+	case historyLoadedMsg:
+		if m.cache.GetChat().ChatId == msg.chatId {
+			m.loadingHistory = false
+			if msg.err != nil {
+				m.historyFailed = true
+				m.notice = "加载历史消息失败, 请稍后滚动重试"
+				cmds = append(cmds, fetchHistoryRetryCmd(msg.chatId))
+			}
+			m.refreshViewport()
+		}
+	case usersFetchedMsg:
+		m.refreshViewport()
+	case historyRetryMsg:
+		if m.cache.GetChat().ChatId == msg.chatId {
+			m.historyFailed = false
 		}
 	}
 	var taCmd, vpCmd tea.Cmd
 	m.textarea, taCmd = m.textarea.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
+	if cmd := m.loadHistoryCmd(); cmd != nil {
+		cmds = append(cmds, cmd)
+	}
 
 	if taCmd != nil {
 		cmds = append(cmds, taCmd)
@@ -95,8 +222,9 @@ func (m chatModel) View() string {
 	var chatName string
 	chat := m.cache.GetChat()
 	if chat.ChatType == 1 {
-		if user, err := m.sdk.Storage().Users.Get(context.Background(), chat.ChatId); err == nil {
-			chatName = user.UserName
+		chatName = userName(m.sdk, chat.ChatId)
+		if m.sdk.E2EEnabled() {
+			chatName += " 🔒"
 		}
 	}
 	title := lipgloss.NewStyle().
@@ -106,9 +234,14 @@ func (m chatModel) View() string {
 		Foreground(textColor).
 		Bold(true).
 		Align(lipgloss.Center).
-		Render(fmt.Sprintf("与 %s 聊天中", chatName))
+		Render(fmt.Sprintf("与 %s 聊天中\n%s", chatName,
+			lipgloss.NewStyle().Foreground(subtextColor).Render(m.notice)))
 
-	messageArea := m.viewMessage()
+	messageArea := m.viewport.View()
+	if len(m.msgOffsets) == 0 {
+		messageArea = lipgloss.Place(m.viewport.Width, m.viewport.Height, lipgloss.Center, lipgloss.Center,
+			"暂无消息，开始对话吧！")
+	}
 	messageArea = lipgloss.NewStyle().
 		Width(m.width).
 		Height(m.height - 5).
@@ -124,26 +257,56 @@ func (m chatModel) View() string {
 	return lipgloss.JoinVertical(lipgloss.Left, title, messageArea, inputArea)
 }
 
-func (m chatModel) sendMessage() *sqllite2.ChatMessage {
+func (m chatModel) sendMessage() (*sqllite2.ChatMessage, error) {
 	value := m.textarea.Value()
-	if value == "" {
-		return nil
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
 	}
 	chat := m.cache.GetChat()
-	p := payload.NewTextMessage(value, false, nil)
+	p, err := buildPayload(m.sdk, value, m.markdown)
+	if err != nil {
+		logger.Errorf("构造消息失败, error: %v", err)
+		return nil, err
+	}
+	if p == nil {
+		return nil, nil
+	}
+	// 开启端到端加密时 SDK 在发送前加密单聊消息
 	request := send.NewSendMsg(m.sdk.GetUID(), chat.ChatId, chat.ChatType, p, 0, 0)
-	ack, err := m.sdk.SendMessage(context.Background(), request)
+	ack, sent, err := m.sdk.SendMessage(context.Background(), request)
+	if errors.Is(err, e2e.ErrNoPeerKey) {
+		logger.Errorf("消息加密失败, error: %v", err)
+		return nil, fmt.Errorf("对方还没有开启端到端加密, 消息未发送")
+	}
+	if errors.Is(err, e2e.ErrKeyChanged) {
+		logger.Errorf("消息加密失败, error: %v", err)
+		pinned, pending, _ := m.sdk.PendingPeerKey(chat.ChatId)
+		return nil, errors.New(keyChangedNotice(pinned, pending))
+	}
 	if err != nil {
 		logger.Errorf("消息发送失败, error: %v", err)
 		m.textarea.SetValue("")
-		return nil
+		return nil, fmt.Errorf("消息发送失败: %w", err)
 	}
 	sendAck, ok := ack.(*send.SendAck)
 	if !ok {
-		return nil
+		return nil, nil
+	}
+	// 保存经过发送拦截器之后的消息, 拦截器可能修改了内容
+	if final, ok := sent.(*send.SendMsg); ok {
+		request = final
 	}
 	msg := m.saveSentMessage(request, sendAck)
-	return msg
+	return msg, nil
+}
+
+// focusMessage 加载 msg 前后的消息并定位到 msg
+func (m *chatModel) focusMessage(msg *sqllite2.ChatMessage) {
+	m.cache.LoadAround(msg.ServerSeq)
+	m.focusMsgId = msg.MsgID
+	m.scrollTo = msg.MsgID
+	m.notice = "已定位到搜索的消息"
+	m.refreshViewport()
 }
 
 func (m *chatModel) updateSize(width, height int) {
@@ -152,12 +315,38 @@ func (m *chatModel) updateSize(width, height int) {
 	m.viewport.Width = width - 4
 	m.viewport.Height = height - 7
 	m.textarea.SetWidth(width - 2)
+	m.refreshViewport()
 }
 
+// loadHistoryCmd 视口滚动到顶部或底部时加载更早或更新的一页消息
+func (m *chatModel) loadHistoryCmd() tea.Cmd {
+	if m.loadingHistory || m.historyFailed || len(m.msgOffsets) == 0 {
+		return nil
+	}
+	if m.viewport.AtTop() && m.cache.HasOlder() {
+		m.loadingHistory = true
+		return fetchLoadHistoryCmd(m.cache, true)
+	}
+	if m.viewport.AtBottom() && !m.cache.AtLatest() {
+		m.loadingHistory = true
+		return fetchLoadHistoryCmd(m.cache, false)
+	}
+	return nil
+}
+
+// saveDraft 保存输入框中没有发送的内容, 输入框为空时删除草稿
+func (m *chatModel) saveDraft() {
+	chat := m.cache.GetChat()
+	if err := m.sdk.Storage().Drafts.Save(context.Background(), chat.ChatId, chat.ChatType, m.textarea.Value()); err != nil {
+		logger.Errorf("保存草稿失败: %v", err)
+	}
+}
+
+// saveSentMessage 保存发出的消息, 加密消息在本地保存加密前的 p
 func (m chatModel) saveSentMessage(req *send.SendMsg, ack *send.SendAck) *sqllite2.ChatMessage {
 	chat := m.cache.GetChat()
 	uid := m.sdk.GetUID()
-	content, contentType := payload.ExtractContent(req.Payload)
+	content, contentType := payload.ExtractContent(req.GetPayload())
 	message := sqllite2.NewMessage(chat.ChatType, chat.ChatId, ack.MsgId(), uid, chat.ChatId,
 		req.FromUserType, req.ToUserType, ack.MsgSeq(), content, contentType, req.CmdId(),
 		req.SendTimestamp, 0, ack.ServerSeq())
@@ -168,38 +357,114 @@ func (m chatModel) saveSentMessage(req *send.SendMsg, ack *send.SendAck) *sqllit
 	return message
 }
 
-func (m chatModel) viewMessage() string {
+// refreshViewport 重新渲染消息, 保持当前可见的消息停留在原来的位置
+// Note: 之前在底部并且窗口包含最新的消息时跟随到底部
+func (m *chatModel) refreshViewport() {
+	followBottom := m.viewport.AtBottom() && m.cache.AtLatest()
+	anchorId, anchorDelta, hasAnchor := m.anchor()
+	content, offsets := m.renderMessages()
+	m.viewport.SetContent(content)
+	m.msgOffsets = offsets
+	if offset, ok := offsets[m.scrollTo]; ok && m.scrollTo != 0 {
+		m.viewport.SetYOffset(offset)
+		m.scrollTo = 0
+		return
+	}
+	if offset, ok := offsets[anchorId]; ok && hasAnchor && !followBottom {
+		m.viewport.SetYOffset(offset + anchorDelta)
+		return
+	}
+	m.viewport.GotoBottom()
+}
+
+// anchor 视口顶部的消息以及视口顶部相对这条消息起始行的偏移
+func (m *chatModel) anchor() (msgId int64, delta int, ok bool) {
+	top := m.viewport.YOffset
+	best := -1
+	for id, offset := range m.msgOffsets {
+		if offset <= top && offset > best {
+			msgId, best = id, offset
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	return msgId, top - best, true
+}
+
+// renderMessages 渲染窗口内的消息, 同时返回每条消息的起始行
+func (m *chatModel) renderMessages() (string, map[int64]int) {
 	chatMessages := m.cache.GetMessages()
+	offsets := make(map[int64]int, len(chatMessages))
 	if len(chatMessages) == 0 {
-		return lipgloss.Place(m.viewport.Width, m.viewport.Height, lipgloss.Center, lipgloss.Center,
-			"暂无消息，开始对话吧！")
+		return "", offsets
 	}
 	var messages strings.Builder
+	if !m.cache.HasOlder() {
+		tip := lipgloss.NewStyle().Width(m.viewport.Width).Align(lipgloss.Center).Foreground(subtextColor).
+			Render("—— 没有更早的消息了 ——")
+		messages.WriteString(tip + "\n")
+	}
 	uid := m.sdk.GetUID()
+	lines := strings.Count(messages.String(), "\n")
 	for _, msg := range chatMessages {
+		style := messageStyle(yourMsgStyle, msg)
+		if msg.MsgFrom == uid {
+			style = messageStyle(myMsgStyle, msg)
+		}
+		if msg.MsgID == m.focusMsgId {
+			style = style.BorderForeground(focusColor)
+		}
+		offsets[msg.MsgID] = lines
 		timeStr := pkg.FormatTime(msg.SendTime, pkg.DateTime)
+		var message string
 		if msg.MsgFrom == uid {
 			content := lipgloss.JoinVertical(lipgloss.Left,
 				lipgloss.NewStyle().Foreground(subtextColor).Render(timeStr),
-				msg.MsgContent,
+				m.messageContent(msg),
 			)
-			message := myMsgStyle.Render(content)
+			message = style.Render(content)
 			message = lipgloss.NewStyle().Width(m.viewport.Width).Align(lipgloss.Right).Render(message)
-			messages.WriteString(message + "\n")
 		} else {
-			var name string
-			if user, err := m.sdk.Storage().Users.Get(context.Background(), msg.MsgFrom); err == nil {
-				name = user.UserName
-			}
+			name := userName(m.sdk, msg.MsgFrom)
 			content := lipgloss.JoinVertical(lipgloss.Left,
 				lipgloss.NewStyle().Foreground(subtextColor).Render(fmt.Sprintf("%s %s", name, timeStr)),
-				msg.MsgContent,
+				m.messageContent(msg),
 			)
-			message := yourMsgStyle.Render(content)
-			messages.WriteString(message + "\n")
+			message = style.Render(content)
 		}
+		messages.WriteString(message + "\n")
+		lines += strings.Count(message, "\n") + 1
 	}
-	m.viewport.SetContent(messages.String())
-	m.viewport.GotoBottom()
-	return m.viewport.View()
+	return messages.String(), offsets
+}
+
+// messageContent 按消息类型渲染消息内容
+func (m chatModel) messageContent(msg *sqllite2.ChatMessage) string {
+	switch helloim_proto.PayloadType(msg.ContentType) {
+	case helloim_proto.PayloadType_MARKDOWN:
+		if conf.RichText {
+			return renderMarkdown(msg.MsgContent, m.viewport.Width-markdownBubbleMargin)
+		}
+	case helloim_proto.PayloadType_VOICE:
+		return viewVoice(msg.MsgContent)
+	case helloim_proto.PayloadType_LOCATION:
+		return viewLocation(msg.MsgContent)
+	case helloim_proto.PayloadType_CONTACT_CARD:
+		return viewContactCard(msg.MsgContent)
+	case helloim_proto.PayloadType_ENCRYPTED:
+		return "[加密消息, 无法解密]"
+	}
+	return msg.MsgContent
+}
+
+// markdownBubbleMargin 气泡的外边距、内边距和边框占用的宽度
+const markdownBubbleMargin = 10
+
+// messageStyle markdown 消息按视口宽度折行, 不再限制气泡的最大宽度
+func messageStyle(style lipgloss.Style, msg *sqllite2.ChatMessage) lipgloss.Style {
+	if conf.RichText && msg.ContentType == int32(helloim_proto.PayloadType_MARKDOWN) {
+		return style.UnsetMaxWidth()
+	}
+	return style
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/xuning888/helloIMClient/im"
 	sqllite2 "github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
 	"github.com/xuning888/helloIMClient/pkg"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
@@ -19,9 +20,17 @@ type chatListModel struct {
 	sdk          *im.Client
 	cursor       int
 	chats        []*sqllite2.ImChat
+	archived     []*sqllite2.ImChat
 	lastMessages map[string]*sqllite2.ChatMessage
-	width        int
-	height       int
+	drafts       map[string]*sqllite2.ChatDraft
+	// requests 待处理的好友申请数
+	requests int
+	// showArchived 显示已归档的会话
+	showArchived bool
+	// focused 焦点在会话列表上, 只有这时处理置顶、删除等按键, 避免和输入框冲突
+	focused bool
+	width   int
+	height  int
 }
 
 func initChatListModel(sdk *im.Client) chatListModel {
@@ -31,13 +40,86 @@ func initChatListModel(sdk *im.Client) chatListModel {
 		logger.Errorf("Error loading chats: %v", err)
 		chats = make([]*sqllite2.ImChat, 0)
 	}
+	archived, err := sdk.Storage().Chats.ListArchived(ctx)
+	if err != nil {
+		logger.Errorf("Error loading archived chats: %v", err)
+	}
 	lastMessages := sdk.Storage().Messages.BatchLastMessageFromRemote(ctx, chats)
+	for key, msg := range sdk.Storage().Messages.BatchLastMessage(ctx, archived) {
+		lastMessages[key] = msg
+	}
+	drafts, err := sdk.Storage().Drafts.List(ctx)
+	if err != nil {
+		logger.Errorf("Error loading drafts: %v", err)
+	}
+	requests, err := sdk.Storage().Contacts.Requests(ctx)
+	if err != nil {
+		logger.Errorf("Error loading friend requests: %v", err)
+	}
 	return chatListModel{
 		sdk:          sdk,
 		cursor:       0,
 		chats:        chats,
+		archived:     archived,
 		lastMessages: lastMessages,
+		drafts:       drafts,
+		requests:     len(requests),
+		focused:      true,
+	}
+}
+
+// visibleChats 当前显示的会话列表
+func (m chatListModel) visibleChats() []*sqllite2.ImChat {
+	if m.showArchived {
+		return m.archived
 	}
+	return m.chats
+}
+
+// selected 光标所在的会话, 列表为空时返回 nil
+func (m chatListModel) selected() *sqllite2.ImChat {
+	chats := m.visibleChats()
+	if m.cursor >= 0 && m.cursor < len(chats) {
+		return chats[m.cursor]
+	}
+	return nil
+}
+
+// handleChatAction 处理置顶、免打扰、删除和归档的按键
+func (m chatListModel) handleChatAction(key string) tea.Cmd {
+	chat := m.selected()
+	if chat == nil {
+		return nil
+	}
+	chats := m.sdk.Storage().Chats
+	chatId, chatType := chat.ChatId, chat.ChatType
+	switch key {
+	case "p":
+		if chat.ChatTop {
+			return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Unpin(ctx, chatId, chatType) })
+		}
+		return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Pin(ctx, chatId, chatType) })
+	case "m":
+		if chat.ChatMute {
+			return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Unmute(ctx, chatId, chatType) })
+		}
+		return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Mute(ctx, chatId, chatType) })
+	case "a":
+		if chat.ChatArchive {
+			return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Unarchive(ctx, chatId, chatType) })
+		}
+		return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Archive(ctx, chatId, chatType) })
+	case "d":
+		return chatActionCmd(m.sdk, func(ctx context.Context) error { return chats.Delete(ctx, chatId, chatType) })
+	case "b":
+		// 拉黑单聊的对方, 会话随之隐藏, 在添加好友页中取消拉黑
+		if chatType != 1 {
+			return nil
+		}
+		contacts := m.sdk.Storage().Contacts
+		return chatActionCmd(m.sdk, func(ctx context.Context) error { return contacts.Block(ctx, chatId) })
+	}
+	return nil
 }
 
 func (m chatListModel) Init() tea.Cmd {
@@ -53,17 +135,29 @@ func (m chatListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cursor--
 			}
 		case tea.KeyDown.String():
-			if m.cursor < len(m.chats)-1 {
+			if m.cursor < len(m.visibleChats())-1 {
 				m.cursor++
 			}
 		case tea.KeyEnter.String():
-			if m.cursor >= 0 && m.cursor < len(m.chats) {
-				chat := m.chats[m.cursor]
+			if chat := m.selected(); chat != nil {
 				return m, fetchChatModel(chat)
 			}
 			return m, nil
 		case tea.KeyF3.String():
 			return m, fetchStartSearchCmd()
+		case tea.KeyF4.String():
+			return m, fetchStartMessageSearchCmd()
+		case tea.KeyF6.String():
+			return m, fetchStartAddFriendCmd()
+		case tea.KeyF5.String():
+			if m.focused {
+				m.showArchived = !m.showArchived
+				m.cursor = 0
+			}
+		case "p", "m", "a", "d", "b":
+			if m.focused {
+				return m, m.handleChatAction(msg.String())
+			}
 		case tea.KeyCtrlC.String():
 			return m, tea.Quit
 		}
@@ -72,10 +166,12 @@ func (m chatListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		newSelected := 0
-		selected := m.cursor
-		if selected >= 0 && selected < len(m.chats) {
-			selectedChat := m.chats[selected]
-			for i, chat := range msg.chats {
+		if selectedChat := m.selected(); selectedChat != nil {
+			chats := msg.chats
+			if m.showArchived {
+				chats = msg.archived
+			}
+			for i, chat := range chats {
 				if chat.ChatId == selectedChat.ChatId && chat.ChatType == selectedChat.ChatType {
 					newSelected = i
 					break
@@ -83,9 +179,15 @@ func (m chatListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 		m.chats = msg.chats
+		m.archived = msg.archived
 		m.lastMessages = msg.lastMessages
+		m.drafts = msg.drafts
 		m.cursor = newSelected
 		logger.Infof("触发更新会话列表事件")
+	case friendRequestsMsg:
+		if msg.err == nil {
+			m.requests = len(msg.requests)
+		}
 	}
 	return m, nil
 }
@@ -105,23 +207,27 @@ func (m chatListModel) chatListView() string {
 		Bold(true).
 		Align(lipgloss.Center).
 		PaddingTop(1).
-		Render(" 会话列表 ")
+		Render(m.title())
 	content.WriteString(title + "\n")
 
-	for i, chat := range m.chats {
+	chats := m.visibleChats()
+	for i, chat := range chats {
 		var name string
 		if chat.ChatType == 1 {
-			if user, err := m.sdk.Storage().Users.Get(context.Background(), chat.ChatId); err == nil {
-				name = user.UserName
-			}
+			name = userName(m.sdk, chat.ChatId)
 		}
+		name += chatFlags(chat)
 		lastMsg := m.lastMessages[chat.Key()]
 		lastMsgText := ""
 		if lastMsg != nil {
-			lastMsgText = truncateText(lastMsg.MsgContent, 20)
+			lastMsgText = truncateText(payload.Summary(lastMsg.ContentType, lastMsg.MsgContent), 20)
+		}
+		if draft := m.drafts[chat.Key()]; draft != nil {
+			lastMsgText = lipgloss.NewStyle().Foreground(draftColor).Render("[草稿]") + " " +
+				truncateText(strings.ReplaceAll(draft.DraftContent, "\n", " "), 20)
 		}
 
-		timeStr := pkg.FormatTime(chat.UpdateTimestamp, pkg.DateTime)
+		timeStr := pkg.FormatTime(max(chat.UpdateTimestamp, chat.DraftTimestamp), pkg.DateTime)
 
 		chatContent := fmt.Sprintf("%s\n%s", name, lastMsgText)
 		timeContent := fmt.Sprintf("%s", timeStr)
@@ -140,7 +246,7 @@ func (m chatListModel) chatListView() string {
 
 		content.WriteString(item + "\n")
 
-		if i < len(m.chats)-1 {
+		if i < len(chats)-1 {
 			separator := lipgloss.NewStyle().
 				Width(m.width).
 				Foreground(borderColor).
@@ -155,6 +261,35 @@ func (m chatListModel) chatListView() string {
 		Render(content.String())
 }
 
+func (m chatListModel) title() string {
+	if m.showArchived {
+		return fmt.Sprintf(" 已归档(%d) ", len(m.archived))
+	}
+	title := " 会话列表 "
+	if len(m.archived) > 0 {
+		title += fmt.Sprintf("· 已归档 %d ", len(m.archived))
+	}
+	if m.requests > 0 {
+		title += fmt.Sprintf("· 好友申请 %d ", m.requests)
+	}
+	return title
+}
+
+// chatFlags 会话名称后面的置顶、免打扰标记
+func chatFlags(chat *sqllite2.ImChat) string {
+	var flags []string
+	if chat.ChatTop {
+		flags = append(flags, "置顶")
+	}
+	if chat.ChatMute {
+		flags = append(flags, "免打扰")
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return lipgloss.NewStyle().Foreground(subtextColor).Render(" [" + strings.Join(flags, "·") + "]")
+}
+
 func truncateText(text string, maxLen int) string {
 	if len(text) <= maxLen {
 		return text
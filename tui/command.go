@@ -0,0 +1,87 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xuning888/helloIMClient/im"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+// 输入框中支持的命令
+const (
+	voiceCommand    = "/voice"    // /voice <音频文件路径> [时长(秒)]
+	locationCommand = "/location" // /location <纬度> <经度> <地点名称> [详细地址]
+	cardCommand     = "/card"     // /card <用户id>
+	markdownCommand = "/md"       // /md <markdown 文本>
+	trustCommand    = "/trust"    // /trust <对方的新指纹>, 确认对方变更后的公钥, 不发送消息
+)
+
+// isTrustCommand 输入框的内容是否是 /trust 命令
+func isTrustCommand(value string) bool {
+	fields := strings.Fields(value)
+	return len(fields) > 0 && fields[0] == trustCommand
+}
+
+// buildPayload 根据输入框的内容构造消息体, 以 / 开头的内容按命令解析
+// markdown 为 true 时普通文本按 markdown 格式发送
+func buildPayload(sdk *im.Client, value string, markdown bool) (*helloim_proto.Payload, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	switch fields[0] {
+	case markdownCommand:
+		content := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), markdownCommand))
+		if content == "" {
+			return nil, fmt.Errorf("用法: %s <markdown 文本>", markdownCommand)
+		}
+		return payload.NewMarkdownMessage(content, false, nil), nil
+	case voiceCommand:
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("用法: %s <音频文件路径> [时长(秒)]", voiceCommand)
+		}
+		var duration int64
+		if len(fields) > 2 {
+			var err error
+			if duration, err = strconv.ParseInt(fields[2], 10, 32); err != nil {
+				return nil, fmt.Errorf("无效的时长: %s", fields[2])
+			}
+		}
+		return sdk.NewVoiceMessage(context.Background(), fields[1], int32(duration))
+	case locationCommand:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("用法: %s <纬度> <经度> <地点名称> [详细地址]", locationCommand)
+		}
+		lat, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("无效的纬度: %s", fields[1])
+		}
+		lng, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || lng < -180 || lng > 180 {
+			return nil, fmt.Errorf("无效的经度: %s", fields[2])
+		}
+		address := strings.Join(fields[4:], " ")
+		return payload.NewLocationMessage(lat, lng, fields[3], address, false, nil), nil
+	case cardCommand:
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("用法: %s <用户id>", cardCommand)
+		}
+		userId, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的用户id: %s", fields[1])
+		}
+		user, err := sdk.Storage().Users.Get(context.Background(), userId)
+		if err != nil {
+			return nil, fmt.Errorf("用户 %d 不存在", userId)
+		}
+		return payload.NewContactCardMessage(user.UserID, user.UserName, user.Icon, false, nil), nil
+	}
+	if markdown {
+		return payload.NewMarkdownMessage(value, false, nil), nil
+	}
+	return payload.NewTextMessage(value, false, nil), nil
+}
@@ -0,0 +1,45 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+func TestBuildPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		markdown bool
+		want     helloim_proto.PayloadType
+		content  string
+		empty    bool
+		wantErr  bool
+	}{
+		{name: "whitespace", value: " \n\t ", empty: true},
+		{name: "text", value: "hello", want: helloim_proto.PayloadType_TEXT, content: "hello"},
+		{name: "markdown mode", value: "**hi**", markdown: true, want: helloim_proto.PayloadType_MARKDOWN, content: "**hi**"},
+		{name: "markdown command", value: "/md  **hi** ", want: helloim_proto.PayloadType_MARKDOWN, content: "**hi**"},
+		{name: "markdown without content", value: "/md ", wantErr: true},
+		{name: "voice without path", value: "/voice", wantErr: true},
+		{name: "invalid latitude", value: "/location 91 0 somewhere", wantErr: true},
+		{name: "invalid card user", value: "/card abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := buildPayload(nil, tt.value, tt.markdown)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			if tt.empty {
+				assert.Nil(t, p)
+				return
+			}
+			assert.Equal(t, tt.want, p.GetPayloadType())
+			assert.Equal(t, tt.content, p.GetText().GetContent())
+		})
+	}
+}
@@ -3,6 +3,7 @@ package tui
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -11,13 +12,17 @@ import (
 )
 
 type commonModel struct {
-	sdk      *im.Client
-	chatList chatListModel
-	chat     *chatModel
-	search   *searchModel
-	focus    string
-	width    int
-	height   int
+	sdk       *im.Client
+	chatList  chatListModel
+	chat      *chatModel
+	search    *searchModel
+	msgSearch *msgSearchModel
+	addFriend *addFriendModel
+	// kicked 被踢下线后不为空, 只显示提示框
+	kicked *im.KickedOut
+	focus  string
+	width  int
+	height int
 }
 
 func InitMainModel(sdk *im.Client) tea.Model {
@@ -36,7 +41,23 @@ func (m commonModel) Init() tea.Cmd {
 
 func (m commonModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd = make([]tea.Cmd, 0)
+	if m.kicked != nil {
+		switch msg := msg.(type) {
+		case tea.WindowSizeMsg:
+			m.width = msg.Width
+			m.height = msg.Height
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter", "q", tea.KeyCtrlC.String():
+				return m, tea.Quit
+			}
+		}
+		return m, nil
+	}
 	switch msg := msg.(type) {
+	case kickedOutMsg:
+		m.kicked = msg.info
+		return m, nil
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
@@ -44,6 +65,9 @@ func (m commonModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case tea.KeyCtrlC.String():
+			if m.chat != nil {
+				m.chat.saveDraft()
+			}
 			return m, tea.Quit
 		}
 	case selectChatMsg:
@@ -51,40 +75,75 @@ func (m commonModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.chat = initChatModel(msg.chat, m.sdk)
 			m.focus = "chat"
 			logger.Infof("首次进入会话")
+			cmds = append(cmds, markReadCmd(m.sdk, msg.chat))
 		} else {
 			if m.chat.cache.GetChat().ChatId != msg.chat.ChatId {
+				m.chat.saveDraft()
 				m.chat = initChatModel(msg.chat, m.sdk)
 				m.focus = "chat"
+				cmds = append(cmds, markReadCmd(m.sdk, msg.chat))
 			}
 		}
 		m.updateLayout()
 	case backToListMsg, exitSearch:
+		if m.chat != nil {
+			m.chat.saveDraft()
+		}
 		m.focus = "list"
 		m.chat = nil
+		m.search = nil
+		m.msgSearch = nil
+		m.addFriend = nil
 	case startSearchMsg:
-		m.search = initSearchModel()
+		m.search = initSearchModel(m.sdk)
 		m.focus = "search"
 		m.updateLayout()
+	case startMessageSearchMsg:
+		m.msgSearch = initMsgSearchModel(m.sdk)
+		m.focus = "msgSearch"
+		m.updateLayout()
+		return m, nil
+	case startAddFriendMsg:
+		m.addFriend = initAddFriendModel(m.sdk)
+		m.focus = "addFriend"
+		m.updateLayout()
+		return m, FetchFriendRequestsCmd(m.sdk)
+	case searchSelectedMessageMsg:
+		if err := m.openChat(msg.msg.ChatID, msg.msg.ChatType); err != nil {
+			return m, nil
+		}
+		m.msgSearch = nil
+		m.updateLayout()
+		m.chat.focusMessage(msg.msg)
+		return m, FetchUpdatedChatListCmd(m.sdk)
 	case searchSelectedUserMsg:
 		user := msg.user
 		if user != nil {
-			chat, err := m.sdk.Storage().Chats.GetOrCreate(context.Background(), user.UserID, 1)
-			if err != nil {
-				logger.Errorf("创建聊天会话失败: %v", err)
+			if err := m.openChat(user.UserID, 1); err != nil {
 				return m, nil
 			}
-			m.chat = initChatModel(chat, m.sdk)
-			m.focus = "chat"
 			m.search = nil
 		}
 		logger.Infof("触发搜索结果事件, user: %v", user)
 		cmds = append(cmds, FetchUpdatedChatListCmd(m.sdk))
 		m.updateLayout()
+	case openChatMsg:
+		if err := m.openChat(msg.chatId, msg.chatType); err != nil {
+			return m, nil
+		}
+		cmds = append(cmds, FetchUpdatedChatListCmd(m.sdk))
+		m.updateLayout()
+		// 新的会话已经打开, 本次消息不再交给旧的会话处理
+		return m, tea.Batch(cmds...)
 	}
-	updatedList, listCmd := m.chatList.Update(msg)
-	m.chatList = updatedList.(chatListModel)
-	if listCmd != nil {
-		cmds = append(cmds, listCmd)
+	// 搜索消息和添加好友时的按键只交给对应的页面处理, 避免 Enter 同时打开会话列表中选中的会话
+	if _, isKey := msg.(tea.KeyMsg); !isKey || m.focus != "msgSearch" && m.focus != "addFriend" {
+		m.chatList.focused = m.focus == "list"
+		updatedList, listCmd := m.chatList.Update(msg)
+		m.chatList = updatedList.(chatListModel)
+		if listCmd != nil {
+			cmds = append(cmds, listCmd)
+		}
 	}
 	if m.chat != nil {
 		updatedChat, chatCmd := m.chat.Update(msg)
@@ -104,6 +163,24 @@ func (m commonModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmds = append(cmds, searchCmd)
 		}
 	}
+	if m.msgSearch != nil {
+		updatedSearch, searchCmd := m.msgSearch.Update(msg)
+		if sm, ok := updatedSearch.(*msgSearchModel); ok {
+			m.msgSearch = sm
+		}
+		if searchCmd != nil {
+			cmds = append(cmds, searchCmd)
+		}
+	}
+	if m.addFriend != nil {
+		updated, addFriendCmd := m.addFriend.Update(msg)
+		if am, ok := updated.(*addFriendModel); ok {
+			m.addFriend = am
+		}
+		if addFriendCmd != nil {
+			cmds = append(cmds, addFriendCmd)
+		}
+	}
 	return m, tea.Batch(cmds...)
 }
 
@@ -111,11 +188,24 @@ func (m commonModel) View() string {
 	if m.width == 0 || m.height == 0 {
 		return "Loading..."
 	}
+	if m.kicked != nil {
+		return kickedOutView(m.kicked, m.width, m.height)
+	}
 	if m.focus == "search" {
 		if m.search != nil {
 			return m.search.View()
 		}
 	}
+	if m.focus == "msgSearch" {
+		if m.msgSearch != nil {
+			return m.msgSearch.View()
+		}
+	}
+	if m.focus == "addFriend" {
+		if m.addFriend != nil {
+			return m.addFriend.View()
+		}
+	}
 	leftWidth := m.width / 3
 	rightWidth := m.width - leftWidth
 
@@ -147,12 +237,24 @@ func (m commonModel) View() string {
 	return lipgloss.JoinVertical(lipgloss.Left, content, statusBar)
 }
 
+// userName 渲染时使用的用户名, 本地没有资料时先显示用户 id, 后台获取完成后重新渲染
+func userName(sdk *im.Client, userId int64) string {
+	if user, ok := sdk.Storage().Users.Peek(context.Background(), userId); ok {
+		return user.UserName
+	}
+	return strconv.FormatInt(userId, 10)
+}
+
 func (m commonModel) statusBarView() string {
 	focusInfo := fmt.Sprintf("焦点: %s", m.focus)
 	if m.focus == "list" {
-		focusInfo = "list: ↑↓ 选择 • Space 打开 • Tab 切换 • ctrl+c 退出"
+		focusInfo = "list: ↑↓ 选择 • Space 打开 • Tab 切换 • p 置顶 • m 免打扰 • d 删除 • a 归档 • b 拉黑 • F5 已归档 • F3 搜索好友 • F4 搜索消息 • F6 添加好友 • ctrl+c 退出"
 	} else if m.focus == "chat" {
-		focusInfo = "chat: Enter 发送 • Tab 切换 • Esc 返回"
+		focusInfo = "chat: Enter 发送 • PgUp/PgDn 翻页 • ctrl+j 换行 • ctrl+t Markdown • /voice /location /card 发送语音、位置、名片 • ctrl+p 播放语音 • ctrl+k 名片聊天 • ctrl+e 查看指纹 • /trust 确认对方新公钥 • Esc 返回"
+	} else if m.focus == "msgSearch" {
+		focusInfo = "msgSearch: ↑↓ 选择 • Enter 跳转到消息 • Esc 返回"
+	} else if m.focus == "addFriend" {
+		focusInfo = "addFriend: ↑↓ 选择 • Enter 同意申请/发送申请 • ctrl+r 拒绝申请 • ctrl+b 拉黑/取消拉黑 • Esc 返回"
 	} else {
 		focusInfo = "search: ↑↓ 选择 • Enter 创建会话 • Esc 返回"
 	}
@@ -166,6 +268,21 @@ func (m commonModel) statusBarView() string {
 		Render(focusInfo)
 }
 
+// openChat 通过 Chats.GetOrCreate 打开与指定会话的聊天
+func (m *commonModel) openChat(chatId int64, chatType int32) error {
+	chat, err := m.sdk.Storage().Chats.GetOrCreate(context.Background(), chatId, chatType)
+	if err != nil {
+		logger.Errorf("创建聊天会话失败: %v", err)
+		return err
+	}
+	if m.chat != nil {
+		m.chat.saveDraft()
+	}
+	m.chat = initChatModel(chat, m.sdk)
+	m.focus = "chat"
+	return nil
+}
+
 func (m *commonModel) updateLayout() {
 	if m.chat != nil {
 		m.chat.updateSize(m.width/3*2, m.height-1)
@@ -173,5 +290,11 @@ func (m *commonModel) updateLayout() {
 	if m.search != nil {
 		m.search.updateSize(m.width/3*2, m.height-1)
 	}
+	if m.msgSearch != nil {
+		m.msgSearch.updateSize(m.width, m.height-1)
+	}
+	if m.addFriend != nil {
+		m.addFriend.updateSize(m.width, m.height-1)
+	}
 	m.chatList.updateSize(m.width/3, m.height-1)
 }
@@ -0,0 +1,290 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/xuning888/helloIMClient/im"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+var _ tea.Model = &addFriendModel{}
+
+// addFriendModel 添加好友: 输入框为空时显示收到的好友申请, 输入用户名时在服务端搜索用户
+type addFriendModel struct {
+	sdk      *im.Client
+	input    textarea.Model
+	users    []*sqllite.ImUser
+	blocked  map[int64]bool
+	requests []*sqllite.FriendRequest
+	cursor   int
+	// status 最近一次操作的结果
+	status string
+	width  int
+	height int
+}
+
+func initAddFriendModel(sdk *im.Client) *addFriendModel {
+	ta := textarea.New()
+	ta.Placeholder = "输入用户名搜索..."
+	ta.Focus()
+	ta.ShowLineNumbers = false
+	ta.KeyMap.InsertNewline.SetEnabled(false)
+	return &addFriendModel{
+		sdk:     sdk,
+		input:   ta,
+		blocked: make(map[int64]bool),
+	}
+}
+
+func (m *addFriendModel) keyword() string {
+	return strings.TrimSpace(m.input.Value())
+}
+
+// itemCount 当前列表的长度, 输入框为空时是好友申请, 否则是搜索结果
+func (m *addFriendModel) itemCount() int {
+	if m.keyword() == "" {
+		return len(m.requests)
+	}
+	return len(m.users)
+}
+
+func (m addFriendModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m addFriendModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case tea.KeyEsc.String():
+			return &m, fetchExitSearchMsg()
+		case tea.KeyUp.String():
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case tea.KeyDown.String():
+			if m.cursor < m.itemCount()-1 {
+				m.cursor++
+			}
+		case tea.KeyEnter.String():
+			return &m, m.handleEnter()
+		case tea.KeyCtrlR.String():
+			if m.keyword() == "" && m.cursor < len(m.requests) {
+				requestId := m.requests[m.cursor].RequestId
+				contacts := m.sdk.Storage().Contacts
+				return &m, contactActionCmd("已拒绝好友申请", func(ctx context.Context) error {
+					return contacts.Reject(ctx, requestId)
+				})
+			}
+		case tea.KeyCtrlB.String():
+			if m.keyword() != "" && m.cursor < len(m.users) {
+				return &m, m.toggleBlock(m.users[m.cursor])
+			}
+		default:
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(msg)
+			cmds = append(cmds, cmd)
+			m.cursor = 0
+			if key := m.keyword(); key != "" {
+				cmds = append(cmds, searchRemoteUserCmd(m.sdk, key))
+			} else {
+				m.users = nil
+			}
+		}
+	case remoteUsersMsg:
+		// 丢弃过期的搜索结果
+		if msg.key != m.keyword() {
+			break
+		}
+		if msg.err != nil {
+			logger.Errorf("搜索用户失败: %v", msg.err)
+			m.status = fmt.Sprintf("搜索用户失败: %v", msg.err)
+		}
+		m.users = msg.users
+		m.blocked = msg.blocked
+		m.cursor = min(m.cursor, max(len(m.users)-1, 0))
+	case friendRequestsMsg:
+		if msg.err == nil {
+			m.requests = msg.requests
+			m.cursor = min(m.cursor, max(m.itemCount()-1, 0))
+		}
+	case contactActionMsg:
+		m.status = msg.text
+		cmds = append(cmds, FetchFriendRequestsCmd(m.sdk), FetchUpdatedChatListCmd(m.sdk))
+		if key := m.keyword(); key != "" {
+			cmds = append(cmds, searchRemoteUserCmd(m.sdk, key))
+		}
+	}
+	return &m, tea.Batch(cmds...)
+}
+
+// handleEnter 同意选中的好友申请, 或者向选中的用户发送好友申请
+func (m *addFriendModel) handleEnter() tea.Cmd {
+	contacts := m.sdk.Storage().Contacts
+	if m.keyword() == "" {
+		if m.cursor >= len(m.requests) {
+			return nil
+		}
+		request := m.requests[m.cursor]
+		return contactActionCmd(fmt.Sprintf("已添加 %s 为好友", request.FromUserName), func(ctx context.Context) error {
+			return contacts.Accept(ctx, request.RequestId)
+		})
+	}
+	if m.cursor >= len(m.users) {
+		return nil
+	}
+	user := m.users[m.cursor]
+	return contactActionCmd(fmt.Sprintf("已向 %s 发送好友申请", user.UserName), func(ctx context.Context) error {
+		_, err := contacts.SendRequest(ctx, user.UserID, "")
+		return err
+	})
+}
+
+func (m *addFriendModel) toggleBlock(user *sqllite.ImUser) tea.Cmd {
+	contacts := m.sdk.Storage().Contacts
+	if m.blocked[user.UserID] {
+		return contactActionCmd(fmt.Sprintf("已将 %s 移出黑名单", user.UserName), func(ctx context.Context) error {
+			return contacts.Unblock(ctx, user.UserID)
+		})
+	}
+	return contactActionCmd(fmt.Sprintf("已拉黑 %s", user.UserName), func(ctx context.Context) error {
+		return contacts.Block(ctx, user.UserID)
+	})
+}
+
+func (m addFriendModel) View() string {
+	var content strings.Builder
+
+	title := lipgloss.NewStyle().
+		Width(m.width).
+		Height(2).
+		Background(headerColor).
+		Foreground(textColor).
+		Bold(true).
+		Align(lipgloss.Center).
+		Render("添加好友")
+	content.WriteString(title + "\n")
+
+	searchBox := lipgloss.JoinHorizontal(lipgloss.Left, "搜索: ", m.input.View())
+	content.WriteString(lipgloss.NewStyle().Width(m.width).Padding(1, 2).Render(searchBox) + "\n")
+	if m.status != "" {
+		content.WriteString(lipgloss.NewStyle().Padding(0, 2).Foreground(subtextColor).Render(m.status) + "\n")
+	}
+	content.WriteString(lipgloss.NewStyle().
+		Width(m.width).
+		Foreground(borderColor).
+		Render(strings.Repeat("─", m.width)) + "\n")
+
+	var items []string
+	if m.keyword() == "" {
+		if len(m.requests) == 0 {
+			content.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("没有待处理的好友申请\n"))
+		} else {
+			content.WriteString(lipgloss.NewStyle().Padding(0, 2).Bold(true).Render("好友申请") + "\n")
+		}
+		for _, request := range m.requests {
+			item := fmt.Sprintf("%s (ID: %d)", request.FromUserName, request.FromUserId)
+			if request.Message != "" {
+				item += ": " + truncateText(request.Message, 30)
+			}
+			items = append(items, item)
+		}
+	} else {
+		if len(m.users) == 0 {
+			content.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("未找到用户\n"))
+		}
+		for _, user := range m.users {
+			item := fmt.Sprintf("%s (ID: %d)", user.UserName, user.UserID)
+			if m.blocked[user.UserID] {
+				item += " [已拉黑]"
+			}
+			items = append(items, item)
+		}
+	}
+	for i, item := range items {
+		style := chatItemStyle
+		if i == m.cursor {
+			style = selectedChatStyle
+		}
+		content.WriteString(lipgloss.NewStyle().Padding(0, 2).Render(style.Render(item)) + "\n")
+	}
+
+	return lipgloss.NewStyle().
+		Width(m.width).
+		Height(m.height).
+		Render(content.String())
+}
+
+func (m *addFriendModel) updateSize(w, h int) {
+	m.width = w
+	m.height = h
+}
+
+type startAddFriendMsg struct{}
+
+func fetchStartAddFriendCmd() tea.Cmd {
+	return func() tea.Msg {
+		return startAddFriendMsg{}
+	}
+}
+
+// friendRequestsMsg 收到的待处理的好友申请
+type friendRequestsMsg struct {
+	requests []*sqllite.FriendRequest
+	err      error
+}
+
+// FetchFriendRequestsCmd 重新加载待处理的好友申请, 收到好友申请通知时调用
+func FetchFriendRequestsCmd(sdk *im.Client) tea.Cmd {
+	return func() tea.Msg {
+		requests, err := sdk.Storage().Contacts.Requests(context.Background())
+		if err != nil {
+			logger.Errorf("加载好友申请失败: %v", err)
+		}
+		return friendRequestsMsg{requests: requests, err: err}
+	}
+}
+
+// remoteUsersMsg 在服务端搜索用户的结果, blocked 标记其中已拉黑的用户
+type remoteUsersMsg struct {
+	key     string
+	users   []*sqllite.ImUser
+	blocked map[int64]bool
+	err     error
+}
+
+func searchRemoteUserCmd(sdk *im.Client, key string) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		users, err := sdk.Storage().Contacts.SearchRemote(ctx, key)
+		blocked := make(map[int64]bool)
+		for _, user := range users {
+			if ok, _ := sdk.Storage().Contacts.IsBlocked(ctx, user.UserID); ok {
+				blocked[user.UserID] = true
+			}
+		}
+		return remoteUsersMsg{key: key, users: users, blocked: blocked, err: err}
+	}
+}
+
+// contactActionMsg 发送、处理好友申请和拉黑的结果
+type contactActionMsg struct {
+	text string
+}
+
+func contactActionCmd(success string, action func(ctx context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		if err := action(context.Background()); err != nil {
+			logger.Errorf("联系人操作失败: %v", err)
+			return contactActionMsg{text: fmt.Sprintf("操作失败: %v", err)}
+		}
+		return contactActionMsg{text: success}
+	}
+}
@@ -0,0 +1,57 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/xuning888/helloIMClient/im"
+)
+
+// fingerprintMsg 双方的公钥指纹, 展示在会话的提示栏中
+type fingerprintMsg struct {
+	chatId int64
+	notice string
+	err    error
+}
+
+// keyChangedNotice 对方的公钥变更后的提示, 用户核对新的指纹后输入 /trust 确认
+func keyChangedNotice(pinned, pending string) string {
+	return fmt.Sprintf("对方的公钥已变更! 原指纹 %s | 新指纹 %s, 核对后输入 %s <新指纹> 确认", pinned, pending, trustCommand)
+}
+
+// fetchFingerprintCmd 获取自己和单聊对方的公钥指纹, 双方通过其他渠道核对一致后才能确认没有被中间人替换公钥
+func fetchFingerprintCmd(sdk *im.Client, chatId int64) tea.Cmd {
+	return func() tea.Msg {
+		if pinned, pending, ok := sdk.PendingPeerKey(chatId); ok {
+			return fingerprintMsg{chatId: chatId, notice: keyChangedNotice(pinned, pending)}
+		}
+		peer, err := sdk.PeerFingerprint(context.Background(), chatId)
+		if err != nil {
+			return fingerprintMsg{chatId: chatId, err: fmt.Errorf("获取对方公钥失败: %w", err)}
+		}
+		return fingerprintMsg{chatId: chatId, notice: fmt.Sprintf("我的指纹 %s | 对方指纹 %s", sdk.Fingerprint(), peer)}
+	}
+}
+
+// KeyChangedCmd 单聊对方的公钥变更, 在会话的提示栏中展示新旧指纹
+func KeyChangedCmd(evt im.KeyChangedEvent) tea.Cmd {
+	return func() tea.Msg {
+		return fingerprintMsg{chatId: evt.Peer, notice: keyChangedNotice(evt.Pinned, evt.Pending)}
+	}
+}
+
+// trustKeyCmd 用户核对指纹后确认对方变更后的公钥, value 是输入框中的 /trust 命令
+func trustKeyCmd(sdk *im.Client, chatId int64, value string) tea.Cmd {
+	return func() tea.Msg {
+		fingerprint := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), trustCommand))
+		if fingerprint == "" {
+			return fingerprintMsg{chatId: chatId, err: fmt.Errorf("用法: %s <对方的新指纹>", trustCommand)}
+		}
+		if err := sdk.TrustPeerKey(chatId, fingerprint); err != nil {
+			return fingerprintMsg{chatId: chatId, err: fmt.Errorf("确认公钥失败: %w", err)}
+		}
+		return fingerprintMsg{chatId: chatId, notice: fmt.Sprintf("已确认对方的新公钥 %s", fingerprint)}
+	}
+}
@@ -2,19 +2,23 @@ package tui
 
 import (
 	"context"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/xuning888/helloIMClient/im"
 	sqllite "github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
 type chatListUpdatedMsg struct {
 	lastMessages map[string]*sqllite.ChatMessage
+	drafts       map[string]*sqllite.ChatDraft
 	chats        []*sqllite.ImChat
+	archived     []*sqllite.ImChat
 	err          error
 }
 
-// FetchUpdatedChatListCmd 创建更新会话列表的命令
+// FetchUpdatedChatListCmd 创建更新会话列表的命令, 同时更新已归档的会话
 func FetchUpdatedChatListCmd(sdk *im.Client) tea.Cmd {
 	return func() tea.Msg {
 		ctx := context.Background()
@@ -22,8 +26,26 @@ func FetchUpdatedChatListCmd(sdk *im.Client) tea.Cmd {
 		if err != nil {
 			return chatListUpdatedMsg{chats: nil, lastMessages: nil, err: err}
 		}
-		lastMessages := sdk.Storage().Messages.BatchLastMessage(ctx, chats)
-		return chatListUpdatedMsg{chats: chats, lastMessages: lastMessages, err: nil}
+		archived, err := sdk.Storage().Chats.ListArchived(ctx)
+		if err != nil {
+			return chatListUpdatedMsg{chats: nil, lastMessages: nil, err: err}
+		}
+		drafts, err := sdk.Storage().Drafts.List(ctx)
+		if err != nil {
+			return chatListUpdatedMsg{chats: nil, lastMessages: nil, err: err}
+		}
+		lastMessages := sdk.Storage().Messages.BatchLastMessage(ctx, append(append([]*sqllite.ImChat{}, chats...), archived...))
+		return chatListUpdatedMsg{chats: chats, archived: archived, lastMessages: lastMessages, drafts: drafts, err: nil}
+	}
+}
+
+// chatActionCmd 修改会话的设置(置顶、免打扰、删除、归档), 完成后刷新会话列表
+func chatActionCmd(sdk *im.Client, action func(ctx context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		if err := action(context.Background()); err != nil {
+			logger.Errorf("修改会话设置失败: %v", err)
+		}
+		return FetchUpdatedChatListCmd(sdk)()
 	}
 }
 
@@ -61,3 +83,76 @@ func FetchUpdateMessage(chatId int64, msg []*sqllite.ChatMessage) tea.Cmd {
 		}
 	}
 }
+
+type openChatMsg struct {
+	chatId   int64
+	chatType int32
+}
+
+// fetchOpenChatCmd 打开(不存在时创建)与指定会话的聊天
+func fetchOpenChatCmd(chatId int64, chatType int32) tea.Cmd {
+	return func() tea.Msg {
+		return openChatMsg{
+			chatId:   chatId,
+			chatType: chatType,
+		}
+	}
+}
+
+type historyLoadedMsg struct {
+	chatId int64
+	count  int
+	err    error
+}
+
+// historyRetryDelay 加载历史消息失败后, 等待这段时间再允许自动加载
+const historyRetryDelay = 5 * time.Second
+
+// historyRetryMsg 加载历史消息失败后的等待时间已过
+type historyRetryMsg struct {
+	chatId int64
+}
+
+func fetchHistoryRetryCmd(chatId int64) tea.Cmd {
+	return tea.Tick(historyRetryDelay, func(time.Time) tea.Msg {
+		return historyRetryMsg{chatId: chatId}
+	})
+}
+
+// fetchLoadHistoryCmd 加载更早(older 为 true)或更新的一页消息
+func fetchLoadHistoryCmd(cache im.MsgCache, older bool) tea.Cmd {
+	return func() tea.Msg {
+		var count int
+		var err error
+		if older {
+			count, err = cache.LoadOlder()
+		} else {
+			count, err = cache.LoadNewer()
+		}
+		return historyLoadedMsg{chatId: cache.GetChat().ChatId, count: count, err: err}
+	}
+}
+
+// markReadCmd 打开会话时把已读游标移动到最后一条消息, 同步给其他设备
+func markReadCmd(sdk *im.Client, chat *sqllite.ImChat) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		lastMsg, err := sdk.Storage().Messages.LastMessage(ctx, chat.ChatId, chat.ChatType)
+		if err != nil {
+			return nil
+		}
+		if err = sdk.MarkRead(ctx, chat.ChatId, chat.ChatType, lastMsg.MsgID); err != nil {
+			logger.Errorf("MarkRead chatId: %d, error: %v", chat.ChatId, err)
+		}
+		return nil
+	}
+}
+
+type usersFetchedMsg struct{}
+
+// UsersFetchedCmd 后台获取到用户资料后重新渲染用户名
+func UsersFetchedCmd() tea.Cmd {
+	return func() tea.Msg {
+		return usersFetchedMsg{}
+	}
+}
@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/xuning888/helloIMClient/im"
+	pb "github.com/xuning888/helloIMClient/im/proto"
+)
+
+type kickedOutMsg struct {
+	info *im.KickedOut
+}
+
+// KickedOutCmd 创建被踢下线的命令, TUI 显示原因后只能退出
+func KickedOutCmd(info *im.KickedOut) tea.Cmd {
+	return func() tea.Msg {
+		return kickedOutMsg{info: info}
+	}
+}
+
+func kickoutReasonText(reason pb.KickoutReason) string {
+	switch reason {
+	case pb.KickoutReason_KICKOUT_OTHER_DEVICE:
+		return "账号已在其他设备登录"
+	case pb.KickoutReason_KICKOUT_SESSION_REVOKED:
+		return "登录已失效, 请重新登录"
+	case pb.KickoutReason_KICKOUT_ACCOUNT_DISABLED:
+		return "账号已被停用"
+	default:
+		return "已被服务端强制下线"
+	}
+}
+
+// kickedOutView 被踢下线的提示框
+func kickedOutView(info *im.KickedOut, width, height int) string {
+	lines := []string{kickoutReasonText(info.Reason)}
+	if info.Message != "" {
+		lines = append(lines, info.Message)
+	}
+	if info.DeviceId != "" || info.Platform != "" {
+		lines = append(lines, fmt.Sprintf("新设备: %s %s", info.Platform, info.DeviceId))
+	}
+	lines = append(lines, "", "按 Enter 退出")
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(focusColor).
+		Foreground(textColor).
+		Padding(1, 3).
+		Align(lipgloss.Center).
+		Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, box)
+}
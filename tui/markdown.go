@@ -0,0 +1,233 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Note: 消息气泡本身带有背景色, 这里只使用开关单个属性的 SGR 序列(如 22/23/39),
+// 不使用 \x1b[0m, 避免把气泡的背景色一起重置掉
+const (
+	sgrBold          = "\x1b[1m"
+	sgrBoldOff       = "\x1b[22m"
+	sgrItalic        = "\x1b[3m"
+	sgrItalicOff     = "\x1b[23m"
+	sgrUnderline     = "\x1b[4m"
+	sgrUnderlineOff  = "\x1b[24m"
+	sgrStrike        = "\x1b[9m"
+	sgrStrikeOff     = "\x1b[29m"
+	sgrForegroundOff = "\x1b[39m"
+
+	codeStyle = "monokai"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.Strikethrough, extension.Linkify))
+
+// renderMarkdown 把 markdown 文本渲染为终端可以显示的文本, 按 width 折行
+func renderMarkdown(src string, width int) string {
+	if width <= 0 {
+		width = 40
+	}
+	source := []byte(src)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	r := &markdownRenderer{source: source}
+	return strings.Join(r.blocks(doc, width), "\n")
+}
+
+type markdownRenderer struct {
+	source []byte
+}
+
+// blocks 渲染 node 的所有子块, 块之间空一行
+func (r *markdownRenderer) blocks(node ast.Node, width int) []string {
+	var lines []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if len(lines) > 0 && child.HasBlankPreviousLines() {
+			lines = append(lines, "")
+		}
+		lines = append(lines, r.block(child, width)...)
+	}
+	return lines
+}
+
+func (r *markdownRenderer) block(node ast.Node, width int) []string {
+	switch n := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return wrapLines(r.inlines(n), width)
+	case *ast.Heading:
+		content := sgrBold + r.inlines(n) + sgrBoldOff
+		if n.Level == 1 {
+			content = sgrUnderline + content + sgrUnderlineOff
+		}
+		return wrapLines(content, width)
+	case *ast.FencedCodeBlock:
+		return r.code(n, string(n.Language(r.source)), width)
+	case *ast.CodeBlock:
+		return r.code(n, "", width)
+	case *ast.List:
+		return r.list(n, width)
+	case *ast.Blockquote:
+		return prefixLines(r.blocks(n, width-2), "▎ ", "▎ ")
+	case *ast.ThematicBreak:
+		return []string{strings.Repeat("─", width)}
+	case *ast.HTMLBlock:
+		return wrapLines(r.rawLines(n), width)
+	}
+	return r.blocks(node, width)
+}
+
+func (r *markdownRenderer) list(list *ast.List, width int) []string {
+	var lines []string
+	index := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "• "
+		if list.IsOrdered() {
+			marker = strconv.Itoa(index) + ". "
+			index++
+		}
+		indent := strings.Repeat(" ", ansi.StringWidth(marker))
+		lines = append(lines, prefixLines(r.blocks(item, width-len(indent)), marker, indent)...)
+	}
+	return lines
+}
+
+func (r *markdownRenderer) code(node ast.Node, language string, width int) []string {
+	code := strings.TrimRight(r.rawLines(node), "\n")
+	highlighted := highlightCode(code, language)
+	var lines []string
+	for _, line := range strings.Split(highlighted, "\n") {
+		lines = append(lines, strings.Split(ansi.Hardwrap(line, width-2, true), "\n")...)
+	}
+	return prefixLines(lines, "  ", "  ")
+}
+
+func (r *markdownRenderer) rawLines(node ast.Node) string {
+	var b strings.Builder
+	segments := node.Lines()
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.At(i)
+		b.Write(segment.Value(r.source))
+	}
+	return b.String()
+}
+
+// inlines 渲染 node 的行内元素
+func (r *markdownRenderer) inlines(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		r.inline(&b, child)
+	}
+	return b.String()
+}
+
+func (r *markdownRenderer) inline(b *strings.Builder, node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Text:
+		value := n.Segment.Value(r.source)
+		if !n.IsRaw() {
+			// 处理转义的标点和实体, 例如 \* 和 &amp;, 行内代码中的文本保持原样
+			value = util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(value)))
+		}
+		b.Write(value)
+		// 聊天消息中的换行都保留, 不按 markdown 的规则合并为空格
+		if n.HardLineBreak() || n.SoftLineBreak() {
+			b.WriteString("\n")
+		}
+	case *ast.String:
+		b.Write(n.Value)
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			b.WriteString(sgrBold + r.inlines(n) + sgrBoldOff)
+		} else {
+			b.WriteString(sgrItalic + r.inlines(n) + sgrItalicOff)
+		}
+	case *east.Strikethrough:
+		b.WriteString(sgrStrike + r.inlines(n) + sgrStrikeOff)
+	case *ast.CodeSpan:
+		b.WriteString("\x1b[38;5;214m" + r.inlines(n) + sgrForegroundOff)
+	case *ast.Link:
+		b.WriteString(hyperlink(string(n.Destination), r.inlines(n)))
+	case *ast.AutoLink:
+		url := string(n.URL(r.source))
+		b.WriteString(hyperlink(url, string(n.Label(r.source))))
+	case *ast.Image:
+		b.WriteString(hyperlink(string(n.Destination), "[图片: "+r.inlines(n)+"]"))
+	case *ast.RawHTML:
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			b.Write(segment.Value(r.source))
+		}
+	default:
+		b.WriteString(r.inlines(n))
+	}
+}
+
+// hyperlink 生成可以点击的 OSC-8 超链接
+func hyperlink(url, label string) string {
+	if label == "" {
+		label = url
+	}
+	return ansi.SetHyperlink(url) + sgrUnderline + label + sgrUnderlineOff + ansi.ResetHyperlink()
+}
+
+// highlightCode 代码块语法高亮, 只设置前景色
+func highlightCode(code, language string) string {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+	style := styles.Get(codeStyle)
+	var b strings.Builder
+	for _, token := range iterator.Tokens() {
+		colour := style.Get(token.Type).Colour
+		if !colour.IsSet() {
+			b.WriteString(token.Value)
+			continue
+		}
+		// 按行着色, 折行或加前缀时颜色不会串到下一行
+		for i, part := range strings.Split(token.Value, "\n") {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			if part != "" {
+				fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm%s%s", colour.Red(), colour.Green(), colour.Blue(), part, sgrForegroundOff)
+			}
+		}
+	}
+	return b.String()
+}
+
+func wrapLines(s string, width int) []string {
+	return strings.Split(ansi.Wrap(s, width, ""), "\n")
+}
+
+// prefixLines 第一行加 first 前缀, 其余行加 rest 前缀
+func prefixLines(lines []string, first, rest string) []string {
+	for i := range lines {
+		if i == 0 {
+			lines[i] = first + lines[i]
+		} else {
+			lines[i] = rest + lines[i]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,81 @@
+package tui
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestRenderMarkdown 渲染 testdata/markdown 下的 .md 文件并与 .golden 比较, 修改渲染后使用 -update 重新生成
+func TestRenderMarkdown(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "markdown", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no markdown test files")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".md")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := renderMarkdown(string(src), 60)
+			golden := strings.TrimSuffix(file, ".md") + ".golden"
+			if *update {
+				if err = os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Fatalf("render %s:\n%q\nwant:\n%q", file, got, want)
+			}
+			for i, line := range strings.Split(got, "\n") {
+				if w := ansi.StringWidth(line); w > 60 {
+					t.Fatalf("line %d is %d columns wide: %q", i, w, line)
+				}
+			}
+		})
+	}
+}
+
+// TestRenderMarkdown_PlainText 没有 markdown 语法的文本去掉控制序列后保持原样
+func TestRenderMarkdown_PlainText(t *testing.T) {
+	cases := []string{
+		"2 * 3 * 4 = 24",
+		"snake_case_name and file_name_v2.txt",
+		"a < b && c > d",
+		"100% done #hashtag",
+		"中文消息, 没有任何格式",
+		"第一行\n第二行",
+	}
+	for _, c := range cases {
+		if got := ansi.Strip(renderMarkdown(c, 60)); got != c {
+			t.Fatalf("render %q: got %q", c, got)
+		}
+	}
+}
+
+// TestMessageContent 普通文本消息不按 markdown 渲染
+func TestMessageContent(t *testing.T) {
+	m := chatModel{}
+	content := "**not bold** [x](http://a) `code`"
+	msg := &sqllite.ChatMessage{ContentType: int32(helloim_proto.PayloadType_TEXT), MsgContent: content}
+	if got := m.messageContent(msg); got != content {
+		t.Fatalf("text message rendered as %q", got)
+	}
+}
@@ -0,0 +1,108 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/xuning888/helloIMClient/conf"
+	"github.com/xuning888/helloIMClient/im"
+	sqllite "github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/payload"
+	"github.com/xuning888/helloIMClient/im/proto"
+)
+
+type mediaResultMsg struct {
+	notice string
+	err    error
+}
+
+// lastVoiceMessage 当前会话中最近的一条语音消息
+func lastVoiceMessage(msgs []*sqllite.ChatMessage) *helloim_proto.VoicePayload {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].ContentType != int32(helloim_proto.PayloadType_VOICE) {
+			continue
+		}
+		if voice, err := payload.ParseVoice(msgs[i].MsgContent); err == nil {
+			return voice
+		}
+	}
+	return nil
+}
+
+// fetchSaveVoiceCmd 下载语音文件到本地
+func fetchSaveVoiceCmd(sdk *im.Client, voice *helloim_proto.VoicePayload) tea.Cmd {
+	return func() tea.Msg {
+		path, err := sdk.DownloadMedia(context.Background(), voice.GetVoiceUrl())
+		if err != nil {
+			return mediaResultMsg{err: fmt.Errorf("保存语音失败: %w", err)}
+		}
+		return mediaResultMsg{notice: fmt.Sprintf("语音已保存到 %s", path)}
+	}
+}
+
+// fetchPlayVoiceCmd 下载语音文件并使用外部命令播放
+func fetchPlayVoiceCmd(sdk *im.Client, voice *helloim_proto.VoicePayload) tea.Cmd {
+	return func() tea.Msg {
+		player := strings.Fields(conf.VoicePlayer)
+		if len(player) == 0 {
+			return mediaResultMsg{err: fmt.Errorf("未配置语音播放命令")}
+		}
+		path, err := sdk.DownloadMedia(context.Background(), voice.GetVoiceUrl())
+		if err != nil {
+			return mediaResultMsg{err: fmt.Errorf("下载语音失败: %w", err)}
+		}
+		args := append(player[1:], path)
+		if out, err := exec.Command(player[0], args...).CombinedOutput(); err != nil {
+			return mediaResultMsg{err: fmt.Errorf("播放语音失败: %v, %s", err, strings.TrimSpace(string(out)))}
+		}
+		return mediaResultMsg{notice: "语音播放完毕"}
+	}
+}
+
+// lastContactCard 当前会话中最近的一条名片消息
+func lastContactCard(msgs []*sqllite.ChatMessage) *helloim_proto.ContactCardPayload {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].ContentType != int32(helloim_proto.PayloadType_CONTACT_CARD) {
+			continue
+		}
+		if card, err := payload.ParseContactCard(msgs[i].MsgContent); err == nil {
+			return card
+		}
+	}
+	return nil
+}
+
+// viewVoice 语音消息的展示内容
+func viewVoice(content string) string {
+	voice, err := payload.ParseVoice(content)
+	if err != nil {
+		return "[语音]"
+	}
+	return fmt.Sprintf("[语音] %d\" %s\nctrl+p 播放 • ctrl+o 保存", voice.GetDuration(), voice.GetCodec())
+}
+
+// viewLocation 位置消息的展示内容
+func viewLocation(content string) string {
+	location, err := payload.ParseLocation(content)
+	if err != nil {
+		return "[位置]"
+	}
+	lines := []string{"[位置] " + location.GetTitle()}
+	if location.GetAddress() != "" {
+		lines = append(lines, location.GetAddress())
+	}
+	lines = append(lines, fmt.Sprintf("(%.6f, %.6f)", location.GetLat(), location.GetLng()))
+	return strings.Join(lines, "\n")
+}
+
+// viewContactCard 名片消息的展示内容
+func viewContactCard(content string) string {
+	card, err := payload.ParseContactCard(content)
+	if err != nil {
+		return "[名片]"
+	}
+	return fmt.Sprintf("[名片] %s (ID: %d)\nctrl+k 发起聊天", card.GetUserName(), card.GetUserId())
+}
@@ -0,0 +1,294 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/xuning888/helloIMClient/im"
+	"github.com/xuning888/helloIMClient/im/dal/sqllite"
+	"github.com/xuning888/helloIMClient/im/proto"
+	"github.com/xuning888/helloIMClient/pkg"
+	"github.com/xuning888/helloIMClient/pkg/logger"
+)
+
+var _ tea.Model = &msgSearchModel{}
+
+// 搜索框中可以按类型过滤的消息
+var searchContentTypes = map[string]helloim_proto.PayloadType{
+	"text":     helloim_proto.PayloadType_TEXT,
+	"image":    helloim_proto.PayloadType_IMAGE,
+	"file":     helloim_proto.PayloadType_FILE,
+	"voice":    helloim_proto.PayloadType_VOICE,
+	"location": helloim_proto.PayloadType_LOCATION,
+	"card":     helloim_proto.PayloadType_CONTACT_CARD,
+	"md":       helloim_proto.PayloadType_MARKDOWN,
+}
+
+var highlightStyle = lipgloss.NewStyle().Foreground(focusColor).Bold(true)
+
+// msgSearchModel 全局消息搜索
+type msgSearchModel struct {
+	sdk           *im.Client
+	searchInput   textarea.Model
+	searchResults []*sqllite.MessageSearchResult
+	err           error
+	width         int
+	height        int
+	cursor        int
+	searching     bool
+}
+
+func initMsgSearchModel(sdk *im.Client) *msgSearchModel {
+	searchTa := textarea.New()
+	searchTa.Placeholder = "关键字 [from:用户id] [chat:会话id] [type:text|image|file|voice|location|card|md] [after:2006-01-02] [before:2006-01-02]"
+	searchTa.Focus()
+	searchTa.ShowLineNumbers = false
+	searchTa.KeyMap.InsertNewline.SetEnabled(false)
+	return &msgSearchModel{
+		sdk:           sdk,
+		searchInput:   searchTa,
+		searchResults: make([]*sqllite.MessageSearchResult, 0),
+	}
+}
+
+func (m msgSearchModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m msgSearchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case tea.KeyEsc.String():
+			return m, fetchExitSearchMsg()
+		case tea.KeyEnter.String():
+			if m.cursor >= 0 && m.cursor < len(m.searchResults) {
+				return m, fetchSearchSelectedMessageMsg(m.searchResults[m.cursor].Message)
+			}
+			return m, nil
+		case tea.KeyUp.String():
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case tea.KeyDown.String():
+			if m.cursor < len(m.searchResults)-1 {
+				m.cursor++
+			}
+		default:
+			var cmd tea.Cmd
+			m.searchInput, cmd = m.searchInput.Update(msg)
+			cmds = append(cmds, cmd)
+			query := strings.TrimSpace(m.searchInput.Value())
+			if query != "" {
+				m.searching = true
+				cmds = append(cmds, fetchSearchMessageCmd(m.sdk, query))
+			} else {
+				m.searchResults = make([]*sqllite.MessageSearchResult, 0)
+				m.searching = false
+				m.err = nil
+			}
+		}
+	case searchMessageMsg:
+		// 丢弃过期的搜索结果
+		if msg.query != strings.TrimSpace(m.searchInput.Value()) {
+			break
+		}
+		m.searching = false
+		m.err = msg.err
+		m.cursor = 0
+		if msg.err == nil {
+			m.searchResults = msg.results
+		} else {
+			logger.Errorf("搜索消息失败: %v", msg.err)
+			m.searchResults = make([]*sqllite.MessageSearchResult, 0)
+		}
+	}
+	return &m, tea.Batch(cmds...)
+}
+
+func (m msgSearchModel) View() string {
+	var content strings.Builder
+
+	title := lipgloss.NewStyle().
+		Width(m.width).
+		Height(2).
+		Background(headerColor).
+		Foreground(textColor).
+		Bold(true).
+		Align(lipgloss.Center).
+		Render("搜索消息")
+	content.WriteString(title + "\n")
+
+	searchLabel := "搜索: "
+	searchBox := lipgloss.JoinHorizontal(lipgloss.Left,
+		lipgloss.NewStyle().Width(len(searchLabel)).Render(searchLabel),
+		m.searchInput.View(),
+	)
+	content.WriteString(lipgloss.NewStyle().Width(m.width).Padding(1, 2).Render(searchBox) + "\n")
+
+	separator := lipgloss.NewStyle().
+		Width(m.width).
+		Foreground(borderColor).
+		Render(strings.Repeat("─", m.width))
+	content.WriteString(separator + "\n")
+
+	content.WriteString(m.renderSearchResults())
+
+	return lipgloss.NewStyle().
+		Width(m.width).
+		Height(m.height).
+		Render(content.String())
+}
+
+func (m msgSearchModel) renderSearchResults() string {
+	hint := lipgloss.NewStyle().Padding(1, 2)
+	switch {
+	case m.searching:
+		return hint.Render("搜索中...")
+	case m.err != nil:
+		return hint.Render(m.err.Error())
+	case len(m.searchResults) == 0:
+		if strings.TrimSpace(m.searchInput.Value()) != "" {
+			return hint.Render("未找到消息")
+		}
+		return hint.Render("输入关键字搜索所有会话的消息")
+	}
+	var results strings.Builder
+	for i, result := range m.searchResults {
+		itemStyle := chatItemStyle
+		if i == m.cursor {
+			itemStyle = selectedChatStyle
+		}
+		msg := result.Message
+		header := lipgloss.NewStyle().Foreground(subtextColor).Render(
+			fmt.Sprintf("%s · %s · %s", m.chatName(msg), m.userName(msg.MsgFrom), pkg.FormatTime(msg.SendTime, pkg.DateTime)))
+		item := itemStyle.Copy().Width(m.width - 4).Render(header + "\n" + renderSnippet(result.Snippet))
+		results.WriteString(lipgloss.NewStyle().Padding(0, 2).Render(item) + "\n")
+	}
+	return results.String()
+}
+
+func (m msgSearchModel) chatName(msg *sqllite.ChatMessage) string {
+	if msg.ChatType == 1 {
+		return m.userName(msg.ChatID)
+	}
+	return fmt.Sprintf("群聊 %d", msg.ChatID)
+}
+
+func (m msgSearchModel) userName(userId int64) string {
+	return userName(m.sdk, userId)
+}
+
+func (m *msgSearchModel) updateSize(w, h int) {
+	m.width = w
+	m.height = h
+}
+
+// renderSnippet 把搜索结果中的高亮标记替换为高亮样式, 摘要只显示一行
+func renderSnippet(snippet string) string {
+	snippet = strings.Join(strings.Fields(snippet), " ")
+	var b strings.Builder
+	for {
+		start := strings.Index(snippet, sqllite.HighlightStart)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(snippet[start:], sqllite.HighlightEnd)
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(snippet[:start])
+		b.WriteString(highlightStyle.Render(snippet[start+len(sqllite.HighlightStart) : end]))
+		snippet = snippet[end+len(sqllite.HighlightEnd):]
+	}
+	b.WriteString(snippet)
+	return b.String()
+}
+
+// parseMessageQuery 解析搜索框的内容, 带有过滤前缀的词作为过滤条件, 其余的词作为关键字
+func parseMessageQuery(query string) (sqllite.MessageSearchOptions, error) {
+	var opts sqllite.MessageSearchOptions
+	var keywords []string
+	for _, field := range strings.Fields(query) {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			keywords = append(keywords, field)
+			continue
+		}
+		switch name {
+		case "from", "chat":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return opts, fmt.Errorf("无效的id: %s", field)
+			}
+			if name == "from" {
+				opts.From = id
+			} else {
+				opts.ChatID = id
+			}
+		case "type":
+			contentType, ok := searchContentTypes[value]
+			if !ok {
+				return opts, fmt.Errorf("未知的消息类型: %s", value)
+			}
+			opts.ContentTypes = append(opts.ContentTypes, int32(contentType))
+		case "after", "before":
+			day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				return opts, fmt.Errorf("无效的日期: %s", field)
+			}
+			if name == "after" {
+				opts.StartTime = day.UnixMilli()
+			} else {
+				opts.EndTime = day.UnixMilli()
+			}
+		default:
+			keywords = append(keywords, field)
+		}
+	}
+	opts.Keyword = strings.Join(keywords, " ")
+	return opts, nil
+}
+
+type searchMessageMsg struct {
+	query   string
+	results []*sqllite.MessageSearchResult
+	err     error
+}
+
+func fetchSearchMessageCmd(sdk *im.Client, query string) tea.Cmd {
+	return func() tea.Msg {
+		opts, err := parseMessageQuery(query)
+		if err != nil {
+			return searchMessageMsg{query: query, err: err}
+		}
+		results, err := sdk.Storage().Messages.Search(context.Background(), opts)
+		return searchMessageMsg{query: query, results: results, err: err}
+	}
+}
+
+type searchSelectedMessageMsg struct {
+	msg *sqllite.ChatMessage
+}
+
+func fetchSearchSelectedMessageMsg(msg *sqllite.ChatMessage) tea.Cmd {
+	return func() tea.Msg {
+		return searchSelectedMessageMsg{msg: msg}
+	}
+}
+
+type startMessageSearchMsg struct{}
+
+func fetchStartMessageSearchCmd() tea.Cmd {
+	return func() tea.Msg {
+		return startMessageSearchMsg{}
+	}
+}
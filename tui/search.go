@@ -8,13 +8,16 @@ import (
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/xuning888/helloIMClient/im"
 	"github.com/xuning888/helloIMClient/im/dal/sqllite"
 	"github.com/xuning888/helloIMClient/pkg/logger"
 )
 
 var _ tea.Model = &searchModel{}
 
+// searchModel 在好友中搜索用户并发起单聊, 添加好友见 addFriendModel
 type searchModel struct {
+	sdk           *im.Client
 	searchInput   textarea.Model
 	searchResults []*sqllite.ImUser
 	width         int
@@ -23,13 +26,14 @@ type searchModel struct {
 	searching     bool
 }
 
-func initSearchModel() *searchModel {
+func initSearchModel(sdk *im.Client) *searchModel {
 	searchTa := textarea.New()
-	searchTa.Placeholder = "输入用户名搜索..."
+	searchTa.Placeholder = "输入好友的用户名搜索..."
 	searchTa.Focus()
 	searchTa.ShowLineNumbers = false
 	searchTa.KeyMap.InsertNewline.SetEnabled(false)
 	return &searchModel{
+		sdk:           sdk,
 		searchInput:   searchTa,
 		searchResults: make([]*sqllite.ImUser, 0),
 		cursor:        0,
@@ -72,7 +76,7 @@ func (m searchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			searchKey := strings.TrimSpace(m.searchInput.Value())
 			if searchKey != "" {
 				m.searching = true
-				cmds = append(cmds, fetchSearchUserMsg(searchKey))
+				cmds = append(cmds, fetchSearchUserMsg(m.sdk, searchKey))
 			} else {
 				m.searchResults = make([]*sqllite.ImUser, 0)
 				m.searching = false
@@ -101,7 +105,7 @@ func (m searchModel) View() string {
 		Foreground(textColor).
 		Bold(true).
 		Align(lipgloss.Center).
-		Render("搜索用户")
+		Render("搜索好友")
 	content.WriteString(title + "\n")
 
 	// 搜索框
@@ -145,9 +149,9 @@ func (m searchModel) renderSearchResults() string {
 		results.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("搜索中...\n"))
 	} else if len(m.searchResults) == 0 {
 		if strings.TrimSpace(m.searchInput.Value()) != "" {
-			results.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("未找到用户\n"))
+			results.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("未找到好友, F6 添加好友\n"))
 		} else {
-			results.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("输入用户名进行搜索\n"))
+			results.WriteString(lipgloss.NewStyle().Padding(1, 2).Render("输入好友的用户名进行搜索\n"))
 		}
 	} else {
 		for i, user := range m.searchResults {
@@ -204,9 +208,9 @@ type searchUserMsg struct {
 	err   error
 }
 
-func fetchSearchUserMsg(key string) tea.Cmd {
+func fetchSearchUserMsg(sdk *im.Client, key string) tea.Cmd {
 	return func() tea.Msg {
-		users, err := sqllite.SearchUser(context.Background(), key)
+		users, err := sdk.Storage().Contacts.Search(context.Background(), key)
 		return searchUserMsg{
 			key:   key,
 			users: users,
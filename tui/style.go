@@ -13,6 +13,8 @@ var (
 	myMsgColor      = lipgloss.Color("#007AFF") // 自己消息颜色
 	otherMsgColor   = lipgloss.Color("#404040") // 他人消息颜色
 	headerColor     = lipgloss.Color("#2A2A2A") // 标题背景
+	focusColor      = lipgloss.Color("#FFCC00") // 搜索命中的高亮色
+	draftColor      = lipgloss.Color("#FF6B6B") // 草稿标记
 )
 
 var (